@@ -0,0 +1,242 @@
+package mkafka
+
+import (
+	"errors"
+	"sort"
+
+	"github.com/Shopify/sarama"
+)
+
+// topic管理相关，基于sarama.ClusterAdmin，请求超时时间取自客户端配置的Admin.Timeout
+
+// 常用的topic配置项
+const (
+	TopicRetentionMs       = "retention.ms"
+	TopicCleanupPolicy     = "cleanup.policy"
+	TopicMinInsyncReplicas = "min.insync.replicas"
+)
+
+// TopicSpec topic的期望状态，Partitions为0时使用broker默认分区数，ReplicationFactor为0时使用broker默认副本数
+type TopicSpec struct {
+	Name              string
+	Partitions        int32
+	ReplicationFactor int16
+	Configs           map[string]string
+}
+
+func (s TopicSpec) detail() *sarama.TopicDetail {
+	d := &sarama.TopicDetail{
+		NumPartitions:     s.Partitions,
+		ReplicationFactor: s.ReplicationFactor,
+	}
+	if d.NumPartitions == 0 {
+		d.NumPartitions = -1
+	}
+	if d.ReplicationFactor == 0 {
+		d.ReplicationFactor = -1
+	}
+	if len(s.Configs) > 0 {
+		d.ConfigEntries = make(map[string]*string, len(s.Configs))
+		for k, v := range s.Configs {
+			v := v
+			d.ConfigEntries[k] = &v
+		}
+	}
+	return d
+}
+
+// adminProvider 自带ClusterAdmin的客户端，如kafkatest.Cluster.NewClient返回的模拟客户端
+type adminProvider interface {
+	ClusterAdmin() sarama.ClusterAdmin
+}
+
+// NewClusterAdmin 基于已有客户端创建ClusterAdmin，注意关闭admin会同时关闭该客户端
+func NewClusterAdmin(client sarama.Client) (sarama.ClusterAdmin, error) {
+	if p, ok := client.(adminProvider); ok {
+		return p.ClusterAdmin(), nil
+	}
+	admin, err := sarama.NewClusterAdminFromClient(client)
+	if err != nil {
+		logger.Err(err).Msg("创建ClusterAdmin失败")
+		return nil, err
+	}
+	return admin, nil
+}
+
+// CreateTopics 按spec创建topic，包括其配置项，topic已存在时返回sarama.ErrTopicAlreadyExists
+func CreateTopics(admin sarama.ClusterAdmin, specs ...TopicSpec) error {
+	for _, s := range specs {
+		if err := admin.CreateTopic(s.Name, s.detail(), false); err != nil {
+			logger.Err(err).Str("topic", s.Name).Msg("创建Topic失败")
+			return err
+		}
+	}
+	return nil
+}
+
+// DeleteTopics 删除topic
+func DeleteTopics(admin sarama.ClusterAdmin, topics ...string) error {
+	for _, t := range topics {
+		if err := admin.DeleteTopic(t); err != nil {
+			logger.Err(err).Str("topic", t).Msg("删除Topic失败")
+			return err
+		}
+	}
+	return nil
+}
+
+// DescribeTopicConfig 获取topic的全部配置项，包括broker默认值
+func DescribeTopicConfig(admin sarama.ClusterAdmin, topic string) (map[string]string, error) {
+	entries, err := admin.DescribeConfig(sarama.ConfigResource{
+		Type: sarama.TopicResource,
+		Name: topic,
+	})
+	if err != nil {
+		logger.Err(err).Str("topic", topic).Msg("获取Topic配置失败")
+		return nil, err
+	}
+
+	r := make(map[string]string, len(entries))
+	for _, e := range entries {
+		r[e.Name] = e.Value
+	}
+	return r, nil
+}
+
+// AlterTopicConfig 增量修改topic配置项，未指定的配置项保持不变
+func AlterTopicConfig(admin sarama.ClusterAdmin, topic string, configs map[string]string) error {
+	entries := make(map[string]sarama.IncrementalAlterConfigsEntry, len(configs))
+	for k, v := range configs {
+		v := v
+		entries[k] = sarama.IncrementalAlterConfigsEntry{
+			Operation: sarama.IncrementalAlterConfigsOperationSet,
+			Value:     &v,
+		}
+	}
+
+	err := admin.IncrementalAlterConfig(sarama.TopicResource, topic, entries, false)
+	if err != nil {
+		logger.Err(err).Str("topic", topic).Msg("修改Topic配置失败")
+		return err
+	}
+	return nil
+}
+
+// AddPartitions 将topic的分区数扩充到total，kafka不支持减少分区
+func AddPartitions(admin sarama.ClusterAdmin, topic string, total int32) error {
+	err := admin.CreatePartitions(topic, total, nil, false)
+	if err != nil {
+		logger.Err(err).Str("topic", topic).Int32("partitions", total).Msg("扩充分区失败")
+		return err
+	}
+	return nil
+}
+
+// ListTopics 列出集群中所有topic及其分区数、副本数和非默认配置项，按名称排序
+func ListTopics(admin sarama.ClusterAdmin) ([]TopicSpec, error) {
+	details, err := admin.ListTopics()
+	if err != nil {
+		logger.Err(err).Msg("获取Topic列表失败")
+		return nil, err
+	}
+
+	r := make([]TopicSpec, 0, len(details))
+	for name, d := range details {
+		s := TopicSpec{
+			Name:              name,
+			Partitions:        d.NumPartitions,
+			ReplicationFactor: d.ReplicationFactor,
+			Configs:           make(map[string]string, len(d.ConfigEntries)),
+		}
+		for k, v := range d.ConfigEntries {
+			if v != nil {
+				s.Configs[k] = *v
+			}
+		}
+		r = append(r, s)
+	}
+	sort.Slice(r, func(i, j int) bool { return r[i].Name < r[j].Name })
+	return r, nil
+}
+
+// DescribeTopics 获取topic的分区元数据，包括leader、副本和ISR
+func DescribeTopics(admin sarama.ClusterAdmin, topics ...string) ([]*sarama.TopicMetadata, error) {
+	md, err := admin.DescribeTopics(topics)
+	if err != nil {
+		logger.Err(err).Strs("topics", topics).Msg("获取Topic元数据失败")
+		return nil, err
+	}
+	return md, nil
+}
+
+// EnsureTopics 使集群中的topic符合spec，可重复调用
+//
+// topic不存在则创建，已存在则扩充分区并修改有差异的配置项；并发创建导致TopicAlreadyExists时，
+// 重新获取该topic的信息后同样按spec调整，因为对方创建时使用的分区数和配置不一定与spec相同；
+// 分区数缩减和副本数变更无法通过admin接口完成，只记录警告
+func EnsureTopics(admin sarama.ClusterAdmin, specs ...TopicSpec) error {
+	existing, err := admin.ListTopics()
+	if err != nil {
+		logger.Err(err).Msg("获取Topic列表失败")
+		return err
+	}
+
+	for _, s := range specs {
+		d, ok := existing[s.Name]
+		if !ok {
+			err := admin.CreateTopic(s.Name, s.detail(), false)
+			if err == nil {
+				continue
+			}
+			if !errors.Is(err, sarama.ErrTopicAlreadyExists) {
+				logger.Err(err).Str("topic", s.Name).Msg("创建Topic失败")
+				return err
+			}
+			if existing, err = admin.ListTopics(); err != nil {
+				logger.Err(err).Msg("获取Topic列表失败")
+				return err
+			}
+			if d, ok = existing[s.Name]; !ok {
+				logger.Warn().Str("topic", s.Name).Msg("Topic已存在但未出现在Topic列表中，跳过调整")
+				continue
+			}
+		}
+		if err := reconcileTopic(admin, s, d); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// reconcileTopic 按spec调整已存在的topic，d为其当前的分区数和副本数
+func reconcileTopic(admin sarama.ClusterAdmin, s TopicSpec, d sarama.TopicDetail) error {
+	if s.Partitions > d.NumPartitions {
+		if err := AddPartitions(admin, s.Name, s.Partitions); err != nil {
+			return err
+		}
+	} else if s.Partitions != 0 && s.Partitions < d.NumPartitions {
+		logger.Warn().Str("topic", s.Name).Int32("current", d.NumPartitions).Int32("desired", s.Partitions).Msg("分区数无法缩减")
+	}
+
+	if s.ReplicationFactor != 0 && s.ReplicationFactor != d.ReplicationFactor {
+		logger.Warn().Str("topic", s.Name).Int16("current", d.ReplicationFactor).Int16("desired", s.ReplicationFactor).Msg("副本数无法直接修改")
+	}
+
+	if len(s.Configs) == 0 {
+		return nil
+	}
+	current, err := DescribeTopicConfig(admin, s.Name)
+	if err != nil {
+		return err
+	}
+	changed := make(map[string]string)
+	for k, v := range s.Configs {
+		if cv, ok := current[k]; !ok || cv != v {
+			changed[k] = v
+		}
+	}
+	if len(changed) == 0 {
+		return nil
+	}
+	return AlterTopicConfig(admin, s.Name, changed)
+}
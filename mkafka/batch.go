@@ -0,0 +1,143 @@
+package mkafka
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Shopify/sarama"
+)
+
+// 按分区批量处理消息，批次处理成功后才提交offset
+
+const (
+	defaultBatchMessages = 100
+	defaultBatchBytes    = 1 << 20
+	defaultBatchWait     = time.Second
+)
+
+// BatchHandler 处理同一分区的一批消息，消息按offset排序；部分失败时返回*BatchError
+type BatchHandler func(ctx context.Context, msgs []*sarama.ConsumerMessage) error
+
+// BatchError 批次部分失败，Index之前的消息已处理成功
+type BatchError struct {
+	Index int
+	Err   error
+}
+
+func (e *BatchError) Error() string {
+	return fmt.Sprintf("[mouse] -> kafka 批次中第%d条消息处理失败: %v", e.Index, e.Err)
+}
+
+func (e *BatchError) Unwrap() error {
+	return e.Err
+}
+
+// BatchConfig 批次的触发条件，任意一个满足时调用BatchHandler
+type BatchConfig struct {
+	// MaxMessages 批次最大消息数，默认100
+	MaxMessages int
+	// MaxBytes 批次中key和value的最大总字节数，默认1MB，单条消息超过时单独成为一个批次
+	MaxBytes int
+	// MaxWait 批次中第一条消息的最长等待时间，默认1秒
+	MaxWait time.Duration
+}
+
+type batchHandler struct {
+	h    BatchHandler
+	conf BatchConfig
+}
+
+// NewBatchGroupHandler 将BatchHandler适配为sarama.ConsumerGroupHandler
+//
+// 批次处理成功后标记并提交最后一条消息的offset；返回*BatchError时提交Index之前的消息，
+// 其他错误时不提交；出错后停止消费该分区并将错误返回给消费者组。会话结束时未满的批次不处理，在下一次rebalance后重新投递
+func NewBatchGroupHandler(h BatchHandler, conf BatchConfig) sarama.ConsumerGroupHandler {
+	if conf.MaxMessages <= 0 {
+		conf.MaxMessages = defaultBatchMessages
+	}
+	if conf.MaxBytes <= 0 {
+		conf.MaxBytes = defaultBatchBytes
+	}
+	if conf.MaxWait <= 0 {
+		conf.MaxWait = defaultBatchWait
+	}
+	return &batchHandler{h: h, conf: conf}
+}
+
+func (b *batchHandler) Setup(sarama.ConsumerGroupSession) error {
+	return nil
+}
+
+func (b *batchHandler) Cleanup(sarama.ConsumerGroupSession) error {
+	return nil
+}
+
+func (b *batchHandler) ConsumeClaim(sess sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
+	ctx := sess.Context()
+
+	var (
+		batch = make([]*sarama.ConsumerMessage, 0, b.conf.MaxMessages)
+		size  int
+		wait  <-chan time.Time
+	)
+
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		msgs := batch
+		batch = make([]*sarama.ConsumerMessage, 0, b.conf.MaxMessages)
+		size = 0
+		wait = nil
+
+		err := b.h(ctx, msgs)
+		done := len(msgs)
+		if err != nil {
+			done = 0
+			var be *BatchError
+			if errors.As(err, &be) && be.Index >= 0 && be.Index <= len(msgs) {
+				done = be.Index
+			}
+			logger.Err(err).Str("topic", claim.Topic()).Int32("partition", claim.Partition()).
+				Int64("offset", msgs[0].Offset).Int("size", len(msgs)).Msg("批量处理消息失败")
+		}
+		if done > 0 {
+			sess.MarkMessage(msgs[done-1], "")
+			sess.Commit()
+		}
+		return err
+	}
+
+	for {
+		select {
+		case msg, ok := <-claim.Messages():
+			if !ok {
+				return nil
+			}
+			n := len(msg.Key) + len(msg.Value)
+			if len(batch) > 0 && size+n > b.conf.MaxBytes {
+				if err := flush(); err != nil {
+					return err
+				}
+			}
+			batch = append(batch, msg)
+			size += n
+			if len(batch) == 1 {
+				wait = time.After(b.conf.MaxWait)
+			}
+			if len(batch) >= b.conf.MaxMessages || size >= b.conf.MaxBytes {
+				if err := flush(); err != nil {
+					return err
+				}
+			}
+		case <-wait:
+			if err := flush(); err != nil {
+				return err
+			}
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
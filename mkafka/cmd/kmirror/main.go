@@ -0,0 +1,181 @@
+// kmirror 复制topic中一段范围内的消息到另一个topic或NDJSON文件，或将NDJSON文件重放到topic
+//
+// 用法：
+//
+//	kmirror -brokers localhost:9092 -topic orders -start 2023-01-02T15:04:05Z -end newest -to-topic orders.staging
+//	kmirror -brokers localhost:9092 -topic orders -partitions 0,1 -start 100 -end 200 -to-file orders.ndjson
+//	kmirror -brokers localhost:9092 -from-file orders.ndjson -to-topic orders.replay -rate 100
+//
+// -start可以是offset、RFC3339时间或oldest，-end可以是offset、RFC3339时间或newest；安全配置从KAFKA_开头的环境变量读取，见mkafka.SecurityConfigFromEnv
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/mouseleee/mlib/mkafka"
+)
+
+func main() {
+	var (
+		brokers       = flag.String("brokers", "localhost:9092", "broker地址，多个以逗号分隔")
+		topic         = flag.String("topic", "", "源topic")
+		partitions    = flag.String("partitions", "", "源分区，多个以逗号分隔，为空时为所有分区")
+		start         = flag.String("start", "oldest", "起点：offset、RFC3339时间、oldest")
+		end           = flag.String("end", "newest", "终点（不含）：offset、RFC3339时间、newest")
+		toTopic       = flag.String("to-topic", "", "目标topic")
+		toFile        = flag.String("to-file", "", "目标NDJSON文件，-表示标准输出")
+		fromFile      = flag.String("from-file", "", "重放的NDJSON文件，-表示标准输入")
+		keepPartition = flag.Bool("keep-partition", false, "写入目标topic中相同编号的分区")
+		rate          = flag.Float64("rate", 0, "每秒最多写入的消息数，0为不限速")
+	)
+	flag.Parse()
+
+	if err := run(*brokers, *topic, *partitions, *start, *end, *toTopic, *toFile, *fromFile, *keepPartition, *rate); err != nil {
+		fmt.Fprintln(os.Stderr, "kmirror:", err)
+		os.Exit(1)
+	}
+}
+
+func run(brokers, topic, partitions, start, end, toTopic, toFile, fromFile string, keepPartition bool, rate float64) error {
+	if (toTopic == "") == (toFile == "") {
+		return errors.New("需要指定-to-topic或-to-file之一")
+	}
+	if fromFile == "" && topic == "" {
+		return errors.New("需要指定-topic或-from-file")
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	sec, err := mkafka.SecurityConfigFromEnv("")
+	if err != nil {
+		return err
+	}
+	opts := []mkafka.Option{mkafka.WithClientID("kmirror"), mkafka.WithSecurity(sec)}
+
+	var (
+		sink mkafka.MirrorSink
+		nw   *mkafka.NDJSONWriter
+	)
+	if toTopic != "" {
+		conf, err := mkafka.NewProducerConfig(opts...)
+		if err != nil {
+			return err
+		}
+		if keepPartition {
+			conf.Producer.Partitioner = sarama.NewManualPartitioner
+		}
+		prd, err := sarama.NewSyncProducer(strings.Split(brokers, ","), conf)
+		if err != nil {
+			return err
+		}
+		defer prd.Close()
+		sink = mkafka.NewTopicSink(prd, toTopic, keepPartition)
+	} else {
+		var w io.Writer = os.Stdout
+		if toFile != "-" {
+			f, err := os.Create(toFile)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			w = f
+		}
+		nw = mkafka.NewNDJSONWriter(w)
+		sink = nw
+	}
+	mopts := mkafka.MirrorOptions{Rate: rate}
+
+	var stats mkafka.MirrorStats
+	if fromFile != "" {
+		var r io.Reader = os.Stdin
+		if fromFile != "-" {
+			f, err := os.Open(fromFile)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			r = f
+		}
+		stats, err = mkafka.Replay(ctx, r, mopts, sink)
+	} else {
+		source, perr := parseSource(topic, partitions, start, end)
+		if perr != nil {
+			return perr
+		}
+		client, cerr := mkafka.CreateKafkaClient(brokers, nil, opts...)
+		if cerr != nil {
+			return cerr
+		}
+		defer client.Close()
+		consumer, cerr := sarama.NewConsumerFromClient(client)
+		if cerr != nil {
+			return cerr
+		}
+		defer consumer.Close()
+		stats, err = mkafka.Mirror(ctx, consumer, client, source, mopts, sink)
+	}
+
+	if nw != nil {
+		if ferr := nw.Flush(); err == nil {
+			err = ferr
+		}
+	}
+	fmt.Fprintf(os.Stderr, "read %d, written %d, skipped %d\n", stats.Read, stats.Written, stats.Skipped)
+	if err == nil && len(stats.Incomplete) > 0 {
+		err = fmt.Errorf("以下分区没有读到终点（分区:下一个offset）：%v", stats.Incomplete)
+	}
+	return err
+}
+
+func parseSource(topic, partitions, start, end string) (mkafka.MirrorSource, error) {
+	s := mkafka.MirrorSource{Topic: topic}
+	if partitions != "" {
+		for _, p := range strings.Split(partitions, ",") {
+			v, err := strconv.ParseInt(strings.TrimSpace(p), 10, 32)
+			if err != nil {
+				return s, fmt.Errorf("无效的分区%q", p)
+			}
+			s.Partitions = append(s.Partitions, int32(v))
+		}
+	}
+
+	var err error
+	if s.StartOffset, s.StartTime, err = parsePosition(start, sarama.OffsetOldest); err != nil {
+		return s, err
+	}
+	if s.EndOffset, s.EndTime, err = parsePosition(end, sarama.OffsetNewest); err != nil {
+		return s, err
+	}
+	return s, nil
+}
+
+// parsePosition 解析offset、RFC3339时间、oldest或newest，为空时返回def
+func parsePosition(v string, def int64) (int64, time.Time, error) {
+	switch v {
+	case "":
+		return def, time.Time{}, nil
+	case "oldest":
+		return sarama.OffsetOldest, time.Time{}, nil
+	case "newest":
+		return sarama.OffsetNewest, time.Time{}, nil
+	}
+	if off, err := strconv.ParseInt(v, 10, 64); err == nil {
+		return off, time.Time{}, nil
+	}
+	t, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		return 0, time.Time{}, fmt.Errorf("无效的位置%q", v)
+	}
+	return 0, t, nil
+}
@@ -0,0 +1,256 @@
+package mkafka
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/xdg-go/scram"
+)
+
+// 通过Option组合生成生产者/消费者配置，生成时校验配置项之间的组合是否合法
+
+// ErrInvalidConfig 配置项组合不合法
+var ErrInvalidConfig = errors.New("[mouse] -> kafka 无效配置")
+
+// Option 生产者/消费者的配置项，只对消费者生效的配置项在生成生产者配置时被忽略
+type Option func(*options)
+
+type options struct {
+	clientID string
+	version  sarama.KafkaVersion
+
+	tls *tls.Config
+
+	saslMechanism sarama.SASLMechanism
+	saslUser      string
+	saslPassword  string
+	tokenProvider TokenProvider
+
+	rebalance      sarama.BalanceStrategy
+	isolation      *sarama.IsolationLevel
+	sessionTimeout time.Duration
+	heartbeat      time.Duration
+
+	// err 记录Option执行过程中的错误，在生成配置时返回
+	err error
+}
+
+// WithClientID 设置客户端ID，用于broker端的日志和配额
+func WithClientID(id string) Option {
+	return func(o *options) {
+		o.clientID = id
+	}
+}
+
+// WithVersion 设置kafka协议版本，应不高于集群版本
+func WithVersion(v sarama.KafkaVersion) Option {
+	return func(o *options) {
+		o.version = v
+	}
+}
+
+// WithTLS 使用TLS连接broker
+func WithTLS(conf *tls.Config) Option {
+	return func(o *options) {
+		o.tls = conf
+	}
+}
+
+// WithSASLPlain 使用SASL/PLAIN认证，建议同时开启TLS
+func WithSASLPlain(user, password string) Option {
+	return func(o *options) {
+		o.saslMechanism = sarama.SASLTypePlaintext
+		o.saslUser = user
+		o.saslPassword = password
+	}
+}
+
+// WithSASLScram 使用SASL/SCRAM认证，mechanism为sarama.SASLTypeSCRAMSHA256或sarama.SASLTypeSCRAMSHA512
+func WithSASLScram(mechanism sarama.SASLMechanism, user, password string) Option {
+	return func(o *options) {
+		o.saslMechanism = mechanism
+		o.saslUser = user
+		o.saslPassword = password
+	}
+}
+
+// WithRebalanceStrategy 设置消费者组的分区分配策略
+func WithRebalanceStrategy(s sarama.BalanceStrategy) Option {
+	return func(o *options) {
+		o.rebalance = s
+	}
+}
+
+// WithIsolationLevel 设置消费者的事务隔离级别
+func WithIsolationLevel(l sarama.IsolationLevel) Option {
+	return func(o *options) {
+		o.isolation = &l
+	}
+}
+
+// WithSessionTimeout 设置消费者组的会话超时和心跳间隔，心跳间隔应不大于会话超时的1/3
+func WithSessionTimeout(session, heartbeat time.Duration) Option {
+	return func(o *options) {
+		o.sessionTimeout = session
+		o.heartbeat = heartbeat
+	}
+}
+
+// NewClientConfig 在sarama默认配置基础上应用opts生成客户端配置，用于admin等不区分角色的场景
+func NewClientConfig(opts ...Option) (*sarama.Config, error) {
+	o := applyOptions(opts)
+	conf := sarama.NewConfig()
+	if err := o.applyCommon(conf); err != nil {
+		return nil, err
+	}
+	return validate(conf)
+}
+
+// NewProducerConfig 在DefaultProducerConfig基础上应用opts生成生产者配置
+func NewProducerConfig(opts ...Option) (*sarama.Config, error) {
+	o := applyOptions(opts)
+	conf := DefaultProducerConfig()
+	if err := o.applyCommon(conf); err != nil {
+		return nil, err
+	}
+
+	if conf.Producer.Idempotent && !conf.Version.IsAtLeast(sarama.V0_11_0_0) {
+		return nil, fmt.Errorf("%w: 幂等生产者需要kafka版本不低于0.11.0，当前为%s", ErrInvalidConfig, conf.Version)
+	}
+
+	return validate(conf)
+}
+
+// NewConsumerConfig 在DefaultConsumerConfig基础上应用opts生成消费者组配置
+func NewConsumerConfig(opts ...Option) (*sarama.Config, error) {
+	o := applyOptions(opts)
+	conf := DefaultConsumerConfig()
+	if err := o.applyCommon(conf); err != nil {
+		return nil, err
+	}
+
+	if o.rebalance != nil {
+		conf.Consumer.Group.Rebalance.Strategy = o.rebalance
+	}
+	if o.isolation != nil {
+		if *o.isolation == sarama.ReadCommitted && !conf.Version.IsAtLeast(sarama.V0_11_0_0) {
+			return nil, fmt.Errorf("%w: read_committed需要kafka版本不低于0.11.0，当前为%s", ErrInvalidConfig, conf.Version)
+		}
+		conf.Consumer.IsolationLevel = *o.isolation
+	}
+	if o.sessionTimeout > 0 {
+		conf.Consumer.Group.Session.Timeout = o.sessionTimeout
+	}
+	if o.heartbeat > 0 {
+		conf.Consumer.Group.Heartbeat.Interval = o.heartbeat
+	}
+	if conf.Consumer.Group.Heartbeat.Interval*3 > conf.Consumer.Group.Session.Timeout {
+		return nil, fmt.Errorf("%w: 心跳间隔%s应不大于会话超时%s的1/3", ErrInvalidConfig, conf.Consumer.Group.Heartbeat.Interval, conf.Consumer.Group.Session.Timeout)
+	}
+
+	return validate(conf)
+}
+
+func applyOptions(opts []Option) *options {
+	o := &options{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// applyCommon 应用生产者和消费者共用的配置项
+func (o *options) applyCommon(conf *sarama.Config) error {
+	if o.err != nil {
+		return o.err
+	}
+	if o.clientID != "" {
+		conf.ClientID = o.clientID
+	}
+	if o.version != (sarama.KafkaVersion{}) {
+		conf.Version = o.version
+	}
+
+	if o.tls != nil {
+		conf.Net.TLS.Enable = true
+		conf.Net.TLS.Config = o.tls
+	}
+
+	if o.saslMechanism == "" {
+		return nil
+	}
+	if o.saslMechanism == sarama.SASLTypeOAuth {
+		if o.tokenProvider == nil {
+			return fmt.Errorf("%w: OAUTHBEARER认证需要提供TokenProvider", ErrInvalidConfig)
+		}
+	} else if o.saslUser == "" || o.saslPassword == "" {
+		return fmt.Errorf("%w: SASL认证需要提供用户名和密码", ErrInvalidConfig)
+	}
+	conf.Net.SASL.Enable = true
+	conf.Net.SASL.Mechanism = o.saslMechanism
+	conf.Net.SASL.User = o.saslUser
+	conf.Net.SASL.Password = o.saslPassword
+	conf.Net.SASL.Handshake = true
+	if conf.Version.IsAtLeast(sarama.V1_0_0_0) {
+		conf.Net.SASL.Version = sarama.SASLHandshakeV1
+	} else {
+		conf.Net.SASL.Version = sarama.SASLHandshakeV0
+	}
+
+	switch o.saslMechanism {
+	case sarama.SASLTypePlaintext:
+		if o.tls == nil {
+			logger.Warn().Msg("SASL/PLAIN未开启TLS，密码将以明文传输")
+		}
+	case sarama.SASLTypeOAuth:
+		if o.tls == nil {
+			logger.Warn().Msg("SASL/OAUTHBEARER未开启TLS，token将以明文传输")
+		}
+		conf.Net.SASL.TokenProvider = o.tokenProvider
+	case sarama.SASLTypeSCRAMSHA256:
+		conf.Net.SASL.SCRAMClientGeneratorFunc = func() sarama.SCRAMClient {
+			return &scramClient{hash: scram.SHA256}
+		}
+	case sarama.SASLTypeSCRAMSHA512:
+		conf.Net.SASL.SCRAMClientGeneratorFunc = func() sarama.SCRAMClient {
+			return &scramClient{hash: scram.SHA512}
+		}
+	default:
+		return fmt.Errorf("%w: 不支持的SASL机制%s", ErrInvalidConfig, o.saslMechanism)
+	}
+	return nil
+}
+
+func validate(conf *sarama.Config) (*sarama.Config, error) {
+	if err := conf.Validate(); err != nil {
+		logger.Err(err).Msg("kafka配置校验失败")
+		return nil, fmt.Errorf("%w: %v", ErrInvalidConfig, err)
+	}
+	return conf, nil
+}
+
+// scramClient 基于xdg-go/scram实现sarama.SCRAMClient
+type scramClient struct {
+	hash scram.HashGeneratorFcn
+	conv *scram.ClientConversation
+}
+
+func (c *scramClient) Begin(userName, password, authzID string) error {
+	client, err := c.hash.NewClient(userName, password, authzID)
+	if err != nil {
+		return err
+	}
+	c.conv = client.NewConversation()
+	return nil
+}
+
+func (c *scramClient) Step(challenge string) (string, error) {
+	return c.conv.Step(challenge)
+}
+
+func (c *scramClient) Done() bool {
+	return c.conv.Done()
+}
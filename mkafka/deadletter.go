@@ -0,0 +1,95 @@
+package mkafka
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/Shopify/sarama"
+)
+
+// 死信：消息多次处理仍失败时交给死信处理（如写入死信topic）并跳过，避免一条无法处理的消息阻塞整个分区
+
+const (
+	// HeaderDeadLetterError 死信消息最后一次处理失败的原因
+	HeaderDeadLetterError = "x-dead-letter-error"
+	// HeaderDeadLetterSource 死信消息的原始位置，格式为topic/partition/offset
+	HeaderDeadLetterSource = "x-dead-letter-source"
+)
+
+const (
+	defaultDeadLetterAttempts = 3
+	defaultDeadLetterBackoff  = 100 * time.Millisecond
+)
+
+// DeadLetterConfig 死信中间件配置
+type DeadLetterConfig struct {
+	// Attempts 每条消息最多处理的次数，默认3
+	Attempts int
+	// Backoff 第一次重试前的等待时间，之后每次翻倍，默认100毫秒
+	Backoff time.Duration
+	// Send 处理Attempts次仍失败的消息和最后一次的错误交给Send，返回nil时跳过该消息；为nil时只记录日志后跳过
+	Send func(ctx context.Context, msg *sarama.ConsumerMessage, err error) error
+}
+
+// DeadLetter 处理失败时原地重试，Attempts次仍失败后交给conf.Send并返回nil，使消息被标记；
+// Send失败时返回错误，由NewGroupHandler继续重试。
+// 与Dedup一起使用时应放在Dedup之内，使ErrDedupInFlight由NewGroupHandler等待重试而不是进入死信
+func DeadLetter(conf DeadLetterConfig) Middleware {
+	if conf.Attempts <= 0 {
+		conf.Attempts = defaultDeadLetterAttempts
+	}
+	if conf.Backoff <= 0 {
+		conf.Backoff = defaultDeadLetterBackoff
+	}
+
+	return func(next Handler) Handler {
+		return func(ctx context.Context, msg *sarama.ConsumerMessage) error {
+			var err error
+			backoff := conf.Backoff
+			for i := 0; i < conf.Attempts; i++ {
+				if i > 0 {
+					if serr := sleep(ctx, backoff); serr != nil {
+						return err
+					}
+					backoff *= 2
+				}
+				if err = next(ctx, msg); err == nil || ctx.Err() != nil {
+					return err
+				}
+			}
+
+			Logger(ctx).Error().Err(err).Str("topic", msg.Topic).Int32("partition", msg.Partition).Int64("offset", msg.Offset).
+				Int("attempts", conf.Attempts).Msg("消息多次处理失败，转入死信")
+			if conf.Send == nil {
+				return nil
+			}
+			return conf.Send(ctx, msg, err)
+		}
+	}
+}
+
+// DeadLetterTopic 返回将死信写入topic的DeadLetterConfig.Send，保留原消息的key、value和header，
+// 并在HeaderDeadLetterError和HeaderDeadLetterSource中记录失败原因和原始位置
+func DeadLetterTopic(producer sarama.SyncProducer, topic string) func(ctx context.Context, msg *sarama.ConsumerMessage, err error) error {
+	return func(ctx context.Context, msg *sarama.ConsumerMessage, err error) error {
+		out := &sarama.ProducerMessage{
+			Topic: topic,
+			Value: sarama.ByteEncoder(msg.Value),
+		}
+		if msg.Key != nil {
+			out.Key = sarama.ByteEncoder(msg.Key)
+		}
+		for _, h := range msg.Headers {
+			if h != nil {
+				out.Headers = append(out.Headers, sarama.RecordHeader{Key: h.Key, Value: h.Value})
+			}
+		}
+		SetHeader(out, HeaderDeadLetterError, []byte(err.Error()))
+		SetHeader(out, HeaderDeadLetterSource, []byte(fmt.Sprintf("%s/%d/%d", msg.Topic, msg.Partition, msg.Offset)))
+		if _, _, serr := producer.SendMessage(out); serr != nil {
+			return fmt.Errorf("[mouse] -> kafka 写入死信topic %s失败: %w", topic, serr)
+		}
+		return nil
+	}
+}
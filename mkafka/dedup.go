@@ -0,0 +1,135 @@
+package mkafka
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/Shopify/sarama"
+)
+
+// 幂等消费：处理前在DedupStore中占用消息的去重键，处理成功后标记为已完成，重复投递的消息直接跳过
+//
+// mredis.DedupStore和msql.DedupStore分别提供基于Redis和MySQL的实现
+
+// ErrDedupInFlight 消息正在被其他消费者处理，NewGroupHandler会退避重试，直到对方处理完成或占用过期
+var ErrDedupInFlight = errors.New("[mouse] -> kafka 消息正在处理中")
+
+const (
+	defaultDedupTTL   = 24 * time.Hour
+	defaultDedupLease = time.Minute
+)
+
+// DedupStore 记录消息去重键的处理状态，实现需保证Begin的原子性
+type DedupStore interface {
+	// Begin 原子地占用key，占用lease时间后自动失效；
+	// key不存在或已失效时占用并返回acquired=true，已处理完成时返回done=true，正在处理中时两者都为false
+	Begin(ctx context.Context, key string, lease time.Duration) (acquired bool, done bool, err error)
+	// Commit 将key标记为已处理完成，保留ttl时间
+	Commit(ctx context.Context, key string, ttl time.Duration) error
+	// Abort 处理失败时释放占用，使消息可以被重新处理
+	Abort(ctx context.Context, key string) error
+}
+
+// DedupConfig 去重中间件配置
+type DedupConfig struct {
+	Store DedupStore
+	// TTL 已处理记录的保留时间，应大于消息可能被重复投递的时间窗口，默认24小时
+	TTL time.Duration
+	// Lease 处理中记录的保留时间，超过后认为处理者已退出，应大于单条消息的处理时间，默认1分钟
+	Lease time.Duration
+	// Key 消息的去重键，默认为DefaultDedupKey
+	Key func(*sarama.ConsumerMessage) string
+}
+
+// DefaultDedupKey 优先使用HeaderDedupKey中的业务去重键，没有时使用topic/partition/offset
+func DefaultDedupKey(msg *sarama.ConsumerMessage) string {
+	if v, ok := HeaderValue(msg, HeaderDedupKey); ok && len(v) > 0 {
+		return msg.Topic + "/" + string(v)
+	}
+	return fmt.Sprintf("%s/%d/%d", msg.Topic, msg.Partition, msg.Offset)
+}
+
+// Dedup 去重中间件，已处理过的消息直接跳过；消息正在被其他消费者处理时返回ErrDedupInFlight
+func Dedup(conf DedupConfig) Middleware {
+	if conf.TTL <= 0 {
+		conf.TTL = defaultDedupTTL
+	}
+	if conf.Lease <= 0 {
+		conf.Lease = defaultDedupLease
+	}
+	if conf.Key == nil {
+		conf.Key = DefaultDedupKey
+	}
+
+	return func(next Handler) Handler {
+		return func(ctx context.Context, msg *sarama.ConsumerMessage) error {
+			key := conf.Key(msg)
+			acquired, done, err := conf.Store.Begin(ctx, key, conf.Lease)
+			if err != nil {
+				return err
+			}
+			if done {
+				Logger(ctx).Debug().Str("key", key).Msg("跳过重复消息")
+				return nil
+			}
+			if !acquired {
+				return ErrDedupInFlight
+			}
+
+			if err := next(ctx, msg); err != nil {
+				if e := conf.Store.Abort(context.Background(), key); e != nil {
+					Logger(ctx).Err(e).Str("key", key).Msg("释放去重键失败")
+				}
+				return err
+			}
+			return conf.Store.Commit(context.Background(), key, conf.TTL)
+		}
+	}
+}
+
+// MemoryDedupStore 进程内的DedupStore，用于测试和单实例消费者
+type MemoryDedupStore struct {
+	mu   sync.Mutex
+	keys map[string]dedupEntry
+}
+
+type dedupEntry struct {
+	done   bool
+	expire time.Time
+}
+
+// NewMemoryDedupStore 创建空的MemoryDedupStore
+func NewMemoryDedupStore() *MemoryDedupStore {
+	return &MemoryDedupStore{keys: make(map[string]dedupEntry)}
+}
+
+func (s *MemoryDedupStore) Begin(ctx context.Context, key string, lease time.Duration) (bool, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	if e, ok := s.keys[key]; ok && now.Before(e.expire) {
+		return false, e.done, nil
+	}
+	s.keys[key] = dedupEntry{expire: now.Add(lease)}
+	return true, false, nil
+}
+
+func (s *MemoryDedupStore) Commit(ctx context.Context, key string, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keys[key] = dedupEntry{done: true, expire: time.Now().Add(ttl)}
+	return nil
+}
+
+func (s *MemoryDedupStore) Abort(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if e, ok := s.keys[key]; ok && !e.done {
+		delete(s.keys, key)
+	}
+	return nil
+}
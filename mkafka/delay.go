@@ -0,0 +1,171 @@
+package mkafka
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/Shopify/sarama"
+)
+
+// 延迟投递：消息先按延迟时间写入分级的延迟topic，转发者消费延迟topic，到期后发布到目标topic
+//
+// 每个延迟topic只保存延迟不小于该级别的消息，消息在写入时间+级别之后才被转发，所以同一个topic中的消息按顺序到期，
+// 转发者只需等待队首的消息；到期后剩余的延迟会再次写入更小级别的延迟topic，直到投递时间到达
+
+const (
+	// HeaderDelayTarget 延迟消息的目标topic
+	HeaderDelayTarget = "x-delay-target"
+	// HeaderDeliverAt 延迟消息的投递时间，毫秒时间戳
+	HeaderDeliverAt = "x-deliver-at"
+)
+
+// DefaultDelayBuckets 默认的延迟级别
+var DefaultDelayBuckets = []time.Duration{
+	time.Second, 5 * time.Second, 30 * time.Second,
+	time.Minute, 5 * time.Minute, 30 * time.Minute,
+	time.Hour, 6 * time.Hour, 24 * time.Hour,
+}
+
+// DelayScheduler 发送延迟消息并转发到期的消息
+type DelayScheduler struct {
+	producer sarama.SyncProducer
+	prefix   string
+	buckets  []time.Duration
+}
+
+// NewDelayScheduler 创建延迟调度器，prefix为延迟topic的前缀，为空时使用"mkafka.delay."；buckets为空时使用DefaultDelayBuckets
+func NewDelayScheduler(producer sarama.SyncProducer, prefix string, buckets ...time.Duration) *DelayScheduler {
+	if prefix == "" {
+		prefix = "mkafka.delay."
+	}
+	if len(buckets) == 0 {
+		buckets = DefaultDelayBuckets
+	}
+	bs := append([]time.Duration{}, buckets...)
+	sort.Slice(bs, func(i, j int) bool {
+		return bs[i] < bs[j]
+	})
+	return &DelayScheduler{producer: producer, prefix: prefix, buckets: bs}
+}
+
+// DelayTopic 延迟级别对应的topic
+func (s *DelayScheduler) DelayTopic(bucket time.Duration) string {
+	return s.prefix + bucket.String()
+}
+
+// DelayTopics 所有延迟topic，需要预先创建并由转发者订阅
+func (s *DelayScheduler) DelayTopics() []string {
+	r := make([]string, 0, len(s.buckets))
+	for _, b := range s.buckets {
+		r = append(r, s.DelayTopic(b))
+	}
+	return r
+}
+
+// bucket 选择不大于delay的最大级别，delay小于最小级别时使用最小级别
+func (s *DelayScheduler) bucket(delay time.Duration) time.Duration {
+	b := s.buckets[0]
+	for _, v := range s.buckets {
+		if v > delay {
+			break
+		}
+		b = v
+	}
+	return b
+}
+
+// Schedule 在at时刻将msg投递到msg.Topic，at已过时直接发送；msg的key、value和header保持不变
+func (s *DelayScheduler) Schedule(msg *sarama.ProducerMessage, at time.Time) (int32, int64, error) {
+	delay := time.Until(at)
+	if delay <= 0 {
+		return s.producer.SendMessage(msg)
+	}
+
+	target := msg.Topic
+	delayed := &sarama.ProducerMessage{
+		Topic:   s.DelayTopic(s.bucket(delay)),
+		Key:     msg.Key,
+		Value:   msg.Value,
+		Headers: append([]sarama.RecordHeader{}, msg.Headers...),
+	}
+	SetHeader(delayed, HeaderDelayTarget, []byte(target))
+	SetHeader(delayed, HeaderDeliverAt, []byte(strconv.FormatInt(at.UnixMilli(), 10)))
+	partition, offset, err := s.producer.SendMessage(delayed)
+	if err != nil {
+		logger.Err(err).Str("topic", delayed.Topic).Str("target", target).Msg("发送延迟消息失败")
+	}
+	return partition, offset, err
+}
+
+// ScheduleAfter 在delay之后将msg投递到msg.Topic
+func (s *DelayScheduler) ScheduleAfter(msg *sarama.ProducerMessage, delay time.Duration) (int32, int64, error) {
+	return s.Schedule(msg, time.Now().Add(delay))
+}
+
+// Forwarder 返回转发延迟消息的Handler，需要订阅DelayTopics，配合NewGroupHandler使用
+//
+// Handler阻塞到消息到期，会话结束时返回错误使消息在下一次rebalance后重新投递；
+// 消息没有去重键时以延迟topic/分区/offset作为去重键，重复转发的消息可以通过Dedup去重
+func (s *DelayScheduler) Forwarder() Handler {
+	return func(ctx context.Context, msg *sarama.ConsumerMessage) error {
+		target, ok := HeaderValue(msg, HeaderDelayTarget)
+		if !ok || len(target) == 0 {
+			Logger(ctx).Error().Str("topic", msg.Topic).Int64("offset", msg.Offset).Msg("延迟消息缺少目标topic，丢弃")
+			return nil
+		}
+		v, _ := HeaderValue(msg, HeaderDeliverAt)
+		ms, err := strconv.ParseInt(string(v), 10, 64)
+		if err != nil {
+			Logger(ctx).Error().Str("topic", msg.Topic).Int64("offset", msg.Offset).Msg("延迟消息缺少投递时间，丢弃")
+			return nil
+		}
+		at := time.UnixMilli(ms)
+
+		// 等待到写入时间+级别，或投递时间（较早者）
+		due := at
+		for _, b := range s.buckets {
+			if msg.Topic == s.DelayTopic(b) {
+				if t := msg.Timestamp.Add(b); t.Before(due) {
+					due = t
+				}
+				break
+			}
+		}
+		if d := time.Until(due); d > 0 {
+			t := time.NewTimer(d)
+			select {
+			case <-t.C:
+			case <-ctx.Done():
+				t.Stop()
+				return ctx.Err()
+			}
+		}
+
+		out := &sarama.ProducerMessage{Topic: string(target)}
+		if msg.Key != nil {
+			out.Key = sarama.ByteEncoder(msg.Key)
+		}
+		if msg.Value != nil {
+			out.Value = sarama.ByteEncoder(msg.Value)
+		}
+		for _, h := range msg.Headers {
+			if h == nil {
+				continue
+			}
+			k := string(h.Key)
+			if k == HeaderDelayTarget || k == HeaderDeliverAt {
+				continue
+			}
+			out.Headers = append(out.Headers, sarama.RecordHeader{Key: h.Key, Value: h.Value})
+		}
+		if _, ok := HeaderValue(msg, HeaderDedupKey); !ok {
+			SetHeader(out, HeaderDedupKey, []byte(fmt.Sprintf("%s/%d/%d", msg.Topic, msg.Partition, msg.Offset)))
+		}
+
+		_, _, err = s.Schedule(out, at)
+		return err
+	}
+}
@@ -0,0 +1,440 @@
+package mkafka
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/rcrowley/go-metrics"
+)
+
+// 以Prometheus文本格式导出生产者、消费者和sarama内部的指标
+//
+// 导出的指标（namespace默认为mkafka）：
+//
+//	mkafka_producer_messages_total{topic}                 发送成功的消息数
+//	mkafka_producer_errors_total{topic}                   发送失败的消息数
+//	mkafka_producer_send_latency_seconds{topic}           发送耗时，histogram
+//	mkafka_consumer_messages_total{topic}                 处理的消息数，包括失败的
+//	mkafka_consumer_errors_total{topic}                   处理失败的消息数
+//	mkafka_consumer_processing_latency_seconds{topic}     处理耗时，histogram
+//	mkafka_consumer_rebalances_total{group}               消费者组会话建立次数
+//	mkafka_consumer_assigned_partitions{group}            当前分配到的分区数
+//	mkafka_consumer_group_lag{group,topic,partition}      消费延迟，抓取时计算
+//	mkafka_sarama_<name>{client}                          sarama go-metrics中的指标，meter和counter导出为_total，histogram导出为summary
+
+// DefaultLatencyBuckets 耗时histogram的默认分桶，单位秒
+var DefaultLatencyBuckets = []float64{.001, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+const defaultLagTimeout = 5 * time.Second
+
+// Exporter 收集mkafka的指标并以Prometheus文本格式导出，实现了http.Handler和MetricsRecorder
+type Exporter struct {
+	// LagTimeout 每次抓取计算消费延迟的最长时间，超时未完成的消费者组本次不输出延迟，默认5秒
+	LagTimeout time.Duration
+
+	namespace string
+	buckets   []float64
+
+	mu         sync.Mutex
+	counters   map[string]map[string]float64
+	gauges     map[string]map[string]float64
+	histograms map[string]map[string]*histogram
+	lags       []*lagSource
+	registries map[string]metrics.Registry
+}
+
+type histogram struct {
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+type lagSource struct {
+	// busy 上一次计算尚未返回时为1，避免broker无响应时每次抓取都堆积新的请求
+	busy   int32
+	group  string
+	admin  sarama.ClusterAdmin
+	src    OffsetSource
+	topics []string
+}
+
+// NewExporter 创建指标导出器，namespace为空时使用"mkafka"
+func NewExporter(namespace string) *Exporter {
+	if namespace == "" {
+		namespace = "mkafka"
+	}
+	return &Exporter{
+		LagTimeout: defaultLagTimeout,
+		namespace:  namespace,
+		buckets:    DefaultLatencyBuckets,
+		counters:   make(map[string]map[string]float64),
+		gauges:     make(map[string]map[string]float64),
+		histograms: make(map[string]map[string]*histogram),
+		registries: make(map[string]metrics.Registry),
+	}
+}
+
+// labelEscaper Prometheus文本格式的标签值只转义反斜杠、双引号和换行，其余字符（包括非ASCII）原样输出
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// labels 按顺序生成Prometheus标签，kv为成对的标签名和值
+func labels(kv ...string) string {
+	b := strings.Builder{}
+	b.WriteString("{")
+	for i := 0; i+1 < len(kv); i += 2 {
+		if i > 0 {
+			b.WriteString(",")
+		}
+		b.WriteString(kv[i] + `="` + labelEscaper.Replace(kv[i+1]) + `"`)
+	}
+	b.WriteString("}")
+	return b.String()
+}
+
+func (e *Exporter) add(name, lbs string, v float64) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.counters[name] == nil {
+		e.counters[name] = make(map[string]float64)
+	}
+	e.counters[name][lbs] += v
+}
+
+func (e *Exporter) setGauge(name, lbs string, v float64) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.gauges[name] == nil {
+		e.gauges[name] = make(map[string]float64)
+	}
+	e.gauges[name][lbs] = v
+}
+
+func (e *Exporter) observe(name, lbs string, d time.Duration) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.histograms[name] == nil {
+		e.histograms[name] = make(map[string]*histogram)
+	}
+	h, ok := e.histograms[name][lbs]
+	if !ok {
+		h = &histogram{counts: make([]uint64, len(e.buckets))}
+		e.histograms[name][lbs] = h
+	}
+	v := d.Seconds()
+	for i, b := range e.buckets {
+		if v <= b {
+			h.counts[i]++
+		}
+	}
+	h.count++
+	h.sum += v
+}
+
+// ObserveMessage 记录一条消息的处理结果，配合Metrics中间件使用
+func (e *Exporter) ObserveMessage(topic string, partition int32, latency time.Duration, err error) {
+	lbs := labels("topic", topic)
+	e.add("consumer_messages_total", lbs, 1)
+	if err != nil {
+		e.add("consumer_errors_total", lbs, 1)
+	}
+	e.observe("consumer_processing_latency_seconds", lbs, latency)
+}
+
+// ObserveSend 记录一条消息的发送结果
+func (e *Exporter) ObserveSend(topic string, latency time.Duration, err error) {
+	lbs := labels("topic", topic)
+	if err != nil {
+		e.add("producer_errors_total", lbs, 1)
+	} else {
+		e.add("producer_messages_total", lbs, 1)
+	}
+	e.observe("producer_send_latency_seconds", lbs, latency)
+}
+
+type instrumentedProducer struct {
+	sarama.SyncProducer
+	e *Exporter
+}
+
+// InstrumentProducer 包装同步生产者，记录发送的消息数、错误数和耗时
+func (e *Exporter) InstrumentProducer(p sarama.SyncProducer) sarama.SyncProducer {
+	return &instrumentedProducer{SyncProducer: p, e: e}
+}
+
+func (p *instrumentedProducer) SendMessage(msg *sarama.ProducerMessage) (int32, int64, error) {
+	start := time.Now()
+	partition, offset, err := p.SyncProducer.SendMessage(msg)
+	p.e.ObserveSend(msg.Topic, time.Since(start), err)
+	return partition, offset, err
+}
+
+func (p *instrumentedProducer) SendMessages(msgs []*sarama.ProducerMessage) error {
+	start := time.Now()
+	err := p.SyncProducer.SendMessages(msgs)
+
+	failed := make(map[*sarama.ProducerMessage]error)
+	if errs, ok := err.(sarama.ProducerErrors); ok {
+		for _, pe := range errs {
+			failed[pe.Msg] = pe.Err
+		}
+	} else if err != nil {
+		for _, m := range msgs {
+			failed[m] = err
+		}
+	}
+	d := time.Since(start)
+	for _, m := range msgs {
+		p.e.ObserveSend(m.Topic, d, failed[m])
+	}
+	return err
+}
+
+type instrumentedHandler struct {
+	sarama.ConsumerGroupHandler
+	e     *Exporter
+	group string
+}
+
+// InstrumentGroupHandler 包装消费者组的handler，记录rebalance次数和分配到的分区数
+func (e *Exporter) InstrumentGroupHandler(group string, h sarama.ConsumerGroupHandler) sarama.ConsumerGroupHandler {
+	return &instrumentedHandler{ConsumerGroupHandler: h, e: e, group: group}
+}
+
+func (h *instrumentedHandler) Setup(sess sarama.ConsumerGroupSession) error {
+	lbs := labels("group", h.group)
+	h.e.add("consumer_rebalances_total", lbs, 1)
+	n := 0
+	for _, ps := range sess.Claims() {
+		n += len(ps)
+	}
+	h.e.setGauge("consumer_assigned_partitions", lbs, float64(n))
+	return h.ConsumerGroupHandler.Setup(sess)
+}
+
+// WatchLag 在每次抓取时计算消费者组在topics上的消费延迟，topics为空时为组内已提交的所有topic
+func (e *Exporter) WatchLag(admin sarama.ClusterAdmin, src OffsetSource, group string, topics ...string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.lags = append(e.lags, &lagSource{group: group, admin: admin, src: src, topics: topics})
+}
+
+// RegisterSarama 导出sarama配置中的go-metrics指标，client作为标签区分不同的客户端
+func (e *Exporter) RegisterSarama(client string, conf *sarama.Config) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.registries[client] = conf.MetricRegistry
+}
+
+// ServeHTTP 以Prometheus文本格式输出所有指标
+func (e *Exporter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	if err := e.Write(r.Context(), w); err != nil {
+		logger.Err(err).Msg("输出指标失败")
+	}
+}
+
+// Write 将所有指标以Prometheus文本格式写入w，消费延迟计算失败或超时时跳过该消费者组
+func (e *Exporter) Write(ctx context.Context, w io.Writer) error {
+	lags := e.collectLag(ctx)
+	_, err := w.Write(e.snapshot(lags))
+	return err
+}
+
+// snapshot 在锁内生成所有指标的文本，写出时不持有锁
+func (e *Exporter) snapshot(lags map[string]float64) []byte {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	buf := &bytes.Buffer{}
+	for _, name := range sortedKeys(e.counters) {
+		e.writeFamily(buf, name, "counter", e.counters[name])
+	}
+	for _, name := range sortedKeys(e.gauges) {
+		e.writeFamily(buf, name, "gauge", e.gauges[name])
+	}
+	if len(lags) > 0 {
+		e.writeFamily(buf, "consumer_group_lag", "gauge", lags)
+	}
+	for _, name := range sortedKeys(e.histograms) {
+		e.writeHistogram(buf, name, e.histograms[name])
+	}
+	e.writeRegistries(buf)
+	return buf.Bytes()
+}
+
+// collectLag 并发计算所有消费者组的延迟，最多等待到ctx结束或LagTimeout；
+// ConsumerGroupLag不接收ctx，超时的计算在后台继续，返回前同一消费者组不再发起新的计算
+func (e *Exporter) collectLag(ctx context.Context) map[string]float64 {
+	e.mu.Lock()
+	sources := append([]*lagSource{}, e.lags...)
+	timeout := e.LagTimeout
+	e.mu.Unlock()
+	if timeout <= 0 {
+		timeout = defaultLagTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	type result struct {
+		group string
+		lag   []PartitionLag
+		err   error
+	}
+	results := make(chan result, len(sources))
+	pending := 0
+	for _, s := range sources {
+		if !atomic.CompareAndSwapInt32(&s.busy, 0, 1) {
+			logger.Warn().Str("group", s.group).Msg("上一次计算消费延迟尚未返回，本次跳过")
+			continue
+		}
+		pending++
+		go func(s *lagSource) {
+			defer atomic.StoreInt32(&s.busy, 0)
+			lag, err := ConsumerGroupLag(s.admin, s.src, s.group, s.topics...)
+			results <- result{group: s.group, lag: lag, err: err}
+		}(s)
+	}
+
+	r := make(map[string]float64)
+	for ; pending > 0; pending-- {
+		select {
+		case res := <-results:
+			if res.err != nil {
+				logger.Err(res.err).Str("group", res.group).Msg("计算消费延迟失败")
+				continue
+			}
+			for _, l := range res.lag {
+				r[labels("group", res.group, "topic", l.Topic, "partition", strconv.Itoa(int(l.Partition)))] = float64(l.Lag)
+			}
+		case <-ctx.Done():
+			logger.Warn().Err(ctx.Err()).Int("pending", pending).Msg("计算消费延迟超时，跳过未完成的消费者组")
+			return r
+		}
+	}
+	return r
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	r := make([]string, 0, len(m))
+	for k := range m {
+		r = append(r, k)
+	}
+	sort.Strings(r)
+	return r
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func (e *Exporter) writeFamily(w io.Writer, name, typ string, series map[string]float64) {
+	full := e.namespace + "_" + name
+	fmt.Fprintf(w, "# TYPE %s %s\n", full, typ)
+	for _, lbs := range sortedKeys(series) {
+		fmt.Fprintf(w, "%s%s %s\n", full, lbs, formatFloat(series[lbs]))
+	}
+}
+
+func (e *Exporter) writeHistogram(w io.Writer, name string, series map[string]*histogram) {
+	full := e.namespace + "_" + name
+	fmt.Fprintf(w, "# TYPE %s histogram\n", full)
+	for _, lbs := range sortedKeys(series) {
+		h := series[lbs]
+		inner := strings.TrimSuffix(strings.TrimPrefix(lbs, "{"), "}")
+		for i, b := range e.buckets {
+			fmt.Fprintf(w, "%s_bucket{%s,le=%q} %d\n", full, inner, formatFloat(b), h.counts[i])
+		}
+		fmt.Fprintf(w, "%s_bucket{%s,le=\"+Inf\"} %d\n", full, inner, h.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", full, lbs, formatFloat(h.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", full, lbs, h.count)
+	}
+}
+
+var invalidMetricChars = regexp.MustCompile(`[^a-zA-Z0-9_]`)
+
+// family 同名的一组指标，多个客户端的指标合并输出，每个名称只有一行TYPE
+type family struct {
+	typ   string
+	lines []string
+}
+
+// writeRegistries 输出所有客户端的sarama go-metrics指标，名称中的-等字符替换为_
+func (e *Exporter) writeRegistries(w io.Writer) {
+	fams := make(map[string]*family)
+	add := func(name, typ, line string) {
+		f, ok := fams[name]
+		if !ok {
+			f = &family{typ: typ}
+			fams[name] = f
+		}
+		f.lines = append(f.lines, line)
+	}
+	for _, client := range sortedKeys(e.registries) {
+		e.collectRegistry(add, client, e.registries[client])
+	}
+
+	for _, name := range sortedKeys(fams) {
+		f := fams[name]
+		fmt.Fprintf(w, "# TYPE %s %s\n", name, f.typ)
+		for _, l := range f.lines {
+			fmt.Fprintln(w, l)
+		}
+	}
+}
+
+// collectRegistry 将一个客户端的指标按名称交给add
+func (e *Exporter) collectRegistry(add func(name, typ, line string), client string, reg metrics.Registry) {
+	if reg == nil {
+		return
+	}
+	lbs := labels("client", client)
+	inner := strings.TrimSuffix(strings.TrimPrefix(lbs, "{"), "}")
+
+	all := make(map[string]any)
+	reg.Each(func(name string, m any) {
+		all[name] = m
+	})
+	for _, name := range sortedKeys(all) {
+		full := e.namespace + "_sarama_" + invalidMetricChars.ReplaceAllString(name, "_")
+		switch m := all[name].(type) {
+		case metrics.Meter:
+			s := m.Snapshot()
+			add(full+"_total", "counter", fmt.Sprintf("%s_total%s %d", full, lbs, s.Count()))
+			add(full+"_rate1m", "gauge", fmt.Sprintf("%s_rate1m%s %s", full, lbs, formatFloat(s.Rate1())))
+		case metrics.Counter:
+			add(full+"_total", "counter", fmt.Sprintf("%s_total%s %d", full, lbs, m.Count()))
+		case metrics.Gauge:
+			add(full, "gauge", fmt.Sprintf("%s%s %d", full, lbs, m.Value()))
+		case metrics.GaugeFloat64:
+			add(full, "gauge", fmt.Sprintf("%s%s %s", full, lbs, formatFloat(m.Value())))
+		case metrics.Histogram:
+			s := m.Snapshot()
+			ps := s.Percentiles([]float64{0.5, 0.75, 0.95, 0.99})
+			for i, q := range []string{"0.5", "0.75", "0.95", "0.99"} {
+				add(full, "summary", fmt.Sprintf("%s{%s,quantile=%q} %s", full, inner, q, formatFloat(ps[i])))
+			}
+			add(full, "summary", fmt.Sprintf("%s_sum%s %d", full, lbs, s.Sum()))
+			add(full, "summary", fmt.Sprintf("%s_count%s %d", full, lbs, s.Count()))
+		}
+	}
+}
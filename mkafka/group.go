@@ -0,0 +1,483 @@
+package mkafka
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/Shopify/sarama"
+)
+
+// 消费者组管理相关：组和成员信息、消费延迟计算、offset重置
+
+// ErrGroupActive 消费者组仍有活跃成员，无法重置offset
+var ErrGroupActive = errors.New("[mouse] -> kafka 消费者组仍有活跃成员，请先停止所有消费者")
+
+// OffsetSource 查询分区和offset，sarama.Client实现了该接口
+type OffsetSource interface {
+	Partitions(topic string) ([]int32, error)
+	GetOffset(topic string, partitionID int32, time int64) (int64, error)
+}
+
+// GroupMember 消费者组成员及其分配到的分区
+type GroupMember struct {
+	MemberID   string
+	ClientID   string
+	ClientHost string
+	Assignment map[string][]int32
+}
+
+// GroupInfo 消费者组描述
+type GroupInfo struct {
+	Group    string
+	State    string
+	Protocol string
+	Members  []GroupMember
+}
+
+// PartitionLag 单个分区的消费延迟，Committed为-1表示该分区尚未提交过offset，此时延迟按最早offset计算
+type PartitionLag struct {
+	Topic         string
+	Partition     int32
+	Committed     int64
+	HighWaterMark int64
+	Lag           int64
+}
+
+// ListConsumerGroups 列出集群中所有消费者组，按名称排序
+func ListConsumerGroups(admin sarama.ClusterAdmin) ([]string, error) {
+	groups, err := admin.ListConsumerGroups()
+	if err != nil {
+		logger.Err(err).Msg("获取消费者组列表失败")
+		return nil, err
+	}
+
+	r := make([]string, 0, len(groups))
+	for g := range groups {
+		r = append(r, g)
+	}
+	sort.Strings(r)
+	return r, nil
+}
+
+// DescribeConsumerGroups 获取消费者组的状态和成员分配情况
+func DescribeConsumerGroups(admin sarama.ClusterAdmin, groups ...string) ([]GroupInfo, error) {
+	descs, err := admin.DescribeConsumerGroups(groups)
+	if err != nil {
+		logger.Err(err).Strs("groups", groups).Msg("获取消费者组信息失败")
+		return nil, err
+	}
+
+	r := make([]GroupInfo, 0, len(descs))
+	for _, d := range descs {
+		if d.Err != sarama.ErrNoError {
+			logger.Err(d.Err).Str("group", d.GroupId).Msg("获取消费者组信息失败")
+			return nil, d.Err
+		}
+
+		info := GroupInfo{
+			Group:    d.GroupId,
+			State:    d.State,
+			Protocol: d.Protocol,
+			Members:  make([]GroupMember, 0, len(d.Members)),
+		}
+		for id, m := range d.Members {
+			member := GroupMember{
+				MemberID:   id,
+				ClientID:   m.ClientId,
+				ClientHost: m.ClientHost,
+			}
+			if a, err := m.GetMemberAssignment(); err == nil && a != nil {
+				member.Assignment = a.Topics
+			}
+			info.Members = append(info.Members, member)
+		}
+		sort.Slice(info.Members, func(i, j int) bool { return info.Members[i].MemberID < info.Members[j].MemberID })
+		r = append(r, info)
+	}
+	return r, nil
+}
+
+// committedOffsets 获取消费者组已提交的offset，topics为空时返回该组提交过的所有topic
+func committedOffsets(admin sarama.ClusterAdmin, src OffsetSource, group string, topics []string) (map[string]map[int32]int64, error) {
+	var req map[string][]int32
+	if len(topics) > 0 {
+		req = make(map[string][]int32, len(topics))
+		for _, t := range topics {
+			ps, err := src.Partitions(t)
+			if err != nil {
+				logger.Err(err).Str("topic", t).Msg("获取分区失败")
+				return nil, err
+			}
+			req[t] = ps
+		}
+	}
+
+	rsp, err := admin.ListConsumerGroupOffsets(group, req)
+	if err != nil {
+		logger.Err(err).Str("group", group).Msg("获取消费者组offset失败")
+		return nil, err
+	}
+	if rsp.Err != sarama.ErrNoError {
+		logger.Err(rsp.Err).Str("group", group).Msg("获取消费者组offset失败")
+		return nil, rsp.Err
+	}
+
+	r := make(map[string]map[int32]int64, len(rsp.Blocks))
+	for t, ps := range rsp.Blocks {
+		r[t] = make(map[int32]int64, len(ps))
+		for p, b := range ps {
+			if b.Err != sarama.ErrNoError {
+				logger.Err(b.Err).Str("topic", t).Int32("partition", p).Msg("获取offset失败")
+				return nil, b.Err
+			}
+			r[t][p] = b.Offset
+		}
+	}
+	return r, nil
+}
+
+// ConsumerGroupLag 计算消费者组在各分区上的延迟（高水位 - 已提交offset），topics为空时计算该组提交过的所有topic
+func ConsumerGroupLag(admin sarama.ClusterAdmin, src OffsetSource, group string, topics ...string) ([]PartitionLag, error) {
+	committed, err := committedOffsets(admin, src, group, topics)
+	if err != nil {
+		return nil, err
+	}
+
+	r := make([]PartitionLag, 0)
+	for t, ps := range committed {
+		for p, off := range ps {
+			hwm, err := src.GetOffset(t, p, sarama.OffsetNewest)
+			if err != nil {
+				logger.Err(err).Str("topic", t).Int32("partition", p).Msg("获取高水位失败")
+				return nil, err
+			}
+			from := off
+			if from < 0 {
+				from, err = src.GetOffset(t, p, sarama.OffsetOldest)
+				if err != nil {
+					logger.Err(err).Str("topic", t).Int32("partition", p).Msg("获取最早offset失败")
+					return nil, err
+				}
+			}
+			lag := hwm - from
+			if lag < 0 {
+				lag = 0
+			}
+			r = append(r, PartitionLag{
+				Topic:         t,
+				Partition:     p,
+				Committed:     off,
+				HighWaterMark: hwm,
+				Lag:           lag,
+			})
+		}
+	}
+	sort.Slice(r, func(i, j int) bool {
+		if r[i].Topic != r[j].Topic {
+			return r[i].Topic < r[j].Topic
+		}
+		return r[i].Partition < r[j].Partition
+	})
+	return r, nil
+}
+
+// ResetMode offset重置方式
+type ResetMode int
+
+const (
+	// ResetEarliest 重置到分区最早的offset
+	ResetEarliest ResetMode = iota
+	// ResetLatest 重置到分区高水位
+	ResetLatest
+	// ResetToOffset 重置到指定offset，超出分区范围时取边界值
+	ResetToOffset
+	// ResetToTime 重置到指定时间之后的第一条消息，没有则取高水位
+	ResetToTime
+)
+
+func (m ResetMode) String() string {
+	switch m {
+	case ResetEarliest:
+		return "earliest"
+	case ResetLatest:
+		return "latest"
+	case ResetToOffset:
+		return "offset"
+	case ResetToTime:
+		return "timestamp"
+	}
+	return "unknown"
+}
+
+// ResetSpec offset重置参数
+//
+// Partitions指定topic下需要重置的分区，未在Partitions中出现的topic重置全部分区；
+// Topics和Partitions都为空时重置该组提交过offset的所有topic
+type ResetSpec struct {
+	Mode       ResetMode
+	Topics     []string
+	Partitions map[string][]int32
+	Offset     int64
+	Time       time.Time
+
+	// DryRun 为true时只输出重置计划，不提交offset
+	DryRun bool
+	// Out 重置计划的输出位置，默认为os.Stdout
+	Out io.Writer
+}
+
+// OffsetChange 单个分区的offset变更，Current为-1表示该分区尚未提交过offset
+type OffsetChange struct {
+	Topic     string
+	Partition int32
+	Current   int64
+	Target    int64
+}
+
+// ResetPlan 消费者组的offset重置计划
+type ResetPlan struct {
+	Group   string
+	Mode    ResetMode
+	Changes []OffsetChange
+}
+
+func (p *ResetPlan) String() string {
+	b := strings.Builder{}
+	fmt.Fprintf(&b, "GROUP %s RESET TO %s\n", p.Group, p.Mode)
+	w := tabwriter.NewWriter(&b, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "TOPIC\tPARTITION\tCURRENT\tTARGET\tDELTA")
+	for _, c := range p.Changes {
+		delta := "-"
+		if c.Current >= 0 {
+			delta = fmt.Sprint(c.Target - c.Current)
+		}
+		fmt.Fprintf(w, "%s\t%d\t%d\t%d\t%s\n", c.Topic, c.Partition, c.Current, c.Target, delta)
+	}
+	w.Flush()
+	return b.String()
+}
+
+// PlanOffsetReset 根据spec计算消费者组各分区的目标offset，不做任何修改
+func PlanOffsetReset(admin sarama.ClusterAdmin, src OffsetSource, group string, spec ResetSpec) (*ResetPlan, error) {
+	topics := append([]string{}, spec.Topics...)
+	for t := range spec.Partitions {
+		topics = append(topics, t)
+	}
+	committed, err := committedOffsets(admin, src, group, topics)
+	if err != nil {
+		return nil, err
+	}
+
+	plan := &ResetPlan{Group: group, Mode: spec.Mode}
+	for t, ps := range committed {
+		selected, ok := spec.Partitions[t]
+		if !ok {
+			selected = make([]int32, 0, len(ps))
+			for p := range ps {
+				selected = append(selected, p)
+			}
+		}
+
+		for _, p := range selected {
+			target, err := resetTarget(src, t, p, spec)
+			if err != nil {
+				return nil, err
+			}
+			cur, ok := ps[p]
+			if !ok {
+				cur = -1
+			}
+			plan.Changes = append(plan.Changes, OffsetChange{
+				Topic:     t,
+				Partition: p,
+				Current:   cur,
+				Target:    target,
+			})
+		}
+	}
+	sort.Slice(plan.Changes, func(i, j int) bool {
+		if plan.Changes[i].Topic != plan.Changes[j].Topic {
+			return plan.Changes[i].Topic < plan.Changes[j].Topic
+		}
+		return plan.Changes[i].Partition < plan.Changes[j].Partition
+	})
+	return plan, nil
+}
+
+func resetTarget(src OffsetSource, topic string, partition int32, spec ResetSpec) (int64, error) {
+	oldest, err := src.GetOffset(topic, partition, sarama.OffsetOldest)
+	if err != nil {
+		logger.Err(err).Str("topic", topic).Int32("partition", partition).Msg("获取最早offset失败")
+		return 0, err
+	}
+	newest, err := src.GetOffset(topic, partition, sarama.OffsetNewest)
+	if err != nil {
+		logger.Err(err).Str("topic", topic).Int32("partition", partition).Msg("获取高水位失败")
+		return 0, err
+	}
+
+	switch spec.Mode {
+	case ResetEarliest:
+		return oldest, nil
+	case ResetLatest:
+		return newest, nil
+	case ResetToOffset:
+		if spec.Offset < oldest {
+			return oldest, nil
+		}
+		if spec.Offset > newest {
+			return newest, nil
+		}
+		return spec.Offset, nil
+	case ResetToTime:
+		off, err := src.GetOffset(topic, partition, spec.Time.UnixMilli())
+		if err != nil {
+			logger.Err(err).Str("topic", topic).Int32("partition", partition).Msg("根据时间获取offset失败")
+			return 0, err
+		}
+		// 指定时间之后没有消息时broker返回-1
+		if off < 0 {
+			return newest, nil
+		}
+		return off, nil
+	}
+	return 0, fmt.Errorf("[mouse] -> kafka 不支持的重置方式: %d", spec.Mode)
+}
+
+// ResetGroupOffsets 重置消费者组的offset，消费者组必须没有活跃成员，返回执行（或DryRun时计划执行）的重置计划
+func ResetGroupOffsets(client sarama.Client, group string, spec ResetSpec) (*ResetPlan, error) {
+	// admin关闭时会关闭client，client由调用方负责关闭
+	admin, err := NewClusterAdmin(client)
+	if err != nil {
+		return nil, err
+	}
+
+	plan, err := PlanOffsetReset(admin, client, group, spec)
+	if err != nil {
+		return nil, err
+	}
+
+	if spec.DryRun {
+		out := spec.Out
+		if out == nil {
+			out = os.Stdout
+		}
+		fmt.Fprint(out, plan)
+		return plan, nil
+	}
+
+	infos, err := DescribeConsumerGroups(admin, group)
+	if err != nil {
+		return nil, err
+	}
+	for _, info := range infos {
+		if info.State != "Empty" && info.State != "Dead" {
+			logger.Err(ErrGroupActive).Str("group", group).Str("state", info.State).Msg("重置offset失败")
+			return nil, ErrGroupActive
+		}
+	}
+
+	if err := commitGroupOffsets(client, group, plan.Changes); err != nil {
+		return nil, err
+	}
+	for _, c := range plan.Changes {
+		logger.Info().Str("group", group).Str("topic", c.Topic).Int32("partition", c.Partition).Int64("from", c.Current).Int64("to", c.Target).Msg("重置offset")
+	}
+	return plan, nil
+}
+
+// groupOffsetCommitter 自行实现组外提交offset的客户端，如kafkatest.Cluster.NewClient返回的模拟客户端
+type groupOffsetCommitter interface {
+	CommitGroupOffsets(group string, offsets map[string]map[int32]int64) error
+}
+
+// commitGroupOffsets 以组外成员身份直接向coordinator提交offset
+func commitGroupOffsets(client sarama.Client, group string, changes []OffsetChange) error {
+	if len(changes) == 0 {
+		return nil
+	}
+
+	if gc, ok := client.(groupOffsetCommitter); ok {
+		offsets := make(map[string]map[int32]int64)
+		for _, c := range changes {
+			if offsets[c.Topic] == nil {
+				offsets[c.Topic] = make(map[int32]int64)
+			}
+			offsets[c.Topic][c.Partition] = c.Target
+		}
+		if err := gc.CommitGroupOffsets(group, offsets); err != nil {
+			logger.Err(err).Str("group", group).Msg("提交offset失败")
+			return err
+		}
+		return nil
+	}
+
+	err := client.RefreshCoordinator(group)
+	if err != nil {
+		logger.Err(err).Msg("刷新coordinator失败")
+		return err
+	}
+	crdntr, err := client.Coordinator(group)
+	if err != nil {
+		logger.Err(err).Msg("获取coordinator失败")
+		return err
+	}
+
+	req := &sarama.OffsetCommitRequest{
+		Version:                 2,
+		ConsumerGroup:           group,
+		ConsumerGroupGeneration: -1,
+		RetentionTime:           -1,
+	}
+	for _, c := range changes {
+		req.AddBlock(c.Topic, c.Partition, c.Target, -1, 0, "")
+	}
+
+	rsp, err := crdntr.CommitOffset(req)
+	if err != nil {
+		logger.Err(err).Msg("发送提交offset请求失败")
+		return err
+	}
+	for t, ps := range rsp.Errors {
+		for p, e := range ps {
+			if e != sarama.ErrNoError {
+				logger.Err(e).Str("topic", t).Int32("partition", p).Msg("提交offset失败")
+				return e
+			}
+		}
+	}
+	return nil
+}
+
+// DeleteGroupOffsets 删除消费者组在指定分区上已提交的offset，partitions为空时删除该组所有已提交的offset
+func DeleteGroupOffsets(admin sarama.ClusterAdmin, group string, partitions map[string][]int32) error {
+	if len(partitions) == 0 {
+		rsp, err := admin.ListConsumerGroupOffsets(group, nil)
+		if err != nil {
+			logger.Err(err).Str("group", group).Msg("获取消费者组offset失败")
+			return err
+		}
+		partitions = make(map[string][]int32, len(rsp.Blocks))
+		for t, ps := range rsp.Blocks {
+			for p := range ps {
+				partitions[t] = append(partitions[t], p)
+			}
+		}
+	}
+
+	for t, ps := range partitions {
+		for _, p := range ps {
+			if err := admin.DeleteConsumerGroupOffset(group, t, p); err != nil {
+				logger.Err(err).Str("topic", t).Int32("partition", p).Msg("删除offset失败")
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,136 @@
+package mkafka
+
+import (
+	"context"
+	"time"
+
+	"github.com/Shopify/sarama"
+)
+
+// 以函数的形式处理消费者组中的消息，通过Middleware在处理前后附加通用逻辑
+
+// Handler 处理单条消息，返回nil时消息被标记为已消费
+type Handler func(ctx context.Context, msg *sarama.ConsumerMessage) error
+
+// Middleware 包装Handler
+type Middleware func(Handler) Handler
+
+const (
+	// commitEvery 分区处理中每标记该数量的消息提交一次offset
+	commitEvery = 100
+	// retryBackoffMin、retryBackoffMax 处理失败后原地重试的最短和最长等待时间
+	retryBackoffMin = 100 * time.Millisecond
+	retryBackoffMax = 30 * time.Second
+)
+
+// committer 在分区处理过程中定期提交已标记的offset，每commitEvery条消息或每defaultCommitInterval提交一次，
+// 关闭自动提交时进程崩溃最多重复处理这一区间内的消息
+type committer struct {
+	sess   sarama.ConsumerGroupSession
+	ticker *time.Ticker
+	n      int
+}
+
+func newCommitter(sess sarama.ConsumerGroupSession) *committer {
+	return &committer{sess: sess, ticker: time.NewTicker(defaultCommitInterval)}
+}
+
+// mark 记录标记的消息数，累计达到commitEvery时提交
+func (c *committer) mark(n int) {
+	if c.n += n; c.n >= commitEvery {
+		c.commit()
+	}
+}
+
+func (c *committer) commit() {
+	c.sess.Commit()
+	c.n = 0
+}
+
+// stop 停止定时提交并提交剩余的offset
+func (c *committer) stop() {
+	c.ticker.Stop()
+	c.commit()
+}
+
+type groupHandler struct {
+	h Handler
+}
+
+// NewGroupHandler 将Handler适配为sarama.ConsumerGroupHandler，mws中靠前的在外层
+//
+// 消息按分区顺序处理，处理成功后标记；处理失败（包括Dedup返回的ErrDedupInFlight）时记录日志并原地重试同一条消息，
+// 等待时间从100毫秒开始翻倍，最长30秒，直到成功或会话结束，期间不会结束会话或触发rebalance，该分区之后的消息也不会被处理；
+// 会话结束时未处理成功的消息不标记，之后重新投递。需要跳过无法处理的消息时使用DeadLetter中间件。
+// 已标记的offset每100条消息或每5秒提交一次，分区处理结束时再提交一次，
+// 关闭自动提交时进程崩溃最多重复处理最近一次提交之后的消息
+func NewGroupHandler(h Handler, mws ...Middleware) sarama.ConsumerGroupHandler {
+	return &groupHandler{h: Chain(mws...)(h)}
+}
+
+func (g *groupHandler) Setup(sarama.ConsumerGroupSession) error {
+	return nil
+}
+
+func (g *groupHandler) Cleanup(sarama.ConsumerGroupSession) error {
+	return nil
+}
+
+func (g *groupHandler) ConsumeClaim(sess sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
+	c := newCommitter(sess)
+	defer c.stop()
+
+	ctx := sess.Context()
+	for {
+		select {
+		case msg, ok := <-claim.Messages():
+			if !ok {
+				return nil
+			}
+			if err := retry(ctx, g.h, msg); err != nil {
+				return nil
+			}
+			sess.MarkMessage(msg, "")
+			c.mark(1)
+		case <-c.ticker.C:
+			c.commit()
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// retry 处理消息，失败时按retryBackoffMin到retryBackoffMax之间翻倍的等待时间原地重试，
+// 直到处理成功或ctx结束，ctx结束时返回ctx的错误
+func retry(ctx context.Context, h Handler, msg *sarama.ConsumerMessage) error {
+	backoff := retryBackoffMin
+	for {
+		err := h(ctx, msg)
+		if err == nil {
+			return nil
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		logger.Err(err).Str("topic", msg.Topic).Int32("partition", msg.Partition).Int64("offset", msg.Offset).
+			Dur("backoff", backoff).Msg("处理消息失败，稍后重试")
+		if err := sleep(ctx, backoff); err != nil {
+			return err
+		}
+		if backoff *= 2; backoff > retryBackoffMax {
+			backoff = retryBackoffMax
+		}
+	}
+}
+
+// sleep 等待d或ctx结束，ctx结束时返回ctx的错误
+func sleep(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
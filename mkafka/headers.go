@@ -0,0 +1,45 @@
+package mkafka
+
+import (
+	"github.com/Shopify/sarama"
+)
+
+// 消息header相关
+
+const (
+	// HeaderDedupKey 消息去重键，同一业务事件多次投递时保持不变
+	HeaderDedupKey = "x-dedup-key"
+	// HeaderTraceParent W3C Trace Context的traceparent
+	HeaderTraceParent = "traceparent"
+	// HeaderTraceState W3C Trace Context的tracestate，原样透传
+	HeaderTraceState = "tracestate"
+	// HeaderCorrelationID 关联ID，在整条调用链上保持不变
+	HeaderCorrelationID = "x-correlation-id"
+	// HeaderProducer 发送消息的生产者标识
+	HeaderProducer = "x-producer"
+)
+
+// HeaderValue 获取消息中指定header的值，存在多个同名header时返回最后一个
+func HeaderValue(msg *sarama.ConsumerMessage, key string) ([]byte, bool) {
+	var (
+		v  []byte
+		ok bool
+	)
+	for _, h := range msg.Headers {
+		if h != nil && string(h.Key) == key {
+			v, ok = h.Value, true
+		}
+	}
+	return v, ok
+}
+
+// SetHeader 设置生产者消息的header，替换已有的同名header
+func SetHeader(msg *sarama.ProducerMessage, key string, value []byte) {
+	hs := msg.Headers[:0]
+	for _, h := range msg.Headers {
+		if string(h.Key) != key {
+			hs = append(hs, h)
+		}
+	}
+	msg.Headers = append(hs, sarama.RecordHeader{Key: []byte(key), Value: value})
+}
@@ -0,0 +1,149 @@
+package mkafka
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/Shopify/sarama"
+)
+
+// 健康检查：注册若干检查项，通过HTTP暴露给存活/就绪探针
+
+const defaultHealthTimeout = 5 * time.Second
+
+var (
+	// ErrNoBroker 集群中没有可用的broker
+	ErrNoBroker = errors.New("[mouse] -> kafka 没有可用的broker")
+	// ErrNotJoined 消费者没有加入消费者组
+	ErrNotJoined = errors.New("[mouse] -> kafka 消费者未加入消费者组")
+	// ErrCommitStale 距离上一次提交offset的时间过长
+	ErrCommitStale = errors.New("[mouse] -> kafka 长时间没有提交offset")
+)
+
+// HealthCheck 检查一项依赖是否正常，返回nil表示正常
+type HealthCheck func(ctx context.Context) error
+
+// Health 一组健康检查，实现http.Handler：全部正常时返回200，否则返回503，响应体为各检查项的结果
+//
+//	{"status":"ok","checks":{"broker":"ok","group":"[mouse] -> kafka 消费者未加入消费者组"}}
+type Health struct {
+	// Timeout 每次检查的超时时间，默认5秒
+	Timeout time.Duration
+
+	mu     sync.Mutex
+	names  []string
+	checks map[string]HealthCheck
+}
+
+// HealthReport 检查结果
+type HealthReport struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks"`
+}
+
+// NewHealth 创建空的健康检查，存活和就绪通常使用两个Health分别注册
+func NewHealth() *Health {
+	return &Health{checks: make(map[string]HealthCheck)}
+}
+
+// Register 注册检查项，同名时替换
+func (h *Health) Register(name string, check HealthCheck) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.checks[name]; !ok {
+		h.names = append(h.names, name)
+	}
+	h.checks[name] = check
+}
+
+// Check 并发执行所有检查项，返回失败的检查项
+func (h *Health) Check(ctx context.Context) map[string]error {
+	timeout := h.Timeout
+	if timeout <= 0 {
+		timeout = defaultHealthTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	h.mu.Lock()
+	checks := make(map[string]HealthCheck, len(h.checks))
+	for k, v := range h.checks {
+		checks[k] = v
+	}
+	h.mu.Unlock()
+
+	var (
+		mu   sync.Mutex
+		wg   sync.WaitGroup
+		errs = make(map[string]error)
+	)
+	for name, check := range checks {
+		wg.Add(1)
+		go func(name string, check HealthCheck) {
+			defer wg.Done()
+			if err := check(ctx); err != nil {
+				mu.Lock()
+				errs[name] = err
+				mu.Unlock()
+			}
+		}(name, check)
+	}
+	wg.Wait()
+	return errs
+}
+
+// Report 执行所有检查项并汇总结果
+func (h *Health) Report(ctx context.Context) HealthReport {
+	errs := h.Check(ctx)
+
+	h.mu.Lock()
+	names := append([]string{}, h.names...)
+	h.mu.Unlock()
+
+	r := HealthReport{Status: "ok", Checks: make(map[string]string, len(names))}
+	for _, name := range names {
+		if err, ok := errs[name]; ok {
+			r.Status = "fail"
+			r.Checks[name] = err.Error()
+			continue
+		}
+		r.Checks[name] = "ok"
+	}
+	return r
+}
+
+func (h *Health) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r := h.Report(req.Context())
+	if r.Status != "ok" {
+		logger.Warn().Interface("checks", r.Checks).Msg("健康检查失败")
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if r.Status != "ok" {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	_ = json.NewEncoder(w).Encode(r)
+}
+
+// BrokerCheck 通过DescribeCluster检查broker是否可以连通，admin可以由sarama.NewClusterAdminFromClient创建
+func BrokerCheck(admin sarama.ClusterAdmin) HealthCheck {
+	return func(ctx context.Context) error {
+		errc := make(chan error, 1)
+		go func() {
+			brokers, _, err := admin.DescribeCluster()
+			if err == nil && len(brokers) == 0 {
+				err = ErrNoBroker
+			}
+			errc <- err
+		}()
+		select {
+		case err := <-errc:
+			return err
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
@@ -38,10 +38,14 @@ func DefaultProducerConfig() *sarama.Config {
 	return conf
 }
 
-// DefaultProducer 默认生产者
-func DefaultProducer(brokers string) (sarama.SyncProducer, error) {
+// DefaultProducer 默认生产者，opts用于指定认证等额外配置
+func DefaultProducer(brokers string, opts ...Option) (sarama.SyncProducer, error) {
+	conf, err := NewProducerConfig(opts...)
+	if err != nil {
+		return nil, err
+	}
 	addrs := strings.Split(brokers, ",")
-	prd, err := sarama.NewSyncProducer(addrs, DefaultProducerConfig())
+	prd, err := sarama.NewSyncProducer(addrs, conf)
 	if err != nil {
 		logger.Err(err).Msg("创建默认生产者失败")
 		return nil, err
@@ -54,13 +58,19 @@ func DefaultConsumerConfig() *sarama.Config {
 	conf := sarama.NewConfig()
 	conf.Consumer.Offsets.AutoCommit.Enable = false
 	conf.Consumer.Offsets.Initial = sarama.OffsetOldest
+	// 提交失败等错误通过Errors()返回，Runner据此判断提交是否成功；没有读取时sarama直接丢弃
+	conf.Consumer.Return.Errors = true
 	return conf
 }
 
-// DefaultConsumerGroup 默认消费者组
-func DefaultConsumerGroup(brokers string, group string) (sarama.ConsumerGroup, error) {
+// DefaultConsumerGroup 默认消费者组，opts用于指定认证等额外配置
+func DefaultConsumerGroup(brokers string, group string, opts ...Option) (sarama.ConsumerGroup, error) {
+	conf, err := NewConsumerConfig(opts...)
+	if err != nil {
+		return nil, err
+	}
 	addrs := strings.Split(brokers, ",")
-	csm, err := sarama.NewConsumerGroup(addrs, group, DefaultProducerConfig())
+	csm, err := sarama.NewConsumerGroup(addrs, group, conf)
 	if err != nil {
 		logger.Err(err).Msg("创建默认消费者组失败")
 		return nil, err
@@ -80,107 +90,64 @@ func CustomConsumerGroup(brokers string, group string, config *sarama.Config) (s
 }
 
 // CreateKafkaClient 创建kafka客户端，使用后需关闭
-func CreateKafkaClient(brokers string, config *sarama.Config) (sarama.Client, error) {
+//
+// config为nil时使用sarama默认配置；opts应用在config的副本上，config本身不会被修改，可以在多个客户端间复用
+func CreateKafkaClient(brokers string, config *sarama.Config, opts ...Option) (sarama.Client, error) {
+	if config == nil {
+		config = sarama.NewConfig()
+	} else {
+		// 各项配置都是值或者被整体替换的指针，浅拷贝即可
+		c := *config
+		config = &c
+	}
+	if err := applyOptions(opts).applyCommon(config); err != nil {
+		return nil, err
+	}
 	addr := strings.Split(brokers, ",")
 	return sarama.NewClient(addr, config)
 }
 
-// CreateTopic 创建topic
+// CreateTopic 创建topic，请求超时时间取自客户端配置的Admin.Timeout
 func CreateTopic(client sarama.Client, topic string, partition int32, replica int16) error {
-	ctrlr, err := client.RefreshController()
+	// admin关闭时会关闭client，client由调用方负责关闭
+	admin, err := NewClusterAdmin(client)
 	if err != nil {
-		logger.Err(err).Msg("获取Controller失败")
-		return err
-	}
-	defer ctrlr.Close()
-
-	rsp, err := ctrlr.CreateTopics(&sarama.CreateTopicsRequest{
-		TopicDetails: map[string]*sarama.TopicDetail{
-			topic: {
-				NumPartitions:     partition,
-				ReplicationFactor: replica,
-			},
-		},
-		Timeout: 5 * time.Second,
-	})
-	if err != nil {
-		logger.Err(err).Msg("发送创建Topic请求失败")
 		return err
 	}
 
-	for t, e := range rsp.TopicErrors {
-		if e.Err != 0 {
-			logger.Err(e.Err).Str("topic", t).Msg("创建Topic失败")
-			return e
-		}
-	}
-	return nil
+	return CreateTopics(admin, TopicSpec{
+		Name:              topic,
+		Partitions:        partition,
+		ReplicationFactor: replica,
+	})
 }
 
 // RemoveTopic 删除topic
 func RemoveTopic(client sarama.Client, topics []string) error {
-	ctrlr, err := client.RefreshController()
+	admin, err := NewClusterAdmin(client)
 	if err != nil {
-		logger.Err(err).Msg("获取Controller失败")
 		return err
 	}
-	defer ctrlr.Close()
 
-	rsp, err := ctrlr.DeleteTopics(&sarama.DeleteTopicsRequest{
-		Topics:  topics,
-		Timeout: 5 * time.Second,
-	})
-	if err != nil {
-		logger.Err(err).Msg("发送删除Topic请求失败")
-		return err
-	}
-
-	for t, e := range rsp.TopicErrorCodes {
-		if e != 0 {
-			logger.Err(e).Str("topic", t).Msg("删除Topic失败")
-			return e
-		}
-	}
-	return nil
+	return DeleteTopics(admin, topics...)
 }
 
-// ResetConsumerGroupOffset 通过消费者组删除对某个topic的某些partition的offset
+// ResetConsumerGroupOffset 删除消费者组在指定partition上已提交的offset，allTopic为true时删除该组所有已提交的offset
+//
+// Deprecated: 该函数实际是删除offset，删除请使用DeleteGroupOffsets，重置请使用ResetGroupOffsets
 func ResetConsumerGroupOffset(client sarama.Client, group string, allTopic bool, partitions map[string][]int32) error {
-	err := client.RefreshCoordinator(group)
-	if err != nil {
-		logger.Err(err).Msg("刷新coordinator失败")
-		return err
-	}
-	crdntr, err := client.Coordinator(group)
+	// admin关闭时会关闭client，client由调用方负责关闭
+	admin, err := NewClusterAdmin(client)
 	if err != nil {
-		logger.Err(err).Msg("获取coordinator失败")
 		return err
 	}
-	defer crdntr.Close()
 
-	req := new(sarama.DeleteOffsetsRequest)
-	req.Group = group
-	for k, v := range partitions {
-		for _, sv := range v {
-			req.AddPartition(k, sv)
-		}
-	}
-	res, err := crdntr.DeleteOffsets(req)
-	if err != nil {
-		logger.Err(err).Msg("发送删除指定topic的offset请求失败")
-		return err
+	if allTopic {
+		partitions = nil
+	} else if len(partitions) == 0 {
+		return nil
 	}
-
-	for k, e := range res.Errors {
-		for p, se := range e {
-			if se != 0 {
-				logger.Err(se).Str("topic", k).Int32("partition", p).Msg("删除offset失败")
-				return se
-			}
-		}
-	}
-
-	return nil
+	return DeleteGroupOffsets(admin, group, partitions)
 }
 
 func DeleteConsumerGroup(client sarama.Client, groups []string) error {
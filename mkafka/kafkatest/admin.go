@@ -0,0 +1,403 @@
+package kafkatest
+
+import (
+	"sort"
+
+	"github.com/Shopify/sarama"
+)
+
+// brokerID 模拟集群只有一个broker
+const brokerID int32 = 1
+
+type clusterAdmin struct {
+	c *Cluster
+}
+
+// NewClusterAdmin 创建操作模拟集群的ClusterAdmin，支持topic、topic配置和消费者组相关操作，其余操作返回ErrUnsupported
+func (c *Cluster) NewClusterAdmin() sarama.ClusterAdmin {
+	return &clusterAdmin{c: c}
+}
+
+func (a *clusterAdmin) CreateTopic(topic string, detail *sarama.TopicDetail, validateOnly bool) error {
+	a.c.mu.Lock()
+	defer a.c.mu.Unlock()
+
+	if _, ok := a.c.topics[topic]; ok {
+		return &sarama.TopicError{Err: sarama.ErrTopicAlreadyExists}
+	}
+	if validateOnly {
+		return nil
+	}
+	var (
+		partitions  int32 = -1
+		replication int16 = -1
+	)
+	configs := make(map[string]string)
+	if detail != nil {
+		partitions, replication = detail.NumPartitions, detail.ReplicationFactor
+		for k, v := range detail.ConfigEntries {
+			if v != nil {
+				configs[k] = *v
+			}
+		}
+	}
+	if err := a.c.createTopic(topic, partitions, replication, configs); err != nil {
+		return &sarama.TopicError{Err: err.(sarama.KError)}
+	}
+	return nil
+}
+
+func (a *clusterAdmin) ListTopics() (map[string]sarama.TopicDetail, error) {
+	a.c.mu.Lock()
+	defer a.c.mu.Unlock()
+
+	r := make(map[string]sarama.TopicDetail, len(a.c.topics))
+	for name, t := range a.c.topics {
+		d := sarama.TopicDetail{
+			NumPartitions:     int32(len(t.partitions)),
+			ReplicationFactor: t.replication,
+			ReplicaAssignment: make(map[int32][]int32, len(t.partitions)),
+			ConfigEntries:     make(map[string]*string, len(t.configs)),
+		}
+		for i := range t.partitions {
+			d.ReplicaAssignment[int32(i)] = []int32{brokerID}
+		}
+		for k, v := range t.configs {
+			v := v
+			d.ConfigEntries[k] = &v
+		}
+		r[name] = d
+	}
+	return r, nil
+}
+
+func (a *clusterAdmin) DescribeTopics(topics []string) ([]*sarama.TopicMetadata, error) {
+	a.c.mu.Lock()
+	defer a.c.mu.Unlock()
+
+	if len(topics) == 0 {
+		for name := range a.c.topics {
+			topics = append(topics, name)
+		}
+		sort.Strings(topics)
+	}
+
+	r := make([]*sarama.TopicMetadata, 0, len(topics))
+	for _, name := range topics {
+		md := &sarama.TopicMetadata{Name: name}
+		t, ok := a.c.topics[name]
+		if !ok {
+			md.Err = sarama.ErrUnknownTopicOrPartition
+			r = append(r, md)
+			continue
+		}
+		for i := range t.partitions {
+			md.Partitions = append(md.Partitions, &sarama.PartitionMetadata{
+				ID:       int32(i),
+				Leader:   brokerID,
+				Replicas: []int32{brokerID},
+				Isr:      []int32{brokerID},
+			})
+		}
+		r = append(r, md)
+	}
+	return r, nil
+}
+
+func (a *clusterAdmin) DeleteTopic(topic string) error {
+	a.c.mu.Lock()
+	defer a.c.mu.Unlock()
+
+	if _, ok := a.c.topics[topic]; !ok {
+		return sarama.ErrUnknownTopicOrPartition
+	}
+	delete(a.c.topics, topic)
+	for _, g := range a.c.groups {
+		delete(g.offsets, topic)
+	}
+	a.c.rebalanceSubscribers(topic)
+	return nil
+}
+
+func (a *clusterAdmin) CreatePartitions(topic string, count int32, assignment [][]int32, validateOnly bool) error {
+	a.c.mu.Lock()
+	defer a.c.mu.Unlock()
+
+	t, ok := a.c.topics[topic]
+	if !ok {
+		return &sarama.TopicPartitionError{Err: sarama.ErrUnknownTopicOrPartition}
+	}
+	if int(count) <= len(t.partitions) {
+		return &sarama.TopicPartitionError{Err: sarama.ErrInvalidPartitions}
+	}
+	if validateOnly {
+		return nil
+	}
+	t.partitions = append(t.partitions, make([][]*sarama.ConsumerMessage, int(count)-len(t.partitions))...)
+	a.c.rebalanceSubscribers(topic)
+	return nil
+}
+
+func (a *clusterAdmin) AlterPartitionReassignments(topic string, assignment [][]int32) error {
+	return ErrUnsupported
+}
+
+func (a *clusterAdmin) ListPartitionReassignments(topics string, partitions []int32) (map[string]map[int32]*sarama.PartitionReplicaReassignmentsStatus, error) {
+	return nil, ErrUnsupported
+}
+
+func (a *clusterAdmin) DeleteRecords(topic string, partitionOffsets map[int32]int64) error {
+	return ErrUnsupported
+}
+
+// DescribeConfig 只返回显式设置过的topic配置项
+func (a *clusterAdmin) DescribeConfig(resource sarama.ConfigResource) ([]sarama.ConfigEntry, error) {
+	if resource.Type != sarama.TopicResource {
+		return nil, ErrUnsupported
+	}
+
+	a.c.mu.Lock()
+	defer a.c.mu.Unlock()
+
+	t, ok := a.c.topics[resource.Name]
+	if !ok {
+		return nil, sarama.ErrUnknownTopicOrPartition
+	}
+	r := make([]sarama.ConfigEntry, 0, len(t.configs))
+	for k, v := range t.configs {
+		r = append(r, sarama.ConfigEntry{
+			Name:   k,
+			Value:  v,
+			Source: sarama.SourceTopic,
+		})
+	}
+	sort.Slice(r, func(i, j int) bool { return r[i].Name < r[j].Name })
+	return r, nil
+}
+
+func (a *clusterAdmin) AlterConfig(resourceType sarama.ConfigResourceType, name string, entries map[string]*string, validateOnly bool) error {
+	if resourceType != sarama.TopicResource {
+		return ErrUnsupported
+	}
+
+	a.c.mu.Lock()
+	defer a.c.mu.Unlock()
+
+	t, ok := a.c.topics[name]
+	if !ok {
+		return sarama.ErrUnknownTopicOrPartition
+	}
+	if validateOnly {
+		return nil
+	}
+	t.configs = make(map[string]string, len(entries))
+	for k, v := range entries {
+		if v != nil {
+			t.configs[k] = *v
+		}
+	}
+	return nil
+}
+
+func (a *clusterAdmin) IncrementalAlterConfig(resourceType sarama.ConfigResourceType, name string, entries map[string]sarama.IncrementalAlterConfigsEntry, validateOnly bool) error {
+	if resourceType != sarama.TopicResource {
+		return ErrUnsupported
+	}
+
+	a.c.mu.Lock()
+	defer a.c.mu.Unlock()
+
+	t, ok := a.c.topics[name]
+	if !ok {
+		return sarama.ErrUnknownTopicOrPartition
+	}
+	for _, e := range entries {
+		if e.Operation != sarama.IncrementalAlterConfigsOperationSet && e.Operation != sarama.IncrementalAlterConfigsOperationDelete {
+			return ErrUnsupported
+		}
+	}
+	if validateOnly {
+		return nil
+	}
+	for k, e := range entries {
+		if e.Operation == sarama.IncrementalAlterConfigsOperationDelete || e.Value == nil {
+			delete(t.configs, k)
+			continue
+		}
+		t.configs[k] = *e.Value
+	}
+	return nil
+}
+
+func (a *clusterAdmin) CreateACL(resource sarama.Resource, acl sarama.Acl) error {
+	return ErrUnsupported
+}
+
+func (a *clusterAdmin) CreateACLs([]*sarama.ResourceAcls) error {
+	return ErrUnsupported
+}
+
+func (a *clusterAdmin) ListAcls(filter sarama.AclFilter) ([]sarama.ResourceAcls, error) {
+	return nil, ErrUnsupported
+}
+
+func (a *clusterAdmin) DeleteACL(filter sarama.AclFilter, validateOnly bool) ([]sarama.MatchingAcl, error) {
+	return nil, ErrUnsupported
+}
+
+func (a *clusterAdmin) ListConsumerGroups() (map[string]string, error) {
+	a.c.mu.Lock()
+	defer a.c.mu.Unlock()
+
+	r := make(map[string]string, len(a.c.groups))
+	for name := range a.c.groups {
+		r[name] = "consumer"
+	}
+	return r, nil
+}
+
+func (a *clusterAdmin) DescribeConsumerGroups(groups []string) ([]*sarama.GroupDescription, error) {
+	a.c.mu.Lock()
+	defer a.c.mu.Unlock()
+
+	r := make([]*sarama.GroupDescription, 0, len(groups))
+	for _, name := range groups {
+		d := &sarama.GroupDescription{
+			GroupId:      name,
+			State:        "Dead",
+			ProtocolType: "consumer",
+			Members:      make(map[string]*sarama.GroupMemberDescription),
+		}
+		g, ok := a.c.groups[name]
+		if !ok {
+			r = append(r, d)
+			continue
+		}
+
+		d.State = "Empty"
+		if len(g.members) > 0 {
+			d.State = "Stable"
+			d.Protocol = "range"
+		}
+		for id, m := range g.members {
+			// 借助SyncGroupRequest编码分配结果，与真实broker返回的格式一致
+			req := &sarama.SyncGroupRequest{}
+			if err := req.AddGroupAssignmentMember(id, &sarama.ConsumerGroupMemberAssignment{
+				Topics: g.gen.assignments[id],
+			}); err != nil {
+				return nil, err
+			}
+			d.Members[id] = &sarama.GroupMemberDescription{
+				MemberId:         id,
+				ClientId:         m.clientID,
+				ClientHost:       "/127.0.0.1",
+				MemberAssignment: req.GroupAssignments[0].Assignment,
+			}
+		}
+		r = append(r, d)
+	}
+	return r, nil
+}
+
+func (a *clusterAdmin) ListConsumerGroupOffsets(group string, topicPartitions map[string][]int32) (*sarama.OffsetFetchResponse, error) {
+	a.c.mu.Lock()
+	defer a.c.mu.Unlock()
+
+	rsp := &sarama.OffsetFetchResponse{Version: 2}
+	var offsets map[string]map[int32]int64
+	if g, ok := a.c.groups[group]; ok {
+		offsets = g.offsets
+	}
+
+	if topicPartitions == nil {
+		for t, ps := range offsets {
+			for p, off := range ps {
+				rsp.AddBlock(t, p, &sarama.OffsetFetchResponseBlock{Offset: off, LeaderEpoch: -1})
+			}
+		}
+		return rsp, nil
+	}
+
+	for t, ps := range topicPartitions {
+		for _, p := range ps {
+			off, ok := offsets[t][p]
+			if !ok {
+				off = -1
+			}
+			rsp.AddBlock(t, p, &sarama.OffsetFetchResponseBlock{Offset: off, LeaderEpoch: -1})
+		}
+	}
+	return rsp, nil
+}
+
+func (a *clusterAdmin) DeleteConsumerGroupOffset(group string, topic string, partition int32) error {
+	a.c.mu.Lock()
+	defer a.c.mu.Unlock()
+
+	g, ok := a.c.groups[group]
+	if !ok {
+		return sarama.ErrGroupIDNotFound
+	}
+	for _, m := range g.members {
+		if contains(m.topics, topic) {
+			return sarama.ErrGroupSubscribedToTopic
+		}
+	}
+	delete(g.offsets[topic], partition)
+	return nil
+}
+
+func (a *clusterAdmin) DeleteConsumerGroup(group string) error {
+	a.c.mu.Lock()
+	defer a.c.mu.Unlock()
+
+	g, ok := a.c.groups[group]
+	if !ok {
+		return sarama.ErrGroupIDNotFound
+	}
+	if len(g.members) > 0 {
+		return sarama.ErrNonEmptyGroup
+	}
+	delete(a.c.groups, group)
+	return nil
+}
+
+func (a *clusterAdmin) DescribeCluster() ([]*sarama.Broker, int32, error) {
+	return []*sarama.Broker{sarama.NewBroker("kafkatest:9092")}, brokerID, nil
+}
+
+func (a *clusterAdmin) DescribeLogDirs(brokers []int32) (map[int32][]sarama.DescribeLogDirsResponseDirMetadata, error) {
+	return nil, ErrUnsupported
+}
+
+func (a *clusterAdmin) DescribeUserScramCredentials(users []string) ([]*sarama.DescribeUserScramCredentialsResult, error) {
+	return nil, ErrUnsupported
+}
+
+func (a *clusterAdmin) DeleteUserScramCredentials(delete []sarama.AlterUserScramCredentialsDelete) ([]*sarama.AlterUserScramCredentialsResult, error) {
+	return nil, ErrUnsupported
+}
+
+func (a *clusterAdmin) UpsertUserScramCredentials(upsert []sarama.AlterUserScramCredentialsUpsert) ([]*sarama.AlterUserScramCredentialsResult, error) {
+	return nil, ErrUnsupported
+}
+
+func (a *clusterAdmin) DescribeClientQuotas(components []sarama.QuotaFilterComponent, strict bool) ([]sarama.DescribeClientQuotasEntry, error) {
+	return nil, ErrUnsupported
+}
+
+func (a *clusterAdmin) AlterClientQuotas(entity []sarama.QuotaEntityComponent, op sarama.ClientQuotasOp, validateOnly bool) error {
+	return ErrUnsupported
+}
+
+func (a *clusterAdmin) Controller() (*sarama.Broker, error) {
+	return nil, ErrUnsupported
+}
+
+func (a *clusterAdmin) RemoveMemberFromConsumerGroup(groupId string, groupInstanceIds []string) (*sarama.LeaveGroupResponse, error) {
+	return nil, ErrUnsupported
+}
+
+func (a *clusterAdmin) Close() error {
+	return nil
+}
@@ -0,0 +1,167 @@
+package kafkatest
+
+import (
+	"sync/atomic"
+
+	"github.com/Shopify/sarama"
+)
+
+// client 模拟集群的sarama.Client，用于测试接收sarama.Client的函数（如mkafka.CreateTopic）；
+// mkafka.NewClusterAdmin对其返回操作模拟集群的ClusterAdmin，mkafka.ResetGroupOffsets通过CommitGroupOffsets提交offset；
+// Topics、Partitions、WritablePartitions和GetOffset转发给模拟集群，涉及broker的其余方法返回ErrUnsupported
+type client struct {
+	c      *Cluster
+	conf   *sarama.Config
+	closed int32
+}
+
+// NewClient 创建模拟集群的客户端
+func (c *Cluster) NewClient() sarama.Client {
+	return &client{c: c, conf: sarama.NewConfig()}
+}
+
+// ClusterAdmin 供mkafka.NewClusterAdmin使用
+func (cl *client) ClusterAdmin() sarama.ClusterAdmin {
+	return cl.c.NewClusterAdmin()
+}
+
+func (cl *client) Config() *sarama.Config {
+	return cl.conf
+}
+
+func (cl *client) Topics() ([]string, error) {
+	if cl.Closed() {
+		return nil, sarama.ErrClosedClient
+	}
+	return cl.c.Topics(), nil
+}
+
+func (cl *client) Partitions(topic string) ([]int32, error) {
+	if cl.Closed() {
+		return nil, sarama.ErrClosedClient
+	}
+	return cl.c.Partitions(topic)
+}
+
+// WritablePartitions 模拟集群的分区总是可写
+func (cl *client) WritablePartitions(topic string) ([]int32, error) {
+	return cl.Partitions(topic)
+}
+
+func (cl *client) GetOffset(topic string, partitionID int32, time int64) (int64, error) {
+	if cl.Closed() {
+		return -1, sarama.ErrClosedClient
+	}
+	return cl.c.GetOffset(topic, partitionID, time)
+}
+
+// CommitGroupOffsets 供mkafka.ResetGroupOffsets以组外成员身份提交offset；与broker相同，消费者组有活跃成员时拒绝提交
+func (cl *client) CommitGroupOffsets(group string, offsets map[string]map[int32]int64) error {
+	if cl.Closed() {
+		return sarama.ErrClosedClient
+	}
+
+	c := cl.c
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	g := c.group(group)
+	if len(g.members) > 0 {
+		return sarama.ErrUnknownMemberId
+	}
+	for t, ps := range offsets {
+		tp, ok := c.topics[t]
+		if !ok {
+			return sarama.ErrUnknownTopicOrPartition
+		}
+		for p := range ps {
+			if p < 0 || int(p) >= len(tp.partitions) {
+				return sarama.ErrUnknownTopicOrPartition
+			}
+		}
+	}
+	for t, ps := range offsets {
+		if g.offsets[t] == nil {
+			g.offsets[t] = make(map[int32]int64)
+		}
+		for p, off := range ps {
+			g.offsets[t][p] = off
+		}
+	}
+	return nil
+}
+
+func (cl *client) Controller() (*sarama.Broker, error) {
+	return nil, ErrUnsupported
+}
+
+func (cl *client) RefreshController() (*sarama.Broker, error) {
+	return nil, ErrUnsupported
+}
+
+func (cl *client) Brokers() []*sarama.Broker {
+	return nil
+}
+
+func (cl *client) Broker(brokerID int32) (*sarama.Broker, error) {
+	return nil, ErrUnsupported
+}
+
+func (cl *client) Leader(topic string, partitionID int32) (*sarama.Broker, error) {
+	return nil, ErrUnsupported
+}
+
+func (cl *client) Replicas(topic string, partitionID int32) ([]int32, error) {
+	return nil, ErrUnsupported
+}
+
+func (cl *client) InSyncReplicas(topic string, partitionID int32) ([]int32, error) {
+	return nil, ErrUnsupported
+}
+
+func (cl *client) OfflineReplicas(topic string, partitionID int32) ([]int32, error) {
+	return nil, ErrUnsupported
+}
+
+func (cl *client) RefreshBrokers(addrs []string) error {
+	return ErrUnsupported
+}
+
+func (cl *client) RefreshMetadata(topics ...string) error {
+	return ErrUnsupported
+}
+
+func (cl *client) Coordinator(consumerGroup string) (*sarama.Broker, error) {
+	return nil, ErrUnsupported
+}
+
+func (cl *client) RefreshCoordinator(consumerGroup string) error {
+	return ErrUnsupported
+}
+
+func (cl *client) TransactionCoordinator(transactionID string) (*sarama.Broker, error) {
+	return nil, ErrUnsupported
+}
+
+func (cl *client) RefreshTransactionCoordinator(transactionID string) error {
+	return ErrUnsupported
+}
+
+func (cl *client) InitProducerID() (*sarama.InitProducerIDResponse, error) {
+	return nil, ErrUnsupported
+}
+
+func (cl *client) LeastLoadedBroker() *sarama.Broker {
+	return nil
+}
+
+func (cl *client) Close() error {
+	if !atomic.CompareAndSwapInt32(&cl.closed, 0, 1) {
+		return sarama.ErrClosedClient
+	}
+	return nil
+}
+
+func (cl *client) Closed() bool {
+	return atomic.LoadInt32(&cl.closed) == 1
+}
@@ -0,0 +1,244 @@
+// Package kafkatest 提供进程内的kafka模拟集群，用于在没有broker的环境下测试基于mkafka/sarama的代码
+//
+// 模拟集群实现了sarama.SyncProducer、sarama.Consumer、sarama.ConsumerGroup和sarama.ClusterAdmin中常用的部分，
+// 以及mkafka.OffsetSource，消息只保存在内存中；NewClient返回的客户端可以传给接收sarama.Client的mkafka管理函数
+package kafkatest
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Shopify/sarama"
+)
+
+// ErrUnsupported 模拟集群不支持的操作
+var ErrUnsupported = errors.New("[mouse] -> kafkatest 不支持的操作")
+
+// Cluster 进程内的kafka模拟集群，所有方法并发安全
+type Cluster struct {
+	mu     sync.Mutex
+	topics map[string]*topic
+	groups map[string]*group
+
+	// notify 在有新消息或消费状态变化时关闭并替换，用于唤醒等待中的消费者
+	notify chan struct{}
+
+	autoCreate int32
+	members    int
+}
+
+type topic struct {
+	replication int16
+	configs     map[string]string
+	partitions  [][]*sarama.ConsumerMessage
+}
+
+// NewCluster 创建空的模拟集群，向不存在的topic发送消息时自动创建单分区topic
+func NewCluster() *Cluster {
+	return &Cluster{
+		topics:     make(map[string]*topic),
+		groups:     make(map[string]*group),
+		notify:     make(chan struct{}),
+		autoCreate: 1,
+	}
+}
+
+// SetAutoCreateTopics 设置自动创建topic时的分区数，为0时关闭自动创建
+func (c *Cluster) SetAutoCreateTopics(partitions int32) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.autoCreate = partitions
+}
+
+// CreateTopic 创建topic，已存在时返回sarama.ErrTopicAlreadyExists
+func (c *Cluster) CreateTopic(name string, partitions int32) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.createTopic(name, partitions, 1, nil)
+}
+
+func (c *Cluster) createTopic(name string, partitions int32, replication int16, configs map[string]string) error {
+	if name == "" {
+		return sarama.ErrInvalidTopic
+	}
+	if _, ok := c.topics[name]; ok {
+		return sarama.ErrTopicAlreadyExists
+	}
+	if partitions <= 0 {
+		partitions = 1
+	}
+	if replication <= 0 {
+		replication = 1
+	}
+	if configs == nil {
+		configs = make(map[string]string)
+	}
+	c.topics[name] = &topic{
+		replication: replication,
+		configs:     configs,
+		partitions:  make([][]*sarama.ConsumerMessage, partitions),
+	}
+	c.rebalanceSubscribers(name)
+	return nil
+}
+
+// Topics 返回所有topic名称，按名称排序
+func (c *Cluster) Topics() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	r := make([]string, 0, len(c.topics))
+	for name := range c.topics {
+		r = append(r, name)
+	}
+	sort.Strings(r)
+	return r
+}
+
+// Messages 返回分区中的所有消息的副本，用于断言
+func (c *Cluster) Messages(topic string, partition int32) []*sarama.ConsumerMessage {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	t, ok := c.topics[topic]
+	if !ok || partition < 0 || int(partition) >= len(t.partitions) {
+		return nil
+	}
+	r := make([]*sarama.ConsumerMessage, 0, len(t.partitions[partition]))
+	for _, m := range t.partitions[partition] {
+		cp := *m
+		r = append(r, &cp)
+	}
+	return r
+}
+
+// SetCommitError 使消费者组之后的提交失败并通过Errors()返回err，err为nil时恢复正常
+func (c *Cluster) SetCommitError(group string, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.group(group).commitErr = err
+}
+
+// CommittedOffset 返回消费者组在分区上已提交的offset，未提交时返回-1
+func (c *Cluster) CommittedOffset(group, topic string, partition int32) int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	g, ok := c.groups[group]
+	if !ok {
+		return -1
+	}
+	if off, ok := g.offsets[topic][partition]; ok {
+		return off
+	}
+	return -1
+}
+
+// Partitions 返回topic的分区列表，实现mkafka.OffsetSource
+func (c *Cluster) Partitions(topic string) ([]int32, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	t, ok := c.topics[topic]
+	if !ok {
+		return nil, sarama.ErrUnknownTopicOrPartition
+	}
+	r := make([]int32, len(t.partitions))
+	for i := range r {
+		r[i] = int32(i)
+	}
+	return r, nil
+}
+
+// GetOffset 查询分区offset，time可以是sarama.OffsetNewest、sarama.OffsetOldest或毫秒时间戳，实现mkafka.OffsetSource
+func (c *Cluster) GetOffset(topic string, partitionID int32, time int64) (int64, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	t, ok := c.topics[topic]
+	if !ok || partitionID < 0 || int(partitionID) >= len(t.partitions) {
+		return -1, sarama.ErrUnknownTopicOrPartition
+	}
+	log := t.partitions[partitionID]
+	switch time {
+	case sarama.OffsetNewest:
+		return int64(len(log)), nil
+	case sarama.OffsetOldest:
+		return 0, nil
+	}
+	for _, m := range log {
+		if m.Timestamp.UnixMilli() >= time {
+			return m.Offset, nil
+		}
+	}
+	return -1, nil
+}
+
+// append 追加消息并唤醒消费者，调用方需持有锁
+func (c *Cluster) append(msg *sarama.ProducerMessage, partitioner sarama.Partitioner) error {
+	t, ok := c.topics[msg.Topic]
+	if !ok {
+		if c.autoCreate <= 0 {
+			return sarama.ErrUnknownTopicOrPartition
+		}
+		if err := c.createTopic(msg.Topic, c.autoCreate, 1, nil); err != nil {
+			return err
+		}
+		t = c.topics[msg.Topic]
+	}
+
+	if partitioner != nil {
+		p, err := partitioner.Partition(msg, int32(len(t.partitions)))
+		if err != nil {
+			return err
+		}
+		msg.Partition = p
+	}
+	if msg.Partition < 0 || int(msg.Partition) >= len(t.partitions) {
+		return sarama.ErrInvalidPartition
+	}
+
+	cm := &sarama.ConsumerMessage{
+		Topic:     msg.Topic,
+		Partition: msg.Partition,
+		Timestamp: msg.Timestamp,
+	}
+	if cm.Timestamp.IsZero() {
+		cm.Timestamp = time.Now()
+	}
+	if msg.Key != nil {
+		b, err := msg.Key.Encode()
+		if err != nil {
+			return err
+		}
+		cm.Key = b
+	}
+	if msg.Value != nil {
+		b, err := msg.Value.Encode()
+		if err != nil {
+			return err
+		}
+		cm.Value = b
+	}
+	for i := range msg.Headers {
+		h := msg.Headers[i]
+		cm.Headers = append(cm.Headers, &h)
+	}
+
+	log := t.partitions[msg.Partition]
+	cm.Offset = int64(len(log))
+	t.partitions[msg.Partition] = append(log, cm)
+	msg.Offset = cm.Offset
+	msg.Timestamp = cm.Timestamp
+
+	c.wake()
+	return nil
+}
+
+// wake 唤醒所有等待中的消费者，调用方需持有锁
+func (c *Cluster) wake() {
+	close(c.notify)
+	c.notify = make(chan struct{})
+}
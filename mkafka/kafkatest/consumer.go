@@ -0,0 +1,217 @@
+package kafkatest
+
+import (
+	"context"
+	"sync"
+
+	"github.com/Shopify/sarama"
+)
+
+type consumer struct {
+	c *Cluster
+
+	mu         sync.Mutex
+	partitions []*partitionConsumer
+}
+
+// NewConsumer 创建直接消费分区的消费者，conf为nil时使用sarama默认配置
+func (c *Cluster) NewConsumer(conf *sarama.Config) sarama.Consumer {
+	return &consumer{c: c}
+}
+
+func (cs *consumer) Topics() ([]string, error) {
+	return cs.c.Topics(), nil
+}
+
+func (cs *consumer) Partitions(topic string) ([]int32, error) {
+	return cs.c.Partitions(topic)
+}
+
+func (cs *consumer) ConsumePartition(topic string, partition int32, offset int64) (sarama.PartitionConsumer, error) {
+	hwm, err := cs.c.GetOffset(topic, partition, sarama.OffsetNewest)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case offset == sarama.OffsetNewest:
+		offset = hwm
+	case offset == sarama.OffsetOldest:
+		offset = 0
+	case offset < 0 || offset > hwm:
+		return nil, sarama.ErrOffsetOutOfRange
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	pc := &partitionConsumer{
+		c:         cs.c,
+		topic:     topic,
+		partition: partition,
+		msgs:      make(chan *sarama.ConsumerMessage),
+		errors:    make(chan *sarama.ConsumerError),
+		cancel:    cancel,
+		done:      make(chan struct{}),
+	}
+	go pc.feed(ctx, offset)
+
+	cs.mu.Lock()
+	cs.partitions = append(cs.partitions, pc)
+	cs.mu.Unlock()
+	return pc, nil
+}
+
+func (cs *consumer) HighWaterMarks() map[string]map[int32]int64 {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	r := make(map[string]map[int32]int64)
+	for _, pc := range cs.partitions {
+		if r[pc.topic] == nil {
+			r[pc.topic] = make(map[int32]int64)
+		}
+		r[pc.topic][pc.partition] = pc.HighWaterMarkOffset()
+	}
+	return r
+}
+
+func (cs *consumer) Close() error {
+	cs.mu.Lock()
+	ps := cs.partitions
+	cs.partitions = nil
+	cs.mu.Unlock()
+
+	for _, pc := range ps {
+		pc.Close()
+	}
+	return nil
+}
+
+func (cs *consumer) each(topicPartitions map[string][]int32, fn func(*partitionConsumer)) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	for _, pc := range cs.partitions {
+		if topicPartitions == nil {
+			fn(pc)
+			continue
+		}
+		for _, p := range topicPartitions[pc.topic] {
+			if p == pc.partition {
+				fn(pc)
+			}
+		}
+	}
+}
+
+func (cs *consumer) Pause(topicPartitions map[string][]int32) {
+	cs.each(topicPartitions, (*partitionConsumer).Pause)
+}
+
+func (cs *consumer) Resume(topicPartitions map[string][]int32) {
+	cs.each(topicPartitions, (*partitionConsumer).Resume)
+}
+
+func (cs *consumer) PauseAll() {
+	cs.each(nil, (*partitionConsumer).Pause)
+}
+
+func (cs *consumer) ResumeAll() {
+	cs.each(nil, (*partitionConsumer).Resume)
+}
+
+type partitionConsumer struct {
+	c         *Cluster
+	topic     string
+	partition int32
+	msgs      chan *sarama.ConsumerMessage
+	errors    chan *sarama.ConsumerError
+
+	cancel    context.CancelFunc
+	done      chan struct{}
+	closeOnce sync.Once
+
+	mu     sync.Mutex
+	paused bool
+}
+
+// feed 将分区中从offset开始的消息依次送入消息channel，关闭时关闭channel
+func (pc *partitionConsumer) feed(ctx context.Context, off int64) {
+	defer close(pc.done)
+	defer close(pc.errors)
+	defer close(pc.msgs)
+
+	c := pc.c
+	for {
+		c.mu.Lock()
+		wait := c.notify
+		var msg *sarama.ConsumerMessage
+		if t, ok := c.topics[pc.topic]; ok && !pc.IsPaused() {
+			if log := t.partitions[pc.partition]; off < int64(len(log)) {
+				cp := *log[off]
+				msg = &cp
+			}
+		}
+		c.mu.Unlock()
+
+		if msg == nil {
+			select {
+			case <-wait:
+				continue
+			case <-ctx.Done():
+				return
+			}
+		}
+
+		select {
+		case pc.msgs <- msg:
+			off++
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (pc *partitionConsumer) AsyncClose() {
+	pc.closeOnce.Do(pc.cancel)
+}
+
+func (pc *partitionConsumer) Close() error {
+	pc.AsyncClose()
+	<-pc.done
+	return nil
+}
+
+func (pc *partitionConsumer) Messages() <-chan *sarama.ConsumerMessage {
+	return pc.msgs
+}
+
+func (pc *partitionConsumer) Errors() <-chan *sarama.ConsumerError {
+	return pc.errors
+}
+
+func (pc *partitionConsumer) HighWaterMarkOffset() int64 {
+	off, _ := pc.c.GetOffset(pc.topic, pc.partition, sarama.OffsetNewest)
+	return off
+}
+
+func (pc *partitionConsumer) Pause() {
+	pc.setPaused(true)
+}
+
+func (pc *partitionConsumer) Resume() {
+	pc.setPaused(false)
+}
+
+func (pc *partitionConsumer) setPaused(paused bool) {
+	pc.mu.Lock()
+	pc.paused = paused
+	pc.mu.Unlock()
+
+	pc.c.mu.Lock()
+	pc.c.wake()
+	pc.c.mu.Unlock()
+}
+
+func (pc *partitionConsumer) IsPaused() bool {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	return pc.paused
+}
@@ -0,0 +1,506 @@
+package kafkatest
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/Shopify/sarama"
+)
+
+// group 消费者组状态，由Cluster的锁保护
+type group struct {
+	members map[string]*member
+	gen     *generation
+	offsets map[string]map[int32]int64
+	// commitErr 不为nil时提交失败
+	commitErr error
+}
+
+type member struct {
+	clientID string
+	topics   []string
+	paused   map[string]map[int32]bool
+}
+
+// generation 一次分区分配的结果，成员变化时关闭done并生成新的generation
+type generation struct {
+	id          int32
+	done        chan struct{}
+	assignments map[string]map[string][]int32
+}
+
+// group 获取消费者组，不存在则创建，调用方需持有锁
+func (c *Cluster) group(name string) *group {
+	g, ok := c.groups[name]
+	if !ok {
+		g = &group{
+			members: make(map[string]*member),
+			gen:     &generation{done: make(chan struct{})},
+			offsets: make(map[string]map[int32]int64),
+		}
+		c.groups[name] = g
+	}
+	return g
+}
+
+// rebalance 按range策略重新分配分区，调用方需持有锁
+func (c *Cluster) rebalance(g *group) {
+	close(g.gen.done)
+
+	subscribers := make(map[string][]string)
+	for id, m := range g.members {
+		for _, t := range m.topics {
+			subscribers[t] = append(subscribers[t], id)
+		}
+	}
+
+	assignments := make(map[string]map[string][]int32, len(g.members))
+	for id := range g.members {
+		assignments[id] = make(map[string][]int32)
+	}
+	for name, ids := range subscribers {
+		t, ok := c.topics[name]
+		if !ok {
+			continue
+		}
+		sort.Strings(ids)
+		n, m := len(t.partitions), len(ids)
+		p := 0
+		for i, id := range ids {
+			cnt := n / m
+			if i < n%m {
+				cnt++
+			}
+			for j := 0; j < cnt; j++ {
+				assignments[id][name] = append(assignments[id][name], int32(p))
+				p++
+			}
+		}
+	}
+
+	g.gen = &generation{
+		id:          g.gen.id + 1,
+		done:        make(chan struct{}),
+		assignments: assignments,
+	}
+	c.wake()
+}
+
+// rebalanceSubscribers 对订阅了topic的消费者组重新分配分区，调用方需持有锁
+func (c *Cluster) rebalanceSubscribers(topic string) {
+	for _, g := range c.groups {
+		for _, m := range g.members {
+			if contains(m.topics, topic) {
+				c.rebalance(g)
+				break
+			}
+		}
+	}
+}
+
+func contains(ss []string, s string) bool {
+	for _, v := range ss {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+type consumerGroup struct {
+	c        *Cluster
+	group    string
+	memberID string
+	conf     *sarama.Config
+	errors   chan error
+
+	mu        sync.Mutex
+	closed    chan struct{}
+	closeOnce sync.Once
+	running   sync.WaitGroup
+}
+
+// NewConsumerGroup 创建加入模拟集群中消费者组的消费者，conf为nil时使用sarama默认配置
+//
+// 成员在第一次调用Consume时加入消费者组，Close时离开，成员变化会触发组内所有成员的rebalance；
+// 起始offset、自动提交和错误返回遵循conf中的Consumer配置
+func (c *Cluster) NewConsumerGroup(groupID string, conf *sarama.Config) sarama.ConsumerGroup {
+	if conf == nil {
+		conf = sarama.NewConfig()
+	}
+
+	c.mu.Lock()
+	c.members++
+	id := fmt.Sprintf("%s-%d", conf.ClientID, c.members)
+	c.mu.Unlock()
+
+	return &consumerGroup{
+		c:        c,
+		group:    groupID,
+		memberID: id,
+		conf:     conf,
+		errors:   make(chan error, conf.ChannelBufferSize),
+		closed:   make(chan struct{}),
+	}
+}
+
+func (cg *consumerGroup) Consume(ctx context.Context, topics []string, handler sarama.ConsumerGroupHandler) error {
+	cg.mu.Lock()
+	select {
+	case <-cg.closed:
+		cg.mu.Unlock()
+		return sarama.ErrClosedConsumerGroup
+	default:
+	}
+	cg.running.Add(1)
+	cg.mu.Unlock()
+	defer cg.running.Done()
+
+	if len(topics) == 0 {
+		return sarama.ConfigurationError("no topics provided")
+	}
+
+	c := cg.c
+	c.mu.Lock()
+	g := c.group(cg.group)
+	m := g.members[cg.memberID]
+	if m == nil {
+		m = &member{clientID: cg.conf.ClientID}
+		g.members[cg.memberID] = m
+	}
+	if !sameTopics(m.topics, topics) {
+		m.topics = append([]string{}, topics...)
+		c.rebalance(g)
+	}
+	gen := g.gen
+
+	sctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	sess := &session{
+		cg:     cg,
+		genID:  gen.id,
+		ctx:    sctx,
+		claims: make(map[string][]int32),
+		marked: make(map[string]map[int32]int64),
+	}
+	claims := make([]*claim, 0)
+	for t, ps := range gen.assignments[cg.memberID] {
+		sess.claims[t] = append([]int32{}, ps...)
+		for _, p := range ps {
+			off, ok := g.offsets[t][p]
+			if !ok {
+				off = 0
+				if cg.conf.Consumer.Offsets.Initial == sarama.OffsetNewest {
+					off = int64(len(c.topics[t].partitions[p]))
+				}
+			}
+			claims = append(claims, &claim{
+				c:         c,
+				topic:     t,
+				partition: p,
+				initial:   off,
+				msgs:      make(chan *sarama.ConsumerMessage, cg.conf.ChannelBufferSize),
+			})
+		}
+	}
+	c.mu.Unlock()
+
+	go func() {
+		select {
+		case <-gen.done:
+		case <-cg.closed:
+		case <-sctx.Done():
+		}
+		cancel()
+	}()
+
+	if err := handler.Setup(sess); err != nil {
+		cg.handleError(err)
+		return err
+	}
+
+	wg := sync.WaitGroup{}
+	for _, cl := range claims {
+		cl := cl
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			cg.feed(sctx, cl)
+		}()
+		go func() {
+			defer wg.Done()
+			if err := handler.ConsumeClaim(sess, cl); err != nil {
+				cg.handleError(err)
+			}
+		}()
+	}
+
+	<-sctx.Done()
+	wg.Wait()
+
+	err := handler.Cleanup(sess)
+	if err != nil {
+		cg.handleError(err)
+	}
+	if cg.conf.Consumer.Offsets.AutoCommit.Enable {
+		sess.Commit()
+	}
+	return err
+}
+
+// feed 将分区中的消息依次送入claim，session结束时关闭消息channel
+func (cg *consumerGroup) feed(ctx context.Context, cl *claim) {
+	defer close(cl.msgs)
+
+	c := cg.c
+	off := cl.initial
+	for {
+		c.mu.Lock()
+		wait := c.notify
+		var msg *sarama.ConsumerMessage
+		if t, ok := c.topics[cl.topic]; ok && int(cl.partition) < len(t.partitions) && !cg.paused(cl.topic, cl.partition) {
+			if log := t.partitions[cl.partition]; off < int64(len(log)) {
+				cp := *log[off]
+				msg = &cp
+			}
+		}
+		c.mu.Unlock()
+
+		if msg == nil {
+			select {
+			case <-wait:
+				continue
+			case <-ctx.Done():
+				return
+			}
+		}
+
+		select {
+		case cl.msgs <- msg:
+			off++
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// paused 调用方需持有Cluster的锁
+func (cg *consumerGroup) paused(topic string, partition int32) bool {
+	g, ok := cg.c.groups[cg.group]
+	if !ok {
+		return false
+	}
+	m, ok := g.members[cg.memberID]
+	if !ok {
+		return false
+	}
+	return m.paused[topic][partition]
+}
+
+func (cg *consumerGroup) handleError(err error) {
+	if !cg.conf.Consumer.Return.Errors {
+		sarama.Logger.Printf("kafkatest/%s/%s error: %v\n", cg.group, cg.memberID, err)
+		return
+	}
+	select {
+	case cg.errors <- err:
+	default:
+	}
+}
+
+func (cg *consumerGroup) Errors() <-chan error {
+	return cg.errors
+}
+
+func (cg *consumerGroup) Close() error {
+	cg.closeOnce.Do(func() {
+		cg.mu.Lock()
+		close(cg.closed)
+		cg.mu.Unlock()
+		cg.running.Wait()
+
+		c := cg.c
+		c.mu.Lock()
+		if g, ok := c.groups[cg.group]; ok {
+			if _, ok := g.members[cg.memberID]; ok {
+				delete(g.members, cg.memberID)
+				c.rebalance(g)
+			}
+		}
+		c.mu.Unlock()
+		close(cg.errors)
+	})
+	return nil
+}
+
+func (cg *consumerGroup) setPaused(partitions map[string][]int32, all bool, paused bool) {
+	c := cg.c
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	g, ok := c.groups[cg.group]
+	if !ok {
+		return
+	}
+	m, ok := g.members[cg.memberID]
+	if !ok {
+		return
+	}
+	if all {
+		partitions = g.gen.assignments[cg.memberID]
+	}
+	if m.paused == nil {
+		m.paused = make(map[string]map[int32]bool)
+	}
+	for t, ps := range partitions {
+		if m.paused[t] == nil {
+			m.paused[t] = make(map[int32]bool)
+		}
+		for _, p := range ps {
+			m.paused[t][p] = paused
+		}
+	}
+	c.wake()
+}
+
+func (cg *consumerGroup) Pause(partitions map[string][]int32) {
+	cg.setPaused(partitions, false, true)
+}
+
+func (cg *consumerGroup) Resume(partitions map[string][]int32) {
+	cg.setPaused(partitions, false, false)
+}
+
+func (cg *consumerGroup) PauseAll() {
+	cg.setPaused(nil, true, true)
+}
+
+func (cg *consumerGroup) ResumeAll() {
+	cg.setPaused(nil, true, false)
+}
+
+func sameTopics(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	x, y := append([]string{}, a...), append([]string{}, b...)
+	sort.Strings(x)
+	sort.Strings(y)
+	for i := range x {
+		if x[i] != y[i] {
+			return false
+		}
+	}
+	return true
+}
+
+type session struct {
+	cg     *consumerGroup
+	genID  int32
+	ctx    context.Context
+	claims map[string][]int32
+
+	mu     sync.Mutex
+	marked map[string]map[int32]int64
+}
+
+func (s *session) Claims() map[string][]int32 {
+	return s.claims
+}
+
+func (s *session) MemberID() string {
+	return s.cg.memberID
+}
+
+func (s *session) GenerationID() int32 {
+	return s.genID
+}
+
+func (s *session) mark(topic string, partition int32, offset int64, force bool) {
+	s.mu.Lock()
+	if s.marked[topic] == nil {
+		s.marked[topic] = make(map[int32]int64)
+	}
+	if cur, ok := s.marked[topic][partition]; force || !ok || offset > cur {
+		s.marked[topic][partition] = offset
+	}
+	s.mu.Unlock()
+
+	if s.cg.conf.Consumer.Offsets.AutoCommit.Enable {
+		s.Commit()
+	}
+}
+
+func (s *session) MarkOffset(topic string, partition int32, offset int64, metadata string) {
+	s.mark(topic, partition, offset, false)
+}
+
+func (s *session) ResetOffset(topic string, partition int32, offset int64, metadata string) {
+	s.mark(topic, partition, offset, true)
+}
+
+func (s *session) MarkMessage(msg *sarama.ConsumerMessage, metadata string) {
+	s.MarkOffset(msg.Topic, msg.Partition, msg.Offset+1, metadata)
+}
+
+func (s *session) Commit() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c := s.cg.c
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	g := c.group(s.cg.group)
+	if g.commitErr != nil {
+		// 与sarama相同，提交失败通过Errors()按分区返回
+		for t, ps := range s.marked {
+			for p := range ps {
+				s.cg.handleError(&sarama.ConsumerError{Topic: t, Partition: p, Err: g.commitErr})
+			}
+		}
+		return
+	}
+	for t, ps := range s.marked {
+		if g.offsets[t] == nil {
+			g.offsets[t] = make(map[int32]int64)
+		}
+		for p, off := range ps {
+			g.offsets[t][p] = off
+		}
+	}
+}
+
+func (s *session) Context() context.Context {
+	return s.ctx
+}
+
+type claim struct {
+	c         *Cluster
+	topic     string
+	partition int32
+	initial   int64
+	msgs      chan *sarama.ConsumerMessage
+}
+
+func (cl *claim) Topic() string {
+	return cl.topic
+}
+
+func (cl *claim) Partition() int32 {
+	return cl.partition
+}
+
+func (cl *claim) InitialOffset() int64 {
+	return cl.initial
+}
+
+func (cl *claim) HighWaterMarkOffset() int64 {
+	off, _ := cl.c.GetOffset(cl.topic, cl.partition, sarama.OffsetNewest)
+	return off
+}
+
+func (cl *claim) Messages() <-chan *sarama.ConsumerMessage {
+	return cl.msgs
+}
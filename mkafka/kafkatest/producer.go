@@ -0,0 +1,89 @@
+package kafkatest
+
+import (
+	"sync"
+
+	"github.com/Shopify/sarama"
+)
+
+type syncProducer struct {
+	c           *Cluster
+	partitioner sarama.Partitioner
+
+	mu     sync.Mutex
+	closed bool
+}
+
+// NewSyncProducer 创建写入模拟集群的同步生产者，conf为nil时使用sarama默认配置，分区选择使用conf中的Partitioner
+func (c *Cluster) NewSyncProducer(conf *sarama.Config) sarama.SyncProducer {
+	if conf == nil {
+		conf = sarama.NewConfig()
+	}
+	return &syncProducer{
+		c:           c,
+		partitioner: conf.Producer.Partitioner(""),
+	}
+}
+
+func (p *syncProducer) SendMessage(msg *sarama.ProducerMessage) (int32, int64, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return -1, -1, sarama.ErrClosedClient
+	}
+
+	p.c.mu.Lock()
+	defer p.c.mu.Unlock()
+	if err := p.c.append(msg, p.partitioner); err != nil {
+		return -1, -1, err
+	}
+	return msg.Partition, msg.Offset, nil
+}
+
+func (p *syncProducer) SendMessages(msgs []*sarama.ProducerMessage) error {
+	var errs sarama.ProducerErrors
+	for _, m := range msgs {
+		if _, _, err := p.SendMessage(m); err != nil {
+			errs = append(errs, &sarama.ProducerError{Msg: m, Err: err})
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+func (p *syncProducer) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.closed = true
+	return nil
+}
+
+func (p *syncProducer) TxnStatus() sarama.ProducerTxnStatusFlag {
+	return sarama.ProducerTxnFlagReady
+}
+
+func (p *syncProducer) IsTransactional() bool {
+	return false
+}
+
+func (p *syncProducer) BeginTxn() error {
+	return ErrUnsupported
+}
+
+func (p *syncProducer) CommitTxn() error {
+	return ErrUnsupported
+}
+
+func (p *syncProducer) AbortTxn() error {
+	return ErrUnsupported
+}
+
+func (p *syncProducer) AddOffsetsToTxn(offsets map[string][]*sarama.PartitionOffsetMetadata, groupId string) error {
+	return ErrUnsupported
+}
+
+func (p *syncProducer) AddMessageToTxn(msg *sarama.ConsumerMessage, groupId string, metadata *string) error {
+	return ErrUnsupported
+}
@@ -0,0 +1,156 @@
+package mkafka
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime/debug"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Shopify/sarama"
+)
+
+// 消费端常用的中间件
+//
+// 建议的组合顺序（靠前的在外层）：
+//
+//	Chain(Recovery(), Tracing(), Logging(), Metrics(m), Timeout(d))
+//
+// Recovery在最外层以捕获所有中间件的panic，Tracing在Logging之前使日志带有trace_id，
+// Timeout在最内层使耗时统计包含超时的消息
+
+// ErrPanic Handler发生panic
+var ErrPanic = errors.New("[mouse] -> kafka 处理消息panic")
+
+// Chain 将多个中间件组合为一个，靠前的在外层
+func Chain(mws ...Middleware) Middleware {
+	return func(h Handler) Handler {
+		for i := len(mws) - 1; i >= 0; i-- {
+			h = mws[i](h)
+		}
+		return h
+	}
+}
+
+// Recovery 将Handler中的panic转换为包装了ErrPanic的错误，并记录调用栈
+func Recovery() Middleware {
+	return func(next Handler) Handler {
+		return func(ctx context.Context, msg *sarama.ConsumerMessage) (err error) {
+			defer func() {
+				if r := recover(); r != nil {
+					err = fmt.Errorf("%w: %v", ErrPanic, r)
+					Logger(ctx).Error().Str("topic", msg.Topic).Int32("partition", msg.Partition).Int64("offset", msg.Offset).
+						Bytes("stack", debug.Stack()).Msg(err.Error())
+				}
+			}()
+			return next(ctx, msg)
+		}
+	}
+}
+
+// Timeout 为每条消息的处理设置超时，Handler需要响应ctx的取消；返回Handler的结果，超时后仍然成功的消息视为成功。
+// d不大于0时不设置超时
+func Timeout(d time.Duration) Middleware {
+	return func(next Handler) Handler {
+		if d <= 0 {
+			return next
+		}
+		return func(ctx context.Context, msg *sarama.ConsumerMessage) error {
+			ctx, cancel := context.WithTimeout(ctx, d)
+			defer cancel()
+			return next(ctx, msg)
+		}
+	}
+}
+
+// Logging 通过ctx上的logger（没有时为SetLogger设置的logger）记录每条消息的处理结果，成功为debug级别，失败为error级别
+func Logging() Middleware {
+	return func(next Handler) Handler {
+		return func(ctx context.Context, msg *sarama.ConsumerMessage) error {
+			start := time.Now()
+			err := next(ctx, msg)
+
+			l := Logger(ctx)
+			ev := l.Debug()
+			if err != nil {
+				ev = l.Error().Err(err)
+			}
+			ev.Str("topic", msg.Topic).Int32("partition", msg.Partition).Int64("offset", msg.Offset).
+				Dur("latency", time.Since(start)).Msg("处理消息")
+			return err
+		}
+	}
+}
+
+// MetricsRecorder 记录消息处理的耗时和结果
+type MetricsRecorder interface {
+	ObserveMessage(topic string, partition int32, latency time.Duration, err error)
+}
+
+// Metrics 将每条消息的处理耗时和结果交给rec记录
+func Metrics(rec MetricsRecorder) Middleware {
+	return func(next Handler) Handler {
+		return func(ctx context.Context, msg *sarama.ConsumerMessage) error {
+			start := time.Now()
+			err := next(ctx, msg)
+			rec.ObserveMessage(msg.Topic, msg.Partition, time.Since(start), err)
+			return err
+		}
+	}
+}
+
+// HandlerStats 一个topic的处理统计
+type HandlerStats struct {
+	Topic        string
+	Count        int64
+	Errors       int64
+	TotalLatency time.Duration
+	MaxLatency   time.Duration
+}
+
+// MemoryMetrics 在内存中按topic汇总的MetricsRecorder，并发安全
+type MemoryMetrics struct {
+	mu     sync.Mutex
+	topics map[string]*HandlerStats
+}
+
+// NewMemoryMetrics 创建空的MemoryMetrics
+func NewMemoryMetrics() *MemoryMetrics {
+	return &MemoryMetrics{topics: make(map[string]*HandlerStats)}
+}
+
+func (m *MemoryMetrics) ObserveMessage(topic string, partition int32, latency time.Duration, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s, ok := m.topics[topic]
+	if !ok {
+		s = &HandlerStats{Topic: topic}
+		m.topics[topic] = s
+	}
+	s.Count++
+	if err != nil {
+		s.Errors++
+	}
+	s.TotalLatency += latency
+	if latency > s.MaxLatency {
+		s.MaxLatency = latency
+	}
+}
+
+// Snapshot 返回当前的统计，按topic排序
+func (m *MemoryMetrics) Snapshot() []HandlerStats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	r := make([]HandlerStats, 0, len(m.topics))
+	for _, s := range m.topics {
+		r = append(r, *s)
+	}
+	sort.Slice(r, func(i, j int) bool {
+		return r[i].Topic < r[j].Topic
+	})
+	return r
+}
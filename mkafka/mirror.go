@@ -0,0 +1,421 @@
+package mkafka
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/Shopify/sarama"
+)
+
+// 复制topic中一段范围内的消息到另一个topic或NDJSON文件，以及从NDJSON文件重放
+//
+// NDJSON文件每行一条消息：
+//
+//	{"topic":"orders","partition":0,"offset":42,"timestamp":"2006-01-02T15:04:05.999999999Z",
+//	 "key":"<base64>","value":"<base64>","headers":[{"key":"trace","value":"<base64>"}]}
+//
+// key和value为null表示消息没有key或value
+
+// MirrorSource 要复制的消息范围，每个分区复制[起点, 终点)之间的消息
+type MirrorSource struct {
+	Topic string
+	// Partitions 为空时复制所有分区
+	Partitions []int32
+
+	// StartTime 不为零时从该时间之后的第一条消息开始，否则从StartOffset开始；
+	// StartOffset可以是sarama.OffsetOldest；终点不会超过开始复制时的高水位，不支持sarama.OffsetNewest
+	StartOffset int64
+	StartTime   time.Time
+
+	// EndTime 不为零时到该时间之后的第一条消息为止，否则到EndOffset为止；
+	// EndOffset为0或sarama.OffsetNewest时为开始复制时的高水位
+	EndOffset int64
+	EndTime   time.Time
+}
+
+// MirrorOptions 复制和重放时对消息的处理
+type MirrorOptions struct {
+	// Filter 返回false的消息被跳过
+	Filter func(*sarama.ConsumerMessage) bool
+	// Transform 在写入前修改消息，返回nil时跳过
+	Transform func(*sarama.ConsumerMessage) (*sarama.ConsumerMessage, error)
+	// Rate 每秒最多写入的消息数，为0时不限速
+	Rate float64
+	// IdleTimeout 仅用于Mirror：分区的高水位已到达终点后，超过该时间没有读到消息时结束该分区并记录在MirrorStats.Incomplete中，默认10秒；
+	// 终点前的最后一条记录是事务标记或已被压缩时读不到该offset，依靠此超时结束
+	IdleTimeout time.Duration
+}
+
+const defaultMirrorIdleTimeout = 10 * time.Second
+
+// MirrorSink 复制的目标
+type MirrorSink interface {
+	WriteMessage(msg *sarama.ConsumerMessage) error
+}
+
+// MirrorStats 复制结果
+type MirrorStats struct {
+	Read    int64
+	Written int64
+	Skipped int64
+	// Incomplete 没有读到终点、因IdleTimeout结束的分区及其下一个未读到的offset；
+	// 可能是终点前的记录为事务标记或已被压缩，也可能是读取过慢，需要调用方确认
+	Incomplete map[int32]int64
+}
+
+// partitionEnd 因空闲结束的分区
+type partitionEnd struct {
+	partition int32
+	next      int64
+}
+
+type topicSink struct {
+	producer      sarama.SyncProducer
+	topic         string
+	keepPartition bool
+}
+
+// NewTopicSink 将消息发送到topic，topic为空时发送到消息原来的topic；
+// keepPartition为true时写入相同编号的分区，生产者需要使用sarama.NewManualPartitioner
+func NewTopicSink(producer sarama.SyncProducer, topic string, keepPartition bool) MirrorSink {
+	return &topicSink{producer: producer, topic: topic, keepPartition: keepPartition}
+}
+
+func (s *topicSink) WriteMessage(msg *sarama.ConsumerMessage) error {
+	out := &sarama.ProducerMessage{
+		Topic:     s.topic,
+		Timestamp: msg.Timestamp,
+	}
+	if out.Topic == "" {
+		out.Topic = msg.Topic
+	}
+	if s.keepPartition {
+		out.Partition = msg.Partition
+	}
+	if msg.Key != nil {
+		out.Key = sarama.ByteEncoder(msg.Key)
+	}
+	if msg.Value != nil {
+		out.Value = sarama.ByteEncoder(msg.Value)
+	}
+	for _, h := range msg.Headers {
+		if h != nil {
+			out.Headers = append(out.Headers, sarama.RecordHeader{Key: h.Key, Value: h.Value})
+		}
+	}
+	_, _, err := s.producer.SendMessage(out)
+	return err
+}
+
+type ndjsonHeader struct {
+	Key   string `json:"key"`
+	Value []byte `json:"value"`
+}
+
+type ndjsonRecord struct {
+	Topic     string         `json:"topic"`
+	Partition int32          `json:"partition"`
+	Offset    int64          `json:"offset"`
+	Timestamp time.Time      `json:"timestamp"`
+	Key       []byte         `json:"key"`
+	Value     []byte         `json:"value"`
+	Headers   []ndjsonHeader `json:"headers,omitempty"`
+}
+
+// NDJSONWriter 将消息以NDJSON格式写入文件
+type NDJSONWriter struct {
+	w   *bufio.Writer
+	enc *json.Encoder
+}
+
+// NewNDJSONWriter 创建NDJSON写入者，写入结束后需要调用Flush
+func NewNDJSONWriter(w io.Writer) *NDJSONWriter {
+	bw := bufio.NewWriter(w)
+	return &NDJSONWriter{w: bw, enc: json.NewEncoder(bw)}
+}
+
+func (n *NDJSONWriter) WriteMessage(msg *sarama.ConsumerMessage) error {
+	rec := ndjsonRecord{
+		Topic:     msg.Topic,
+		Partition: msg.Partition,
+		Offset:    msg.Offset,
+		Timestamp: msg.Timestamp,
+		Key:       msg.Key,
+		Value:     msg.Value,
+	}
+	for _, h := range msg.Headers {
+		if h != nil {
+			rec.Headers = append(rec.Headers, ndjsonHeader{Key: string(h.Key), Value: h.Value})
+		}
+	}
+	return n.enc.Encode(rec)
+}
+
+// Flush 将缓冲的数据写入底层文件
+func (n *NDJSONWriter) Flush() error {
+	return n.w.Flush()
+}
+
+// ReadNDJSON 逐行读取NDJSON文件中的消息，fn返回错误时停止
+func ReadNDJSON(r io.Reader, fn func(*sarama.ConsumerMessage) error) error {
+	dec := json.NewDecoder(r)
+	for {
+		rec := ndjsonRecord{}
+		if err := dec.Decode(&rec); err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+		msg := &sarama.ConsumerMessage{
+			Topic:     rec.Topic,
+			Partition: rec.Partition,
+			Offset:    rec.Offset,
+			Timestamp: rec.Timestamp,
+			Key:       rec.Key,
+			Value:     rec.Value,
+		}
+		for _, h := range rec.Headers {
+			msg.Headers = append(msg.Headers, &sarama.RecordHeader{Key: []byte(h.Key), Value: h.Value})
+		}
+		if err := fn(msg); err != nil {
+			return err
+		}
+	}
+}
+
+// mirrorWriter 对消息应用MirrorOptions后写入sink
+type mirrorWriter struct {
+	opts  MirrorOptions
+	sink  MirrorSink
+	stats MirrorStats
+	start time.Time
+}
+
+func (w *mirrorWriter) write(ctx context.Context, msg *sarama.ConsumerMessage) error {
+	w.stats.Read++
+	if w.opts.Filter != nil && !w.opts.Filter(msg) {
+		w.stats.Skipped++
+		return nil
+	}
+	if w.opts.Transform != nil {
+		m, err := w.opts.Transform(msg)
+		if err != nil {
+			return err
+		}
+		if m == nil {
+			w.stats.Skipped++
+			return nil
+		}
+		msg = m
+	}
+
+	if w.opts.Rate > 0 {
+		if w.start.IsZero() {
+			w.start = time.Now()
+		}
+		next := w.start.Add(time.Duration(float64(w.stats.Written) / w.opts.Rate * float64(time.Second)))
+		if d := time.Until(next); d > 0 {
+			t := time.NewTimer(d)
+			select {
+			case <-t.C:
+			case <-ctx.Done():
+				t.Stop()
+				return ctx.Err()
+			}
+		}
+	}
+
+	if err := w.sink.WriteMessage(msg); err != nil {
+		return err
+	}
+	w.stats.Written++
+	return nil
+}
+
+// partitionRange 解析分区的[start, end)
+func (s MirrorSource) partitionRange(src OffsetSource, p int32) (int64, int64, error) {
+	oldest, err := src.GetOffset(s.Topic, p, sarama.OffsetOldest)
+	if err != nil {
+		return 0, 0, err
+	}
+	hwm, err := src.GetOffset(s.Topic, p, sarama.OffsetNewest)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	// 按时间查询不到时表示该时间之后没有消息
+	byTime := func(t time.Time) (int64, error) {
+		off, err := src.GetOffset(s.Topic, p, t.UnixMilli())
+		if err == nil && off < 0 {
+			off = hwm
+		}
+		return off, err
+	}
+
+	start := s.StartOffset
+	if !s.StartTime.IsZero() {
+		if start, err = byTime(s.StartTime); err != nil {
+			return 0, 0, err
+		}
+	} else if start < 0 && start != sarama.OffsetOldest {
+		// OffsetNewest时起点等于高水位，而终点不超过高水位，范围总是为空
+		return 0, 0, fmt.Errorf("%w: 起始offset %d 无效，只支持sarama.OffsetOldest", ErrInvalidConfig, start)
+	}
+	if start < oldest {
+		start = oldest
+	}
+
+	end := s.EndOffset
+	if !s.EndTime.IsZero() {
+		if end, err = byTime(s.EndTime); err != nil {
+			return 0, 0, err
+		}
+	}
+	if end <= 0 || end > hwm {
+		end = hwm
+	}
+	return start, end, nil
+}
+
+// Mirror 将source范围内的消息写入sink，同一分区的消息按顺序写入，不同分区之间的顺序不保证
+//
+// consumer用于读取分区，src用于查询offset（sarama.Client满足OffsetSource）
+func Mirror(ctx context.Context, consumer sarama.Consumer, src OffsetSource, source MirrorSource, opts MirrorOptions, sink MirrorSink) (MirrorStats, error) {
+	partitions := source.Partitions
+	if len(partitions) == 0 {
+		ps, err := src.Partitions(source.Topic)
+		if err != nil {
+			logger.Err(err).Str("topic", source.Topic).Msg("获取分区失败")
+			return MirrorStats{}, err
+		}
+		partitions = ps
+	}
+
+	idle := opts.IdleTimeout
+	if idle <= 0 {
+		idle = defaultMirrorIdleTimeout
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	msgs := make(chan *sarama.ConsumerMessage)
+	errs := make(chan error, len(partitions))
+	idled := make(chan partitionEnd, len(partitions))
+	wg := sync.WaitGroup{}
+	for _, p := range partitions {
+		start, end, err := source.partitionRange(src, p)
+		if err != nil {
+			logger.Err(err).Str("topic", source.Topic).Int32("partition", p).Msg("获取复制范围失败")
+			return MirrorStats{}, err
+		}
+		if start >= end {
+			continue
+		}
+		pc, err := consumer.ConsumePartition(source.Topic, p, start)
+		if err != nil {
+			logger.Err(err).Str("topic", source.Topic).Int32("partition", p).Msg("消费分区失败")
+			return MirrorStats{}, err
+		}
+
+		wg.Add(1)
+		go func(pc sarama.PartitionConsumer, p int32, next, end int64) {
+			defer wg.Done()
+			defer pc.Close()
+			ticker := time.NewTicker(idle)
+			defer ticker.Stop()
+			errc := pc.Errors()
+			received := false
+			for {
+				select {
+				case m, ok := <-pc.Messages():
+					if !ok {
+						return
+					}
+					if m.Offset >= end {
+						return
+					}
+					received = true
+					next = m.Offset + 1
+					select {
+					case msgs <- m:
+					case <-ctx.Done():
+						return
+					}
+					if m.Offset+1 >= end {
+						return
+					}
+				case e, ok := <-errc:
+					if !ok {
+						// 关闭后不再选择，避免空转
+						errc = nil
+						continue
+					}
+					errs <- e
+					return
+				case <-ticker.C:
+					if !received && pc.HighWaterMarkOffset() >= end {
+						logger.Warn().Str("topic", source.Topic).Int32("partition", p).Int64("next", next).Int64("end", end).
+							Msg("分区空闲超时，未读到终点")
+						idled <- partitionEnd{partition: p, next: next}
+						return
+					}
+					received = false
+				case <-ctx.Done():
+					return
+				}
+			}
+		}(pc, p, start, end)
+	}
+	go func() {
+		wg.Wait()
+		close(msgs)
+	}()
+
+	w := &mirrorWriter{opts: opts, sink: sink}
+	for {
+		select {
+		case m, ok := <-msgs:
+			if !ok {
+				close(idled)
+				for e := range idled {
+					if w.stats.Incomplete == nil {
+						w.stats.Incomplete = make(map[int32]int64)
+					}
+					w.stats.Incomplete[e.partition] = e.next
+				}
+				select {
+				case err := <-errs:
+					return w.stats, err
+				default:
+				}
+				return w.stats, ctx.Err()
+			}
+			if err := w.write(ctx, m); err != nil {
+				logger.Err(err).Str("topic", m.Topic).Int32("partition", m.Partition).Int64("offset", m.Offset).Msg("写入消息失败")
+				return w.stats, err
+			}
+		case err := <-errs:
+			return w.stats, err
+		}
+	}
+}
+
+// Replay 将NDJSON文件中的消息写入sink
+func Replay(ctx context.Context, r io.Reader, opts MirrorOptions, sink MirrorSink) (MirrorStats, error) {
+	w := &mirrorWriter{opts: opts, sink: sink}
+	err := ReadNDJSON(r, func(m *sarama.ConsumerMessage) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		return w.write(ctx, m)
+	})
+	return w.stats, err
+}
@@ -0,0 +1,176 @@
+package mkafka
+
+import (
+	"hash/fnv"
+	"runtime"
+
+	"github.com/Shopify/sarama"
+)
+
+// 分区内按key并行处理：同一分区的消息按key哈希分发到多个worker，相同key的消息保持顺序，
+// 只提交连续处理完成的offset（水位），保证已提交offset之前的消息都已处理
+
+const defaultOrderedQueueSize = 64
+
+// OrderedConfig 分区内并行处理配置
+type OrderedConfig struct {
+	// Workers 每个分区的worker数，默认为CPU核数
+	Workers int
+	// QueueSize 每个worker的待处理队列长度，默认64
+	QueueSize int
+}
+
+type orderedHandler struct {
+	h    Handler
+	conf OrderedConfig
+}
+
+type orderedResult struct {
+	offset int64
+	err    error
+}
+
+// NewOrderedGroupHandler 与NewGroupHandler相同，但每个分区的消息由多个worker并行处理
+//
+// key相同的消息由同一个worker按顺序处理，key为nil的消息按offset分散；处理失败时与NewGroupHandler相同，
+// 由worker原地退避重试，同一worker上之后的消息等待重试成功，队列满后分发也随之暂停；
+// 会话结束时提交水位，水位之后的消息重新投递；水位每100条消息或每5秒提交一次
+func NewOrderedGroupHandler(h Handler, conf OrderedConfig, mws ...Middleware) sarama.ConsumerGroupHandler {
+	if conf.Workers <= 0 {
+		conf.Workers = runtime.NumCPU()
+	}
+	if conf.QueueSize <= 0 {
+		conf.QueueSize = defaultOrderedQueueSize
+	}
+	return &orderedHandler{h: Chain(mws...)(h), conf: conf}
+}
+
+func (o *orderedHandler) Setup(sarama.ConsumerGroupSession) error {
+	return nil
+}
+
+func (o *orderedHandler) Cleanup(sarama.ConsumerGroupSession) error {
+	return nil
+}
+
+// worker 选择处理消息的worker
+func (o *orderedHandler) worker(msg *sarama.ConsumerMessage) int {
+	if msg.Key == nil {
+		return int(msg.Offset % int64(o.conf.Workers))
+	}
+	h := fnv.New32a()
+	h.Write(msg.Key)
+	return int(h.Sum32() % uint32(o.conf.Workers))
+}
+
+func (o *orderedHandler) ConsumeClaim(sess sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
+	c := newCommitter(sess)
+	defer c.stop()
+
+	ctx := sess.Context()
+
+	results := make(chan orderedResult)
+	queues := make([]chan *sarama.ConsumerMessage, o.conf.Workers)
+	done := make(chan struct{})
+	running := o.conf.Workers
+	for i := range queues {
+		queues[i] = make(chan *sarama.ConsumerMessage, o.conf.QueueSize)
+		go func(q chan *sarama.ConsumerMessage) {
+			defer func() { done <- struct{}{} }()
+			for msg := range q {
+				// 会话结束后不再处理队列中剩余的消息
+				err := ctx.Err()
+				if err == nil {
+					err = retry(ctx, o.h, msg)
+				}
+				results <- orderedResult{offset: msg.Offset, err: err}
+			}
+		}(queues[i])
+	}
+
+	w := &watermark{}
+	handle := func(r orderedResult) {
+		// 只有会话结束时才会失败，消息不标记
+		if r.err != nil {
+			return
+		}
+		if next, n := w.done(r.offset); n > 0 {
+			sess.MarkOffset(claim.Topic(), claim.Partition(), next, "")
+			c.mark(n)
+		}
+	}
+
+	msgs := claim.Messages()
+dispatch:
+	for {
+		select {
+		case msg, ok := <-msgs:
+			if !ok {
+				break dispatch
+			}
+			w.add(msg.Offset)
+			q := queues[o.worker(msg)]
+			for sent := false; !sent; {
+				select {
+				case q <- msg:
+					sent = true
+				case r := <-results:
+					handle(r)
+				case <-c.ticker.C:
+					c.commit()
+				case <-ctx.Done():
+					break dispatch
+				}
+			}
+		case r := <-results:
+			handle(r)
+		case <-c.ticker.C:
+			c.commit()
+		case <-ctx.Done():
+			break dispatch
+		}
+	}
+
+	for _, q := range queues {
+		close(q)
+	}
+	for running > 0 {
+		select {
+		case r := <-results:
+			handle(r)
+		case <-done:
+			running--
+		}
+	}
+	return nil
+}
+
+// watermark 记录已分发的offset，只在最早的offset处理完成时推进
+type watermark struct {
+	pending []int64
+	finish  map[int64]bool
+}
+
+func (w *watermark) add(offset int64) {
+	w.pending = append(w.pending, offset)
+}
+
+// done 标记offset处理完成，返回下一个待消费的offset和水位推进的消息数
+func (w *watermark) done(offset int64) (int64, int) {
+	if w.finish == nil {
+		w.finish = make(map[int64]bool)
+	}
+	w.finish[offset] = true
+
+	var (
+		next int64
+		n    int
+	)
+	for len(w.pending) > 0 && w.finish[w.pending[0]] {
+		next = w.pending[0] + 1
+		delete(w.finish, w.pending[0])
+		w.pending = w.pending[1:]
+		n++
+	}
+	return next, n
+}
@@ -0,0 +1,468 @@
+package mkafka
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/Shopify/sarama"
+)
+
+// 运行消费者组并协调关闭：停止分发新消息，等待处理中的消息结束，提交offset后在期限内关闭消费者组和其他客户端
+
+const (
+	defaultCommitInterval = 5 * time.Second
+	// commitSettle 提交失败通过消费者组的Errors()异步返回，提交后经过该时间没有错误才视为成功
+	commitSettle = time.Second
+)
+
+var (
+	// ErrShutdownTimeout 没有在期限内完成关闭
+	ErrShutdownTimeout = errors.New("[mouse] -> kafka 关闭超时")
+	// ErrRunnerStarted Runner只能运行一次
+	ErrRunnerStarted = errors.New("[mouse] -> kafka Runner已经运行")
+)
+
+// Runner 运行消费者组，记录组成员和提交状态用于健康检查
+type Runner struct {
+	// CommitInterval 定期提交已标记offset的间隔，默认5秒，小于0时不定期提交
+	CommitInterval time.Duration
+
+	group   sarama.ConsumerGroup
+	topics  []string
+	handler sarama.ConsumerGroupHandler
+	closers []io.Closer
+
+	mu         sync.Mutex
+	started    bool
+	cancel     context.CancelFunc
+	done       chan struct{}
+	draining   chan struct{}
+	drained    chan struct{}
+	active     int
+	joined     bool
+	joinedAt   time.Time
+	lastMark   time.Time
+	lastCommit time.Time
+	// commitAt 最近一次尚未确认结果的提交，commitErrs为当时的错误数
+	commitAt   time.Time
+	commitErrs int64
+	errs       int64
+	lastErr    error
+	errsDone   chan struct{}
+
+	closeOnce sync.Once
+	closeErr  error
+}
+
+// NewRunner 创建消费topics的Runner，closers（生产者、client等）在消费者组之后按顺序关闭
+//
+//	r := mkafka.NewRunner(group, topics, mkafka.NewGroupHandler(h), producer)
+//	ready := mkafka.NewHealth()
+//	ready.Register("broker", mkafka.BrokerCheck(admin))
+//	ready.Register("group", r.GroupCheck())
+//	ready.Register("commit", r.CommitCheck(time.Minute))
+//	http.Handle("/readyz", ready)
+//	err := r.RunUntilSignal(30 * time.Second)
+func NewRunner(group sarama.ConsumerGroup, topics []string, handler sarama.ConsumerGroupHandler, closers ...io.Closer) *Runner {
+	return &Runner{
+		group:    group,
+		topics:   topics,
+		handler:  handler,
+		closers:  closers,
+		done:     make(chan struct{}),
+		draining: make(chan struct{}),
+		drained:  make(chan struct{}),
+	}
+}
+
+// Run 循环加入消费者组并消费，直到Shutdown或ctx结束，这两种情况下返回nil
+//
+// ctx结束时立即停止，处理中的消息会看到ctx取消；需要等待处理中的消息时使用Shutdown，Run返回后仍需调用Shutdown关闭客户端。
+// 消费者组被其他地方关闭时返回sarama.ErrClosedConsumerGroup，配置错误时返回sarama.ConfigurationError，其他错误稍后重试。
+//
+// 消费者组的Errors()由Run读取并记录，提交失败时CommitCheck据此报告异常，需要开启Consumer.Return.Errors（DefaultConsumerConfig已开启）
+func (r *Runner) Run(ctx context.Context) error {
+	r.mu.Lock()
+	if r.started {
+		r.mu.Unlock()
+		return ErrRunnerStarted
+	}
+	r.started = true
+	ctx, cancel := context.WithCancel(ctx)
+	r.cancel = cancel
+	r.errsDone = make(chan struct{})
+	r.mu.Unlock()
+	defer close(r.done)
+	defer cancel()
+
+	go r.drainErrors()
+
+	for {
+		select {
+		case <-r.draining:
+			return nil
+		default:
+		}
+		if err := r.group.Consume(ctx, r.topics, (*runnerHandler)(r)); err != nil {
+			if errors.Is(err, sarama.ErrClosedConsumerGroup) {
+				if r.isDraining() {
+					return nil
+				}
+				return err
+			}
+			var cerr sarama.ConfigurationError
+			if errors.As(err, &cerr) {
+				logger.Err(err).Strs("topics", r.topics).Msg("消费者组配置错误")
+				return err
+			}
+			logger.Err(err).Strs("topics", r.topics).Msg("消费失败，稍后重试")
+			select {
+			case <-ctx.Done():
+			case <-time.After(time.Second):
+			}
+		}
+		if ctx.Err() != nil {
+			return nil
+		}
+	}
+}
+
+// RunUntilSignal 运行到收到SIGINT或SIGTERM，然后在timeout内完成Shutdown
+func (r *Runner) RunUntilSignal(timeout time.Duration) error {
+	sig, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	errc := make(chan error, 1)
+	go func() {
+		errc <- r.Run(context.Background())
+	}()
+
+	var err error
+	select {
+	case <-sig.Done():
+		logger.Info().Strs("topics", r.topics).Msg("收到退出信号，开始关闭")
+	case err = <-errc:
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if e := r.Shutdown(ctx); err == nil {
+		err = e
+	}
+	return err
+}
+
+// Shutdown 停止分发新消息，等待处理中的消息结束并提交offset，然后关闭消费者组和closers
+//
+// ctx结束时不再等待，取消处理中的消息并返回ErrShutdownTimeout；可以重复调用
+func (r *Runner) Shutdown(ctx context.Context) error {
+	r.mu.Lock()
+	select {
+	case <-r.draining:
+	default:
+		close(r.draining)
+		if r.active == 0 {
+			close(r.drained)
+		}
+	}
+	cancel, started := r.cancel, r.started
+	r.mu.Unlock()
+
+	var err error
+	select {
+	case <-r.drained:
+	case <-ctx.Done():
+		logger.Warn().Strs("topics", r.topics).Msg("等待处理中的消息超时")
+		err = ErrShutdownTimeout
+	}
+
+	// 取消会话，Cleanup中提交offset
+	if cancel != nil {
+		cancel()
+	}
+	if started {
+		select {
+		case <-r.done:
+		case <-ctx.Done():
+			err = ErrShutdownTimeout
+		}
+	}
+
+	closed := make(chan error, 1)
+	go func() {
+		closed <- r.close()
+	}()
+	select {
+	case e := <-closed:
+		if err == nil {
+			err = e
+		}
+	case <-ctx.Done():
+		logger.Warn().Strs("topics", r.topics).Msg("关闭客户端超时")
+		err = ErrShutdownTimeout
+	}
+	return err
+}
+
+// drainErrors 读取消费者组的错误直到消费者组关闭，关闭后确认最后一次提交的结果
+func (r *Runner) drainErrors() {
+	for err := range r.group.Errors() {
+		logger.Err(err).Strs("topics", r.topics).Msg("消费者组错误")
+		r.mu.Lock()
+		r.errs++
+		r.lastErr = err
+		r.mu.Unlock()
+	}
+	r.mu.Lock()
+	r.settleCommit(true)
+	r.mu.Unlock()
+	close(r.errsDone)
+}
+
+// settleCommit 确认尚未确认的提交，之后没有收到错误时视为成功；final为false时只确认超过commitSettle的提交，调用方需持有锁
+func (r *Runner) settleCommit(final bool) {
+	if r.commitAt.IsZero() || (!final && time.Since(r.commitAt) < commitSettle) {
+		return
+	}
+	if r.errs == r.commitErrs {
+		r.lastCommit = r.commitAt
+	}
+	r.commitAt = time.Time{}
+}
+
+func (r *Runner) close() error {
+	r.closeOnce.Do(func() {
+		if err := r.group.Close(); err != nil {
+			logger.Err(err).Msg("关闭消费者组失败")
+			r.closeErr = err
+		}
+		r.mu.Lock()
+		errsDone := r.errsDone
+		r.mu.Unlock()
+		if errsDone != nil {
+			<-errsDone
+		}
+		for _, c := range r.closers {
+			if err := c.Close(); err != nil {
+				logger.Err(err).Msg("关闭客户端失败")
+				if r.closeErr == nil {
+					r.closeErr = err
+				}
+			}
+		}
+	})
+	return r.closeErr
+}
+
+// GroupCheck 检查消费者已加入消费者组且没有在关闭
+func (r *Runner) GroupCheck() HealthCheck {
+	return func(ctx context.Context) error {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		select {
+		case <-r.draining:
+			return ErrNotJoined
+		default:
+		}
+		if !r.joined {
+			return ErrNotJoined
+		}
+		return nil
+	}
+}
+
+// CommitCheck 检查已处理的消息在maxAge内被成功提交，没有新消息时总是正常；提交持续失败时返回的错误包含最近一次消费者组错误
+func (r *Runner) CommitCheck(maxAge time.Duration) HealthCheck {
+	return func(ctx context.Context) error {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		r.settleCommit(false)
+		if !r.joined || !r.lastMark.After(r.lastCommit) {
+			return nil
+		}
+		last := r.lastCommit
+		if last.Before(r.joinedAt) {
+			last = r.joinedAt
+		}
+		if time.Since(last) > maxAge {
+			if r.lastErr != nil {
+				return fmt.Errorf("%w: %v", ErrCommitStale, r.lastErr)
+			}
+			return ErrCommitStale
+		}
+		return nil
+	}
+}
+
+// LastCommit 最近一次成功提交offset的时间；提交的结果异步返回，提交后最多commitSettle才会反映在这里
+func (r *Runner) LastCommit() time.Time {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.settleCommit(false)
+	return r.lastCommit
+}
+
+func (r *Runner) isDraining() bool {
+	select {
+	case <-r.draining:
+		return true
+	default:
+		return false
+	}
+}
+
+// runnerHandler 包装Runner的handler，记录会话状态并在关闭时停止分发消息
+type runnerHandler Runner
+
+func (h *runnerHandler) Setup(sess sarama.ConsumerGroupSession) error {
+	r := (*Runner)(h)
+	r.mu.Lock()
+	r.joined = true
+	r.joinedAt = time.Now()
+	r.mu.Unlock()
+
+	s := &runnerSession{ConsumerGroupSession: sess, r: r}
+	if interval := r.commitInterval(); interval > 0 {
+		go func() {
+			t := time.NewTicker(interval)
+			defer t.Stop()
+			for {
+				select {
+				case <-t.C:
+					s.Commit()
+				case <-sess.Context().Done():
+					return
+				}
+			}
+		}()
+	}
+	return r.handler.Setup(s)
+}
+
+func (h *runnerHandler) Cleanup(sess sarama.ConsumerGroupSession) error {
+	r := (*Runner)(h)
+	s := &runnerSession{ConsumerGroupSession: sess, r: r}
+	err := r.handler.Cleanup(s)
+	s.Commit()
+
+	r.mu.Lock()
+	r.joined = false
+	r.mu.Unlock()
+	return err
+}
+
+func (h *runnerHandler) ConsumeClaim(sess sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
+	r := (*Runner)(h)
+	r.mu.Lock()
+	if r.isDraining() {
+		r.mu.Unlock()
+		return nil
+	}
+	r.active++
+	r.mu.Unlock()
+
+	c := &runnerClaim{ConsumerGroupClaim: claim, msgs: make(chan *sarama.ConsumerMessage)}
+	go c.forward(sess.Context(), r.draining)
+	err := r.handler.ConsumeClaim(&runnerSession{ConsumerGroupSession: sess, r: r}, c)
+
+	r.mu.Lock()
+	r.active--
+	if r.active == 0 && r.isDraining() {
+		close(r.drained)
+	}
+	r.mu.Unlock()
+
+	// sarama在第一个分区返回时结束整个会话，关闭时等待其他分区处理完成再返回
+	if r.isDraining() {
+		select {
+		case <-r.drained:
+		case <-sess.Context().Done():
+		}
+	}
+	return err
+}
+
+func (r *Runner) commitInterval() time.Duration {
+	if r.CommitInterval == 0 {
+		return defaultCommitInterval
+	}
+	return r.CommitInterval
+}
+
+// runnerSession 记录标记和提交的时间
+type runnerSession struct {
+	sarama.ConsumerGroupSession
+	r *Runner
+}
+
+func (s *runnerSession) MarkMessage(msg *sarama.ConsumerMessage, metadata string) {
+	s.ConsumerGroupSession.MarkMessage(msg, metadata)
+	s.mark()
+}
+
+func (s *runnerSession) MarkOffset(topic string, partition int32, offset int64, metadata string) {
+	s.ConsumerGroupSession.MarkOffset(topic, partition, offset, metadata)
+	s.mark()
+}
+
+func (s *runnerSession) mark() {
+	s.r.mu.Lock()
+	s.r.lastMark = time.Now()
+	s.r.mu.Unlock()
+}
+
+// Commit 提交并记录为待确认，上一次提交之后没有收到错误时确认其成功
+func (s *runnerSession) Commit() {
+	r := s.r
+	r.mu.Lock()
+	r.settleCommit(true)
+	r.commitAt = time.Now()
+	r.commitErrs = r.errs
+	r.mu.Unlock()
+	s.ConsumerGroupSession.Commit()
+}
+
+// runnerClaim 转发分区的消息，关闭时关闭消息channel使handler处理完当前消息后返回
+type runnerClaim struct {
+	sarama.ConsumerGroupClaim
+	msgs chan *sarama.ConsumerMessage
+}
+
+func (c *runnerClaim) Messages() <-chan *sarama.ConsumerMessage {
+	return c.msgs
+}
+
+func (c *runnerClaim) forward(ctx context.Context, draining <-chan struct{}) {
+	defer close(c.msgs)
+	in := c.ConsumerGroupClaim.Messages()
+	for {
+		select {
+		case msg, ok := <-in:
+			if !ok {
+				return
+			}
+			select {
+			case <-draining:
+				return
+			default:
+			}
+			select {
+			case c.msgs <- msg:
+			case <-draining:
+				return
+			case <-ctx.Done():
+				return
+			}
+		case <-draining:
+			return
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,247 @@
+package mkafka
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/Shopify/sarama"
+)
+
+// 连接安全集群相关：TLS证书加载、SASL(PLAIN/SCRAM/OAUTHBEARER)认证，以及从环境变量或文件加载认证配置
+
+// 安全协议，与kafka的security.protocol取值一致
+const (
+	ProtocolPlaintext     = "PLAINTEXT"
+	ProtocolSSL           = "SSL"
+	ProtocolSASLPlaintext = "SASL_PLAINTEXT"
+	ProtocolSASLSSL       = "SASL_SSL"
+)
+
+// TokenProvider 为OAUTHBEARER认证提供access token，每次建立连接时调用，实现方负责token的缓存和刷新
+type TokenProvider interface {
+	Token() (*sarama.AccessToken, error)
+}
+
+// StaticTokenProvider 返回固定token
+type StaticTokenProvider string
+
+func (p StaticTokenProvider) Token() (*sarama.AccessToken, error) {
+	return &sarama.AccessToken{Token: string(p)}, nil
+}
+
+// FileTokenProvider 每次从文件读取token，适用于由外部进程定期轮换的token文件
+type FileTokenProvider string
+
+func (p FileTokenProvider) Token() (*sarama.AccessToken, error) {
+	b, err := os.ReadFile(string(p))
+	if err != nil {
+		logger.Err(err).Str("path", string(p)).Msg("读取token文件失败")
+		return nil, err
+	}
+	return &sarama.AccessToken{Token: strings.TrimSpace(string(b))}, nil
+}
+
+// WithSASLOAuthBearer 使用SASL/OAUTHBEARER认证
+func WithSASLOAuthBearer(p TokenProvider) Option {
+	return func(o *options) {
+		o.saslMechanism = sarama.SASLTypeOAuth
+		o.tokenProvider = p
+	}
+}
+
+// WithTLSFiles 使用文件中的证书建立TLS连接，caFile为空时使用系统根证书，certFile和keyFile同时提供时启用双向认证
+func WithTLSFiles(caFile, certFile, keyFile string) Option {
+	return func(o *options) {
+		conf, err := LoadTLSConfig(caFile, certFile, keyFile)
+		if err != nil {
+			o.err = err
+			return
+		}
+		o.tls = conf
+	}
+}
+
+// LoadTLSConfig 从PEM文件加载TLS配置
+func LoadTLSConfig(caFile, certFile, keyFile string) (*tls.Config, error) {
+	conf := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	if caFile != "" {
+		ca, err := os.ReadFile(caFile)
+		if err != nil {
+			logger.Err(err).Str("path", caFile).Msg("读取CA证书失败")
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("%w: CA证书%s中没有有效的PEM证书", ErrInvalidConfig, caFile)
+		}
+		conf.RootCAs = pool
+	}
+
+	if (certFile == "") != (keyFile == "") {
+		return nil, fmt.Errorf("%w: 客户端证书和私钥需要同时提供", ErrInvalidConfig)
+	}
+	if certFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			logger.Err(err).Str("cert", certFile).Str("key", keyFile).Msg("加载客户端证书失败")
+			return nil, err
+		}
+		conf.Certificates = []tls.Certificate{cert}
+	}
+	return conf, nil
+}
+
+// SecurityConfig 连接安全集群所需的配置，可从环境变量或JSON文件加载
+//
+// 密码和token优先使用直接提供的值，为空时从对应的文件读取
+type SecurityConfig struct {
+	Protocol      string `json:"protocol"`
+	SASLMechanism string `json:"sasl_mechanism"`
+	Username      string `json:"username"`
+	Password      string `json:"password"`
+	PasswordFile  string `json:"password_file"`
+	Token         string `json:"token"`
+	TokenFile     string `json:"token_file"`
+	CAFile        string `json:"ca_file"`
+	CertFile      string `json:"cert_file"`
+	KeyFile       string `json:"key_file"`
+	SkipVerify    bool   `json:"skip_verify"`
+}
+
+// SecurityConfigFromEnv 从环境变量加载安全配置，prefix为空时使用KAFKA_
+//
+// 读取的变量为：SECURITY_PROTOCOL, SASL_MECHANISM, SASL_USERNAME, SASL_PASSWORD, SASL_PASSWORD_FILE,
+// OAUTH_TOKEN, OAUTH_TOKEN_FILE, SSL_CA_FILE, SSL_CERT_FILE, SSL_KEY_FILE, SSL_SKIP_VERIFY
+func SecurityConfigFromEnv(prefix string) (*SecurityConfig, error) {
+	if prefix == "" {
+		prefix = "KAFKA_"
+	}
+	env := func(k string) string {
+		return os.Getenv(prefix + k)
+	}
+
+	c := &SecurityConfig{
+		Protocol:      env("SECURITY_PROTOCOL"),
+		SASLMechanism: env("SASL_MECHANISM"),
+		Username:      env("SASL_USERNAME"),
+		Password:      env("SASL_PASSWORD"),
+		PasswordFile:  env("SASL_PASSWORD_FILE"),
+		Token:         env("OAUTH_TOKEN"),
+		TokenFile:     env("OAUTH_TOKEN_FILE"),
+		CAFile:        env("SSL_CA_FILE"),
+		CertFile:      env("SSL_CERT_FILE"),
+		KeyFile:       env("SSL_KEY_FILE"),
+	}
+	if v := env("SSL_SKIP_VERIFY"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %sSSL_SKIP_VERIFY需要bool值", ErrInvalidConfig, prefix)
+		}
+		c.SkipVerify = b
+	}
+	return c, nil
+}
+
+// LoadSecurityConfig 从JSON文件加载安全配置
+func LoadSecurityConfig(path string) (*SecurityConfig, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		logger.Err(err).Str("path", path).Msg("读取安全配置文件失败")
+		return nil, err
+	}
+	c := &SecurityConfig{}
+	if err := json.Unmarshal(b, c); err != nil {
+		logger.Err(err).Str("path", path).Msg("解析安全配置文件失败")
+		return nil, err
+	}
+	return c, nil
+}
+
+// Options 将安全配置转换为Option，Protocol为空时根据是否配置了SASL机制和证书推断
+func (c *SecurityConfig) Options() ([]Option, error) {
+	protocol := strings.ToUpper(c.Protocol)
+	if protocol == "" {
+		useTLS := c.CAFile != "" || c.CertFile != "" || c.SkipVerify
+		switch {
+		case c.SASLMechanism != "" && useTLS:
+			protocol = ProtocolSASLSSL
+		case c.SASLMechanism != "":
+			protocol = ProtocolSASLPlaintext
+		case useTLS:
+			protocol = ProtocolSSL
+		default:
+			protocol = ProtocolPlaintext
+		}
+	}
+
+	opts := make([]Option, 0, 2)
+	switch protocol {
+	case ProtocolPlaintext, ProtocolSASLPlaintext:
+	case ProtocolSSL, ProtocolSASLSSL:
+		conf, err := LoadTLSConfig(c.CAFile, c.CertFile, c.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		conf.InsecureSkipVerify = c.SkipVerify
+		opts = append(opts, WithTLS(conf))
+	default:
+		return nil, fmt.Errorf("%w: 不支持的安全协议%s", ErrInvalidConfig, c.Protocol)
+	}
+
+	if protocol == ProtocolPlaintext || protocol == ProtocolSSL {
+		return opts, nil
+	}
+
+	mechanism := sarama.SASLMechanism(strings.ToUpper(c.SASLMechanism))
+	switch mechanism {
+	case sarama.SASLTypeOAuth:
+		var p TokenProvider
+		switch {
+		case c.Token != "":
+			p = StaticTokenProvider(c.Token)
+		case c.TokenFile != "":
+			p = FileTokenProvider(c.TokenFile)
+		default:
+			return nil, fmt.Errorf("%w: OAUTHBEARER认证需要提供token或token文件", ErrInvalidConfig)
+		}
+		opts = append(opts, WithSASLOAuthBearer(p))
+	case sarama.SASLTypePlaintext, sarama.SASLTypeSCRAMSHA256, sarama.SASLTypeSCRAMSHA512:
+		password := c.Password
+		if password == "" && c.PasswordFile != "" {
+			b, err := os.ReadFile(c.PasswordFile)
+			if err != nil {
+				logger.Err(err).Str("path", c.PasswordFile).Msg("读取密码文件失败")
+				return nil, err
+			}
+			password = strings.TrimSpace(string(b))
+		}
+		if mechanism == sarama.SASLTypePlaintext {
+			opts = append(opts, WithSASLPlain(c.Username, password))
+		} else {
+			opts = append(opts, WithSASLScram(mechanism, c.Username, password))
+		}
+	default:
+		return nil, fmt.Errorf("%w: 不支持的SASL机制%s", ErrInvalidConfig, c.SASLMechanism)
+	}
+	return opts, nil
+}
+
+// WithSecurity 应用安全配置，配置无效时在生成配置时返回错误
+func WithSecurity(c *SecurityConfig) Option {
+	return func(o *options) {
+		opts, err := c.Options()
+		if err != nil {
+			o.err = err
+			return
+		}
+		for _, opt := range opts {
+			opt(o)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package mkafka_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/Shopify/sarama"
+	"github.com/mouseleee/mlib/mkafka"
+	"github.com/mouseleee/mlib/mkafka/kafkatest"
+)
+
+func TestEnsureTopics(t *testing.T) {
+	admin := kafkatest.NewCluster().NewClusterAdmin()
+
+	spec := mkafka.TopicSpec{
+		Name:              "TEST_ENSURE_TOPIC",
+		Partitions:        1,
+		ReplicationFactor: 1,
+		Configs: map[string]string{
+			mkafka.TopicRetentionMs:   "3600000",
+			mkafka.TopicCleanupPolicy: "delete",
+		},
+	}
+	if err := mkafka.EnsureTopics(admin, spec); err != nil {
+		t.Fatal(err)
+	}
+
+	// 第二次调用扩充分区并修改配置
+	spec.Partitions = 2
+	spec.Configs[mkafka.TopicRetentionMs] = "7200000"
+	if err := mkafka.EnsureTopics(admin, spec); err != nil {
+		t.Fatal(err)
+	}
+
+	conf2, err := mkafka.DescribeTopicConfig(admin, spec.Name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conf2[mkafka.TopicCleanupPolicy] != "delete" {
+		t.Errorf("cleanup.policy = %s", conf2[mkafka.TopicCleanupPolicy])
+	}
+	if conf2[mkafka.TopicRetentionMs] != "7200000" {
+		t.Errorf("retention.ms = %s", conf2[mkafka.TopicRetentionMs])
+	}
+
+	topics, err := mkafka.ListTopics(admin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tp := range topics {
+		if tp.Name == spec.Name && tp.Partitions != 2 {
+			t.Errorf("partitions = %d", tp.Partitions)
+		}
+	}
+}
+
+func TestEnsureTopicsExisting(t *testing.T) {
+	c := kafkatest.NewCluster()
+	admin := c.NewClusterAdmin()
+	if err := c.CreateTopic("TEST_EXISTING", 3); err != nil {
+		t.Fatal(err)
+	}
+
+	// 分区数无法缩减，不应返回错误
+	err := mkafka.EnsureTopics(admin, mkafka.TopicSpec{Name: "TEST_EXISTING", Partitions: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ps, _ := c.Partitions("TEST_EXISTING"); len(ps) != 3 {
+		t.Errorf("partitions = %d", len(ps))
+	}
+
+	if err := mkafka.CreateTopics(admin, mkafka.TopicSpec{Name: "TEST_EXISTING"}); !errors.Is(err, sarama.ErrTopicAlreadyExists) {
+		t.Error(err)
+	}
+}
+
+// staleListAdmin 第一次ListTopics返回空列表，模拟列出topic之后被其他进程抢先创建
+type staleListAdmin struct {
+	sarama.ClusterAdmin
+	listed bool
+}
+
+func (a *staleListAdmin) ListTopics() (map[string]sarama.TopicDetail, error) {
+	if !a.listed {
+		a.listed = true
+		return map[string]sarama.TopicDetail{}, nil
+	}
+	return a.ClusterAdmin.ListTopics()
+}
+
+func TestEnsureTopicsConcurrentCreate(t *testing.T) {
+	c := kafkatest.NewCluster()
+	if err := c.CreateTopic("TEST_ENSURE_RACE", 1); err != nil {
+		t.Fatal(err)
+	}
+	admin := &staleListAdmin{ClusterAdmin: c.NewClusterAdmin()}
+
+	// 对方以1个分区和默认配置创建，TopicAlreadyExists后仍按spec扩充分区和修改配置
+	spec := mkafka.TopicSpec{Name: "TEST_ENSURE_RACE", Partitions: 3, Configs: map[string]string{mkafka.TopicRetentionMs: "3600000"}}
+	if err := mkafka.EnsureTopics(admin, spec); err != nil {
+		t.Fatal(err)
+	}
+	if ps, _ := c.Partitions("TEST_ENSURE_RACE"); len(ps) != 3 {
+		t.Errorf("partitions = %d", len(ps))
+	}
+	conf, err := mkafka.DescribeTopicConfig(admin, spec.Name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conf[mkafka.TopicRetentionMs] != "3600000" {
+		t.Errorf("retention.ms = %s", conf[mkafka.TopicRetentionMs])
+	}
+}
@@ -0,0 +1,113 @@
+package mkafka_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/mouseleee/mlib/mkafka"
+	"github.com/mouseleee/mlib/mkafka/kafkatest"
+)
+
+func TestBatchGroupHandler(t *testing.T) {
+	c := kafkatest.NewCluster()
+	produce(t, c, "TEST_BATCH", 25)
+
+	var (
+		mu    sync.Mutex
+		sizes []int
+		total int
+	)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	h := mkafka.NewBatchGroupHandler(func(_ context.Context, msgs []*sarama.ConsumerMessage) error {
+		mu.Lock()
+		defer mu.Unlock()
+		sizes = append(sizes, len(msgs))
+		if total += len(msgs); total == 25 {
+			cancel()
+		}
+		return nil
+	}, mkafka.BatchConfig{MaxMessages: 10, MaxWait: 50 * time.Millisecond})
+
+	csm := c.NewConsumerGroup("test.group", mkafka.DefaultConsumerConfig())
+	for ctx.Err() == nil {
+		if err := csm.Consume(ctx, []string{"TEST_BATCH"}, h); err != nil {
+			t.Fatal(err)
+		}
+	}
+	csm.Close()
+
+	// 前两批按数量触发，最后一批按时间触发
+	if len(sizes) != 3 || sizes[0] != 10 || sizes[1] != 10 || sizes[2] != 5 {
+		t.Errorf("sizes = %v", sizes)
+	}
+	if off := c.CommittedOffset("test.group", "TEST_BATCH", 0); off != 25 {
+		t.Errorf("committed = %d", off)
+	}
+}
+
+func TestBatchGroupHandlerBytes(t *testing.T) {
+	c := kafkatest.NewCluster()
+	produce(t, c, "TEST_BATCH_BYTES", 6)
+
+	var sizes []int
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	// 每条消息约30字节，64字节的限制下每批2条
+	h := mkafka.NewBatchGroupHandler(func(_ context.Context, msgs []*sarama.ConsumerMessage) error {
+		if sizes = append(sizes, len(msgs)); len(sizes) == 3 {
+			cancel()
+		}
+		return nil
+	}, mkafka.BatchConfig{MaxBytes: 64, MaxWait: time.Second})
+
+	csm := c.NewConsumerGroup("test.group", mkafka.DefaultConsumerConfig())
+	defer csm.Close()
+	for ctx.Err() == nil {
+		if err := csm.Consume(ctx, []string{"TEST_BATCH_BYTES"}, h); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(sizes) != 3 || sizes[0] != 2 {
+		t.Errorf("sizes = %v", sizes)
+	}
+}
+
+func TestBatchGroupHandlerPartialFailure(t *testing.T) {
+	c := kafkatest.NewCluster()
+	produce(t, c, "TEST_BATCH_ERR", 20)
+
+	fail := errors.New("fail")
+	var calls int
+	h := mkafka.NewBatchGroupHandler(func(_ context.Context, msgs []*sarama.ConsumerMessage) error {
+		// 第二批的第4条失败
+		if calls++; calls == 2 {
+			return &mkafka.BatchError{Index: 3, Err: fail}
+		}
+		return nil
+	}, mkafka.BatchConfig{MaxMessages: 10})
+
+	csm := c.NewConsumerGroup("test.group", mkafka.DefaultConsumerConfig())
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	if err := csm.Consume(ctx, []string{"TEST_BATCH_ERR"}, h); err != nil {
+		t.Fatal(err)
+	}
+	csm.Close()
+
+	if calls != 2 {
+		t.Errorf("calls = %d", calls)
+	}
+	if off := c.CommittedOffset("test.group", "TEST_BATCH_ERR", 0); off != 13 {
+		t.Errorf("committed = %d", off)
+	}
+
+	var be *mkafka.BatchError
+	if err := error(&mkafka.BatchError{Index: 3, Err: fail}); !errors.As(err, &be) || !errors.Is(err, fail) {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,68 @@
+package mkafka_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/mouseleee/mlib/mkafka"
+)
+
+func TestNewConsumerConfig(t *testing.T) {
+	conf, err := mkafka.NewConsumerConfig(
+		mkafka.WithClientID("mlib-test"),
+		mkafka.WithVersion(sarama.V2_4_0_0),
+		mkafka.WithSASLScram(sarama.SASLTypeSCRAMSHA512, "user", "pass"),
+		mkafka.WithRebalanceStrategy(sarama.BalanceStrategySticky),
+		mkafka.WithIsolationLevel(sarama.ReadCommitted),
+		mkafka.WithSessionTimeout(30*time.Second, 5*time.Second),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conf.Consumer.Offsets.AutoCommit.Enable {
+		t.Error("消费者配置不应开启自动提交")
+	}
+	if conf.Producer.Idempotent {
+		t.Error("消费者配置不应包含生产者幂等配置")
+	}
+	if !conf.Net.SASL.Enable || conf.Net.SASL.SCRAMClientGeneratorFunc == nil {
+		t.Error("SCRAM未生效")
+	}
+	if conf.Consumer.IsolationLevel != sarama.ReadCommitted {
+		t.Error("隔离级别未生效")
+	}
+}
+
+func TestNewProducerConfig(t *testing.T) {
+	conf, err := mkafka.NewProducerConfig(mkafka.WithClientID("mlib-test"), mkafka.WithSASLPlain("user", "pass"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !conf.Producer.Idempotent || conf.Net.SASL.Mechanism != sarama.SASLTypePlaintext {
+		t.Error("生产者配置未生效")
+	}
+}
+
+func TestInvalidConfig(t *testing.T) {
+	cases := map[string][]mkafka.Option{
+		"低版本幂等":  {mkafka.WithVersion(sarama.V0_10_2_0)},
+		"缺少密码":   {mkafka.WithSASLPlain("user", "")},
+		"未知SASL": {mkafka.WithSASLScram("SCRAM-MD5", "user", "pass")},
+	}
+	for name, opts := range cases {
+		if _, err := mkafka.NewProducerConfig(opts...); !errors.Is(err, mkafka.ErrInvalidConfig) {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+
+	_, err := mkafka.NewConsumerConfig(mkafka.WithSessionTimeout(10*time.Second, 5*time.Second))
+	if !errors.Is(err, mkafka.ErrInvalidConfig) {
+		t.Error(err)
+	}
+	_, err = mkafka.NewConsumerConfig(mkafka.WithVersion(sarama.V0_10_2_0), mkafka.WithIsolationLevel(sarama.ReadCommitted))
+	if !errors.Is(err, mkafka.ErrInvalidConfig) {
+		t.Error(err)
+	}
+}
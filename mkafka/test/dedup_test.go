@@ -0,0 +1,106 @@
+package mkafka_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/mouseleee/mlib/mkafka"
+	"github.com/mouseleee/mlib/mkafka/kafkatest"
+	"github.com/rs/zerolog"
+)
+
+func TestDefaultDedupKey(t *testing.T) {
+	msg := &sarama.ConsumerMessage{Topic: "T", Partition: 2, Offset: 9}
+	if k := mkafka.DefaultDedupKey(msg); k != "T/2/9" {
+		t.Errorf("key = %s", k)
+	}
+	msg.Headers = []*sarama.RecordHeader{{Key: []byte(mkafka.HeaderDedupKey), Value: []byte("order-1")}}
+	if k := mkafka.DefaultDedupKey(msg); k != "T/order-1" {
+		t.Errorf("key = %s", k)
+	}
+}
+
+func TestDedup(t *testing.T) {
+	mkafka.SetLogger(zerolog.Nop(), false)
+
+	store := mkafka.NewMemoryDedupStore()
+	var calls int
+	fail := errors.New("fail")
+	h := mkafka.Dedup(mkafka.DedupConfig{Store: store})(func(_ context.Context, msg *sarama.ConsumerMessage) error {
+		calls++
+		if string(msg.Value) == "fail" {
+			return fail
+		}
+		return nil
+	})
+	ctx := context.Background()
+
+	msg := &sarama.ConsumerMessage{Topic: "T", Offset: 1}
+	for i := 0; i < 3; i++ {
+		if err := h(ctx, msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if calls != 1 {
+		t.Errorf("calls = %d", calls)
+	}
+
+	// 处理失败后释放，可以重新处理
+	bad := &sarama.ConsumerMessage{Topic: "T", Offset: 2, Value: []byte("fail")}
+	if err := h(ctx, bad); !errors.Is(err, fail) {
+		t.Fatal(err)
+	}
+	bad.Value = nil
+	if err := h(ctx, bad); err != nil {
+		t.Fatal(err)
+	}
+	if calls != 3 {
+		t.Errorf("calls = %d", calls)
+	}
+
+	// 正在处理中的消息
+	if ok, _, _ := store.Begin(ctx, "T/0/3", time.Minute); !ok {
+		t.Fatal("begin failed")
+	}
+	if err := h(ctx, &sarama.ConsumerMessage{Topic: "T", Offset: 3}); !errors.Is(err, mkafka.ErrDedupInFlight) {
+		t.Errorf("err = %v", err)
+	}
+
+	// 占用过期后可以重新处理
+	if ok, _, _ := store.Begin(ctx, "lease", time.Millisecond); !ok {
+		t.Fatal("begin failed")
+	}
+	time.Sleep(5 * time.Millisecond)
+	if ok, done, _ := store.Begin(ctx, "lease", time.Minute); !ok || done {
+		t.Errorf("acquired = %v, done = %v", ok, done)
+	}
+}
+
+func TestDedupRedelivery(t *testing.T) {
+	mkafka.SetLogger(zerolog.Nop(), false)
+	c := kafkatest.NewCluster()
+	prd := c.NewSyncProducer(nil)
+	// 同一个业务事件被投递两次
+	for i := 0; i < 2; i++ {
+		_, _, err := prd.SendMessage(&sarama.ProducerMessage{
+			Topic:   "TEST_DEDUP",
+			Value:   sarama.StringEncoder("v"),
+			Headers: []sarama.RecordHeader{{Key: []byte(mkafka.HeaderDedupKey), Value: []byte("event-1")}},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var calls int
+	consumeWith(t, c, "test.group", "TEST_DEDUP", 2, func(context.Context, *sarama.ConsumerMessage) error {
+		calls++
+		return nil
+	}, mkafka.Dedup(mkafka.DedupConfig{Store: mkafka.NewMemoryDedupStore()}))
+	if calls != 1 {
+		t.Errorf("calls = %d", calls)
+	}
+}
@@ -0,0 +1,88 @@
+package mkafka_test
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/mouseleee/mlib/mkafka"
+	"github.com/mouseleee/mlib/mkafka/kafkatest"
+	"github.com/rs/zerolog"
+)
+
+func TestDelayScheduler(t *testing.T) {
+	mkafka.SetLogger(zerolog.Nop(), false)
+	c := kafkatest.NewCluster()
+	s := mkafka.NewDelayScheduler(c.NewSyncProducer(nil), "", 200*time.Millisecond, 50*time.Millisecond)
+
+	if ts := s.DelayTopics(); len(ts) != 2 || ts[0] != "mkafka.delay.50ms" || ts[1] != "mkafka.delay.200ms" {
+		t.Fatalf("topics = %v", ts)
+	}
+
+	start := time.Now()
+	at := start.Add(300 * time.Millisecond)
+	_, _, err := s.Schedule(&sarama.ProducerMessage{
+		Topic:   "TEST_DELAY",
+		Key:     sarama.StringEncoder("k"),
+		Value:   sarama.StringEncoder("v"),
+		Headers: []sarama.RecordHeader{{Key: []byte("h"), Value: []byte("1")}},
+	}, at)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 已到期的消息直接发送
+	if _, _, err := s.Schedule(&sarama.ProducerMessage{Topic: "TEST_DELAY", Value: sarama.StringEncoder("now")}, start); err != nil {
+		t.Fatal(err)
+	}
+
+	msgs := c.Messages("mkafka.delay.200ms", 0)
+	if len(msgs) != 1 {
+		t.Fatalf("delay messages = %d", len(msgs))
+	}
+	if v, _ := mkafka.HeaderValue(msgs[0], mkafka.HeaderDeliverAt); string(v) != strconv.FormatInt(at.UnixMilli(), 10) {
+		t.Errorf("deliver at = %s", v)
+	}
+	if got := c.Messages("TEST_DELAY", 0); len(got) != 1 || string(got[0].Value) != "now" {
+		t.Fatalf("target = %v", got)
+	}
+
+	// 200ms级别到期后剩余约100ms，转入50ms级别，再到期后投递
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	csm := c.NewConsumerGroup("delay.forwarder", mkafka.DefaultConsumerConfig())
+	defer csm.Close()
+	go func() {
+		h := mkafka.NewGroupHandler(s.Forwarder())
+		for ctx.Err() == nil {
+			csm.Consume(ctx, s.DelayTopics(), h)
+		}
+	}()
+
+	for len(c.Messages("TEST_DELAY", 0)) < 2 {
+		if ctx.Err() != nil {
+			t.Fatal("延迟消息未投递")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	got := c.Messages("TEST_DELAY", 0)[1]
+	if got.Timestamp.Before(at.Truncate(time.Millisecond)) {
+		t.Errorf("提前投递 %v < %v", got.Timestamp, at)
+	}
+	if string(got.Key) != "k" || string(got.Value) != "v" {
+		t.Errorf("message = %s:%s", got.Key, got.Value)
+	}
+	if v, _ := mkafka.HeaderValue(got, "h"); string(v) != "1" {
+		t.Errorf("header h = %s", v)
+	}
+	if _, ok := mkafka.HeaderValue(got, mkafka.HeaderDelayTarget); ok {
+		t.Error("延迟header未移除")
+	}
+	if v, _ := mkafka.HeaderValue(got, mkafka.HeaderDedupKey); string(v) != "mkafka.delay.200ms/0/0" {
+		t.Errorf("dedup key = %s", v)
+	}
+	if n := len(c.Messages("mkafka.delay.50ms", 0)); n == 0 {
+		t.Errorf("50ms messages = %d", n)
+	}
+}
@@ -0,0 +1,225 @@
+package mkafka_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/mouseleee/mlib/mkafka"
+	"github.com/mouseleee/mlib/mkafka/kafkatest"
+	"github.com/rcrowley/go-metrics"
+	"github.com/rs/zerolog"
+)
+
+// TestExporterMetricNames 导出的指标名称是对外约定，修改时需要同步修改此测试
+func TestExporterMetricNames(t *testing.T) {
+	mkafka.SetLogger(zerolog.Nop(), false)
+	c := kafkatest.NewCluster()
+	if err := c.CreateTopic("TEST_EXPORT", 2); err != nil {
+		t.Fatal(err)
+	}
+	e := mkafka.NewExporter("")
+
+	pconf := sarama.NewConfig()
+	pconf.Producer.Partitioner = sarama.NewManualPartitioner
+	prd := e.InstrumentProducer(c.NewSyncProducer(pconf))
+	for i := 0; i < 4; i++ {
+		if _, _, err := prd.SendMessage(&sarama.ProducerMessage{Topic: "TEST_EXPORT", Partition: int32(i % 2), Value: sarama.StringEncoder("v")}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// 每个分区2条消息，分区0的最后一条处理失败
+	csm := c.NewConsumerGroup("test.group", mkafka.DefaultConsumerConfig())
+	var n int32
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	h := e.InstrumentGroupHandler("test.group", mkafka.NewGroupHandler(func(_ context.Context, msg *sarama.ConsumerMessage) error {
+		if atomic.AddInt32(&n, 1) == 4 {
+			cancel()
+		}
+		if msg.Partition == 0 && msg.Offset == 1 {
+			return errors.New("fail")
+		}
+		return nil
+	}, mkafka.Metrics(e)))
+	for ctx.Err() == nil {
+		csm.Consume(ctx, []string{"TEST_EXPORT"}, h)
+	}
+	csm.Close()
+
+	conf := sarama.NewConfig()
+	metrics.GetOrRegisterMeter("record-send-rate", conf.MetricRegistry).Mark(3)
+	metrics.GetOrRegisterHistogram("request-latency-in-ms", conf.MetricRegistry, metrics.NewUniformSample(10)).Update(5)
+	e.RegisterSarama("producer-1", conf)
+	e.WatchLag(c.NewClusterAdmin(), c, "test.group", "TEST_EXPORT")
+
+	srv := httptest.NewServer(e)
+	defer srv.Close()
+	resp, err := srv.Client().Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	b, _ := io.ReadAll(resp.Body)
+	body := string(b)
+	if ct := resp.Header.Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("content-type = %s", ct)
+	}
+
+	for _, s := range []string{
+		"# TYPE mkafka_producer_messages_total counter\n",
+		`mkafka_producer_messages_total{topic="TEST_EXPORT"} 4` + "\n",
+		"# TYPE mkafka_producer_send_latency_seconds histogram\n",
+		`mkafka_producer_send_latency_seconds_bucket{topic="TEST_EXPORT",le="+Inf"} 4` + "\n",
+		`mkafka_producer_send_latency_seconds_count{topic="TEST_EXPORT"} 4` + "\n",
+		`mkafka_consumer_messages_total{topic="TEST_EXPORT"} 4` + "\n",
+		`mkafka_consumer_errors_total{topic="TEST_EXPORT"} 1` + "\n",
+		`mkafka_consumer_processing_latency_seconds_count{topic="TEST_EXPORT"} 4` + "\n",
+		`mkafka_consumer_rebalances_total{group="test.group"} `,
+		`mkafka_consumer_assigned_partitions{group="test.group"} 2` + "\n",
+		"# TYPE mkafka_consumer_group_lag gauge\n",
+		`mkafka_consumer_group_lag{group="test.group",topic="TEST_EXPORT",partition="0"} 1` + "\n",
+		`mkafka_consumer_group_lag{group="test.group",topic="TEST_EXPORT",partition="1"} 0` + "\n",
+		`mkafka_sarama_record_send_rate_total{client="producer-1"} 3` + "\n",
+		"# TYPE mkafka_sarama_record_send_rate_rate1m gauge\n",
+		"# TYPE mkafka_sarama_request_latency_in_ms summary\n",
+		`mkafka_sarama_request_latency_in_ms{client="producer-1",quantile="0.99"} 5` + "\n",
+		`mkafka_sarama_request_latency_in_ms_count{client="producer-1"} 1` + "\n",
+	} {
+		if !strings.Contains(body, s) {
+			t.Errorf("缺少 %q", s)
+		}
+	}
+	if t.Failed() {
+		t.Log(body)
+	}
+}
+
+// lockedWriter 写入时再记录指标，Write持有锁时会死锁
+type lockedWriter struct {
+	e   *mkafka.Exporter
+	buf strings.Builder
+}
+
+func (w *lockedWriter) Write(p []byte) (int, error) {
+	w.e.ObserveSend("TEST_EXPORT", time.Millisecond, nil)
+	return w.buf.Write(p)
+}
+
+func TestExporterMultipleClients(t *testing.T) {
+	e := mkafka.NewExporter("")
+	for _, client := range []string{"producer-1", "producer-2"} {
+		conf := sarama.NewConfig()
+		metrics.GetOrRegisterMeter("record-send-rate", conf.MetricRegistry).Mark(3)
+		metrics.GetOrRegisterHistogram("request-latency-in-ms", conf.MetricRegistry, metrics.NewUniformSample(10)).Update(5)
+		e.RegisterSarama(client, conf)
+	}
+
+	w := &lockedWriter{e: e}
+	done := make(chan error, 1)
+	go func() {
+		done <- e.Write(context.Background(), w)
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Write持有锁写出")
+	}
+
+	body := w.buf.String()
+	for s, n := range map[string]int{
+		"# TYPE mkafka_sarama_record_send_rate_total counter\n":            1,
+		"# TYPE mkafka_sarama_request_latency_in_ms summary\n":             1,
+		`mkafka_sarama_record_send_rate_total{client="producer-1"} 3`:      1,
+		`mkafka_sarama_record_send_rate_total{client="producer-2"} 3`:      1,
+		`mkafka_sarama_request_latency_in_ms_count{client="producer-2"} 1`: 1,
+	} {
+		if c := strings.Count(body, s); c != n {
+			t.Errorf("%q 出现 %d 次", s, c)
+		}
+	}
+	if t.Failed() {
+		t.Log(body)
+	}
+}
+
+func TestExporterLabelEscape(t *testing.T) {
+	e := mkafka.NewExporter("")
+	e.ObserveSend("订单\"a\\b\nc", time.Millisecond, nil)
+
+	var buf strings.Builder
+	if err := e.Write(context.Background(), &buf); err != nil {
+		t.Fatal(err)
+	}
+	// 只转义反斜杠、双引号和换行，非ASCII字符原样输出
+	want := `mkafka_producer_messages_total{topic="订单\"a\\b\nc"} 1` + "\n"
+	if !strings.Contains(buf.String(), want) {
+		t.Errorf("缺少 %q\n%s", want, buf.String())
+	}
+}
+
+// blockingSource 获取offset时阻塞，模拟无响应的broker
+type blockingSource struct {
+	mkafka.OffsetSource
+	release chan struct{}
+	calls   int32
+}
+
+func (s *blockingSource) GetOffset(topic string, partitionID int32, time int64) (int64, error) {
+	atomic.AddInt32(&s.calls, 1)
+	<-s.release
+	return s.OffsetSource.GetOffset(topic, partitionID, time)
+}
+
+func TestExporterLagTimeout(t *testing.T) {
+	mkafka.SetLogger(zerolog.Nop(), false)
+	c := kafkatest.NewCluster()
+	produce(t, c, "TEST_EXPORT_LAG", 1)
+	admin := c.NewClusterAdmin()
+	if _, err := mkafka.ResetGroupOffsets(c.NewClient(), "lag.slow", mkafka.ResetSpec{Mode: mkafka.ResetEarliest, Topics: []string{"TEST_EXPORT_LAG"}, Out: io.Discard}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := mkafka.ResetGroupOffsets(c.NewClient(), "lag.ok", mkafka.ResetSpec{Mode: mkafka.ResetEarliest, Topics: []string{"TEST_EXPORT_LAG"}, Out: io.Discard}); err != nil {
+		t.Fatal(err)
+	}
+
+	e := mkafka.NewExporter("")
+	e.LagTimeout = 50 * time.Millisecond
+	slow := &blockingSource{OffsetSource: c, release: make(chan struct{})}
+	defer close(slow.release)
+	e.WatchLag(admin, slow, "lag.slow", "TEST_EXPORT_LAG")
+	e.WatchLag(admin, c, "lag.ok", "TEST_EXPORT_LAG")
+
+	// 无响应的消费者组在LagTimeout后跳过，其余消费者组照常输出
+	for i := 0; i < 2; i++ {
+		var buf strings.Builder
+		start := time.Now()
+		if err := e.Write(context.Background(), &buf); err != nil {
+			t.Fatal(err)
+		}
+		if d := time.Since(start); d > time.Second {
+			t.Errorf("Write took %v", d)
+		}
+		body := buf.String()
+		if !strings.Contains(body, `mkafka_consumer_group_lag{group="lag.ok",topic="TEST_EXPORT_LAG",partition="0"} 1`) {
+			t.Errorf("缺少lag.ok的延迟\n%s", body)
+		}
+		if strings.Contains(body, `group="lag.slow"`) {
+			t.Errorf("输出了超时的lag.slow\n%s", body)
+		}
+	}
+	// 上一次计算未返回时不再发起新的计算
+	if n := atomic.LoadInt32(&slow.calls); n != 1 {
+		t.Errorf("slow source called %d times, want 1", n)
+	}
+}
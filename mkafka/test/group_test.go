@@ -0,0 +1,171 @@
+package mkafka_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/Shopify/sarama"
+	"github.com/mouseleee/mlib/mkafka"
+	"github.com/mouseleee/mlib/mkafka/kafkatest"
+)
+
+func TestResetPlanString(t *testing.T) {
+	p := &mkafka.ResetPlan{
+		Group: "test.group",
+		Mode:  mkafka.ResetEarliest,
+		Changes: []mkafka.OffsetChange{
+			{Topic: "t1", Partition: 0, Current: 10, Target: 0},
+			{Topic: "t1", Partition: 1, Current: -1, Target: 0},
+		},
+	}
+	s := p.String()
+	if !strings.Contains(s, "GROUP test.group RESET TO earliest") {
+		t.Error(s)
+	}
+	if !strings.Contains(s, "-10") {
+		t.Error(s)
+	}
+}
+
+func TestConsumerGroupOffsets(t *testing.T) {
+	c := kafkatest.NewCluster()
+	admin := c.NewClusterAdmin()
+	if err := c.CreateTopic("TEST_LAG", 2); err != nil {
+		t.Fatal(err)
+	}
+	produce(t, c, "TEST_LAG", 10)
+	consume(t, c, "test.group", "TEST_LAG", 4)
+
+	groups, err := mkafka.ListConsumerGroups(admin)
+	if err != nil || len(groups) != 1 || groups[0] != "test.group" {
+		t.Error(groups, err)
+	}
+
+	lags, err := mkafka.ConsumerGroupLag(admin, c, "test.group", "TEST_LAG")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var total int64
+	for _, l := range lags {
+		total += l.Lag
+	}
+	if total != 6 {
+		t.Errorf("lag = %d", total)
+	}
+
+	plan, err := mkafka.PlanOffsetReset(admin, c, "test.group", mkafka.ResetSpec{
+		Mode:       mkafka.ResetToOffset,
+		Partitions: map[string][]int32{"TEST_LAG": {0}},
+		Offset:     100,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plan.Changes) != 1 || plan.Changes[0].Target != 5 {
+		t.Error(plan)
+	}
+}
+
+func TestResetGroupOffsets(t *testing.T) {
+	c := kafkatest.NewCluster()
+	if err := c.CreateTopic("TEST_RESET", 2); err != nil {
+		t.Fatal(err)
+	}
+	produce(t, c, "TEST_RESET", 10)
+	consume(t, c, "test.group", "TEST_RESET", 10)
+	client := c.NewClient()
+	defer client.Close()
+
+	// 全部分区重置到最早
+	plan, err := mkafka.ResetGroupOffsets(client, "test.group", mkafka.ResetSpec{Mode: mkafka.ResetEarliest, Topics: []string{"TEST_RESET"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plan.Changes) != 2 {
+		t.Errorf("plan = %v", plan)
+	}
+	for p := int32(0); p < 2; p++ {
+		if off := c.CommittedOffset("test.group", "TEST_RESET", p); off != 0 {
+			t.Errorf("partition %d committed = %d", p, off)
+		}
+	}
+
+	// 只重置指定分区，超出范围的offset截断到高水位
+	newest, _ := c.GetOffset("TEST_RESET", 1, sarama.OffsetNewest)
+	_, err = mkafka.ResetGroupOffsets(client, "test.group", mkafka.ResetSpec{
+		Mode:       mkafka.ResetToOffset,
+		Offset:     100,
+		Partitions: map[string][]int32{"TEST_RESET": {1}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if off := c.CommittedOffset("test.group", "TEST_RESET", 0); off != 0 {
+		t.Errorf("partition 0 committed = %d", off)
+	}
+	if off := c.CommittedOffset("test.group", "TEST_RESET", 1); off != newest {
+		t.Errorf("partition 1 committed = %d, want %d", off, newest)
+	}
+}
+
+func TestResetGroupOffsetsActive(t *testing.T) {
+	c := kafkatest.NewCluster()
+	produce(t, c, "TEST_RESET_ACTIVE", 3)
+	client := c.NewClient()
+	defer client.Close()
+
+	csm := c.NewConsumerGroup("test.group", mkafka.DefaultConsumerConfig())
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for ctx.Err() == nil {
+			csm.Consume(ctx, []string{"TEST_RESET_ACTIVE"}, mkafka.NewGroupHandler(func(context.Context, *sarama.ConsumerMessage) error { return nil }))
+		}
+	}()
+	defer func() {
+		cancel()
+		<-done
+		csm.Close()
+	}()
+
+	admin := c.NewClusterAdmin()
+	waitFor(t, "join", func() bool {
+		infos, err := mkafka.DescribeConsumerGroups(admin, "test.group")
+		return err == nil && len(infos) == 1 && infos[0].State == "Stable"
+	})
+
+	// 有活跃成员时拒绝重置，offset没有被重置到最早
+	_, err := mkafka.ResetGroupOffsets(client, "test.group", mkafka.ResetSpec{Mode: mkafka.ResetEarliest, Topics: []string{"TEST_RESET_ACTIVE"}})
+	if !errors.Is(err, mkafka.ErrGroupActive) {
+		t.Errorf("err = %v", err)
+	}
+	if off := c.CommittedOffset("test.group", "TEST_RESET_ACTIVE", 0); off == 0 {
+		t.Errorf("committed = %d", off)
+	}
+}
+
+func TestResetGroupOffsetsDryRun(t *testing.T) {
+	needBroker(t)
+	cli, err := mkafka.CreateKafkaClient(brokers, nil, mkafka.WithVersion(sarama.V2_4_0_0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cli.Close()
+
+	out := bytes.Buffer{}
+	_, err = mkafka.ResetGroupOffsets(cli, "test.group", mkafka.ResetSpec{
+		Mode:   mkafka.ResetLatest,
+		DryRun: true,
+		Out:    &out,
+	})
+	if err != nil {
+		t.Error(err)
+	}
+	if !strings.HasPrefix(out.String(), "GROUP test.group") {
+		t.Error(out.String())
+	}
+}
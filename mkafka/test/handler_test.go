@@ -0,0 +1,229 @@
+package mkafka_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/mouseleee/mlib/mkafka"
+	"github.com/mouseleee/mlib/mkafka/kafkatest"
+)
+
+// consumeWith 使用Handler和中间件消费，处理成功n条消息（包括被中间件跳过的）或超时后结束
+func consumeWith(t *testing.T, c *kafkatest.Cluster, group, topic string, n int, h mkafka.Handler, mws ...mkafka.Middleware) {
+	t.Helper()
+	csm := c.NewConsumerGroup(group, mkafka.DefaultConsumerConfig())
+	defer csm.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var (
+		mu    sync.Mutex
+		count int
+	)
+	h = mkafka.Chain(mws...)(h)
+	counted := func(ctx context.Context, msg *sarama.ConsumerMessage) error {
+		if err := h(ctx, msg); err != nil {
+			return err
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		if count++; count == n {
+			cancel()
+		}
+		return nil
+	}
+	gh := mkafka.NewGroupHandler(counted)
+	for ctx.Err() == nil {
+		if err := csm.Consume(ctx, []string{topic}, gh); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !errors.Is(ctx.Err(), context.Canceled) {
+		t.Fatalf("consumed %d, want %d", count, n)
+	}
+}
+
+func TestGroupHandler(t *testing.T) {
+	c := kafkatest.NewCluster()
+	produce(t, c, "TEST_HANDLER", 5)
+
+	var order []string
+	mw := func(name string) mkafka.Middleware {
+		return func(next mkafka.Handler) mkafka.Handler {
+			return func(ctx context.Context, msg *sarama.ConsumerMessage) error {
+				order = append(order, name)
+				return next(ctx, msg)
+			}
+		}
+	}
+	consumeWith(t, c, "test.group", "TEST_HANDLER", 5, func(context.Context, *sarama.ConsumerMessage) error {
+		order = append(order, "handler")
+		return nil
+	}, mw("a"), mw("b"))
+
+	if len(order) != 15 || order[0] != "a" || order[1] != "b" || order[2] != "handler" {
+		t.Errorf("order = %v", order)
+	}
+	if off := c.CommittedOffset("test.group", "TEST_HANDLER", 0); off != 5 {
+		t.Errorf("committed = %d", off)
+	}
+}
+
+func TestGroupHandlerError(t *testing.T) {
+	c := kafkatest.NewCluster()
+	produce(t, c, "TEST_HANDLER_ERR", 5)
+
+	csm := c.NewConsumerGroup("test.group", mkafka.DefaultConsumerConfig())
+	defer csm.Close()
+
+	// 第3条消息一直处理失败，在同一个会话中退避重试，之后的消息不再处理
+	var (
+		mu       sync.Mutex
+		attempts int
+		later    bool
+		setups   int
+	)
+	gh := &setupCounter{ConsumerGroupHandler: mkafka.NewGroupHandler(func(ctx context.Context, msg *sarama.ConsumerMessage) error {
+		mu.Lock()
+		defer mu.Unlock()
+		switch {
+		case msg.Offset == 2:
+			attempts++
+			return errors.New("boom")
+		case msg.Offset > 2:
+			later = true
+		}
+		return nil
+	}), setups: &setups}
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	if err := csm.Consume(ctx, []string{"TEST_HANDLER_ERR"}, gh); err != nil {
+		t.Fatal(err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	// 100、200毫秒后重试，500毫秒内最多处理4次
+	if attempts < 2 || attempts > 4 {
+		t.Errorf("attempts = %d", attempts)
+	}
+	if later {
+		t.Error("messages after the failed one were handled")
+	}
+	if setups != 1 {
+		t.Errorf("setups = %d, want 1", setups)
+	}
+	if off := c.CommittedOffset("test.group", "TEST_HANDLER_ERR", 0); off != 2 {
+		t.Errorf("committed = %d", off)
+	}
+}
+
+// setupCounter 记录会话建立的次数
+type setupCounter struct {
+	sarama.ConsumerGroupHandler
+	setups *int
+}
+
+func (s *setupCounter) Setup(sess sarama.ConsumerGroupSession) error {
+	*s.setups++
+	return s.ConsumerGroupHandler.Setup(sess)
+}
+
+func TestDeadLetter(t *testing.T) {
+	c := kafkatest.NewCluster()
+	produce(t, c, "TEST_HANDLER_DLQ", 5)
+
+	// 第3条消息一直处理失败，重试3次后写入死信topic，之后的消息继续处理
+	if err := c.CreateTopic("TEST_HANDLER_DLQ.dead", 1); err != nil {
+		t.Fatal(err)
+	}
+	var attempts int
+	prd := c.NewSyncProducer(nil)
+	consumeWith(t, c, "test.group", "TEST_HANDLER_DLQ", 5, func(ctx context.Context, msg *sarama.ConsumerMessage) error {
+		if msg.Offset == 2 {
+			attempts++
+			return errors.New("boom")
+		}
+		return nil
+	}, mkafka.DeadLetter(mkafka.DeadLetterConfig{Backoff: time.Millisecond, Send: mkafka.DeadLetterTopic(prd, "TEST_HANDLER_DLQ.dead")}))
+
+	if attempts != 3 {
+		t.Errorf("attempts = %d", attempts)
+	}
+	if off := c.CommittedOffset("test.group", "TEST_HANDLER_DLQ", 0); off != 5 {
+		t.Errorf("committed = %d", off)
+	}
+	dead := c.Messages("TEST_HANDLER_DLQ.dead", 0)
+	if len(dead) != 1 {
+		t.Fatalf("dead letters = %d", len(dead))
+	}
+	if v, _ := mkafka.HeaderValue(dead[0], mkafka.HeaderDeadLetterError); string(v) != "boom" {
+		t.Errorf("error header = %q", v)
+	}
+	if v, _ := mkafka.HeaderValue(dead[0], mkafka.HeaderDeadLetterSource); string(v) != "TEST_HANDLER_DLQ/0/2" {
+		t.Errorf("source header = %q", v)
+	}
+}
+
+func TestDeadLetterSendFailure(t *testing.T) {
+	// 死信写入失败时返回错误，由NewGroupHandler继续重试
+	sendErr := errors.New("dlq down")
+	h := mkafka.DeadLetter(mkafka.DeadLetterConfig{Attempts: 2, Backoff: time.Millisecond, Send: func(context.Context, *sarama.ConsumerMessage, error) error {
+		return sendErr
+	}})(func(context.Context, *sarama.ConsumerMessage) error { return errors.New("boom") })
+	if err := h(context.Background(), &sarama.ConsumerMessage{Topic: "T"}); !errors.Is(err, sendErr) {
+		t.Errorf("err = %v", err)
+	}
+}
+
+// testPeriodicCommit 分区处理过程中（未结束时）已处理的offset应当已被提交
+func testPeriodicCommit(t *testing.T, topic string, newHandler func(mkafka.Handler) sarama.ConsumerGroupHandler) {
+	c := kafkatest.NewCluster()
+	produce(t, c, topic, 150)
+	csm := c.NewConsumerGroup("test.commit", mkafka.DefaultConsumerConfig())
+	defer csm.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	committed := make(chan int64, 1)
+	h := func(hctx context.Context, msg *sarama.ConsumerMessage) error {
+		if msg.Offset < 120 {
+			return nil
+		}
+		// 第120条消息处理中，此时至少100条已提交
+		for hctx.Err() == nil {
+			if off := c.CommittedOffset("test.commit", topic, 0); off >= 100 {
+				select {
+				case committed <- off:
+				default:
+				}
+				cancel()
+				break
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+		return hctx.Err()
+	}
+	for ctx.Err() == nil {
+		csm.Consume(ctx, []string{topic}, newHandler(h))
+	}
+	select {
+	case off := <-committed:
+		if off > 120 {
+			t.Errorf("committed = %d", off)
+		}
+	default:
+		t.Fatal("处理过程中没有提交offset")
+	}
+}
+
+func TestGroupHandlerPeriodicCommit(t *testing.T) {
+	testPeriodicCommit(t, "TEST_HANDLER_COMMIT", func(h mkafka.Handler) sarama.ConsumerGroupHandler {
+		return mkafka.NewGroupHandler(h)
+	})
+}
@@ -0,0 +1,68 @@
+package mkafka_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/mouseleee/mlib/mkafka"
+	"github.com/mouseleee/mlib/mkafka/kafkatest"
+)
+
+type downAdmin struct {
+	sarama.ClusterAdmin
+}
+
+func (downAdmin) DescribeCluster() ([]*sarama.Broker, int32, error) {
+	return nil, 0, sarama.ErrOutOfBrokers
+}
+
+func TestHealth(t *testing.T) {
+	c := kafkatest.NewCluster()
+	h := mkafka.NewHealth()
+	h.Register("broker", mkafka.BrokerCheck(c.NewClusterAdmin()))
+
+	get := func() (int, mkafka.HealthReport) {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+		r := mkafka.HealthReport{}
+		if err := json.Unmarshal(rec.Body.Bytes(), &r); err != nil {
+			t.Fatal(err)
+		}
+		return rec.Code, r
+	}
+
+	code, r := get()
+	if code != http.StatusOK || r.Status != "ok" || r.Checks["broker"] != "ok" {
+		t.Errorf("healthy: %d %+v", code, r)
+	}
+
+	h.Register("down", mkafka.BrokerCheck(downAdmin{}))
+	code, r = get()
+	if code != http.StatusServiceUnavailable || r.Status != "fail" || r.Checks["down"] != sarama.ErrOutOfBrokers.Error() || r.Checks["broker"] != "ok" {
+		t.Errorf("unhealthy: %d %+v", code, r)
+	}
+
+	errs := h.Check(context.Background())
+	if len(errs) != 1 || !errors.Is(errs["down"], sarama.ErrOutOfBrokers) {
+		t.Errorf("Check: %v", errs)
+	}
+}
+
+func TestHealthTimeout(t *testing.T) {
+	h := mkafka.NewHealth()
+	h.Timeout = 20 * time.Millisecond
+	h.Register("slow", func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	errs := h.Check(context.Background())
+	if !errors.Is(errs["slow"], context.DeadlineExceeded) {
+		t.Errorf("Check: %v", errs)
+	}
+}
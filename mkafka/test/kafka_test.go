@@ -1,15 +1,24 @@
 package mkafka_test
 
 import (
+	"errors"
 	"os"
 	"testing"
 
 	"github.com/Shopify/sarama"
 	"github.com/mouseleee/mlib/mkafka"
+	"github.com/mouseleee/mlib/mkafka/kafkatest"
 	"github.com/rs/zerolog"
 )
 
-var brokers = "localhost:9093"
+// brokers 真实broker地址，通过MKAFKA_TEST_BROKERS指定，未指定时跳过依赖broker的测试
+var brokers = os.Getenv("MKAFKA_TEST_BROKERS")
+
+func needBroker(t *testing.T) {
+	if brokers == "" {
+		t.Skip("MKAFKA_TEST_BROKERS未设置")
+	}
+}
 
 func TestSetLogger(t *testing.T) {
 	tl := zerolog.New(os.Stdout)
@@ -22,6 +31,7 @@ func TestDefaultProducerConfig(t *testing.T) {
 }
 
 func TestDefaultProducer(t *testing.T) {
+	needBroker(t)
 	_, err := mkafka.DefaultProducer(brokers)
 	if err != nil {
 		t.Error(err)
@@ -33,6 +43,7 @@ func TestDefaultConsumerConfig(t *testing.T) {
 }
 
 func TestDefaultConsumerGroup(t *testing.T) {
+	needBroker(t)
 	_, err := mkafka.DefaultConsumerGroup(brokers, "test.group")
 	if err != nil {
 		t.Error(err)
@@ -40,6 +51,7 @@ func TestDefaultConsumerGroup(t *testing.T) {
 }
 
 func TestCustomConsumerGroup(t *testing.T) {
+	needBroker(t)
 	_, err := mkafka.CustomConsumerGroup(brokers, "test.group", sarama.NewConfig())
 	if err != nil {
 		t.Error(err)
@@ -47,21 +59,28 @@ func TestCustomConsumerGroup(t *testing.T) {
 }
 
 func TestTopicOp(t *testing.T) {
-	cli, err := mkafka.CreateKafkaClient(brokers, sarama.NewConfig())
-	if err != nil {
-		t.Error(err)
-	}
-	defer cli.Close()
+	cluster := kafkatest.NewCluster()
+	client := cluster.NewClient()
+	defer client.Close()
 
-	err = mkafka.CreateTopic(cli, "TEST_TOPIC", 1, 1)
+	err := mkafka.CreateTopic(client, "TEST_TOPIC", 3, 1)
 	if err != nil {
 		t.Error(err)
 	}
+	if ps, _ := cluster.Partitions("TEST_TOPIC"); len(ps) != 3 {
+		t.Errorf("partitions = %v", ps)
+	}
+	if err := mkafka.CreateTopic(client, "TEST_TOPIC", 1, 1); !errors.Is(err, sarama.ErrTopicAlreadyExists) {
+		t.Errorf("err = %v", err)
+	}
 
-	err = mkafka.RemoveTopic(cli, []string{"TEST_TOPIC"})
+	err = mkafka.RemoveTopic(client, []string{"TEST_TOPIC"})
 	if err != nil {
 		t.Error(err)
 	}
+	if _, err := cluster.Partitions("TEST_TOPIC"); err == nil {
+		t.Error("topic未删除")
+	}
 }
 
 // func TestKafkaProduceMsg(t *testing.T) {
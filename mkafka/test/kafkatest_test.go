@@ -0,0 +1,213 @@
+package mkafka_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/mouseleee/mlib/mkafka"
+	"github.com/mouseleee/mlib/mkafka/kafkatest"
+)
+
+// produce 向模拟集群发送n条消息
+func produce(t *testing.T, c *kafkatest.Cluster, topic string, n int) {
+	t.Helper()
+	prd := c.NewSyncProducer(mkafka.DefaultProducerConfig())
+	defer prd.Close()
+
+	for i := 0; i < n; i++ {
+		_, _, err := prd.SendMessage(&sarama.ProducerMessage{
+			Topic: topic,
+			Key:   sarama.StringEncoder(fmt.Sprint(i)),
+			Value: sarama.StringEncoder(fmt.Sprintf("这是第%d条测试消息", i)),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// consume 以消费者组消费n条消息并提交offset
+func consume(t *testing.T, c *kafkatest.Cluster, group, topic string, n int) {
+	t.Helper()
+	csm := c.NewConsumerGroup(group, mkafka.DefaultConsumerConfig())
+	defer csm.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	h := &countConsumer{limit: n, cancel: cancel}
+	for ctx.Err() == nil {
+		if err := csm.Consume(ctx, []string{topic}, h); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if h.count != n {
+		t.Fatalf("consumed %d, want %d", h.count, n)
+	}
+}
+
+// countConsumer 消费limit条消息后结束
+type countConsumer struct {
+	mu     sync.Mutex
+	count  int
+	limit  int
+	cancel context.CancelFunc
+}
+
+func (c *countConsumer) Setup(sarama.ConsumerGroupSession) error {
+	return nil
+}
+
+func (c *countConsumer) Cleanup(sarama.ConsumerGroupSession) error {
+	return nil
+}
+
+func (c *countConsumer) ConsumeClaim(session sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
+	for msg := range claim.Messages() {
+		c.mu.Lock()
+		if c.count >= c.limit {
+			c.mu.Unlock()
+			return nil
+		}
+		c.count++
+		session.MarkMessage(msg, "")
+		session.Commit()
+		if c.count == c.limit {
+			c.cancel()
+		}
+		c.mu.Unlock()
+	}
+	return nil
+}
+
+func TestFakeProduceConsume(t *testing.T) {
+	c := kafkatest.NewCluster()
+	if err := c.CreateTopic("TEST_FAKE", 3); err != nil {
+		t.Fatal(err)
+	}
+	produce(t, c, "TEST_FAKE", 30)
+
+	var total int
+	for p := int32(0); p < 3; p++ {
+		total += len(c.Messages("TEST_FAKE", p))
+	}
+	if total != 30 {
+		t.Errorf("produced %d", total)
+	}
+
+	consume(t, c, "test.group", "TEST_FAKE", 30)
+	var committed int64
+	for p := int32(0); p < 3; p++ {
+		committed += c.CommittedOffset("test.group", "TEST_FAKE", p)
+	}
+	if committed != 30 {
+		t.Errorf("committed %d", committed)
+	}
+
+	// 已提交的offset之后没有新消息
+	produce(t, c, "TEST_FAKE", 3)
+	consume(t, c, "test.group", "TEST_FAKE", 3)
+}
+
+func TestFakeRebalance(t *testing.T) {
+	c := kafkatest.NewCluster()
+	admin := c.NewClusterAdmin()
+	if err := c.CreateTopic("TEST_REBALANCE", 4); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	csms := []sarama.ConsumerGroup{
+		c.NewConsumerGroup("test.group", mkafka.DefaultConsumerConfig()),
+		c.NewConsumerGroup("test.group", mkafka.DefaultConsumerConfig()),
+	}
+	h := &countConsumer{limit: 1 << 30, cancel: func() {}}
+	wg := sync.WaitGroup{}
+	for _, csm := range csms {
+		csm := csm
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for ctx.Err() == nil {
+				csm.Consume(ctx, []string{"TEST_REBALANCE"}, h)
+			}
+		}()
+	}
+
+	// 等待两个成员都加入并完成分配
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		infos, err := mkafka.DescribeConsumerGroups(admin, "test.group")
+		if err != nil {
+			t.Fatal(err)
+		}
+		balanced := len(infos[0].Members) == 2
+		for _, m := range infos[0].Members {
+			balanced = balanced && len(m.Assignment["TEST_REBALANCE"]) == 2
+		}
+		if balanced {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal(infos)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	produce(t, c, "TEST_REBALANCE", 40)
+	for {
+		h.mu.Lock()
+		n := h.count
+		h.mu.Unlock()
+		if n == 40 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("consumed %d", n)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	cancel()
+	wg.Wait()
+	for _, csm := range csms {
+		csm.Close()
+	}
+	if err := admin.DeleteConsumerGroup("test.group"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestFakeClient(t *testing.T) {
+	c := kafkatest.NewCluster()
+	produce(t, c, "TEST_FAKE_CLIENT", 3)
+	client := c.NewClient()
+
+	if ts, err := client.Topics(); err != nil || len(ts) != 1 || ts[0] != "TEST_FAKE_CLIENT" {
+		t.Errorf("topics = %v, err = %v", ts, err)
+	}
+	if ps, err := client.Partitions("TEST_FAKE_CLIENT"); err != nil || len(ps) != 1 {
+		t.Errorf("partitions = %v, err = %v", ps, err)
+	}
+	if off, err := client.GetOffset("TEST_FAKE_CLIENT", 0, sarama.OffsetNewest); err != nil || off != 3 {
+		t.Errorf("newest = %d, err = %v", off, err)
+	}
+	// 涉及broker的方法返回错误而不是panic
+	if _, err := client.Leader("TEST_FAKE_CLIENT", 0); !errors.Is(err, kafkatest.ErrUnsupported) {
+		t.Errorf("Leader err = %v", err)
+	}
+	if err := client.RefreshMetadata(); !errors.Is(err, kafkatest.ErrUnsupported) {
+		t.Errorf("RefreshMetadata err = %v", err)
+	}
+
+	client.Close()
+	if _, err := client.Partitions("TEST_FAKE_CLIENT"); !errors.Is(err, sarama.ErrClosedClient) {
+		t.Errorf("err after close = %v", err)
+	}
+}
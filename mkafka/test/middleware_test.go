@@ -0,0 +1,124 @@
+package mkafka_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/mouseleee/mlib/mkafka"
+	"github.com/rs/zerolog"
+)
+
+var testMsg = &sarama.ConsumerMessage{Topic: "TEST_MW", Partition: 1, Offset: 7}
+
+func TestChainOrder(t *testing.T) {
+	var order []string
+	mw := func(name string) mkafka.Middleware {
+		return func(next mkafka.Handler) mkafka.Handler {
+			return func(ctx context.Context, msg *sarama.ConsumerMessage) error {
+				order = append(order, name+">")
+				err := next(ctx, msg)
+				order = append(order, "<"+name)
+				return err
+			}
+		}
+	}
+	h := mkafka.Chain(mw("a"), mkafka.Chain(mw("b"), mw("c")))(func(context.Context, *sarama.ConsumerMessage) error {
+		order = append(order, "h")
+		return nil
+	})
+	if err := h(context.Background(), testMsg); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(order, " "); got != "a> b> c> h <c <b <a" {
+		t.Errorf("order = %s", got)
+	}
+}
+
+func TestRecovery(t *testing.T) {
+	mkafka.SetLogger(zerolog.Nop(), false)
+
+	h := mkafka.Recovery()(func(context.Context, *sarama.ConsumerMessage) error {
+		panic("boom")
+	})
+	err := h(context.Background(), testMsg)
+	if !errors.Is(err, mkafka.ErrPanic) || !strings.Contains(err.Error(), "boom") {
+		t.Errorf("err = %v", err)
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	h := mkafka.Timeout(20 * time.Millisecond)(func(ctx context.Context, _ *sarama.ConsumerMessage) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	if err := h(context.Background(), testMsg); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("err = %v", err)
+	}
+
+	// 超时后处理成功时返回Handler的结果
+	h = mkafka.Timeout(20 * time.Millisecond)(func(ctx context.Context, _ *sarama.ConsumerMessage) error {
+		<-ctx.Done()
+		return nil
+	})
+	if err := h(context.Background(), testMsg); err != nil {
+		t.Errorf("err = %v", err)
+	}
+
+	// 不大于0时不设置超时
+	h = mkafka.Timeout(0)(func(ctx context.Context, _ *sarama.ConsumerMessage) error {
+		if _, ok := ctx.Deadline(); ok {
+			return errors.New("不应设置超时")
+		}
+		return ctx.Err()
+	})
+	if err := h(context.Background(), testMsg); err != nil {
+		t.Error(err)
+	}
+
+	h = mkafka.Timeout(time.Second)(func(context.Context, *sarama.ConsumerMessage) error {
+		return nil
+	})
+	if err := h(context.Background(), testMsg); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestLoggingAndMetrics(t *testing.T) {
+	buf := &bytes.Buffer{}
+	mkafka.SetLogger(zerolog.New(buf), false)
+	defer mkafka.SetLogger(zerolog.Nop(), false)
+
+	m := mkafka.NewMemoryMetrics()
+	fail := errors.New("fail")
+	h := mkafka.Chain(mkafka.Logging(), mkafka.Metrics(m))(func(_ context.Context, msg *sarama.ConsumerMessage) error {
+		time.Sleep(time.Millisecond)
+		if msg.Offset%2 == 1 {
+			return fail
+		}
+		return nil
+	})
+	for i := int64(0); i < 4; i++ {
+		err := h(context.Background(), &sarama.ConsumerMessage{Topic: "TEST_MW", Offset: i})
+		if (i%2 == 1) != errors.Is(err, fail) {
+			t.Errorf("offset %d err = %v", i, err)
+		}
+	}
+
+	stats := m.Snapshot()
+	if len(stats) != 1 || stats[0].Count != 4 || stats[0].Errors != 2 {
+		t.Fatalf("stats = %+v", stats)
+	}
+	if stats[0].MaxLatency < time.Millisecond || stats[0].TotalLatency < 4*time.Millisecond {
+		t.Errorf("latency = %+v", stats[0])
+	}
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 4 || !strings.Contains(lines[1], `"level":"error"`) || !strings.Contains(lines[1], `"error":"fail"`) {
+		t.Errorf("log = %s", buf.String())
+	}
+}
@@ -0,0 +1,194 @@
+package mkafka_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/mouseleee/mlib/mkafka"
+	"github.com/mouseleee/mlib/mkafka/kafkatest"
+)
+
+// produceAt 向指定分区发送n条带时间戳的消息，时间间隔1秒
+func produceAt(t *testing.T, c *kafkatest.Cluster, topic string, partition int32, base time.Time, n int) {
+	t.Helper()
+	conf := sarama.NewConfig()
+	conf.Producer.Partitioner = sarama.NewManualPartitioner
+	prd := c.NewSyncProducer(conf)
+	for i := 0; i < n; i++ {
+		_, _, err := prd.SendMessage(&sarama.ProducerMessage{
+			Topic:     topic,
+			Partition: partition,
+			Key:       sarama.StringEncoder(fmt.Sprint(i)),
+			Value:     sarama.StringEncoder(fmt.Sprintf("p%d-%d", partition, i)),
+			Headers:   []sarama.RecordHeader{{Key: []byte("h"), Value: []byte(fmt.Sprint(i))}},
+			Timestamp: base.Add(time.Duration(i) * time.Second),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestMirrorToTopic(t *testing.T) {
+	c := kafkatest.NewCluster()
+	if err := c.CreateTopic("TEST_MIRROR_SRC", 2); err != nil {
+		t.Fatal(err)
+	}
+	base := time.Now().Add(-time.Hour).Truncate(time.Second)
+	produceAt(t, c, "TEST_MIRROR_SRC", 0, base, 10)
+	produceAt(t, c, "TEST_MIRROR_SRC", 1, base, 10)
+
+	conf := sarama.NewConfig()
+	conf.Producer.Partitioner = sarama.NewManualPartitioner
+	sink := mkafka.NewTopicSink(c.NewSyncProducer(conf), "TEST_MIRROR_DST", true)
+	c.CreateTopic("TEST_MIRROR_DST", 2)
+
+	// 按时间选择[2s, 6s)，跳过key为3的消息
+	stats, err := mkafka.Mirror(context.Background(), c.NewConsumer(nil), c, mkafka.MirrorSource{
+		Topic:     "TEST_MIRROR_SRC",
+		StartTime: base.Add(2 * time.Second),
+		EndTime:   base.Add(6 * time.Second),
+	}, mkafka.MirrorOptions{
+		Filter: func(m *sarama.ConsumerMessage) bool { return string(m.Key) != "3" },
+	}, sink)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Read != 8 || stats.Written != 6 || stats.Skipped != 2 || stats.Incomplete != nil {
+		t.Errorf("stats = %+v", stats)
+	}
+	for p := int32(0); p < 2; p++ {
+		msgs := c.Messages("TEST_MIRROR_DST", p)
+		if len(msgs) != 3 || string(msgs[0].Value) != fmt.Sprintf("p%d-2", p) || string(msgs[2].Value) != fmt.Sprintf("p%d-5", p) {
+			t.Errorf("partition %d = %v", p, msgs)
+		}
+		if v, _ := mkafka.HeaderValue(msgs[0], "h"); string(v) != "2" || !msgs[0].Timestamp.Equal(base.Add(2*time.Second)) {
+			t.Errorf("header = %s, timestamp = %v", v, msgs[0].Timestamp)
+		}
+	}
+}
+
+func TestMirrorNDJSONReplay(t *testing.T) {
+	c := kafkatest.NewCluster()
+	base := time.Now().Truncate(time.Second)
+	produceAt(t, c, "TEST_MIRROR_FILE", 0, base, 5)
+
+	buf := &bytes.Buffer{}
+	w := mkafka.NewNDJSONWriter(buf)
+	stats, err := mkafka.Mirror(context.Background(), c.NewConsumer(nil), c, mkafka.MirrorSource{
+		Topic:       "TEST_MIRROR_FILE",
+		Partitions:  []int32{0},
+		StartOffset: 1,
+		EndOffset:   4,
+	}, mkafka.MirrorOptions{}, w)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if stats.Written != 3 {
+		t.Fatalf("stats = %+v", stats)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	want := fmt.Sprintf(`{"topic":"TEST_MIRROR_FILE","partition":0,"offset":1,"timestamp":%q,"key":"MQ==","value":"cDAtMQ==","headers":[{"key":"h","value":"MQ=="}]}`,
+		base.Add(time.Second).Format(time.RFC3339Nano))
+	if len(lines) != 3 || lines[0] != want {
+		t.Fatalf("ndjson = %s", buf.String())
+	}
+
+	// 重放到原topic，限速每秒20条
+	start := time.Now()
+	stats, err = mkafka.Replay(context.Background(), strings.NewReader(buf.String()), mkafka.MirrorOptions{
+		Rate: 20,
+		Transform: func(m *sarama.ConsumerMessage) (*sarama.ConsumerMessage, error) {
+			m.Value = append([]byte("replay-"), m.Value...)
+			return m, nil
+		},
+	}, mkafka.NewTopicSink(c.NewSyncProducer(nil), "", false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Written != 3 {
+		t.Errorf("stats = %+v", stats)
+	}
+	if d := time.Since(start); d < 100*time.Millisecond {
+		t.Errorf("限速无效, 耗时 %v", d)
+	}
+	msgs := c.Messages("TEST_MIRROR_FILE", 0)
+	if len(msgs) != 8 || string(msgs[5].Value) != "replay-p0-1" || string(msgs[5].Key) != "1" {
+		t.Errorf("replayed = %v", msgs[5:])
+	}
+}
+
+func TestMirrorInvalidStart(t *testing.T) {
+	c := kafkatest.NewCluster()
+	produceAt(t, c, "TEST_MIRROR_NEWEST", 0, time.Now(), 3)
+
+	// OffsetNewest时范围总是为空，直接拒绝
+	for _, off := range []int64{sarama.OffsetNewest, -3} {
+		_, err := mkafka.Mirror(context.Background(), c.NewConsumer(nil), c, mkafka.MirrorSource{
+			Topic:       "TEST_MIRROR_NEWEST",
+			StartOffset: off,
+			EndOffset:   3,
+		}, mkafka.MirrorOptions{}, mkafka.NewNDJSONWriter(io.Discard))
+		if !errors.Is(err, mkafka.ErrInvalidConfig) {
+			t.Errorf("start %d: err = %v", off, err)
+		}
+	}
+}
+
+// tailConsumer 不返回分区的最后一条消息，模拟末尾是事务标记或已被压缩的记录
+type tailConsumer struct {
+	sarama.Consumer
+	skip int64
+}
+
+type tailPartitionConsumer struct {
+	sarama.PartitionConsumer
+	msgs chan *sarama.ConsumerMessage
+}
+
+func (c *tailConsumer) ConsumePartition(topic string, partition int32, offset int64) (sarama.PartitionConsumer, error) {
+	pc, err := c.Consumer.ConsumePartition(topic, partition, offset)
+	if err != nil {
+		return nil, err
+	}
+	tpc := &tailPartitionConsumer{PartitionConsumer: pc, msgs: make(chan *sarama.ConsumerMessage)}
+	go func() {
+		defer close(tpc.msgs)
+		for m := range pc.Messages() {
+			if m.Offset != c.skip {
+				tpc.msgs <- m
+			}
+		}
+	}()
+	return tpc, nil
+}
+
+func (pc *tailPartitionConsumer) Messages() <-chan *sarama.ConsumerMessage {
+	return pc.msgs
+}
+
+func TestMirrorSkippedTail(t *testing.T) {
+	c := kafkatest.NewCluster()
+	produceAt(t, c, "TEST_MIRROR_TAIL", 0, time.Now(), 5)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	stats, err := mkafka.Mirror(ctx, &tailConsumer{Consumer: c.NewConsumer(nil), skip: 4}, c, mkafka.MirrorSource{
+		Topic:       "TEST_MIRROR_TAIL",
+		StartOffset: sarama.OffsetOldest,
+	}, mkafka.MirrorOptions{IdleTimeout: 50 * time.Millisecond}, mkafka.NewNDJSONWriter(io.Discard))
+	// 没有读到终点的分区记录在结果中
+	if err != nil || stats.Read != 4 || len(stats.Incomplete) != 1 || stats.Incomplete[0] != 4 {
+		t.Errorf("stats = %+v, err = %v", stats, err)
+	}
+}
@@ -0,0 +1,126 @@
+package mkafka_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/mouseleee/mlib/mkafka"
+	"github.com/mouseleee/mlib/mkafka/kafkatest"
+)
+
+// produceKeyed 向单分区topic发送n条消息，key在keys个值之间循环，value为该key下的序号
+func produceKeyed(t *testing.T, c *kafkatest.Cluster, topic string, n, keys int) {
+	t.Helper()
+	prd := c.NewSyncProducer(nil)
+	seq := make(map[string]int)
+	for i := 0; i < n; i++ {
+		k := fmt.Sprintf("k%d", i%keys)
+		_, _, err := prd.SendMessage(&sarama.ProducerMessage{
+			Topic: topic,
+			Key:   sarama.StringEncoder(k),
+			Value: sarama.StringEncoder(fmt.Sprint(seq[k])),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		seq[k]++
+	}
+}
+
+func TestOrderedGroupHandler(t *testing.T) {
+	c := kafkatest.NewCluster()
+	produceKeyed(t, c, "TEST_ORDERED", 200, 8)
+
+	var (
+		mu       sync.Mutex
+		seen     = make(map[string][]string)
+		inflight int32
+		peak     int32
+		handled  int32
+	)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	h := mkafka.NewOrderedGroupHandler(func(_ context.Context, msg *sarama.ConsumerMessage) error {
+		n := atomic.AddInt32(&inflight, 1)
+		for {
+			p := atomic.LoadInt32(&peak)
+			if n <= p || atomic.CompareAndSwapInt32(&peak, p, n) {
+				break
+			}
+		}
+		time.Sleep(time.Duration(rand.Intn(500)) * time.Microsecond)
+		atomic.AddInt32(&inflight, -1)
+
+		mu.Lock()
+		seen[string(msg.Key)] = append(seen[string(msg.Key)], string(msg.Value))
+		mu.Unlock()
+		if atomic.AddInt32(&handled, 1) == 200 {
+			cancel()
+		}
+		return nil
+	}, mkafka.OrderedConfig{Workers: 4})
+
+	csm := c.NewConsumerGroup("test.group", mkafka.DefaultConsumerConfig())
+	for ctx.Err() == nil {
+		if err := csm.Consume(ctx, []string{"TEST_ORDERED"}, h); err != nil {
+			t.Fatal(err)
+		}
+	}
+	csm.Close()
+
+	if handled != 200 {
+		t.Fatalf("handled = %d", handled)
+	}
+	for k, vs := range seen {
+		for i, v := range vs {
+			if v != fmt.Sprint(i) {
+				t.Fatalf("key %s 乱序: %v", k, vs)
+			}
+		}
+	}
+	if peak < 2 {
+		t.Errorf("没有并行处理, peak = %d", peak)
+	}
+	if off := c.CommittedOffset("test.group", "TEST_ORDERED", 0); off != 200 {
+		t.Errorf("committed = %d", off)
+	}
+}
+
+func TestOrderedGroupHandlerError(t *testing.T) {
+	c := kafkatest.NewCluster()
+	produceKeyed(t, c, "TEST_ORDERED_ERR", 100, 4)
+
+	fail := errors.New("fail")
+	h := mkafka.NewOrderedGroupHandler(func(_ context.Context, msg *sarama.ConsumerMessage) error {
+		if msg.Offset == 50 {
+			return fail
+		}
+		return nil
+	}, mkafka.OrderedConfig{Workers: 4, QueueSize: 4})
+
+	csm := c.NewConsumerGroup("test.group", mkafka.DefaultConsumerConfig())
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	if err := csm.Consume(ctx, []string{"TEST_ORDERED_ERR"}, h); err != nil {
+		t.Fatal(err)
+	}
+	csm.Close()
+
+	// 水位停在失败的消息之前
+	if off := c.CommittedOffset("test.group", "TEST_ORDERED_ERR", 0); off > 50 || off < 0 {
+		t.Errorf("committed = %d", off)
+	}
+}
+
+func TestOrderedGroupHandlerPeriodicCommit(t *testing.T) {
+	testPeriodicCommit(t, "TEST_ORDERED_COMMIT", func(h mkafka.Handler) sarama.ConsumerGroupHandler {
+		return mkafka.NewOrderedGroupHandler(h, mkafka.OrderedConfig{Workers: 4})
+	})
+}
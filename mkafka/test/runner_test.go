@@ -0,0 +1,253 @@
+package mkafka_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/mouseleee/mlib/mkafka"
+	"github.com/mouseleee/mlib/mkafka/kafkatest"
+)
+
+type closeRecorder struct {
+	closed bool
+}
+
+func (c *closeRecorder) Close() error {
+	c.closed = true
+	return nil
+}
+
+// waitFor 等待cond成立，超时后失败
+func waitFor(t *testing.T, msg string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timeout waiting for", msg)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestRunnerShutdownDrains(t *testing.T) {
+	c := kafkatest.NewCluster()
+	produce(t, c, "TEST_RUNNER", 5)
+
+	var (
+		mu        sync.Mutex
+		handled   int
+		cancelled bool
+	)
+	started := make(chan struct{})
+	h := func(ctx context.Context, msg *sarama.ConsumerMessage) error {
+		if msg.Offset == 2 {
+			close(started)
+			time.Sleep(200 * time.Millisecond)
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		handled++
+		cancelled = cancelled || ctx.Err() != nil
+		return nil
+	}
+
+	closer := &closeRecorder{}
+	r := mkafka.NewRunner(c.NewConsumerGroup("g", mkafka.DefaultConsumerConfig()), []string{"TEST_RUNNER"}, mkafka.NewGroupHandler(h), closer)
+	r.CommitInterval = -1
+	errc := make(chan error, 1)
+	go func() {
+		errc <- r.Run(context.Background())
+	}()
+
+	select {
+	case <-started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("handler not started")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := r.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-errc; err != nil {
+		t.Fatal(err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if cancelled {
+		t.Error("in-flight handler saw cancelled context")
+	}
+	if handled != 3 {
+		t.Errorf("handled %d messages, want 3", handled)
+	}
+	if off := c.CommittedOffset("g", "TEST_RUNNER", 0); off != 3 {
+		t.Errorf("committed offset %d, want 3", off)
+	}
+	if !closer.closed {
+		t.Error("closer not closed")
+	}
+	if r.LastCommit().IsZero() {
+		t.Error("last commit not recorded")
+	}
+	if err := r.Run(context.Background()); !errors.Is(err, mkafka.ErrRunnerStarted) {
+		t.Errorf("second Run returned %v", err)
+	}
+}
+
+func TestRunnerShutdownTimeout(t *testing.T) {
+	c := kafkatest.NewCluster()
+	produce(t, c, "TEST_RUNNER_TIMEOUT", 1)
+
+	started := make(chan struct{})
+	h := func(ctx context.Context, msg *sarama.ConsumerMessage) error {
+		close(started)
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	r := mkafka.NewRunner(c.NewConsumerGroup("g", mkafka.DefaultConsumerConfig()), []string{"TEST_RUNNER_TIMEOUT"}, mkafka.NewGroupHandler(h))
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		r.Run(context.Background())
+	}()
+
+	select {
+	case <-started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("handler not started")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if err := r.Shutdown(ctx); !errors.Is(err, mkafka.ErrShutdownTimeout) {
+		t.Fatalf("Shutdown returned %v", err)
+	}
+	if off := c.CommittedOffset("g", "TEST_RUNNER_TIMEOUT", 0); off != -1 {
+		t.Errorf("committed offset %d, want none", off)
+	}
+	// 会话取消后handler返回，等待Run退出以免影响其他测试
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run not returned")
+	}
+	// 再次Shutdown等待客户端关闭完成
+	ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := r.Shutdown(ctx); err != nil {
+		t.Errorf("second Shutdown returned %v", err)
+	}
+}
+
+func TestRunnerChecks(t *testing.T) {
+	c := kafkatest.NewCluster()
+	if err := c.CreateTopic("TEST_RUNNER_CHECK", 1); err != nil {
+		t.Fatal(err)
+	}
+
+	block := make(chan struct{})
+	h := func(ctx context.Context, msg *sarama.ConsumerMessage) error {
+		if msg.Offset == 1 {
+			<-block
+		}
+		return nil
+	}
+	r := mkafka.NewRunner(c.NewConsumerGroup("g", mkafka.DefaultConsumerConfig()), []string{"TEST_RUNNER_CHECK"}, mkafka.NewGroupHandler(h))
+	r.CommitInterval = -1
+	group := r.GroupCheck()
+	commit := r.CommitCheck(50 * time.Millisecond)
+	ctx := context.Background()
+
+	if err := group(ctx); !errors.Is(err, mkafka.ErrNotJoined) {
+		t.Errorf("group check before Run: %v", err)
+	}
+	go r.Run(ctx)
+	waitFor(t, "join", func() bool { return group(ctx) == nil })
+
+	// 没有消息时提交检查总是正常
+	time.Sleep(60 * time.Millisecond)
+	if err := commit(ctx); err != nil {
+		t.Errorf("commit check while idle: %v", err)
+	}
+
+	// 第一条消息已标记，第二条阻塞在处理中，关闭定期提交后标记的offset一直没有提交
+	produce(t, c, "TEST_RUNNER_CHECK", 2)
+	waitFor(t, "stale commit", func() bool { return errors.Is(commit(ctx), mkafka.ErrCommitStale) })
+
+	close(block)
+	sctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+	if err := r.Shutdown(sctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := group(ctx); !errors.Is(err, mkafka.ErrNotJoined) {
+		t.Errorf("group check after Shutdown: %v", err)
+	}
+	if off := c.CommittedOffset("g", "TEST_RUNNER_CHECK", 0); off != 2 {
+		t.Errorf("committed offset %d, want 2", off)
+	}
+}
+
+func TestRunnerPeriodicCommit(t *testing.T) {
+	c := kafkatest.NewCluster()
+	produce(t, c, "TEST_RUNNER_PERIODIC", 3)
+
+	r := mkafka.NewRunner(c.NewConsumerGroup("g", mkafka.DefaultConsumerConfig()), []string{"TEST_RUNNER_PERIODIC"},
+		mkafka.NewGroupHandler(func(ctx context.Context, msg *sarama.ConsumerMessage) error { return nil }))
+	r.CommitInterval = 10 * time.Millisecond
+	go r.Run(context.Background())
+	defer r.Shutdown(context.Background())
+
+	waitFor(t, "periodic commit", func() bool { return c.CommittedOffset("g", "TEST_RUNNER_PERIODIC", 0) == 3 })
+}
+
+func TestRunnerCommitFailure(t *testing.T) {
+	c := kafkatest.NewCluster()
+	produce(t, c, "TEST_RUNNER_COMMIT_ERR", 3)
+	c.SetCommitError("g", errors.New("commit rejected"))
+
+	r := mkafka.NewRunner(c.NewConsumerGroup("g", mkafka.DefaultConsumerConfig()), []string{"TEST_RUNNER_COMMIT_ERR"},
+		mkafka.NewGroupHandler(func(ctx context.Context, msg *sarama.ConsumerMessage) error { return nil }))
+	r.CommitInterval = 10 * time.Millisecond
+	commit := r.CommitCheck(50 * time.Millisecond)
+	ctx := context.Background()
+	go r.Run(ctx)
+	defer r.Shutdown(ctx)
+
+	// 提交一直失败时检查失败，错误中包含提交失败的原因
+	waitFor(t, "commit failure", func() bool {
+		err := commit(ctx)
+		return errors.Is(err, mkafka.ErrCommitStale) && strings.Contains(err.Error(), "commit rejected")
+	})
+	if !r.LastCommit().IsZero() {
+		t.Errorf("last commit = %v, want none", r.LastCommit())
+	}
+	if off := c.CommittedOffset("g", "TEST_RUNNER_COMMIT_ERR", 0); off != -1 {
+		t.Errorf("committed offset %d, want none", off)
+	}
+
+	// 恢复后检查正常
+	c.SetCommitError("g", nil)
+	waitFor(t, "commit recovery", func() bool { return commit(ctx) == nil })
+	if off := c.CommittedOffset("g", "TEST_RUNNER_COMMIT_ERR", 0); off != 3 {
+		t.Errorf("committed offset %d, want 3", off)
+	}
+}
+
+func TestRunnerGroupClosed(t *testing.T) {
+	c := kafkatest.NewCluster()
+	group := c.NewConsumerGroup("g", mkafka.DefaultConsumerConfig())
+	r := mkafka.NewRunner(group, []string{"TEST_RUNNER_CLOSED"}, mkafka.NewGroupHandler(func(ctx context.Context, msg *sarama.ConsumerMessage) error { return nil }))
+
+	// 消费者组在Runner之外被关闭时Run返回错误
+	group.Close()
+	if err := r.Run(context.Background()); !errors.Is(err, sarama.ErrClosedConsumerGroup) {
+		t.Errorf("Run returned %v", err)
+	}
+	r.Shutdown(context.Background())
+}
@@ -0,0 +1,168 @@
+package mkafka_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/mouseleee/mlib/mkafka"
+)
+
+// genCert 生成自签名证书和私钥文件
+func genCert(t *testing.T, dir string) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "mlib-test"},
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kb, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600)
+	os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: kb}), 0o600)
+	return certFile, keyFile
+}
+
+func TestLoadTLSConfig(t *testing.T) {
+	dir := t.TempDir()
+	cert, key := genCert(t, dir)
+
+	conf, err := mkafka.LoadTLSConfig(cert, cert, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conf.RootCAs == nil || len(conf.Certificates) != 1 {
+		t.Error("证书未加载")
+	}
+
+	if _, err := mkafka.LoadTLSConfig(cert, cert, ""); !errors.Is(err, mkafka.ErrInvalidConfig) {
+		t.Error(err)
+	}
+	if _, err := mkafka.NewProducerConfig(mkafka.WithTLSFiles(filepath.Join(dir, "missing.pem"), "", "")); err == nil {
+		t.Error("证书文件不存在时应返回错误")
+	}
+}
+
+func TestSecurityConfigFromEnv(t *testing.T) {
+	dir := t.TempDir()
+	cert, _ := genCert(t, dir)
+	pwd := filepath.Join(dir, "password")
+	os.WriteFile(pwd, []byte("secret\n"), 0o600)
+
+	t.Setenv("TEST_KAFKA_SASL_MECHANISM", "SCRAM-SHA-256")
+	t.Setenv("TEST_KAFKA_SASL_USERNAME", "user")
+	t.Setenv("TEST_KAFKA_SASL_PASSWORD_FILE", pwd)
+	t.Setenv("TEST_KAFKA_SSL_CA_FILE", cert)
+
+	sc, err := mkafka.SecurityConfigFromEnv("TEST_KAFKA_")
+	if err != nil {
+		t.Fatal(err)
+	}
+	conf, err := mkafka.NewConsumerConfig(mkafka.WithSecurity(sc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !conf.Net.TLS.Enable || conf.Net.SASL.Mechanism != sarama.SASLTypeSCRAMSHA256 || conf.Net.SASL.Password != "secret" {
+		t.Error("环境变量配置未生效")
+	}
+}
+
+func TestLoadSecurityConfig(t *testing.T) {
+	dir := t.TempDir()
+	token := filepath.Join(dir, "token")
+	os.WriteFile(token, []byte("tkn"), 0o600)
+	f := filepath.Join(dir, "security.json")
+	os.WriteFile(f, []byte(`{"protocol":"SASL_PLAINTEXT","sasl_mechanism":"OAUTHBEARER","token_file":"`+token+`"}`), 0o600)
+
+	sc, err := mkafka.LoadSecurityConfig(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conf, err := mkafka.NewClientConfig(mkafka.WithSecurity(sc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tk, err := conf.Net.SASL.TokenProvider.Token()
+	if err != nil || tk.Token != "tkn" {
+		t.Error(tk, err)
+	}
+
+	sc.Token, sc.TokenFile = "", ""
+	if _, err := mkafka.NewClientConfig(mkafka.WithSecurity(sc)); !errors.Is(err, mkafka.ErrInvalidConfig) {
+		t.Error(err)
+	}
+}
+
+// TestSecuredBrokers 连接按认证机制配置的本地broker，MKAFKA_TEST_<机制>_BROKERS未设置时跳过，
+// 认证参数通过MKAFKA_TEST_<机制>_前缀的环境变量提供，变量名同SecurityConfigFromEnv
+func TestSecuredBrokers(t *testing.T) {
+	for _, mech := range []string{"SSL", "PLAIN", "SCRAM256", "SCRAM512", "OAUTH"} {
+		t.Run(mech, func(t *testing.T) {
+			prefix := "MKAFKA_TEST_" + mech + "_"
+			addrs := os.Getenv(prefix + "BROKERS")
+			if addrs == "" {
+				t.Skip(prefix + "BROKERS未设置")
+			}
+
+			sc, err := mkafka.SecurityConfigFromEnv(prefix)
+			if err != nil {
+				t.Fatal(err)
+			}
+			cli, err := mkafka.CreateKafkaClient(addrs, nil, mkafka.WithSecurity(sc))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer cli.Close()
+
+			prd, err := mkafka.DefaultProducer(addrs, mkafka.WithSecurity(sc))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer prd.Close()
+
+			csm, err := mkafka.DefaultConsumerGroup(addrs, "test.group", mkafka.WithSecurity(sc))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer csm.Close()
+		})
+	}
+}
+
+func TestCreateKafkaClientKeepsConfig(t *testing.T) {
+	conf := sarama.NewConfig()
+	// 配置校验失败，不会连接broker
+	conf.Net.MaxOpenRequests = 0
+	if _, err := mkafka.CreateKafkaClient("localhost:9092", conf, mkafka.WithClientID("a"), mkafka.WithVersion(sarama.V2_4_0_0)); err == nil {
+		t.Fatal("配置无效时应当失败")
+	}
+	// 选项应用在副本上，config可以复用
+	if conf.ClientID != "sarama" || conf.Version != sarama.DefaultVersion {
+		t.Errorf("conf.ClientID = %s, conf.Version = %s", conf.ClientID, conf.Version)
+	}
+}
@@ -0,0 +1,104 @@
+package mkafka_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/Shopify/sarama"
+	"github.com/mouseleee/mlib/mkafka"
+	"github.com/mouseleee/mlib/mkafka/kafkatest"
+	"github.com/rs/zerolog"
+)
+
+func TestParseTraceParent(t *testing.T) {
+	const tp = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	tc, err := mkafka.ParseTraceParent(tp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tc.String() != tp {
+		t.Errorf("String() = %s", tc.String())
+	}
+	if tc.TraceIDString() != "4bf92f3577b34da6a3ce929d0e0e4736" || tc.Flags != 1 {
+		t.Errorf("parsed = %+v", tc)
+	}
+
+	child := tc.Child()
+	if child.TraceID != tc.TraceID || child.SpanID == tc.SpanID {
+		t.Errorf("child = %s", child)
+	}
+
+	for _, s := range []string{
+		"",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e473x-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra",
+	} {
+		if _, err := mkafka.ParseTraceParent(s); err == nil {
+			t.Errorf("ParseTraceParent(%q) 应当失败", s)
+		}
+	}
+	if _, err := mkafka.ParseTraceParent("01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestTracingPropagation(t *testing.T) {
+	buf := &bytes.Buffer{}
+	mkafka.SetLogger(zerolog.New(buf), false)
+	defer mkafka.SetLogger(zerolog.Nop(), false)
+
+	c := kafkatest.NewCluster()
+	prd := mkafka.NewTracingProducer(c.NewSyncProducer(nil), "order-service")
+
+	parent, _ := mkafka.ParseTraceParent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	ctx := mkafka.ContextWithMetadata(context.Background(), mkafka.Metadata{Trace: parent, TraceState: "k=v"})
+	ctx = mkafka.ContextWithCorrelationID(ctx, "req-1")
+	if _, _, err := prd.SendMessageContext(ctx, &sarama.ProducerMessage{Topic: "TEST_TRACE", Value: sarama.StringEncoder("v")}); err != nil {
+		t.Fatal(err)
+	}
+	// 没有上游链路时开启新链路
+	if _, _, err := prd.SendMessage(&sarama.ProducerMessage{Topic: "TEST_TRACE", Value: sarama.StringEncoder("v")}); err != nil {
+		t.Fatal(err)
+	}
+
+	var got []mkafka.Metadata
+	consumeWith(t, c, "test.group", "TEST_TRACE", 2, func(ctx context.Context, msg *sarama.ConsumerMessage) error {
+		md, ok := mkafka.MetadataFromContext(ctx)
+		if !ok {
+			t.Error("context中没有元数据")
+		}
+		got = append(got, md)
+		mkafka.Logger(ctx).Info().Msg("handled")
+		return nil
+	}, mkafka.Tracing())
+
+	if len(got) != 2 {
+		t.Fatalf("handled %d", len(got))
+	}
+	md := got[0]
+	if md.Trace.TraceID != parent.TraceID || md.Trace.SpanID == parent.SpanID {
+		t.Errorf("trace = %s", md.Trace)
+	}
+	if md.CorrelationID != "req-1" || md.Producer != "order-service" || md.TraceState != "k=v" {
+		t.Errorf("metadata = %+v", md)
+	}
+	if got[1].Trace.TraceID == parent.TraceID || got[1].CorrelationID != got[1].Trace.TraceIDString() {
+		t.Errorf("metadata = %+v", got[1])
+	}
+
+	var line map[string]any
+	first := strings.SplitN(buf.String(), "\n", 2)[0]
+	if err := json.Unmarshal([]byte(first), &line); err != nil {
+		t.Fatal(err, buf.String())
+	}
+	if line["trace_id"] != parent.TraceIDString() || line["correlation_id"] != "req-1" || line["producer"] != "order-service" {
+		t.Errorf("log = %s", first)
+	}
+}
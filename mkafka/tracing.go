@@ -0,0 +1,253 @@
+package mkafka
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"strings"
+
+	"github.com/Shopify/sarama"
+	"github.com/rs/zerolog"
+)
+
+// 在生产和消费时通过header传递链路追踪(W3C traceparent)、关联ID和生产者标识，
+// 消费端提取后放入context，并附加到mkafka的logger上
+
+// ErrInvalidTraceParent traceparent格式不合法
+var ErrInvalidTraceParent = errors.New("[mouse] -> kafka 无效的traceparent")
+
+// TraceContext W3C Trace Context中的traceparent
+type TraceContext struct {
+	TraceID [16]byte
+	SpanID  [8]byte
+	Flags   byte
+}
+
+// NewTraceContext 生成新的链路，默认采样
+func NewTraceContext() TraceContext {
+	tc := TraceContext{Flags: 1}
+	_, _ = rand.Read(tc.TraceID[:])
+	_, _ = rand.Read(tc.SpanID[:])
+	return tc
+}
+
+// ParseTraceParent 解析traceparent，格式为 version-traceid-spanid-flags
+func ParseTraceParent(s string) (TraceContext, error) {
+	tc := TraceContext{}
+	parts := strings.Split(strings.TrimSpace(s), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" {
+		return tc, ErrInvalidTraceParent
+	}
+	// version 00 只允许4段，更高版本忽略多出的部分
+	if parts[0] == "00" && len(parts) != 4 {
+		return tc, ErrInvalidTraceParent
+	}
+	if len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return tc, ErrInvalidTraceParent
+	}
+	var flags [1]byte
+	if _, err := hex.Decode(tc.TraceID[:], []byte(parts[1])); err != nil {
+		return tc, ErrInvalidTraceParent
+	}
+	if _, err := hex.Decode(tc.SpanID[:], []byte(parts[2])); err != nil {
+		return tc, ErrInvalidTraceParent
+	}
+	if _, err := hex.Decode(flags[:], []byte(parts[3])); err != nil {
+		return tc, ErrInvalidTraceParent
+	}
+	tc.Flags = flags[0]
+	if !tc.IsValid() {
+		return tc, ErrInvalidTraceParent
+	}
+	return tc, nil
+}
+
+// IsValid trace id和span id都不为全0
+func (tc TraceContext) IsValid() bool {
+	return tc.TraceID != [16]byte{} && tc.SpanID != [8]byte{}
+}
+
+// Child 生成同一链路上的子span
+func (tc TraceContext) Child() TraceContext {
+	c := TraceContext{TraceID: tc.TraceID, Flags: tc.Flags}
+	_, _ = rand.Read(c.SpanID[:])
+	return c
+}
+
+// TraceIDString 十六进制的trace id
+func (tc TraceContext) TraceIDString() string {
+	return hex.EncodeToString(tc.TraceID[:])
+}
+
+// SpanIDString 十六进制的span id
+func (tc TraceContext) SpanIDString() string {
+	return hex.EncodeToString(tc.SpanID[:])
+}
+
+// String 编码为version 00的traceparent
+func (tc TraceContext) String() string {
+	return "00-" + tc.TraceIDString() + "-" + tc.SpanIDString() + "-" + hex.EncodeToString([]byte{tc.Flags})
+}
+
+// Metadata 随消息传递的元数据
+type Metadata struct {
+	Trace         TraceContext
+	TraceState    string
+	CorrelationID string
+	// Producer 消息的生产者标识，只在消费端有意义
+	Producer string
+}
+
+type metadataKey struct{}
+
+// ContextWithMetadata 将元数据放入context
+func ContextWithMetadata(ctx context.Context, md Metadata) context.Context {
+	return context.WithValue(ctx, metadataKey{}, md)
+}
+
+// MetadataFromContext 获取context中的元数据
+func MetadataFromContext(ctx context.Context) (Metadata, bool) {
+	md, ok := ctx.Value(metadataKey{}).(Metadata)
+	return md, ok
+}
+
+// ContextWithCorrelationID 设置关联ID，之后通过ctx发送的消息都携带该ID
+func ContextWithCorrelationID(ctx context.Context, id string) context.Context {
+	md, _ := MetadataFromContext(ctx)
+	md.CorrelationID = id
+	return ContextWithMetadata(ctx, md)
+}
+
+// ExtractMetadata 从消息header中提取元数据，traceparent不合法时忽略
+func ExtractMetadata(msg *sarama.ConsumerMessage) Metadata {
+	md := Metadata{}
+	if v, ok := HeaderValue(msg, HeaderTraceParent); ok {
+		if tc, err := ParseTraceParent(string(v)); err == nil {
+			md.Trace = tc
+			if s, ok := HeaderValue(msg, HeaderTraceState); ok {
+				md.TraceState = string(s)
+			}
+		}
+	}
+	if v, ok := HeaderValue(msg, HeaderCorrelationID); ok {
+		md.CorrelationID = string(v)
+	}
+	if v, ok := HeaderValue(msg, HeaderProducer); ok {
+		md.Producer = string(v)
+	}
+	return md
+}
+
+// InjectMetadata 将ctx中的链路和关联ID写入消息header，返回写入的元数据
+//
+// ctx中有链路时使用其子span，否则开启新链路；没有关联ID时生成新的关联ID；producer不为空时写入生产者标识
+func InjectMetadata(ctx context.Context, msg *sarama.ProducerMessage, producer string) Metadata {
+	md, _ := MetadataFromContext(ctx)
+	if md.Trace.IsValid() {
+		md.Trace = md.Trace.Child()
+	} else {
+		md.Trace = NewTraceContext()
+		md.TraceState = ""
+	}
+	if md.CorrelationID == "" {
+		md.CorrelationID = md.Trace.TraceIDString()
+	}
+	md.Producer = producer
+
+	SetHeader(msg, HeaderTraceParent, []byte(md.Trace.String()))
+	if md.TraceState != "" {
+		SetHeader(msg, HeaderTraceState, []byte(md.TraceState))
+	}
+	SetHeader(msg, HeaderCorrelationID, []byte(md.CorrelationID))
+	if producer != "" {
+		SetHeader(msg, HeaderProducer, []byte(producer))
+	}
+	return md
+}
+
+// Logger 返回ctx上的logger，没有时返回SetLogger设置的logger
+func Logger(ctx context.Context) *zerolog.Logger {
+	if l := zerolog.Ctx(ctx); l != zerolog.DefaultContextLogger && l.GetLevel() != zerolog.Disabled {
+		return l
+	}
+	l := logger
+	return &l
+}
+
+func withMetadataLogger(ctx context.Context, md Metadata) context.Context {
+	lc := logger.With()
+	if md.Trace.IsValid() {
+		lc = lc.Str("trace_id", md.Trace.TraceIDString()).Str("span_id", md.Trace.SpanIDString())
+	}
+	if md.CorrelationID != "" {
+		lc = lc.Str("correlation_id", md.CorrelationID)
+	}
+	if md.Producer != "" {
+		lc = lc.Str("producer", md.Producer)
+	}
+	return lc.Logger().WithContext(ctx)
+}
+
+// Tracing 消费端中间件，提取消息header中的元数据放入context，并将带有trace_id等字段的logger放入context
+//
+// 消息没有traceparent时开启新链路；Handler中通过TracingProducer.SendMessageContext发送的消息会延续该链路
+func Tracing() Middleware {
+	return func(next Handler) Handler {
+		return func(ctx context.Context, msg *sarama.ConsumerMessage) error {
+			md := ExtractMetadata(msg)
+			if md.Trace.IsValid() {
+				md.Trace = md.Trace.Child()
+			} else {
+				md.Trace = NewTraceContext()
+				md.TraceState = ""
+			}
+			if md.CorrelationID == "" {
+				md.CorrelationID = md.Trace.TraceIDString()
+			}
+			ctx = ContextWithMetadata(ctx, md)
+			ctx = withMetadataLogger(ctx, md)
+			return next(ctx, msg)
+		}
+	}
+}
+
+// TracingProducer 在发送消息时写入链路、关联ID和生产者标识的同步生产者
+type TracingProducer struct {
+	sarama.SyncProducer
+	// Identity 生产者标识，写入HeaderProducer
+	Identity string
+}
+
+// NewTracingProducer 包装同步生产者，identity一般为服务名
+func NewTracingProducer(p sarama.SyncProducer, identity string) *TracingProducer {
+	return &TracingProducer{SyncProducer: p, Identity: identity}
+}
+
+// SendMessageContext 写入ctx中的元数据后发送消息
+func (p *TracingProducer) SendMessageContext(ctx context.Context, msg *sarama.ProducerMessage) (int32, int64, error) {
+	md := InjectMetadata(ctx, msg, p.Identity)
+	partition, offset, err := p.SyncProducer.SendMessage(msg)
+	if err != nil {
+		Logger(ctx).Err(err).Str("topic", msg.Topic).Str("span_id", md.Trace.SpanIDString()).Msg("发送消息失败")
+	}
+	return partition, offset, err
+}
+
+// SendMessagesContext 写入ctx中的元数据后批量发送消息，每条消息有独立的span
+func (p *TracingProducer) SendMessagesContext(ctx context.Context, msgs []*sarama.ProducerMessage) error {
+	for _, m := range msgs {
+		InjectMetadata(ctx, m, p.Identity)
+	}
+	return p.SyncProducer.SendMessages(msgs)
+}
+
+// SendMessage 不带context时开启新链路
+func (p *TracingProducer) SendMessage(msg *sarama.ProducerMessage) (int32, int64, error) {
+	return p.SendMessageContext(context.Background(), msg)
+}
+
+// SendMessages 不带context时每条消息开启新链路
+func (p *TracingProducer) SendMessages(msgs []*sarama.ProducerMessage) error {
+	return p.SendMessagesContext(context.Background(), msgs)
+}
@@ -0,0 +1,419 @@
+// Package moutbox 事务性outbox，用于在写入业务数据的同时可靠地发布kafka消息
+//
+// 业务数据和待发布事件在同一个SQL事务中写入，事务提交后由Relay轮询outbox表并通过kafka生产者发布，
+// 发布成功后标记为已发布。Relay保证至少一次投递，每条消息都带有不变的去重键header（mkafka.HeaderDedupKey），
+// 消费端可以据此去重
+package moutbox
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/mouseleee/mlib/mkafka"
+	"github.com/mouseleee/mlib/mlog"
+	"github.com/mouseleee/mlib/msql"
+	"github.com/rs/zerolog"
+)
+
+const defaultLogLevel = "debug"
+
+var logger zerolog.Logger
+
+func init() {
+	logger, _ = mlog.CommandLogger(defaultLogLevel)
+}
+
+// TableName outbox表名
+const TableName = "outbox_event"
+
+// 事件状态
+const (
+	StatusPending   = 0
+	StatusPublished = 1
+	// StatusFailed 发布失败次数达到MaxAttempts，不再发布，需要人工处理后改回StatusPending
+	StatusFailed = 2
+	// StatusClaimed 已被Relay取出正在发布，超过ClaimTimeout未完成时视为Relay崩溃，可以被重新取出
+	StatusClaimed = 3
+)
+
+const (
+	defaultBatchSize    = 100
+	defaultInterval     = time.Second
+	defaultMaxAttempts  = 10
+	defaultClaimTimeout = time.Minute
+)
+
+// OutboxEvent outbox表结构，通过msql生成建表语句
+type OutboxEvent struct {
+	Id          int64     `col:"id" primary:"true" auto:"true"`
+	DedupKey    string    `col:"dedup_key" unique:"true" strlen:"64" comment:"去重键"`
+	Topic       string    `col:"topic" comment:"目标topic"`
+	MsgKey      []byte    `col:"msg_key" null:"true" comment:"消息key"`
+	Payload     []byte    `col:"payload" longstr:"true" comment:"消息内容"`
+	Headers     string    `col:"headers" longstr:"true" comment:"消息header，json格式"`
+	Status      int       `col:"status" default:"0" comment:"0未发布 1已发布 2发布失败 3发布中"`
+	Attempts    int       `col:"attempts" default:"0" comment:"发布失败次数"`
+	CreateTime  time.Time `col:"create_time"`
+	PublishTime time.Time `col:"publish_time" null:"true"`
+	ClaimTime   time.Time `col:"claim_time" null:"true" comment:"被Relay取出的时间"`
+}
+
+// CreateTableSQL 返回outbox表的建表语句
+func CreateTableSQL() (string, error) {
+	return msql.CreateTableSQL(OutboxEvent{}, "事务消息outbox")
+}
+
+// Event 待发布的事件
+type Event struct {
+	// DedupKey 去重键，为空时自动生成，同一个去重键只能写入一次
+	DedupKey string
+	Topic    string
+	Key      []byte
+	Payload  []byte
+	Headers  map[string]string
+}
+
+// Execer 执行SQL语句，*sql.Tx、*sql.DB和*sql.Conn都满足
+type Execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+// Insert 在调用方的事务中写入事件，事务提交后事件才会被Relay发布；返回事件的去重键
+func Insert(ctx context.Context, tx Execer, events ...Event) ([]string, error) {
+	if len(events) == 0 {
+		return nil, nil
+	}
+
+	q := strings.Builder{}
+	q.WriteString("INSERT INTO " + TableName + " (dedup_key, topic, msg_key, payload, headers, status, attempts, create_time) VALUES ")
+	args := make([]any, 0, len(events)*8)
+	keys := make([]string, 0, len(events))
+	now := time.Now()
+	for i, e := range events {
+		if e.Topic == "" {
+			return nil, fmt.Errorf("[mouse] -> outbox 事件缺少topic")
+		}
+		key := e.DedupKey
+		if key == "" {
+			var err error
+			if key, err = newDedupKey(); err != nil {
+				return nil, err
+			}
+		}
+		headers := "{}"
+		if len(e.Headers) > 0 {
+			b, err := json.Marshal(e.Headers)
+			if err != nil {
+				return nil, err
+			}
+			headers = string(b)
+		}
+
+		var msgKey any
+		if e.Key != nil {
+			msgKey = e.Key
+		}
+
+		if i > 0 {
+			q.WriteString(", ")
+		}
+		q.WriteString("(?, ?, ?, ?, ?, ?, ?, ?)")
+		args = append(args, key, e.Topic, msgKey, e.Payload, headers, StatusPending, 0, now)
+		keys = append(keys, key)
+	}
+
+	if _, err := tx.ExecContext(ctx, q.String(), args...); err != nil {
+		return nil, msql.NewMysqlErr("写入outbox失败", err)
+	}
+	return keys, nil
+}
+
+func newDedupKey() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// Relay 轮询outbox表并发布未发布的事件
+//
+// 事件分批取出后在事务之外发布：先在短事务中通过SELECT ... FOR UPDATE SKIP LOCKED（MySQL 8.0+）取出一批事件并标记为
+// StatusClaimed，多个Relay可以同时运行且互不重复处理；发布完成后再更新状态。
+//
+// 事件按写入顺序发布。取出时跳过同一个topic和key之前还有未发布事件（待发布或发布中，包括被其他Relay锁定的）的事件，
+// 这些事件等更早的事件发布后再取出，所以多个Relay同时运行或发布中的事件超时被重新取出时同一个key的消息也不会乱序；
+// 某个事件发布失败时，本批次中与它topic和key都相同的后续事件放回待发布，其余事件继续发布；
+// 失败次数达到MaxAttempts的事件标记为StatusFailed，不再阻塞后续事件
+type Relay struct {
+	DB       *sql.DB
+	Producer sarama.SyncProducer
+
+	// BatchSize 每次最多处理的事件数，默认100
+	BatchSize int
+	// Interval 没有待发布事件时的轮询间隔，默认1秒
+	Interval time.Duration
+	// MaxAttempts 最大发布次数，默认10
+	MaxAttempts int
+	// ClaimTimeout 取出后未完成发布的事件在该时间后可以被重新取出，默认1分钟
+	ClaimTimeout time.Duration
+}
+
+// Run 持续发布事件，直到ctx结束
+func (r *Relay) Run(ctx context.Context) error {
+	interval := r.Interval
+	if interval <= 0 {
+		interval = defaultInterval
+	}
+
+	t := time.NewTimer(0)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-t.C:
+		}
+
+		n, err := r.RelayOnce(ctx)
+		if err != nil && !errors.Is(err, context.Canceled) {
+			logger.Error().Err(err).Msg("发布outbox事件失败")
+		}
+		// 一批处理满说明可能还有积压，立即继续
+		if err == nil && n == r.batchSize() {
+			t.Reset(0)
+		} else {
+			t.Reset(interval)
+		}
+	}
+}
+
+func (r *Relay) batchSize() int {
+	if r.BatchSize <= 0 {
+		return defaultBatchSize
+	}
+	return r.BatchSize
+}
+
+func (r *Relay) maxAttempts() int {
+	if r.MaxAttempts <= 0 {
+		return defaultMaxAttempts
+	}
+	return r.MaxAttempts
+}
+
+func (r *Relay) claimTimeout() time.Duration {
+	if r.ClaimTimeout <= 0 {
+		return defaultClaimTimeout
+	}
+	return r.ClaimTimeout
+}
+
+// orderKey 同一个topic和key的事件需要保持顺序，没有key的事件之间没有顺序要求
+func orderKey(e OutboxEvent) (string, bool) {
+	if e.MsgKey == nil {
+		return "", false
+	}
+	return e.Topic + "\x00" + string(e.MsgKey), true
+}
+
+// RelayOnce 发布一批未发布的事件，返回发布成功的事件数；有事件发布失败时同时返回第一个发布错误
+func (r *Relay) RelayOnce(ctx context.Context) (int, error) {
+	events, err := r.claim(ctx)
+	if err != nil || len(events) == 0 {
+		return 0, err
+	}
+
+	var (
+		published, failed, skipped []any
+		sendErr                    error
+		blocked                    = make(map[string]bool)
+	)
+	for _, e := range events {
+		ok, hasKey := orderKey(e)
+		if hasKey && blocked[ok] {
+			skipped = append(skipped, e.Id)
+			continue
+		}
+		if err := r.publish(e); err != nil {
+			logger.Warn().Err(err).Int64("id", e.Id).Str("dedup_key", e.DedupKey).Msg("发布outbox事件失败，稍后重试")
+			if sendErr == nil {
+				sendErr = err
+			}
+			failed = append(failed, e.Id)
+			if hasKey {
+				blocked[ok] = true
+			}
+			continue
+		}
+		published = append(published, e.Id)
+	}
+
+	// 更新失败时事件保持StatusClaimed，ClaimTimeout后重新发布，由消费端根据去重键去重
+	if len(published) > 0 {
+		args := append([]any{StatusPublished, time.Now()}, published...)
+		if err := r.exec(ctx, "SET status = ?, publish_time = ?", args, len(published)); err != nil {
+			return 0, err
+		}
+	}
+	if len(failed) > 0 {
+		// MySQL按从左到右的顺序计算赋值，status需要在attempts之前计算
+		args := append([]any{r.maxAttempts(), StatusFailed, StatusPending}, failed...)
+		if err := r.exec(ctx, "SET status = CASE WHEN attempts + 1 >= ? THEN ? ELSE ? END, attempts = attempts + 1", args, len(failed)); err != nil {
+			return len(published), err
+		}
+	}
+	if len(skipped) > 0 {
+		args := append([]any{StatusPending}, skipped...)
+		if err := r.exec(ctx, "SET status = ?", args, len(skipped)); err != nil {
+			return len(published), err
+		}
+	}
+	return len(published), sendErr
+}
+
+// exec 更新id在args末尾n个值中的事件
+func (r *Relay) exec(ctx context.Context, set string, args []any, n int) error {
+	q := "UPDATE " + TableName + " " + set + " WHERE id IN (?" + strings.Repeat(", ?", n-1) + ")"
+	if _, err := r.DB.ExecContext(ctx, q, args...); err != nil {
+		return msql.NewMysqlErr("更新outbox失败", err)
+	}
+	return nil
+}
+
+// claim 在短事务中取出一批待发布的事件并标记为StatusClaimed，超过ClaimTimeout的StatusClaimed事件同样可以被取出；
+// 同一个key之前还有未发布事件的事件不取出
+func (r *Relay) claim(ctx context.Context) ([]OutboxEvent, error) {
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, msql.NewMysqlErr("开启事务失败", err)
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	rows, err := tx.QueryContext(ctx,
+		"SELECT id, dedup_key, topic, msg_key, payload, headers FROM "+TableName+
+			" WHERE status = ? OR (status = ? AND claim_time < ?) ORDER BY id LIMIT ? FOR UPDATE SKIP LOCKED",
+		StatusPending, StatusClaimed, now.Add(-r.claimTimeout()), r.batchSize())
+	if err != nil {
+		return nil, msql.NewMysqlErr("查询outbox失败", err)
+	}
+	defer rows.Close()
+
+	events := make([]OutboxEvent, 0)
+	ids := make([]any, 0)
+	for rows.Next() {
+		e := OutboxEvent{}
+		if err := rows.Scan(&e.Id, &e.DedupKey, &e.Topic, &e.MsgKey, &e.Payload, &e.Headers); err != nil {
+			return nil, msql.NewMysqlErr("读取outbox失败", err)
+		}
+		events = append(events, e)
+		ids = append(ids, e.Id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, msql.NewMysqlErr("读取outbox失败", err)
+	}
+	rows.Close()
+	if events, err = dropBlocked(ctx, tx, events); err != nil || len(events) == 0 {
+		return nil, err
+	}
+	ids = ids[:0]
+	for _, e := range events {
+		ids = append(ids, e.Id)
+	}
+
+	q := "UPDATE " + TableName + " SET status = ?, claim_time = ? WHERE id IN (?" + strings.Repeat(", ?", len(ids)-1) + ")"
+	if _, err := tx.ExecContext(ctx, q, append([]any{StatusClaimed, now}, ids...)...); err != nil {
+		return nil, msql.NewMysqlErr("更新outbox失败", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, msql.NewMysqlErr("提交事务失败", err)
+	}
+	return events, nil
+}
+
+// dropBlocked 去掉同一个topic和key之前还有不在本批次中的未发布事件的事件；这些更早的事件正在被其他Relay发布，
+// 或者在其他Relay的claim事务中被锁定而被SKIP LOCKED跳过，先发布之后的事件会打乱顺序
+func dropBlocked(ctx context.Context, tx *sql.Tx, events []OutboxEvent) ([]OutboxEvent, error) {
+	var (
+		ids   []any
+		maxID int64
+	)
+	for _, e := range events {
+		if _, ok := orderKey(e); ok {
+			ids = append(ids, e.Id)
+			maxID = e.Id
+		}
+	}
+	if len(ids) == 0 {
+		return events, nil
+	}
+
+	rows, err := tx.QueryContext(ctx,
+		"SELECT topic, msg_key, MIN(id) FROM "+TableName+" WHERE status IN (?, ?) AND msg_key IS NOT NULL AND id < ?"+
+			" AND id NOT IN (?"+strings.Repeat(", ?", len(ids)-1)+") GROUP BY topic, msg_key",
+		append([]any{StatusPending, StatusClaimed, maxID}, ids...)...)
+	if err != nil {
+		return nil, msql.NewMysqlErr("查询outbox失败", err)
+	}
+	defer rows.Close()
+
+	first := make(map[string]int64)
+	for rows.Next() {
+		var (
+			e  OutboxEvent
+			id int64
+		)
+		if err := rows.Scan(&e.Topic, &e.MsgKey, &id); err != nil {
+			return nil, msql.NewMysqlErr("读取outbox失败", err)
+		}
+		k, _ := orderKey(e)
+		first[k] = id
+	}
+	if err := rows.Err(); err != nil {
+		return nil, msql.NewMysqlErr("读取outbox失败", err)
+	}
+
+	kept := events[:0]
+	for _, e := range events {
+		if k, ok := orderKey(e); ok {
+			if id, blocked := first[k]; blocked && id < e.Id {
+				continue
+			}
+		}
+		kept = append(kept, e)
+	}
+	return kept, nil
+}
+
+func (r *Relay) publish(e OutboxEvent) error {
+	msg := &sarama.ProducerMessage{
+		Topic: e.Topic,
+		Value: sarama.ByteEncoder(e.Payload),
+	}
+	if e.MsgKey != nil {
+		msg.Key = sarama.ByteEncoder(e.MsgKey)
+	}
+
+	headers := make(map[string]string)
+	if e.Headers != "" {
+		if err := json.Unmarshal([]byte(e.Headers), &headers); err != nil {
+			return err
+		}
+	}
+	for k, v := range headers {
+		msg.Headers = append(msg.Headers, sarama.RecordHeader{Key: []byte(k), Value: []byte(v)})
+	}
+	msg.Headers = append(msg.Headers, sarama.RecordHeader{Key: []byte(mkafka.HeaderDedupKey), Value: []byte(e.DedupKey)})
+
+	_, _, err := r.Producer.SendMessage(msg)
+	return err
+}
@@ -0,0 +1,326 @@
+package moutbox_test
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/mouseleee/mlib/mkafka"
+	"github.com/mouseleee/mlib/mkafka/kafkatest"
+	"github.com/mouseleee/mlib/moutbox"
+)
+
+func TestCreateTableSQL(t *testing.T) {
+	ddl, err := moutbox.CreateTableSQL()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{
+		"CREATE TABLE IF NOT EXISTS outbox_event",
+		"`dedup_key` VARCHAR(64) NOT NULL UNIQUE",
+		"`payload` LONGBLOB NOT NULL",
+		"`msg_key` BLOB NULL",
+		"`status` INT NOT NULL DEFAULT 0",
+	} {
+		if !strings.Contains(ddl, s) {
+			t.Errorf("ddl缺少 %q:\n%s", s, ddl)
+		}
+	}
+}
+
+func TestInsert(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO outbox_event")).
+		WithArgs("k1", "T1", []byte("a"), []byte("v1"), `{"h":"1"}`, 0, 0, sqlmock.AnyArg(),
+			sqlmock.AnyArg(), "T2", nil, []byte("v2"), "{}", 0, 0, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(2, 2))
+	mock.ExpectCommit()
+
+	ctx := context.Background()
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys, err := moutbox.Insert(ctx, tx,
+		moutbox.Event{DedupKey: "k1", Topic: "T1", Key: []byte("a"), Payload: []byte("v1"), Headers: map[string]string{"h": "1"}},
+		moutbox.Event{Topic: "T2", Payload: []byte("v2")},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 2 || keys[0] != "k1" || len(keys[1]) != 32 {
+		t.Errorf("keys = %v", keys)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+var (
+	selectPending  = regexp.QuoteMeta("SELECT id, dedup_key, topic, msg_key, payload, headers FROM outbox_event WHERE status = ?")
+	outboxColumns  = []string{"id", "dedup_key", "topic", "msg_key", "payload", "headers"}
+	selectBlocked  = regexp.QuoteMeta("SELECT topic, msg_key, MIN(id) FROM outbox_event WHERE status IN (?, ?)")
+	blockedColumns = []string{"topic", "msg_key", "id"}
+)
+
+// expectClaim 取出rows中的事件并标记为发布中；blocked不为nil时rows中有带key的事件，查询同一个key之前未发布的事件
+func expectClaim(mock sqlmock.Sqlmock, batch int, rows, blocked *sqlmock.Rows, ids ...any) {
+	mock.ExpectBegin()
+	mock.ExpectQuery(selectPending).WithArgs(moutbox.StatusPending, moutbox.StatusClaimed, sqlmock.AnyArg(), batch).WillReturnRows(rows)
+	if blocked != nil {
+		mock.ExpectQuery(selectBlocked).WillReturnRows(blocked)
+	}
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE outbox_event SET status = ?, claim_time = ? WHERE id IN (?" + strings.Repeat(", ?", len(ids)-1) + ")")).
+		WithArgs(append([]driver.Value{moutbox.StatusClaimed, sqlmock.AnyArg()}, toValues(ids)...)...).
+		WillReturnResult(sqlmock.NewResult(0, int64(len(ids))))
+	mock.ExpectCommit()
+}
+
+func expectPublished(mock sqlmock.Sqlmock, ids ...any) {
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE outbox_event SET status = ?, publish_time = ? WHERE id IN (?" + strings.Repeat(", ?", len(ids)-1) + ")")).
+		WithArgs(append([]driver.Value{moutbox.StatusPublished, sqlmock.AnyArg()}, toValues(ids)...)...).
+		WillReturnResult(sqlmock.NewResult(0, int64(len(ids))))
+}
+
+func expectFailed(mock sqlmock.Sqlmock, maxAttempts int, ids ...any) {
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE outbox_event SET status = CASE WHEN attempts + 1 >= ? THEN ? ELSE ? END, attempts = attempts + 1 WHERE id IN (?" + strings.Repeat(", ?", len(ids)-1) + ")")).
+		WithArgs(append([]driver.Value{maxAttempts, moutbox.StatusFailed, moutbox.StatusPending}, toValues(ids)...)...).
+		WillReturnResult(sqlmock.NewResult(0, int64(len(ids))))
+}
+
+func toValues(ids []any) []driver.Value {
+	vs := make([]driver.Value, len(ids))
+	for i, id := range ids {
+		vs[i] = id
+	}
+	return vs
+}
+
+func TestRelayOnce(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	c := kafkatest.NewCluster()
+	relay := &moutbox.Relay{DB: db, Producer: c.NewSyncProducer(nil), BatchSize: 10}
+
+	expectClaim(mock, 10, sqlmock.NewRows(outboxColumns).
+		AddRow(1, "k1", "OUTBOX", []byte("a"), []byte("v1"), `{"h":"1"}`).
+		AddRow(2, "k2", "OUTBOX", nil, []byte("v2"), "{}"), sqlmock.NewRows(blockedColumns), 1, 2)
+	expectPublished(mock, 1, 2)
+
+	n, err := relay.RelayOnce(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Errorf("published = %d", n)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+
+	msgs := c.Messages("OUTBOX", 0)
+	if len(msgs) != 2 {
+		t.Fatalf("messages = %d", len(msgs))
+	}
+	if string(msgs[0].Key) != "a" || string(msgs[0].Value) != "v1" || msgs[1].Key != nil {
+		t.Errorf("unexpected messages %v %v", msgs[0], msgs[1])
+	}
+	if v, _ := mkafka.HeaderValue(msgs[0], "h"); string(v) != "1" {
+		t.Errorf("header h = %s", v)
+	}
+	if v, _ := mkafka.HeaderValue(msgs[1], mkafka.HeaderDedupKey); string(v) != "k2" {
+		t.Errorf("dedup key = %s", v)
+	}
+}
+
+func TestRelayOnceSendFailure(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	c := kafkatest.NewCluster()
+	c.SetAutoCreateTopics(0)
+	if err := c.CreateTopic("OUTBOX", 1); err != nil {
+		t.Fatal(err)
+	}
+	relay := &moutbox.Relay{DB: db, Producer: c.NewSyncProducer(nil)}
+
+	// 第二条消息发布失败，其余消息继续发布
+	expectClaim(mock, 100, sqlmock.NewRows(outboxColumns).
+		AddRow(1, "k1", "OUTBOX", nil, []byte("v1"), "{}").
+		AddRow(2, "k2", "MISSING", nil, []byte("v2"), "{}").
+		AddRow(3, "k3", "OUTBOX", nil, []byte("v3"), "{}"), nil, 1, 2, 3)
+	expectPublished(mock, 1, 3)
+	expectFailed(mock, 10, 2)
+
+	n, err := relay.RelayOnce(context.Background())
+	if err == nil {
+		t.Fatal("expected send error")
+	}
+	if n != 2 {
+		t.Errorf("published = %d", n)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+	if msgs := c.Messages("OUTBOX", 0); len(msgs) != 2 {
+		t.Errorf("messages = %d", len(msgs))
+	}
+}
+
+func TestRelayOnceKeepsKeyOrder(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	c := kafkatest.NewCluster()
+	relay := &moutbox.Relay{DB: db, Producer: c.NewSyncProducer(nil)}
+
+	// key为a的第一条消息发布失败，同一个key的后续消息放回待发布，其他key的消息正常发布
+	expectClaim(mock, 100, sqlmock.NewRows(outboxColumns).
+		AddRow(1, "k1", "OUTBOX", []byte("a"), []byte("v1"), "not json").
+		AddRow(2, "k2", "OUTBOX", []byte("b"), []byte("v2"), "{}").
+		AddRow(3, "k3", "OUTBOX", []byte("a"), []byte("v3"), "{}"), sqlmock.NewRows(blockedColumns), 1, 2, 3)
+	expectPublished(mock, 2)
+	expectFailed(mock, 10, 1)
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE outbox_event SET status = ? WHERE id IN (?)")).
+		WithArgs(moutbox.StatusPending, 3).WillReturnResult(sqlmock.NewResult(0, 1))
+
+	if n, err := relay.RelayOnce(context.Background()); err == nil || n != 1 {
+		t.Errorf("published = %d, err = %v", n, err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+	if msgs := c.Messages("OUTBOX", 0); len(msgs) != 1 || string(msgs[0].Value) != "v2" {
+		t.Errorf("messages = %v", msgs)
+	}
+}
+
+func TestRelayOnceSkipsBlockedKeys(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	c := kafkatest.NewCluster()
+	relay := &moutbox.Relay{DB: db, Producer: c.NewSyncProducer(nil)}
+
+	// key为a的事件3正在被其他Relay发布，事件5不取出；key为b和没有key的事件正常发布
+	expectClaim(mock, 100, sqlmock.NewRows(outboxColumns).
+		AddRow(5, "k5", "OUTBOX", []byte("a"), []byte("v5"), "{}").
+		AddRow(6, "k6", "OUTBOX", []byte("b"), []byte("v6"), "{}").
+		AddRow(7, "k7", "OUTBOX", nil, []byte("v7"), "{}"),
+		sqlmock.NewRows(blockedColumns).AddRow("OUTBOX", []byte("a"), 3), 6, 7)
+	expectPublished(mock, 6, 7)
+
+	if n, err := relay.RelayOnce(context.Background()); err != nil || n != 2 {
+		t.Errorf("published = %d, err = %v", n, err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+	if msgs := c.Messages("OUTBOX", 0); len(msgs) != 2 || string(msgs[0].Value) != "v6" {
+		t.Errorf("messages = %v", msgs)
+	}
+}
+
+func TestRelayOncePermanentFailure(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	c := kafkatest.NewCluster()
+	c.SetAutoCreateTopics(0)
+	if err := c.CreateTopic("OUTBOX", 1); err != nil {
+		t.Fatal(err)
+	}
+	relay := &moutbox.Relay{DB: db, Producer: c.NewSyncProducer(nil), MaxAttempts: 2}
+
+	// 发往不存在的topic的事件每次都失败，第2次失败后由SQL标记为StatusFailed，之后不再被取出；
+	// 期间写入的事件不受影响
+	expectClaim(mock, 100, sqlmock.NewRows(outboxColumns).
+		AddRow(1, "k1", "MISSING", []byte("a"), []byte("v1"), "{}").
+		AddRow(2, "k2", "OUTBOX", nil, []byte("v2"), "{}"), sqlmock.NewRows(blockedColumns), 1, 2)
+	expectPublished(mock, 2)
+	expectFailed(mock, 2, 1)
+
+	expectClaim(mock, 100, sqlmock.NewRows(outboxColumns).
+		AddRow(1, "k1", "MISSING", []byte("a"), []byte("v1"), "{}").
+		AddRow(3, "k3", "OUTBOX", nil, []byte("v3"), "{}"), sqlmock.NewRows(blockedColumns), 1, 3)
+	expectPublished(mock, 3)
+	expectFailed(mock, 2, 1)
+
+	expectClaim(mock, 100, sqlmock.NewRows(outboxColumns).
+		AddRow(4, "k4", "OUTBOX", []byte("a"), []byte("v4"), "{}"), sqlmock.NewRows(blockedColumns), 4)
+	expectPublished(mock, 4)
+
+	ctx := context.Background()
+	for i, want := range []int{1, 1, 1} {
+		n, err := relay.RelayOnce(ctx)
+		if n != want {
+			t.Errorf("round %d published = %d, err = %v", i, n, err)
+		}
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+	if msgs := c.Messages("OUTBOX", 0); len(msgs) != 3 || string(msgs[2].Value) != "v4" {
+		t.Errorf("messages = %v", msgs)
+	}
+}
+
+func TestRelayRun(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	c := kafkatest.NewCluster()
+	relay := &moutbox.Relay{DB: db, Producer: c.NewSyncProducer(nil)}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	mock.ExpectBegin()
+	mock.ExpectQuery(selectPending).WillReturnRows(sqlmock.NewRows(outboxColumns))
+	mock.ExpectRollback()
+
+	done := make(chan error)
+	go func() { done <- relay.Run(ctx) }()
+	for mock.ExpectationsWereMet() != nil {
+		select {
+		case err := <-done:
+			t.Fatal(err)
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	cancel()
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Error(err)
+	}
+}
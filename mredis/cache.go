@@ -0,0 +1,255 @@
+package mredis
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	mrand "math/rand"
+	"time"
+
+	"github.com/go-redis/redis/v9"
+	"github.com/vmihailenco/msgpack/v4"
+	"golang.org/x/sync/singleflight"
+)
+
+// 类型化的缓存：值经Codec编码后保存在prefix+key，GetOrLoad在未命中时调用loader并合并并发的加载
+
+// Codec 缓存值的编解码
+type Codec interface {
+	Marshal(v any) ([]byte, error)
+	Unmarshal(data []byte, v any) error
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(v any) ([]byte, error)      { return json.Marshal(v) }
+func (jsonCodec) Unmarshal(data []byte, v any) error { return json.Unmarshal(data, v) }
+
+type msgpackCodec struct{}
+
+func (msgpackCodec) Marshal(v any) ([]byte, error)      { return msgpack.Marshal(v) }
+func (msgpackCodec) Unmarshal(data []byte, v any) error { return msgpack.Unmarshal(data, v) }
+
+type gobCodec struct{}
+
+func (gobCodec) Marshal(v any) ([]byte, error) {
+	buf := bytes.Buffer{}
+	if err := gob.NewEncoder(&buf).Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (gobCodec) Unmarshal(data []byte, v any) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
+
+// 内置的Codec
+var (
+	JSONCodec    Codec = jsonCodec{}
+	MsgpackCodec Codec = msgpackCodec{}
+	GobCodec     Codec = gobCodec{}
+)
+
+// CacheOptions 缓存配置
+type CacheOptions struct {
+	// Prefix 所有key的前缀，用于区分不同的缓存，如"user:"
+	Prefix string
+	// TTL 默认过期时间，为0时不过期
+	TTL time.Duration
+	// Jitter 过期时间随机增加[0, Jitter*TTL)，避免同时写入的缓存同时过期，如0.1
+	Jitter float64
+	// Codec 默认为JSONCodec
+	Codec Codec
+}
+
+// Cache 保存类型为T的值的缓存
+type Cache[T any] struct {
+	rds  redis.UniversalClient
+	opts CacheOptions
+	sf   singleflight.Group
+}
+
+// NewCache 创建缓存
+func NewCache[T any](rds redis.UniversalClient, opts CacheOptions) *Cache[T] {
+	if opts.Codec == nil {
+		opts.Codec = JSONCodec
+	}
+	return &Cache[T]{rds: rds, opts: opts}
+}
+
+// Key 加上前缀后的redis key
+func (c *Cache[T]) Key(key string) string {
+	return c.opts.Prefix + key
+}
+
+// ttl 默认过期时间加上随机抖动
+func (c *Cache[T]) ttl(ttl time.Duration) time.Duration {
+	if ttl <= 0 || c.opts.Jitter <= 0 {
+		return ttl
+	}
+	return ttl + time.Duration(mrand.Float64()*c.opts.Jitter*float64(ttl))
+}
+
+func (c *Cache[T]) decode(data []byte) (T, error) {
+	var v T
+	err := c.opts.Codec.Unmarshal(data, &v)
+	return v, err
+}
+
+// Get 获取值，不存在时返回ErrNotFound
+func (c *Cache[T]) Get(ctx context.Context, key string) (T, error) {
+	data, err := c.rds.Get(ctx, c.Key(key)).Bytes()
+	if err != nil {
+		var zero T
+		return zero, notFound(err)
+	}
+	return c.decode(data)
+}
+
+// Set 使用默认过期时间保存值
+func (c *Cache[T]) Set(ctx context.Context, key string, v T) error {
+	return c.SetTTL(ctx, key, v, c.opts.TTL)
+}
+
+// SetTTL 使用指定的过期时间保存值，ttl同样会加上抖动
+func (c *Cache[T]) SetTTL(ctx context.Context, key string, v T, ttl time.Duration) error {
+	data, err := c.opts.Codec.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return c.rds.Set(ctx, c.Key(key), data, c.ttl(ttl)).Err()
+}
+
+// Delete 删除值
+func (c *Cache[T]) Delete(ctx context.Context, keys ...string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	// 集群模式下不同slot的key不能在一条DEL中删除，逐个删除
+	_, err := c.rds.Pipelined(ctx, func(p redis.Pipeliner) error {
+		for _, k := range keys {
+			p.Del(ctx, c.Key(k))
+		}
+		return nil
+	})
+	return err
+}
+
+// GetMany 批量获取值，结果中只包含存在的key
+func (c *Cache[T]) GetMany(ctx context.Context, keys []string) (map[string]T, error) {
+	r := make(map[string]T, len(keys))
+	if len(keys) == 0 {
+		return r, nil
+	}
+	cmds := make([]*redis.StringCmd, len(keys))
+	_, err := c.rds.Pipelined(ctx, func(p redis.Pipeliner) error {
+		for i, k := range keys {
+			cmds[i] = p.Get(ctx, c.Key(k))
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return nil, err
+	}
+	for i, cmd := range cmds {
+		data, err := cmd.Bytes()
+		if errors.Is(err, redis.Nil) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		v, err := c.decode(data)
+		if err != nil {
+			return nil, err
+		}
+		r[keys[i]] = v
+	}
+	return r, nil
+}
+
+// SetMany 使用默认过期时间批量保存值，每个key的抖动独立计算
+func (c *Cache[T]) SetMany(ctx context.Context, items map[string]T) error {
+	if len(items) == 0 {
+		return nil
+	}
+	data := make(map[string][]byte, len(items))
+	for k, v := range items {
+		b, err := c.opts.Codec.Marshal(v)
+		if err != nil {
+			return err
+		}
+		data[k] = b
+	}
+	_, err := c.rds.Pipelined(ctx, func(p redis.Pipeliner) error {
+		for k, b := range data {
+			p.Set(ctx, c.Key(k), b, c.ttl(c.opts.TTL))
+		}
+		return nil
+	})
+	return err
+}
+
+// lookup 读取缓存，解码失败（如类型或Codec变化后的旧值）视为未命中；cache表示加载后是否写入缓存
+func (c *Cache[T]) lookup(ctx context.Context, key string) (v T, hit bool, cache bool) {
+	data, err := c.rds.Get(ctx, c.Key(key)).Bytes()
+	if err != nil {
+		return v, false, errors.Is(err, redis.Nil)
+	}
+	v, err = c.decode(data)
+	return v, err == nil, true
+}
+
+// detachedContext 保留父ctx中的值，但不会随父ctx取消或超时
+type detachedContext struct {
+	parent context.Context
+}
+
+func (detachedContext) Deadline() (time.Time, bool) { return time.Time{}, false }
+func (detachedContext) Done() <-chan struct{}       { return nil }
+func (detachedContext) Err() error                  { return nil }
+func (c detachedContext) Value(key any) any         { return c.parent.Value(key) }
+
+// GetOrLoad 获取值，不存在时调用loader加载并保存；同一个key并发的加载只调用一次loader
+//
+// 读取缓存出错时直接调用loader且不写入缓存，缓存中的值无法解码时视为未命中并覆盖；写入缓存失败时仍返回加载的值。
+// 并发的调用共享同一次加载，loader收到的ctx保留调用方ctx中的值但不会被取消；
+// 每个调用方的ctx结束时各自立即返回ctx.Err()，加载在后台继续并写入缓存
+func (c *Cache[T]) GetOrLoad(ctx context.Context, key string, loader func(ctx context.Context) (T, error)) (T, error) {
+	v, hit, cache := c.lookup(ctx, key)
+	if hit {
+		return v, nil
+	}
+
+	ch := c.sf.DoChan(key, func() (any, error) {
+		ctx := detachedContext{parent: ctx}
+		// 等待期间可能已被其他实例写入
+		if cache {
+			if v, hit, _ := c.lookup(ctx, key); hit {
+				return v, nil
+			}
+		}
+		v, err := loader(ctx)
+		if err != nil {
+			return v, err
+		}
+		if cache {
+			_ = c.Set(ctx, key, v)
+		}
+		return v, nil
+	})
+
+	var zero T
+	select {
+	case r := <-ch:
+		if r.Err != nil {
+			return zero, r.Err
+		}
+		return r.Val.(T), nil
+	case <-ctx.Done():
+		return zero, ctx.Err()
+	}
+}
@@ -0,0 +1,69 @@
+package mredis
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-redis/redis/v9"
+)
+
+// 基于Redis的消息去重记录，满足mkafka.DedupStore
+
+const (
+	dedupProcessing = "processing"
+	dedupDone       = "done"
+)
+
+// dedupBeginScript 返回0表示占用成功，1表示处理中，2表示已完成
+var dedupBeginScript = redis.NewScript(`
+local v = redis.call('GET', KEYS[1])
+if not v then
+	redis.call('SET', KEYS[1], ARGV[1], 'PX', ARGV[2])
+	return 0
+end
+if v == ARGV[3] then
+	return 2
+end
+return 1
+`)
+
+// dedupAbortScript 只删除处理中的记录，避免误删已完成的记录
+var dedupAbortScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('DEL', KEYS[1])
+end
+return 0
+`)
+
+// DedupStore 以prefix+key为键记录消息的处理状态
+type DedupStore struct {
+	rds    redis.UniversalClient
+	prefix string
+}
+
+// NewDedupStore 创建去重记录，prefix为空时使用"dedup:"
+func NewDedupStore(rds redis.UniversalClient, prefix string) *DedupStore {
+	if prefix == "" {
+		prefix = "dedup:"
+	}
+	return &DedupStore{rds: rds, prefix: prefix}
+}
+
+// Begin 原子地占用key，见mkafka.DedupStore
+func (s *DedupStore) Begin(ctx context.Context, key string, lease time.Duration) (bool, bool, error) {
+	r, err := dedupBeginScript.Run(ctx, s.rds, []string{s.prefix + key}, dedupProcessing, lease.Milliseconds(), dedupDone).Int()
+	if err != nil {
+		return false, false, err
+	}
+	return r == 0, r == 2, nil
+}
+
+// Commit 标记key已处理完成，保留ttl
+func (s *DedupStore) Commit(ctx context.Context, key string, ttl time.Duration) error {
+	return s.rds.Set(ctx, s.prefix+key, dedupDone, ttl).Err()
+}
+
+// Abort 释放处理中的key
+func (s *DedupStore) Abort(ctx context.Context, key string) error {
+	return dedupAbortScript.Run(ctx, s.rds, []string{s.prefix + key}, dedupProcessing).Err()
+}
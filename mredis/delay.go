@@ -0,0 +1,232 @@
+package mredis
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v9"
+)
+
+// 基于有序集合的延迟任务：任务ID以执行时间（毫秒时间戳）为score保存在zset中，载荷保存在hash中；
+// 到期的任务由Lua脚本原子地取出（ZRANGEBYSCORE + ZREM），每个任务只会被一个工作者取出
+//
+// 取出即删除，工作者在处理完成前崩溃时任务会丢失；需要至少一次的语义时在handler中将任务转入Queue
+
+const (
+	defaultDelayPollInterval = time.Second
+	defaultDelayBatch        = 100
+)
+
+// delayClaimScript 取出score不大于ARGV[1]的最多ARGV[2]个任务，返回{id, payload, score, ...}
+var delayClaimScript = redis.NewScript(`
+local ids = redis.call('ZRANGEBYSCORE', KEYS[1], '-inf', ARGV[1], 'WITHSCORES', 'LIMIT', 0, ARGV[2])
+local r = {}
+for i = 1, #ids, 2 do
+	local id = ids[i]
+	redis.call('ZREM', KEYS[1], id)
+	local v = redis.call('HGET', KEYS[2], id)
+	redis.call('HDEL', KEYS[2], id)
+	table.insert(r, id)
+	table.insert(r, v or '')
+	table.insert(r, ids[i + 1])
+end
+return r
+`)
+
+// DelayedJob 延迟任务
+type DelayedJob struct {
+	ID      string
+	Payload []byte
+	// RunAt 计划的执行时间
+	RunAt time.Time
+}
+
+// DelayHandler 处理一个到期的任务
+type DelayHandler func(ctx context.Context, job *DelayedJob) error
+
+// DelayQueueOptions 延迟队列配置
+type DelayQueueOptions struct {
+	// PollInterval Run在没有到期任务时的最长等待时间，默认1秒
+	PollInterval time.Duration
+	// Batch 每次最多取出的任务数，默认100
+	Batch int
+	// RetryDelay 处理失败的任务在该时间之后重新调度，为0时不重试
+	RetryDelay time.Duration
+}
+
+// DelayQueue 延迟队列
+type DelayQueue struct {
+	rds    redis.UniversalClient
+	prefix string
+	opts   DelayQueueOptions
+}
+
+// NewDelayQueue 创建延迟队列，key使用"delay:{name}:"前缀，集群模式下位于同一个slot
+func NewDelayQueue(rds redis.UniversalClient, name string, opts DelayQueueOptions) *DelayQueue {
+	if opts.PollInterval <= 0 {
+		opts.PollInterval = defaultDelayPollInterval
+	}
+	if opts.Batch <= 0 {
+		opts.Batch = defaultDelayBatch
+	}
+	return &DelayQueue{rds: rds, prefix: "delay:{" + name + "}:", opts: opts}
+}
+
+// Key 保存调度时间的zset，score为毫秒时间戳，可以使用ZCountContext、ZRangeContext等查询
+func (d *DelayQueue) Key() string {
+	return d.prefix + "schedule"
+}
+
+func (d *DelayQueue) jobsKey() string {
+	return d.prefix + "jobs"
+}
+
+func millis(t time.Time) float64 {
+	return float64(t.UnixMilli())
+}
+
+// Schedule 在runAt执行payload，返回生成的任务ID
+func (d *DelayQueue) Schedule(ctx context.Context, payload []byte, runAt time.Time) (string, error) {
+	id, err := newLockToken()
+	if err != nil {
+		return "", err
+	}
+	return id, d.ScheduleID(ctx, id, payload, runAt)
+}
+
+// ScheduleID 以指定的ID调度任务，ID已存在时覆盖原任务的载荷和执行时间
+func (d *DelayQueue) ScheduleID(ctx context.Context, id string, payload []byte, runAt time.Time) error {
+	_, err := d.rds.TxPipelined(ctx, func(p redis.Pipeliner) error {
+		p.HSet(ctx, d.jobsKey(), id, payload)
+		p.ZAdd(ctx, d.Key(), redis.Z{Score: millis(runAt), Member: id})
+		return nil
+	})
+	return err
+}
+
+// Reschedule 修改任务的执行时间，任务不存在（已被取出或取消）时返回ErrNotFound
+func (d *DelayQueue) Reschedule(ctx context.Context, id string, runAt time.Time) error {
+	n, err := d.rds.ZAddArgs(ctx, d.Key(), redis.ZAddArgs{
+		XX:      true,
+		Ch:      true,
+		Members: []redis.Z{{Score: millis(runAt), Member: id}},
+	}).Result()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		// 执行时间未变化时CH也返回0
+		if _, err := d.rds.ZScore(ctx, d.Key(), id).Result(); err != nil {
+			return notFound(err)
+		}
+	}
+	return nil
+}
+
+// Cancel 取消任务，任务不存在时返回ErrNotFound
+func (d *DelayQueue) Cancel(ctx context.Context, id string) error {
+	cmds, err := d.rds.TxPipelined(ctx, func(p redis.Pipeliner) error {
+		p.ZRem(ctx, d.Key(), id)
+		p.HDel(ctx, d.jobsKey(), id)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if cmds[0].(*redis.IntCmd).Val() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// RunAt 任务的执行时间，任务不存在时返回ErrNotFound
+func (d *DelayQueue) RunAt(ctx context.Context, id string) (time.Time, error) {
+	score, err := d.rds.ZScore(ctx, d.Key(), id).Result()
+	if err != nil {
+		return time.Time{}, notFound(err)
+	}
+	return time.UnixMilli(int64(score)), nil
+}
+
+// Len 调度中的任务数
+func (d *DelayQueue) Len(ctx context.Context) (int, error) {
+	return ZCardContext(ctx, d.rds, d.Key())
+}
+
+// Scheduled 执行时间在[from, to]范围内的任务ID，按执行时间排序
+func (d *DelayQueue) Scheduled(ctx context.Context, from, to time.Time) ([]string, error) {
+	return ZRangeContext(ctx, d.rds, d.Key(), millis(from), millis(to))
+}
+
+// Claim 取出最多Batch个在now之前到期的任务，按执行时间排序
+func (d *DelayQueue) Claim(ctx context.Context, now time.Time) ([]*DelayedJob, error) {
+	vals, err := delayClaimScript.Run(ctx, d.rds, []string{d.Key(), d.jobsKey()}, millis(now), d.opts.Batch).StringSlice()
+	if err != nil {
+		return nil, err
+	}
+	jobs := make([]*DelayedJob, 0, len(vals)/3)
+	for i := 0; i+2 < len(vals); i += 3 {
+		score, _ := strconv.ParseFloat(vals[i+2], 64)
+		jobs = append(jobs, &DelayedJob{ID: vals[i], Payload: []byte(vals[i+1]), RunAt: time.UnixMilli(int64(score))})
+	}
+	return jobs, nil
+}
+
+// next 距离下一个任务到期的时间，最长为PollInterval
+func (d *DelayQueue) next(ctx context.Context) time.Duration {
+	zs, err := d.rds.ZRangeWithScores(ctx, d.Key(), 0, 0).Result()
+	if err != nil || len(zs) == 0 {
+		return d.opts.PollInterval
+	}
+	wait := time.Until(time.UnixMilli(int64(zs[0].Score)))
+	if wait > d.opts.PollInterval {
+		return d.opts.PollInterval
+	}
+	return wait
+}
+
+// putBack 按RunAt重新调度任务，ctx结束后同样需要完成，使用独立的ctx
+func (d *DelayQueue) putBack(jobs []*DelayedJob) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	for _, job := range jobs {
+		if err := d.ScheduleID(ctx, job.ID, job.Payload, job.RunAt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Run 循环取出到期的任务并调用h，直到ctx结束或Redis出错；多个实例同时运行时每个任务只被处理一次
+//
+// h返回错误且RetryDelay大于0时，任务在RetryDelay之后以相同的ID重新调度；ctx结束时已取出但未处理的任务按原时间放回
+func (d *DelayQueue) Run(ctx context.Context, h DelayHandler) error {
+	for ctx.Err() == nil {
+		jobs, err := d.Claim(ctx, time.Now())
+		if err != nil {
+			return stopErr(ctx, err)
+		}
+		for i, job := range jobs {
+			if ctx.Err() != nil {
+				return d.putBack(jobs[i:])
+			}
+			if err := h(ctx, job); err != nil && d.opts.RetryDelay > 0 {
+				if err := d.putBack([]*DelayedJob{{ID: job.ID, Payload: job.Payload, RunAt: time.Now().Add(d.opts.RetryDelay)}}); err != nil {
+					return stopErr(ctx, err)
+				}
+			}
+		}
+		if len(jobs) == d.opts.Batch {
+			continue
+		}
+
+		t := time.NewTimer(d.next(ctx))
+		select {
+		case <-ctx.Done():
+			t.Stop()
+		case <-t.C:
+		}
+	}
+	return nil
+}
@@ -2,9 +2,20 @@ module github.com/mouseleee/mlib/mredis
 
 go 1.19
 
-require github.com/go-redis/redis/v9 v9.0.0-rc.1
+require (
+	github.com/alicebob/miniredis/v2 v2.30.0
+	github.com/go-redis/redis/v9 v9.0.0-rc.1
+	github.com/vmihailenco/msgpack/v4 v4.3.13
+	golang.org/x/sync v0.0.0-20220923202941-7f9b1623fab7
+)
 
 require (
+	github.com/alicebob/gopher-json v0.0.0-20200520072559-a9ecdc9d1d3a // indirect
 	github.com/cespare/xxhash/v2 v2.1.2 // indirect
 	github.com/dgryski/go-rendezvous v0.0.0-20200823014737-9f7001d12a5f // indirect
+	github.com/golang/protobuf v1.3.4 // indirect
+	github.com/vmihailenco/tagparser v0.1.1 // indirect
+	github.com/yuin/gopher-lua v0.0.0-20220504180219-658193537a64 // indirect
+	golang.org/x/net v0.0.0-20220722155237-a158d28d115b // indirect
+	google.golang.org/appengine v1.6.5 // indirect
 )
@@ -0,0 +1,277 @@
+package mredis
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	mrand "math/rand"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v9"
+)
+
+// 基于单个Redis节点的分布式锁：值为持有者的随机token，释放和续期时比较token，只有持有者可以操作
+
+const (
+	defaultLockTTL        = 30 * time.Second
+	defaultLockMinBackoff = 50 * time.Millisecond
+	defaultLockMaxBackoff = time.Second
+)
+
+var (
+	// ErrLockNotAcquired 在ctx结束前没有获取到锁
+	ErrLockNotAcquired = errors.New("[mouse] -> redis 获取锁失败")
+	// ErrLockNotHeld 锁已过期或被其他持有者占用
+	ErrLockNotHeld = errors.New("[mouse] -> redis 未持有锁")
+)
+
+// lockReleaseScript token相同时删除
+var lockReleaseScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('DEL', KEYS[1])
+end
+return 0
+`)
+
+// lockExtendScript token相同时重新设置过期时间
+var lockExtendScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('PEXPIRE', KEYS[1], ARGV[2])
+end
+return 0
+`)
+
+// LockOptions 锁的过期时间和重试策略
+type LockOptions struct {
+	// TTL 锁的过期时间，默认30秒
+	TTL time.Duration
+	// MinBackoff、MaxBackoff 阻塞获取时的重试间隔，从MinBackoff开始指数增长到MaxBackoff，默认50毫秒和1秒
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+	// Watchdog 为true时持有期间每TTL/3自动续期，直到Unlock
+	Watchdog bool
+}
+
+func (o LockOptions) withDefaults() LockOptions {
+	if o.TTL <= 0 {
+		o.TTL = defaultLockTTL
+	}
+	if o.MinBackoff <= 0 {
+		o.MinBackoff = defaultLockMinBackoff
+	}
+	if o.MaxBackoff < o.MinBackoff {
+		o.MaxBackoff = defaultLockMaxBackoff
+		if o.MaxBackoff < o.MinBackoff {
+			o.MaxBackoff = o.MinBackoff
+		}
+	}
+	return o
+}
+
+// backoff 第n次重试前的等待时间，带随机抖动
+func (o LockOptions) backoff(n int) time.Duration {
+	d := o.MinBackoff
+	for i := 0; i < n && d < o.MaxBackoff; i++ {
+		d *= 2
+	}
+	if d > o.MaxBackoff {
+		d = o.MaxBackoff
+	}
+	return d/2 + time.Duration(mrand.Int63n(int64(d/2)+1))
+}
+
+// newLockToken 生成持有者token
+func newLockToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// Locker 分布式锁，单节点的Mutex和多节点的Redlock都满足，调用方可以替换实现
+type Locker interface {
+	// TryLock 尝试获取一次锁，锁被占用时返回false
+	TryLock(ctx context.Context) (bool, error)
+	// Lock 阻塞获取锁，ctx结束时返回ErrLockNotAcquired
+	Lock(ctx context.Context) error
+	// Unlock 释放锁，未持有时返回ErrLockNotHeld
+	Unlock(ctx context.Context) error
+	// Extend 续期，未持有时返回ErrLockNotHeld
+	Extend(ctx context.Context, ttl time.Duration) error
+	// Lost 自动续期失败时关闭
+	Lost() <-chan struct{}
+}
+
+// Mutex 单节点分布式锁，同一个Mutex不能被并发地多次获取
+type Mutex struct {
+	rds  redis.UniversalClient
+	key  string
+	opts LockOptions
+
+	mu    sync.Mutex
+	token string
+	wd    *watchdog
+	lost  chan struct{}
+}
+
+// NewMutex 创建以key为锁名的分布式锁
+func NewMutex(rds redis.UniversalClient, key string, opts LockOptions) *Mutex {
+	return &Mutex{rds: rds, key: key, opts: opts.withDefaults()}
+}
+
+// TryLock 尝试获取一次锁，锁被占用时返回false
+func (m *Mutex) TryLock(ctx context.Context) (bool, error) {
+	token, err := newLockToken()
+	if err != nil {
+		return false, err
+	}
+	ok, err := m.rds.SetNX(ctx, m.key, token, m.opts.TTL).Result()
+	if err != nil || !ok {
+		return false, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.token = token
+	m.lost = make(chan struct{})
+	if m.opts.Watchdog {
+		m.wd = startWatchdog(m.opts.TTL, m.lost, func(ctx context.Context) error {
+			return m.extend(ctx, token, m.opts.TTL)
+		})
+	}
+	return true, nil
+}
+
+// Lock 阻塞获取锁，锁被占用时按退避间隔重试，ctx结束时返回ErrLockNotAcquired
+func (m *Mutex) Lock(ctx context.Context) error {
+	return lockWithBackoff(ctx, m.opts, m.TryLock)
+}
+
+// lockWithBackoff 重复调用tryLock直到成功或ctx结束
+func lockWithBackoff(ctx context.Context, opts LockOptions, tryLock func(context.Context) (bool, error)) error {
+	for n := 0; ; n++ {
+		ok, err := tryLock(ctx)
+		if err != nil && ctx.Err() == nil {
+			return err
+		}
+		if ok {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%w: %v", ErrLockNotAcquired, ctx.Err())
+		case <-time.After(opts.backoff(n)):
+		}
+	}
+}
+
+// Unlock 释放锁，锁已过期或不再由当前持有者持有时返回ErrLockNotHeld
+func (m *Mutex) Unlock(ctx context.Context) error {
+	m.mu.Lock()
+	token := m.token
+	m.token = ""
+	wd := m.wd
+	m.wd = nil
+	m.mu.Unlock()
+
+	wd.Stop()
+	if token == "" {
+		return ErrLockNotHeld
+	}
+	n, err := lockReleaseScript.Run(ctx, m.rds, []string{m.key}, token).Int()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrLockNotHeld
+	}
+	return nil
+}
+
+// Extend 将锁的过期时间重新设置为ttl，ttl为0时使用LockOptions.TTL
+func (m *Mutex) Extend(ctx context.Context, ttl time.Duration) error {
+	m.mu.Lock()
+	token := m.token
+	m.mu.Unlock()
+	if token == "" {
+		return ErrLockNotHeld
+	}
+	if ttl <= 0 {
+		ttl = m.opts.TTL
+	}
+	return m.extend(ctx, token, ttl)
+}
+
+func (m *Mutex) extend(ctx context.Context, token string, ttl time.Duration) error {
+	n, err := lockExtendScript.Run(ctx, m.rds, []string{m.key}, token, ttl.Milliseconds()).Int()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrLockNotHeld
+	}
+	return nil
+}
+
+// Token 当前持有者的token，未持有时为空
+func (m *Mutex) Token() string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.token
+}
+
+// Lost 自动续期失败（锁已被其他持有者占用，或超过TTL没有续期成功）时关闭，未持有锁时返回nil
+func (m *Mutex) Lost() <-chan struct{} {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.lost
+}
+
+// watchdog 持有期间每TTL/3续期一次，网络错误时继续重试，锁被其他持有者占用或超过TTL没有续期成功时关闭lost
+type watchdog struct {
+	stop chan struct{}
+	done chan struct{}
+}
+
+func startWatchdog(ttl time.Duration, lost chan struct{}, extend func(context.Context) error) *watchdog {
+	w := &watchdog{stop: make(chan struct{}), done: make(chan struct{})}
+	go func() {
+		defer close(w.done)
+		t := time.NewTicker(ttl / 3)
+		defer t.Stop()
+
+		last := time.Now()
+		for {
+			select {
+			case <-w.stop:
+				return
+			case <-t.C:
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), ttl/3)
+			err := extend(ctx)
+			cancel()
+			if err == nil {
+				last = time.Now()
+				continue
+			}
+			if errors.Is(err, ErrLockNotHeld) || time.Since(last) > ttl {
+				close(lost)
+				return
+			}
+		}
+	}()
+	return w
+}
+
+// Stop 停止续期并等待续期中的请求结束，w为nil时不做任何事
+func (w *watchdog) Stop() {
+	if w == nil {
+		return
+	}
+	close(w.stop)
+	<-w.done
+}
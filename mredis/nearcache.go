@@ -0,0 +1,435 @@
+package mredis
+
+import (
+	"container/list"
+	"context"
+	"encoding/json"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-redis/redis/v9"
+)
+
+// 两级缓存：进程内LRU在Redis缓存之前，写入和删除时通过pub/sub通知其他实例删除本地副本；
+// 订阅断开重连期间可能丢失通知，重连后清空本地缓存，本地TTL限制了丢失通知时读到旧值的时间
+
+const (
+	defaultNearCacheSize = 10000
+	defaultNearCacheTTL  = 10 * time.Second
+)
+
+// NearCacheOptions 本地缓存配置
+type NearCacheOptions struct {
+	// Size 本地最多保存的key数，默认10000
+	Size int
+	// TTL 本地副本的过期时间，默认10秒
+	TTL time.Duration
+	// Channel 失效通知的频道，默认为"mredis:invalidate:"+CacheOptions.Prefix
+	Channel string
+}
+
+// NearCacheStats 本地缓存统计
+type NearCacheStats struct {
+	Hits      int64
+	Misses    int64
+	Evictions int64
+	// Invalidations 收到其他实例通知的失效key数
+	Invalidations int64
+	Size          int
+}
+
+// keyWrite 一个key的写入锁，refs为持有和等待的写入数
+type keyWrite struct {
+	mu   sync.Mutex
+	refs int
+}
+
+type nearEntry[T any] struct {
+	key     string
+	value   T
+	expires time.Time
+}
+
+// invalidation 失效通知，Source为发送实例的ID，发送实例忽略自己的通知
+type invalidation struct {
+	Source string   `json:"source"`
+	Keys   []string `json:"keys"`
+}
+
+// NearCache 带进程内缓存的Cache
+type NearCache[T any] struct {
+	*Cache[T]
+	opts NearCacheOptions
+	id   string
+	ps   *redis.PubSub
+	done chan struct{}
+
+	mu    sync.Mutex
+	ll    *list.List
+	items map[string]*list.Element
+	// seq 每次失效时递增；invalidated记录每个key最近一次失效时的seq，purged为最近一次清空时的seq，
+	// 从Redis读取期间该key发生过失效时不保存读到的值，其他key的失效不影响
+	seq         uint64
+	invalidated map[string]uint64
+	purged      uint64
+
+	// writing 正在写入的key，同一个key的写入依次进行，使本地副本与Redis的写入顺序一致
+	writing map[string]*keyWrite
+
+	hits, misses, evictions, invalidations int64
+}
+
+// NewNearCache 创建两级缓存并订阅失效通知，订阅成功后返回，使用完毕后需要Close
+func NewNearCache[T any](ctx context.Context, rds redis.UniversalClient, cacheOpts CacheOptions, opts NearCacheOptions) (*NearCache[T], error) {
+	if opts.Size <= 0 {
+		opts.Size = defaultNearCacheSize
+	}
+	if opts.TTL <= 0 {
+		opts.TTL = defaultNearCacheTTL
+	}
+	if opts.Channel == "" {
+		opts.Channel = "mredis:invalidate:" + cacheOpts.Prefix
+	}
+	id, err := newLockToken()
+	if err != nil {
+		return nil, err
+	}
+
+	ps := rds.Subscribe(ctx, opts.Channel)
+	if _, err := ps.Receive(ctx); err != nil {
+		ps.Close()
+		return nil, err
+	}
+
+	n := &NearCache[T]{
+		Cache: NewCache[T](rds, cacheOpts),
+		opts:  opts,
+		id:    id,
+		ps:    ps,
+		done:  make(chan struct{}),
+		ll:    list.New(),
+		items: make(map[string]*list.Element),
+
+		invalidated: make(map[string]uint64),
+		writing:     make(map[string]*keyWrite),
+	}
+	go n.listen()
+	return n, nil
+}
+
+// listen 处理失效通知，订阅重连后清空本地缓存
+func (n *NearCache[T]) listen() {
+	defer close(n.done)
+	for m := range n.ps.ChannelWithSubscriptions() {
+		switch m := m.(type) {
+		case *redis.Subscription:
+			if m.Kind == "subscribe" {
+				n.Purge()
+			}
+		case *redis.Message:
+			inv := invalidation{}
+			if err := json.Unmarshal([]byte(m.Payload), &inv); err != nil {
+				n.Purge()
+				continue
+			}
+			if inv.Source != n.id {
+				n.removeLocal(inv.Keys...)
+				atomic.AddInt64(&n.invalidations, int64(len(inv.Keys)))
+			}
+		}
+	}
+}
+
+// Close 取消订阅
+func (n *NearCache[T]) Close() error {
+	err := n.ps.Close()
+	<-n.done
+	return err
+}
+
+// getLocal 读取未过期的本地副本
+func (n *NearCache[T]) getLocal(key string) (T, bool) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if el, ok := n.items[key]; ok {
+		e := el.Value.(*nearEntry[T])
+		if time.Now().Before(e.expires) {
+			n.ll.MoveToFront(el)
+			return e.value, true
+		}
+		n.ll.Remove(el)
+		delete(n.items, key)
+	}
+	var zero T
+	return zero, false
+}
+
+// changedSince 调用方需持有锁，key在seq之后是否失效过
+func (n *NearCache[T]) changedSince(key string, seq uint64) bool {
+	return n.purged > seq || n.invalidated[key] > seq
+}
+
+// putLocal 调用方需持有锁
+func (n *NearCache[T]) putLocal(key string, v T) {
+	expires := time.Now().Add(n.opts.TTL)
+	if el, ok := n.items[key]; ok {
+		e := el.Value.(*nearEntry[T])
+		e.value, e.expires = v, expires
+		n.ll.MoveToFront(el)
+		return
+	}
+	n.items[key] = n.ll.PushFront(&nearEntry[T]{key: key, value: v, expires: expires})
+	for n.ll.Len() > n.opts.Size {
+		el := n.ll.Back()
+		n.ll.Remove(el)
+		delete(n.items, el.Value.(*nearEntry[T]).key)
+		atomic.AddInt64(&n.evictions, 1)
+	}
+}
+
+// setLocal 保存从Redis读到的值，读取开始（seq）之后key失效过时说明读到的可能是旧值，不保存
+func (n *NearCache[T]) setLocal(key string, v T, seq uint64) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.changedSince(key, seq) {
+		return
+	}
+	n.putLocal(key, v)
+}
+
+// commitLocal 写入Redis成功后保存本地副本，seq为写入前removeLocal返回的值；
+// 期间其他实例写入过该key或发生过清空时不保存，否则再次使该key失效，丢弃写入期间开始的读取读到的旧值
+func (n *NearCache[T]) commitLocal(key string, v T, seq uint64) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.changedSince(key, seq) {
+		n.dropLocal(key)
+		return
+	}
+	n.invalidate(key)
+	n.putLocal(key, v)
+}
+
+func (n *NearCache[T]) currentSeq() uint64 {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.seq
+}
+
+// invalidate 调用方需持有锁，记录key的失效，invalidated超过Size个key时整体视为清空以限制内存
+func (n *NearCache[T]) invalidate(key string) {
+	n.seq++
+	if _, ok := n.invalidated[key]; !ok && len(n.invalidated) >= n.opts.Size {
+		n.purged = n.seq
+		n.invalidated = make(map[string]uint64)
+		return
+	}
+	n.invalidated[key] = n.seq
+}
+
+// dropLocal 调用方需持有锁
+func (n *NearCache[T]) dropLocal(key string) {
+	if el, ok := n.items[key]; ok {
+		n.ll.Remove(el)
+		delete(n.items, key)
+	}
+}
+
+// removeLocal 删除本地副本并记录失效，返回失效后的seq
+func (n *NearCache[T]) removeLocal(keys ...string) uint64 {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	for _, k := range keys {
+		n.invalidate(k)
+		n.dropLocal(k)
+	}
+	return n.seq
+}
+
+// Purge 清空本地缓存
+func (n *NearCache[T]) Purge() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.seq++
+	n.purged = n.seq
+	n.invalidated = make(map[string]uint64)
+	n.ll.Init()
+	n.items = make(map[string]*list.Element)
+}
+
+// lockKeys 按顺序获取keys的写入锁，返回释放函数
+func (n *NearCache[T]) lockKeys(keys ...string) func() {
+	sorted := append([]string{}, keys...)
+	sort.Strings(sorted)
+	var (
+		names []string
+		locks []*keyWrite
+	)
+
+	n.mu.Lock()
+	for i, k := range sorted {
+		if i > 0 && k == sorted[i-1] {
+			continue
+		}
+		w, ok := n.writing[k]
+		if !ok {
+			w = &keyWrite{}
+			n.writing[k] = w
+		}
+		w.refs++
+		names = append(names, k)
+		locks = append(locks, w)
+	}
+	n.mu.Unlock()
+
+	for _, w := range locks {
+		w.mu.Lock()
+	}
+	return func() {
+		n.mu.Lock()
+		defer n.mu.Unlock()
+		for i, w := range locks {
+			w.mu.Unlock()
+			if w.refs--; w.refs == 0 {
+				delete(n.writing, names[i])
+			}
+		}
+	}
+}
+
+// publish 通知其他实例删除本地副本
+func (n *NearCache[T]) publish(ctx context.Context, keys ...string) error {
+	b, err := json.Marshal(invalidation{Source: n.id, Keys: keys})
+	if err != nil {
+		return err
+	}
+	return n.rds.Publish(ctx, n.opts.Channel, b).Err()
+}
+
+// Get 依次从本地和Redis获取值，不存在时返回ErrNotFound
+func (n *NearCache[T]) Get(ctx context.Context, key string) (T, error) {
+	if v, ok := n.getLocal(key); ok {
+		atomic.AddInt64(&n.hits, 1)
+		return v, nil
+	}
+	atomic.AddInt64(&n.misses, 1)
+
+	seq := n.currentSeq()
+	v, err := n.Cache.Get(ctx, key)
+	if err == nil {
+		n.setLocal(key, v, seq)
+	}
+	return v, err
+}
+
+// Set 写入Redis和本地，并通知其他实例
+func (n *NearCache[T]) Set(ctx context.Context, key string, v T) error {
+	return n.SetTTL(ctx, key, v, n.Cache.opts.TTL)
+}
+
+// SetTTL 使用指定的Redis过期时间写入
+//
+// 本实例对同一个key的写入依次进行，本地副本按写入Redis的顺序更新；写入期间收到其他实例对该key的失效通知时不保存本地副本
+func (n *NearCache[T]) SetTTL(ctx context.Context, key string, v T, ttl time.Duration) error {
+	unlock := n.lockKeys(key)
+	defer unlock()
+
+	seq := n.removeLocal(key)
+	if err := n.Cache.SetTTL(ctx, key, v, ttl); err != nil {
+		return err
+	}
+	n.commitLocal(key, v, seq)
+	return n.publish(ctx, key)
+}
+
+// SetMany 批量写入Redis和本地，并通知其他实例
+func (n *NearCache[T]) SetMany(ctx context.Context, items map[string]T) error {
+	keys := make([]string, 0, len(items))
+	for k := range items {
+		keys = append(keys, k)
+	}
+	unlock := n.lockKeys(keys...)
+	defer unlock()
+
+	seq := n.removeLocal(keys...)
+	if err := n.Cache.SetMany(ctx, items); err != nil {
+		return err
+	}
+	for k, v := range items {
+		n.commitLocal(k, v, seq)
+	}
+	return n.publish(ctx, keys...)
+}
+
+// Delete 从Redis和本地删除，并通知其他实例
+func (n *NearCache[T]) Delete(ctx context.Context, keys ...string) error {
+	unlock := n.lockKeys(keys...)
+	defer unlock()
+
+	if err := n.Cache.Delete(ctx, keys...); err != nil {
+		return err
+	}
+	n.removeLocal(keys...)
+	return n.publish(ctx, keys...)
+}
+
+// GetMany 批量获取值，本地没有的从Redis获取
+func (n *NearCache[T]) GetMany(ctx context.Context, keys []string) (map[string]T, error) {
+	r := make(map[string]T, len(keys))
+	missing := make([]string, 0, len(keys))
+	for _, k := range keys {
+		if v, ok := n.getLocal(k); ok {
+			r[k] = v
+			continue
+		}
+		missing = append(missing, k)
+	}
+	atomic.AddInt64(&n.hits, int64(len(r)))
+	atomic.AddInt64(&n.misses, int64(len(missing)))
+	if len(missing) == 0 {
+		return r, nil
+	}
+
+	seq := n.currentSeq()
+	remote, err := n.Cache.GetMany(ctx, missing)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range remote {
+		n.setLocal(k, v, seq)
+		r[k] = v
+	}
+	return r, nil
+}
+
+// GetOrLoad 依次从本地和Redis获取值，都不存在时调用loader，见Cache.GetOrLoad
+func (n *NearCache[T]) GetOrLoad(ctx context.Context, key string, loader func(ctx context.Context) (T, error)) (T, error) {
+	if v, ok := n.getLocal(key); ok {
+		atomic.AddInt64(&n.hits, 1)
+		return v, nil
+	}
+	atomic.AddInt64(&n.misses, 1)
+
+	seq := n.currentSeq()
+	v, err := n.Cache.GetOrLoad(ctx, key, loader)
+	if err == nil {
+		n.setLocal(key, v, seq)
+	}
+	return v, err
+}
+
+// Stats 本地缓存的统计
+func (n *NearCache[T]) Stats() NearCacheStats {
+	n.mu.Lock()
+	size := n.ll.Len()
+	n.mu.Unlock()
+	return NearCacheStats{
+		Hits:          atomic.LoadInt64(&n.hits),
+		Misses:        atomic.LoadInt64(&n.misses),
+		Evictions:     atomic.LoadInt64(&n.evictions),
+		Invalidations: atomic.LoadInt64(&n.invalidations),
+		Size:          size,
+	}
+}
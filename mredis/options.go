@@ -0,0 +1,367 @@
+package mredis
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v9"
+)
+
+// 根据配置创建单机、哨兵或集群客户端，配置可以从redis:// URL或环境变量解析
+
+// 客户端模式
+const (
+	ModeStandalone = "standalone"
+	ModeSentinel   = "sentinel"
+	ModeCluster    = "cluster"
+)
+
+// ErrInvalidConfig 配置错误
+var ErrInvalidConfig = errors.New("[mouse] -> redis 配置错误")
+
+// Options 创建Redis客户端的配置，零值字段使用go-redis的默认值
+type Options struct {
+	// Mode 为空时根据配置推断：设置了MasterName时为哨兵，多个地址时为集群，否则为单机
+	Mode string
+	// Addrs 单机的地址、哨兵的地址或集群的种子节点
+	Addrs []string
+	// DB 集群模式不支持非0的DB
+	DB int
+
+	// Username 使用ACL时的用户名
+	Username string
+	Password string
+
+	// MasterName 哨兵监控的主节点名
+	MasterName       string
+	SentinelUsername string
+	SentinelPassword string
+
+	// TLS 为true或TLSConfig不为nil时使用TLS连接；CAFile为空时使用系统根证书，CertFile和KeyFile同时提供时启用双向认证
+	TLS        bool
+	TLSConfig  *tls.Config
+	CAFile     string
+	CertFile   string
+	KeyFile    string
+	SkipVerify bool
+
+	PoolSize     int
+	MinIdleConns int
+	MaxRetries   int
+	DialTimeout  time.Duration
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+	PoolTimeout  time.Duration
+
+	// ReadOnly 集群模式下允许从副本读取；哨兵模式下只连接副本
+	ReadOnly bool
+	// RouteByLatency、RouteRandomly 集群和哨兵模式下将只读命令路由到延迟最低或随机的节点
+	RouteByLatency bool
+	RouteRandomly  bool
+}
+
+// mode 推断客户端模式
+func (o *Options) mode() string {
+	if o.Mode != "" {
+		return strings.ToLower(o.Mode)
+	}
+	switch {
+	case o.MasterName != "":
+		return ModeSentinel
+	case len(o.Addrs) > 1:
+		return ModeCluster
+	default:
+		return ModeStandalone
+	}
+}
+
+// tlsConfig 加载TLS配置，不使用TLS时返回nil
+func (o *Options) tlsConfig() (*tls.Config, error) {
+	if o.TLSConfig != nil {
+		return o.TLSConfig, nil
+	}
+	if !o.TLS && o.CAFile == "" && o.CertFile == "" {
+		return nil, nil
+	}
+
+	conf := &tls.Config{MinVersion: tls.VersionTLS12, InsecureSkipVerify: o.SkipVerify}
+	if o.CAFile != "" {
+		ca, err := os.ReadFile(o.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("%w: CA证书%s中没有有效的PEM证书", ErrInvalidConfig, o.CAFile)
+		}
+		conf.RootCAs = pool
+	}
+	if (o.CertFile == "") != (o.KeyFile == "") {
+		return nil, fmt.Errorf("%w: 客户端证书和私钥需要同时提供", ErrInvalidConfig)
+	}
+	if o.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(o.CertFile, o.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		conf.Certificates = []tls.Certificate{cert}
+	}
+	return conf, nil
+}
+
+// NewClient 根据配置创建客户端：单机和哨兵模式为*redis.Client（哨兵模式设置了RouteByLatency或RouteRandomly时为*redis.ClusterClient），
+// 集群模式为*redis.ClusterClient
+func (o *Options) NewClient() (redis.UniversalClient, error) {
+	if len(o.Addrs) == 0 {
+		return nil, fmt.Errorf("%w: 没有配置地址", ErrInvalidConfig)
+	}
+	tlsConf, err := o.tlsConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	switch o.mode() {
+	case ModeStandalone:
+		return redis.NewClient(&redis.Options{
+			Addr:         o.Addrs[0],
+			DB:           o.DB,
+			Username:     o.Username,
+			Password:     o.Password,
+			TLSConfig:    tlsConf,
+			PoolSize:     o.PoolSize,
+			MinIdleConns: o.MinIdleConns,
+			MaxRetries:   o.MaxRetries,
+			DialTimeout:  o.DialTimeout,
+			ReadTimeout:  o.ReadTimeout,
+			WriteTimeout: o.WriteTimeout,
+			PoolTimeout:  o.PoolTimeout,
+		}), nil
+	case ModeSentinel:
+		if o.MasterName == "" {
+			return nil, fmt.Errorf("%w: 哨兵模式需要MasterName", ErrInvalidConfig)
+		}
+		fo := &redis.FailoverOptions{
+			MasterName:       o.MasterName,
+			SentinelAddrs:    o.Addrs,
+			SentinelUsername: o.SentinelUsername,
+			SentinelPassword: o.SentinelPassword,
+			RouteByLatency:   o.RouteByLatency,
+			RouteRandomly:    o.RouteRandomly,
+			ReplicaOnly:      o.ReadOnly,
+			DB:               o.DB,
+			Username:         o.Username,
+			Password:         o.Password,
+			TLSConfig:        tlsConf,
+			PoolSize:         o.PoolSize,
+			MinIdleConns:     o.MinIdleConns,
+			MaxRetries:       o.MaxRetries,
+			DialTimeout:      o.DialTimeout,
+			ReadTimeout:      o.ReadTimeout,
+			WriteTimeout:     o.WriteTimeout,
+			PoolTimeout:      o.PoolTimeout,
+		}
+		if o.RouteByLatency || o.RouteRandomly {
+			return redis.NewFailoverClusterClient(fo), nil
+		}
+		return redis.NewFailoverClient(fo), nil
+	case ModeCluster:
+		if o.DB != 0 {
+			return nil, fmt.Errorf("%w: 集群模式不支持DB %d", ErrInvalidConfig, o.DB)
+		}
+		return redis.NewClusterClient(&redis.ClusterOptions{
+			Addrs:          o.Addrs,
+			ReadOnly:       o.ReadOnly,
+			RouteByLatency: o.RouteByLatency,
+			RouteRandomly:  o.RouteRandomly,
+			Username:       o.Username,
+			Password:       o.Password,
+			TLSConfig:      tlsConf,
+			PoolSize:       o.PoolSize,
+			MinIdleConns:   o.MinIdleConns,
+			MaxRetries:     o.MaxRetries,
+			DialTimeout:    o.DialTimeout,
+			ReadTimeout:    o.ReadTimeout,
+			WriteTimeout:   o.WriteTimeout,
+			PoolTimeout:    o.PoolTimeout,
+		}), nil
+	default:
+		return nil, fmt.Errorf("%w: 不支持的模式%s", ErrInvalidConfig, o.Mode)
+	}
+}
+
+// ParseURL 解析redis://[user:password@]host:port[/db][?参数]，rediss://使用TLS
+//
+// 参数：addr（额外的地址，可重复）、mode、master_name、sentinel_username、sentinel_password、
+// pool_size、min_idle_conns、max_retries、dial_timeout、read_timeout、write_timeout、pool_timeout（time.Duration格式）、
+// read_only、route_by_latency、route_randomly、skip_verify
+func ParseURL(redisURL string) (*Options, error) {
+	u, err := url.Parse(redisURL)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidConfig, err)
+	}
+
+	o := &Options{}
+	switch u.Scheme {
+	case "redis":
+	case "rediss":
+		o.TLS = true
+	default:
+		return nil, fmt.Errorf("%w: 不支持的scheme %s", ErrInvalidConfig, u.Scheme)
+	}
+	if u.Host != "" {
+		o.Addrs = append(o.Addrs, withDefaultPort(u.Host))
+	}
+	if u.User != nil {
+		o.Username = u.User.Username()
+		o.Password, _ = u.User.Password()
+	}
+	if p := strings.Trim(u.Path, "/"); p != "" {
+		if o.DB, err = strconv.Atoi(p); err != nil {
+			return nil, fmt.Errorf("%w: 无效的DB %s", ErrInvalidConfig, p)
+		}
+	}
+
+	q := u.Query()
+	for _, a := range q["addr"] {
+		o.Addrs = append(o.Addrs, withDefaultPort(a))
+	}
+	o.Mode = q.Get("mode")
+	o.MasterName = q.Get("master_name")
+	o.SentinelUsername = q.Get("sentinel_username")
+	o.SentinelPassword = q.Get("sentinel_password")
+
+	p := paramParser{get: q.Get, name: func(k string) string { return k }}
+	p.int("pool_size", &o.PoolSize)
+	p.int("min_idle_conns", &o.MinIdleConns)
+	p.int("max_retries", &o.MaxRetries)
+	p.duration("dial_timeout", &o.DialTimeout)
+	p.duration("read_timeout", &o.ReadTimeout)
+	p.duration("write_timeout", &o.WriteTimeout)
+	p.duration("pool_timeout", &o.PoolTimeout)
+	p.bool("read_only", &o.ReadOnly)
+	p.bool("route_by_latency", &o.RouteByLatency)
+	p.bool("route_randomly", &o.RouteRandomly)
+	p.bool("skip_verify", &o.SkipVerify)
+	if p.err != nil {
+		return nil, p.err
+	}
+	return o, nil
+}
+
+// OptionsFromEnv 从环境变量加载配置，prefix为空时使用REDIS_
+//
+// 设置了URL时先按ParseURL解析，其他变量覆盖URL中的值。读取的变量为：URL, MODE, ADDRS（逗号分隔）, DB,
+// USERNAME, PASSWORD, MASTER_NAME, SENTINEL_USERNAME, SENTINEL_PASSWORD, TLS, TLS_CA_FILE, TLS_CERT_FILE,
+// TLS_KEY_FILE, TLS_SKIP_VERIFY, POOL_SIZE, MIN_IDLE_CONNS, MAX_RETRIES, DIAL_TIMEOUT, READ_TIMEOUT,
+// WRITE_TIMEOUT, POOL_TIMEOUT, READ_ONLY, ROUTE_BY_LATENCY, ROUTE_RANDOMLY
+func OptionsFromEnv(prefix string) (*Options, error) {
+	if prefix == "" {
+		prefix = "REDIS_"
+	}
+	env := func(k string) string {
+		return os.Getenv(prefix + k)
+	}
+
+	o := &Options{}
+	if u := env("URL"); u != "" {
+		var err error
+		if o, err = ParseURL(u); err != nil {
+			return nil, err
+		}
+	}
+
+	str := func(k string, v *string) {
+		if s := env(k); s != "" {
+			*v = s
+		}
+	}
+	str("MODE", &o.Mode)
+	if s := env("ADDRS"); s != "" {
+		o.Addrs = nil
+		for _, a := range strings.Split(s, ",") {
+			if a = strings.TrimSpace(a); a != "" {
+				o.Addrs = append(o.Addrs, withDefaultPort(a))
+			}
+		}
+	}
+	str("USERNAME", &o.Username)
+	str("PASSWORD", &o.Password)
+	str("MASTER_NAME", &o.MasterName)
+	str("SENTINEL_USERNAME", &o.SentinelUsername)
+	str("SENTINEL_PASSWORD", &o.SentinelPassword)
+	str("TLS_CA_FILE", &o.CAFile)
+	str("TLS_CERT_FILE", &o.CertFile)
+	str("TLS_KEY_FILE", &o.KeyFile)
+
+	p := paramParser{get: env, name: func(k string) string { return prefix + k }}
+	p.int("DB", &o.DB)
+	p.bool("TLS", &o.TLS)
+	p.bool("TLS_SKIP_VERIFY", &o.SkipVerify)
+	p.int("POOL_SIZE", &o.PoolSize)
+	p.int("MIN_IDLE_CONNS", &o.MinIdleConns)
+	p.int("MAX_RETRIES", &o.MaxRetries)
+	p.duration("DIAL_TIMEOUT", &o.DialTimeout)
+	p.duration("READ_TIMEOUT", &o.ReadTimeout)
+	p.duration("WRITE_TIMEOUT", &o.WriteTimeout)
+	p.duration("POOL_TIMEOUT", &o.PoolTimeout)
+	p.bool("READ_ONLY", &o.ReadOnly)
+	p.bool("ROUTE_BY_LATENCY", &o.RouteByLatency)
+	p.bool("ROUTE_RANDOMLY", &o.RouteRandomly)
+	if p.err != nil {
+		return nil, p.err
+	}
+	return o, nil
+}
+
+// withDefaultPort 没有端口时使用6379
+func withDefaultPort(addr string) string {
+	if strings.LastIndex(addr, ":") <= strings.LastIndex(addr, "]") {
+		return addr + ":6379"
+	}
+	return addr
+}
+
+// paramParser 解析字符串参数，记录第一个错误，值为空时不修改
+type paramParser struct {
+	get  func(string) string
+	name func(string) string
+	err  error
+}
+
+func (p *paramParser) parse(k string, fn func(string) error) {
+	s := p.get(k)
+	if s == "" || p.err != nil {
+		return
+	}
+	if err := fn(s); err != nil {
+		p.err = fmt.Errorf("%w: %s的值%s无效", ErrInvalidConfig, p.name(k), s)
+	}
+}
+
+func (p *paramParser) int(k string, v *int) {
+	p.parse(k, func(s string) (err error) {
+		*v, err = strconv.Atoi(s)
+		return err
+	})
+}
+
+func (p *paramParser) bool(k string, v *bool) {
+	p.parse(k, func(s string) (err error) {
+		*v, err = strconv.ParseBool(s)
+		return err
+	})
+}
+
+func (p *paramParser) duration(k string, v *time.Duration) {
+	p.parse(k, func(s string) (err error) {
+		*v, err = time.ParseDuration(s)
+		return err
+	})
+}
@@ -0,0 +1,531 @@
+package mredis
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-redis/redis/v9"
+)
+
+// 基于Redis列表的可靠队列：任务取出时原子地移入工作者自己的处理中列表并记录处理期限，处理完成后确认删除；
+// 工作者崩溃或超过可见性超时的任务由Reap放回队列，重试超过MaxRetries次的任务移入死信列表；
+// 工作者每次取任务时在heartbeats中续期，过期且处理中列表已清空的工作者由Reap移除
+//
+// 所有key使用"queue:{name}:"前缀，集群模式下位于同一个slot，Lua脚本中按前缀拼接key
+
+const (
+	defaultQueueVisibility   = 30 * time.Second
+	defaultQueueMaxRetries   = 3
+	defaultQueueBlock        = 2 * time.Second
+	defaultQueueReapInterval = 10 * time.Second
+	defaultQueueReapBatch    = 100
+)
+
+var (
+	// ErrQueueEmpty 阻塞等待期间没有任务
+	ErrQueueEmpty = errors.New("[mouse] -> redis 队列为空")
+	// ErrJobLost 任务已超时被放回队列，可能正在被其他工作者处理
+	ErrJobLost = errors.New("[mouse] -> redis 任务已超时被重新投递")
+)
+
+// queueFailLua 将任务移出处理中列表，count为1时计一次重试，超过max次时移入死信列表，否则放回原优先级队列的末尾；
+// count为0时不计重试，放回队列的头部
+const queueFailLua = `
+local function fail(prefix, id, max, count)
+	local owner = redis.call('HGET', prefix .. 'owners', id)
+	if owner then
+		redis.call('LREM', prefix .. 'processing:' .. owner, 1, id)
+	end
+	redis.call('HDEL', prefix .. 'owners', id)
+	redis.call('HDEL', prefix .. 'claims', id)
+	redis.call('ZREM', prefix .. 'deadlines', id)
+	if count == 1 and redis.call('HINCRBY', prefix .. 'retries', id, 1) > max then
+		redis.call('LPUSH', prefix .. 'dead', id)
+		return 2
+	end
+	local p = redis.call('HGET', prefix .. 'priority', id) or '0'
+	if count == 1 then
+		redis.call('LPUSH', prefix .. 'pending:' .. p, id)
+	else
+		redis.call('RPUSH', prefix .. 'pending:' .. p, id)
+	end
+	return 1
+end
+`
+
+// queueJobLua 返回{id, payload, 重试次数, 优先级}
+const queueJobLua = `
+local function job(prefix, id)
+	local v = redis.call('HMGET', prefix .. 'jobs', id)
+	local r = redis.call('HMGET', prefix .. 'retries', id)
+	local p = redis.call('HMGET', prefix .. 'priority', id)
+	return {id, v[1] or '', r[1] or '0', p[1] or '0'}
+end
+`
+
+const queueNowLua = `
+local t = redis.call('TIME')
+local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+`
+
+// queueClaimScript 按优先级从高到低取出一个任务移入处理中列表并记录期限和这次领取的token，没有任务时返回nil；
+// 无论是否取到任务都将工作者的心跳续期到alive毫秒之后
+var queueClaimScript = redis.NewScript(queueJobLua + queueNowLua + `
+local prefix, worker, vis, n, alive, token = ARGV[1], ARGV[2], tonumber(ARGV[3]), tonumber(ARGV[4]), tonumber(ARGV[5]), ARGV[6]
+local processing = prefix .. 'processing:' .. worker
+redis.call('ZADD', prefix .. 'heartbeats', now + alive, worker)
+for p = 0, n - 1 do
+	local id = redis.call('RPOPLPUSH', prefix .. 'pending:' .. p, processing)
+	if id then
+		redis.call('ZADD', prefix .. 'deadlines', now + vis, id)
+		redis.call('HSET', prefix .. 'owners', id, worker)
+		redis.call('HSET', prefix .. 'claims', id, token)
+		return job(prefix, id)
+	end
+end
+return false
+`)
+
+// queueRegisterScript 为阻塞取出的任务记录期限和这次领取的token
+var queueRegisterScript = redis.NewScript(queueJobLua + queueNowLua + `
+local prefix, worker, vis, id, alive, token = ARGV[1], ARGV[2], tonumber(ARGV[3]), ARGV[4], tonumber(ARGV[5]), ARGV[6]
+redis.call('ZADD', prefix .. 'heartbeats', now + alive, worker)
+redis.call('ZADD', prefix .. 'deadlines', now + vis, id)
+redis.call('HSET', prefix .. 'owners', id, worker)
+redis.call('HSET', prefix .. 'claims', id, token)
+return job(prefix, id)
+`)
+
+// queueReleaseLostLua 领取已失效时，任务已不属于该工作者则处理中列表中的任务是这次领取的残留，将其移出；
+// 仍属于该工作者时列表中的任务是同一工作者的新一次领取，保留
+const queueReleaseLostLua = `
+local function lost(prefix, worker, id)
+	if redis.call('HGET', prefix .. 'owners', id) ~= worker then
+		redis.call('LREM', prefix .. 'processing:' .. worker, 1, id)
+	end
+	return 0
+end
+`
+
+// queueAckScript 删除任务，token不是当前的领取时返回0
+var queueAckScript = redis.NewScript(queueReleaseLostLua + `
+local prefix, worker, id, token = ARGV[1], ARGV[2], ARGV[3], ARGV[4]
+if redis.call('HGET', prefix .. 'claims', id) ~= token then
+	return lost(prefix, worker, id)
+end
+redis.call('LREM', prefix .. 'processing:' .. worker, 1, id)
+redis.call('ZREM', prefix .. 'deadlines', id)
+redis.call('HDEL', prefix .. 'owners', id)
+redis.call('HDEL', prefix .. 'claims', id)
+redis.call('HDEL', prefix .. 'jobs', id)
+redis.call('HDEL', prefix .. 'retries', id)
+redis.call('HDEL', prefix .. 'priority', id)
+return 1
+`)
+
+// queueNackScript 处理失败或放弃处理，返回0表示token不是当前的领取，1表示放回队列，2表示移入死信列表
+var queueNackScript = redis.NewScript(queueFailLua + queueReleaseLostLua + `
+local prefix, worker, id, token = ARGV[1], ARGV[2], ARGV[3], ARGV[6]
+if redis.call('HGET', prefix .. 'claims', id) ~= token then
+	return lost(prefix, worker, id)
+end
+return fail(prefix, id, tonumber(ARGV[4]), tonumber(ARGV[5]))
+`)
+
+// queueTouchScript 延长处理期限，token不是当前的领取时返回0
+var queueTouchScript = redis.NewScript(queueNowLua + `
+local prefix, vis, id, token = ARGV[1], tonumber(ARGV[3]), ARGV[4], ARGV[5]
+if redis.call('HGET', prefix .. 'claims', id) ~= token then
+	return 0
+end
+redis.call('ZADD', prefix .. 'deadlines', now + vis, id)
+return 1
+`)
+
+// queueReapScript 放回超过期限的任务并移除过期的工作者，返回处理的任务数
+//
+// 阻塞取出之后、记录期限之前崩溃的工作者会在处理中列表中留下没有期限的任务，先为这些任务补记期限；
+// 处理中列表中已删除的任务，以及过期工作者列表中已属于其他工作者的任务直接移出；
+// 心跳过期且处理中列表为空的工作者从heartbeats中移除，列表中还有任务的等任务超时放回后再移除
+var queueReapScript = redis.NewScript(queueFailLua + queueNowLua + `
+local prefix, max, vis, batch = ARGV[1], tonumber(ARGV[2]), tonumber(ARGV[3]), tonumber(ARGV[4])
+local heartbeats = prefix .. 'heartbeats'
+-- 旧版本在集合workers中记录工作者，迁移到heartbeats并视为已过期
+for _, w in ipairs(redis.call('SMEMBERS', prefix .. 'workers')) do
+	redis.call('ZADD', heartbeats, 'NX', 0, w)
+end
+redis.call('DEL', prefix .. 'workers')
+local hb = redis.call('ZRANGE', heartbeats, 0, -1, 'WITHSCORES')
+for i = 1, #hb, 2 do
+	local w, stale = hb[i], tonumber(hb[i + 1]) < now
+	local processing = prefix .. 'processing:' .. w
+	for _, id in ipairs(redis.call('LRANGE', processing, 0, -1)) do
+		local owner = redis.call('HGET', prefix .. 'owners', id)
+		if redis.call('HEXISTS', prefix .. 'jobs', id) == 0 or (stale and owner and owner ~= w) then
+			redis.call('LREM', processing, 1, id)
+		elseif not redis.call('ZSCORE', prefix .. 'deadlines', id) then
+			redis.call('ZADD', prefix .. 'deadlines', now + vis, id)
+			redis.call('HSETNX', prefix .. 'owners', id, w)
+		end
+	end
+	if stale and redis.call('LLEN', processing) == 0 then
+		redis.call('ZREM', heartbeats, w)
+	end
+end
+local expired = redis.call('ZRANGEBYSCORE', prefix .. 'deadlines', '-inf', now, 'LIMIT', 0, batch)
+for _, id in ipairs(expired) do
+	fail(prefix, id, max, 1)
+end
+return #expired
+`)
+
+// queueRetryDeadScript 将任务移出死信列表并清零重试次数后放回队列，任务不在死信列表中时返回0
+var queueRetryDeadScript = redis.NewScript(`
+local prefix, id = ARGV[1], ARGV[2]
+if redis.call('LREM', prefix .. 'dead', 1, id) == 0 then
+	return 0
+end
+redis.call('HDEL', prefix .. 'retries', id)
+local p = redis.call('HGET', prefix .. 'priority', id) or '0'
+redis.call('LPUSH', prefix .. 'pending:' .. p, id)
+return 1
+`)
+
+// Job 队列中的任务
+type Job struct {
+	ID      string
+	Payload []byte
+	// Priority 优先级，0最高
+	Priority int
+	// Retries 已失败的次数
+	Retries int
+	// token 这次领取的标识，任务超时后被重新领取（包括同一工作者的其他goroutine）时原来的Job无法再确认
+	token string
+}
+
+// QueueHandler 处理一个任务，返回nil时任务被删除，否则计一次重试
+type QueueHandler func(ctx context.Context, job *Job) error
+
+// QueueOptions 队列配置
+type QueueOptions struct {
+	// Worker 工作者名，决定处理中列表，默认为主机名-进程号；
+	// 工作者超过VisibilityTimeout+Block没有取任务后心跳过期，处理中列表清空后由Reap移除，再次取任务时重新加入
+	Worker string
+	// Priorities 优先级数，默认1，任务的优先级为[0, Priorities)，0最高
+	Priorities int
+	// VisibilityTimeout 任务取出后未确认的最长时间，超过后由Reap放回队列，默认30秒
+	VisibilityTimeout time.Duration
+	// MaxRetries 最大重试次数，超过后移入死信列表，默认3，小于0时不重试
+	MaxRetries int
+	// Block 队列为空时的阻塞时间，默认2秒，最小1秒；有多个优先级时只阻塞在最高优先级上，其他优先级的任务最多延迟Block
+	Block time.Duration
+	// ReapInterval Run中调用Reap的间隔，默认10秒
+	ReapInterval time.Duration
+}
+
+// Queue 可靠队列
+type Queue struct {
+	rds    redis.UniversalClient
+	prefix string
+	opts   QueueOptions
+	// noBLMove 服务器不支持BLMOVE（Redis 6.2之前）时改用BRPOPLPUSH
+	noBLMove int32
+}
+
+// NewQueue 创建队列
+func NewQueue(rds redis.UniversalClient, name string, opts QueueOptions) *Queue {
+	if opts.Worker == "" {
+		host, _ := os.Hostname()
+		opts.Worker = fmt.Sprintf("%s-%d", host, os.Getpid())
+	}
+	if opts.Priorities <= 0 {
+		opts.Priorities = 1
+	}
+	if opts.VisibilityTimeout <= 0 {
+		opts.VisibilityTimeout = defaultQueueVisibility
+	}
+	if opts.MaxRetries < 0 {
+		opts.MaxRetries = 0
+	} else if opts.MaxRetries == 0 {
+		opts.MaxRetries = defaultQueueMaxRetries
+	}
+	if opts.Block <= 0 {
+		opts.Block = defaultQueueBlock
+	}
+	if opts.Block < time.Second {
+		opts.Block = time.Second
+	}
+	if opts.ReapInterval <= 0 {
+		opts.ReapInterval = defaultQueueReapInterval
+	}
+	return &Queue{rds: rds, prefix: "queue:{" + name + "}:", opts: opts}
+}
+
+func (q *Queue) pendingKey(priority int) string {
+	return q.prefix + "pending:" + strconv.Itoa(priority)
+}
+
+func (q *Queue) processingKey() string {
+	return q.prefix + "processing:" + q.opts.Worker
+}
+
+// Enqueue 以priority优先级加入任务，返回任务ID
+func (q *Queue) Enqueue(ctx context.Context, payload []byte, priority int) (string, error) {
+	if priority < 0 || priority >= q.opts.Priorities {
+		return "", fmt.Errorf("[mouse] -> redis 任务优先级%d超出范围[0, %d)", priority, q.opts.Priorities)
+	}
+	id, err := newLockToken()
+	if err != nil {
+		return "", err
+	}
+	_, err = q.rds.TxPipelined(ctx, func(p redis.Pipeliner) error {
+		p.HSet(ctx, q.prefix+"jobs", id, payload)
+		p.HSet(ctx, q.prefix+"priority", id, priority)
+		p.LPush(ctx, q.pendingKey(priority), id)
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return id, nil
+}
+
+// Dequeue 按优先级取出一个任务，队列为空时阻塞最多Block，仍没有任务时返回ErrQueueEmpty
+//
+// 取出的任务需要在VisibilityTimeout内Ack、Nack或Touch
+func (q *Queue) Dequeue(ctx context.Context) (*Job, error) {
+	token, err := newLockToken()
+	if err != nil {
+		return nil, err
+	}
+	job, err := q.parseJob(token, queueClaimScript.Run(ctx, q.rds, []string{q.prefix + "jobs"},
+		q.prefix, q.opts.Worker, q.opts.VisibilityTimeout.Milliseconds(), q.opts.Priorities, q.aliveMillis(), token))
+	if !errors.Is(err, ErrQueueEmpty) {
+		return job, err
+	}
+
+	id, err := q.blockingMove(ctx)
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return nil, ErrQueueEmpty
+		}
+		return nil, err
+	}
+	return q.parseJob(token, queueRegisterScript.Run(ctx, q.rds, []string{q.prefix + "jobs"},
+		q.prefix, q.opts.Worker, q.opts.VisibilityTimeout.Milliseconds(), id, q.aliveMillis(), token))
+}
+
+// aliveMillis 心跳的有效期，大于阻塞取出的时间，使阻塞在空队列上的工作者不会被移除
+func (q *Queue) aliveMillis() int64 {
+	return (q.opts.VisibilityTimeout + q.opts.Block).Milliseconds()
+}
+
+// blockingMove 阻塞地将最高优先级队列中的任务移入处理中列表
+func (q *Queue) blockingMove(ctx context.Context) (string, error) {
+	src, dst := q.pendingKey(0), q.processingKey()
+	if atomic.LoadInt32(&q.noBLMove) == 0 {
+		id, err := q.rds.BLMove(ctx, src, dst, "RIGHT", "LEFT", q.opts.Block).Result()
+		if err == nil || !strings.Contains(strings.ToLower(err.Error()), "unknown command") {
+			return id, err
+		}
+		atomic.StoreInt32(&q.noBLMove, 1)
+	}
+	return q.rds.BRPopLPush(ctx, src, dst, q.opts.Block).Result()
+}
+
+func (q *Queue) parseJob(token string, cmd *redis.Cmd) (*Job, error) {
+	vals, err := cmd.StringSlice()
+	if errors.Is(err, redis.Nil) {
+		return nil, ErrQueueEmpty
+	}
+	if err != nil {
+		return nil, err
+	}
+	retries, _ := strconv.Atoi(vals[2])
+	priority, _ := strconv.Atoi(vals[3])
+	return &Job{ID: vals[0], Payload: []byte(vals[1]), Retries: retries, Priority: priority, token: token}, nil
+}
+
+// Ack 处理成功，删除任务；任务已超时被放回队列或被重新领取（包括同一工作者的其他goroutine）时返回ErrJobLost
+func (q *Queue) Ack(ctx context.Context, job *Job) error {
+	n, err := queueAckScript.Run(ctx, q.rds, []string{q.prefix + "jobs"}, q.prefix, q.opts.Worker, job.ID, job.token).Int()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrJobLost
+	}
+	return nil
+}
+
+// Nack 处理失败，计一次重试后放回队列，超过MaxRetries次时移入死信列表
+func (q *Queue) Nack(ctx context.Context, job *Job) error {
+	return q.fail(ctx, job, 1)
+}
+
+// Release 放弃处理，不计重试直接放回队列，用于退出时交还未处理的任务
+func (q *Queue) Release(ctx context.Context, job *Job) error {
+	return q.fail(ctx, job, 0)
+}
+
+func (q *Queue) fail(ctx context.Context, job *Job, count int) error {
+	n, err := queueNackScript.Run(ctx, q.rds, []string{q.prefix + "jobs"},
+		q.prefix, q.opts.Worker, job.ID, q.opts.MaxRetries, count, job.token).Int()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrJobLost
+	}
+	return nil
+}
+
+// Touch 将任务的处理期限延长到VisibilityTimeout之后，用于处理时间较长的任务
+func (q *Queue) Touch(ctx context.Context, job *Job) error {
+	n, err := queueTouchScript.Run(ctx, q.rds, []string{q.prefix + "jobs"},
+		q.prefix, q.opts.Worker, q.opts.VisibilityTimeout.Milliseconds(), job.ID, job.token).Int()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrJobLost
+	}
+	return nil
+}
+
+// Reap 将超过处理期限的任务计一次重试后放回队列或移入死信列表，返回处理的任务数；可以在任意实例上调用
+func (q *Queue) Reap(ctx context.Context) (int, error) {
+	total := 0
+	for {
+		n, err := queueReapScript.Run(ctx, q.rds, []string{q.prefix + "jobs"},
+			q.prefix, q.opts.MaxRetries, q.opts.VisibilityTimeout.Milliseconds(), defaultQueueReapBatch).Int()
+		total += n
+		if err != nil || n < defaultQueueReapBatch {
+			return total, err
+		}
+	}
+}
+
+// Run 循环取出任务并调用h，直到ctx结束或Redis出错；可以在多个goroutine中同时调用以并发处理
+//
+// 每ReapInterval调用一次Reap；ctx结束时正在处理的任务如果失败则不计重试放回队列
+func (q *Queue) Run(ctx context.Context, h QueueHandler) error {
+	nextReap := time.Now()
+	for ctx.Err() == nil {
+		if !time.Now().Before(nextReap) {
+			if _, err := q.Reap(ctx); err != nil {
+				return stopErr(ctx, err)
+			}
+			nextReap = time.Now().Add(q.opts.ReapInterval)
+		}
+
+		job, err := q.Dequeue(ctx)
+		if errors.Is(err, ErrQueueEmpty) {
+			continue
+		}
+		if err != nil {
+			return stopErr(ctx, err)
+		}
+
+		if err := q.finish(ctx, job, h(ctx, job)); err != nil && !errors.Is(err, ErrJobLost) {
+			return stopErr(ctx, err)
+		}
+	}
+	return nil
+}
+
+// finish 根据处理结果确认任务，ctx已结束时仍完成确认，避免任务等到超时才被放回队列
+func (q *Queue) finish(ctx context.Context, job *Job, herr error) error {
+	stopped := ctx.Err() != nil
+	if stopped {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+	}
+	switch {
+	case herr == nil:
+		return q.Ack(ctx, job)
+	case stopped:
+		return q.Release(ctx, job)
+	default:
+		return q.Nack(ctx, job)
+	}
+}
+
+// Len 所有优先级中等待处理的任务数
+func (q *Queue) Len(ctx context.Context) (int64, error) {
+	cmds := make([]*redis.IntCmd, q.opts.Priorities)
+	_, err := q.rds.Pipelined(ctx, func(p redis.Pipeliner) error {
+		for i := range cmds {
+			cmds[i] = p.LLen(ctx, q.pendingKey(i))
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	var n int64
+	for _, cmd := range cmds {
+		n += cmd.Val()
+	}
+	return n, nil
+}
+
+// Dead 死信列表中[start, stop]范围内的任务，最近移入的在前
+func (q *Queue) Dead(ctx context.Context, start, stop int64) ([]*Job, error) {
+	ids, err := q.rds.LRange(ctx, q.prefix+"dead", start, stop).Result()
+	if err != nil || len(ids) == 0 {
+		return nil, err
+	}
+	cmds, err := q.rds.Pipelined(ctx, func(p redis.Pipeliner) error {
+		p.HMGet(ctx, q.prefix+"jobs", ids...)
+		p.HMGet(ctx, q.prefix+"retries", ids...)
+		p.HMGet(ctx, q.prefix+"priority", ids...)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	payloads := cmds[0].(*redis.SliceCmd).Val()
+	retries := cmds[1].(*redis.SliceCmd).Val()
+	priorities := cmds[2].(*redis.SliceCmd).Val()
+
+	jobs := make([]*Job, len(ids))
+	for i, id := range ids {
+		j := &Job{ID: id}
+		if s, ok := payloads[i].(string); ok {
+			j.Payload = []byte(s)
+		}
+		if s, ok := retries[i].(string); ok {
+			j.Retries, _ = strconv.Atoi(s)
+		}
+		if s, ok := priorities[i].(string); ok {
+			j.Priority, _ = strconv.Atoi(s)
+		}
+		jobs[i] = j
+	}
+	return jobs, nil
+}
+
+// DeadLen 死信列表的长度
+func (q *Queue) DeadLen(ctx context.Context) (int64, error) {
+	return q.rds.LLen(ctx, q.prefix+"dead").Result()
+}
+
+// RetryDead 将死信列表中的任务清零重试次数后放回队列，任务不在死信列表中时返回ErrNotFound
+func (q *Queue) RetryDead(ctx context.Context, id string) error {
+	n, err := queueRetryDeadScript.Run(ctx, q.rds, []string{q.prefix + "jobs"}, q.prefix, id).Int()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
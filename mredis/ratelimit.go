@@ -0,0 +1,309 @@
+package mredis
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v9"
+)
+
+// 基于Redis的限流器，多个实例共享同一个限额；判断和扣减在Lua脚本中原子完成，时间取Redis服务器的TIME，不受实例间时钟偏差影响
+
+const defaultRateLimitPrefix = "ratelimit:"
+
+// RateLimitResult 限流结果
+type RateLimitResult struct {
+	Allowed bool
+	// Limit 限额（令牌桶为容量）
+	Limit int64
+	// Remaining 本次请求之后剩余的额度
+	Remaining int64
+	// RetryAfter 被拒绝时到额度足够的等待时间
+	RetryAfter time.Duration
+	// ResetAfter 额度恢复到满额的等待时间
+	ResetAfter time.Duration
+}
+
+// RateLimiter 按key限流
+type RateLimiter interface {
+	// Allow 请求1个额度
+	Allow(ctx context.Context, key string) (RateLimitResult, error)
+	// AllowN 请求n个额度，被拒绝时不扣减
+	AllowN(ctx context.Context, key string, n int) (RateLimitResult, error)
+}
+
+// tokenBucketScript 返回{是否允许, 剩余令牌, 重试等待(微秒), 恢复满额等待(微秒)}
+var tokenBucketScript = redis.NewScript(`
+local t = redis.call('TIME')
+local now = tonumber(t[1]) * 1000000 + tonumber(t[2])
+local rate = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local n = tonumber(ARGV[3])
+
+local v = redis.call('HMGET', KEYS[1], 'tokens', 'ts')
+local tokens = tonumber(v[1])
+local ts = tonumber(v[2])
+if tokens == nil or ts == nil then
+	tokens = burst
+	ts = now
+end
+tokens = math.min(burst, tokens + math.max(0, now - ts) * rate / 1000000)
+
+local allowed = 0
+local retry = 0
+if tokens >= n then
+	tokens = tokens - n
+	allowed = 1
+else
+	retry = math.ceil((n - tokens) * 1000000 / rate)
+end
+local reset = math.ceil((burst - tokens) * 1000000 / rate)
+redis.call('HSET', KEYS[1], 'tokens', tostring(tokens), 'ts', tostring(now))
+redis.call('PEXPIRE', KEYS[1], math.ceil(reset / 1000) + 1000)
+return {allowed, math.floor(tokens), retry, reset}
+`)
+
+// fixedWindowScript 返回{是否允许, 剩余额度, 重试等待(微秒), 窗口结束(微秒)}
+var fixedWindowScript = redis.NewScript(`
+local t = redis.call('TIME')
+local now = tonumber(t[1]) * 1000000 + tonumber(t[2])
+local limit = tonumber(ARGV[1])
+local window = tonumber(ARGV[2])
+local n = tonumber(ARGV[3])
+
+local start = now - (now % window)
+local reset = start + window - now
+local v = redis.call('HMGET', KEYS[1], 'start', 'count')
+local count = 0
+if tonumber(v[1]) == start then
+	count = tonumber(v[2])
+end
+
+if count + n > limit then
+	return {0, limit - count, reset, reset}
+end
+count = count + n
+redis.call('HSET', KEYS[1], 'start', tostring(start), 'count', tostring(count))
+redis.call('PEXPIRE', KEYS[1], math.ceil(reset / 1000))
+return {1, limit - count, 0, reset}
+`)
+
+// slidingLogScript 以请求时间为score记录在zset中（与ZSetAdd/ZCount相同的有序集合），统计窗口内的请求数；
+// 返回{是否允许, 剩余额度, 重试等待(微秒), 恢复满额等待(微秒)}
+var slidingLogScript = redis.NewScript(`
+local t = redis.call('TIME')
+local now = tonumber(t[1]) * 1000000 + tonumber(t[2])
+local limit = tonumber(ARGV[1])
+local window = tonumber(ARGV[2])
+local n = tonumber(ARGV[3])
+
+redis.call('ZREMRANGEBYSCORE', KEYS[1], '-inf', now - window)
+local count = redis.call('ZCARD', KEYS[1])
+
+local allowed = 0
+local retry = 0
+if count + n <= limit then
+	for i = 1, n do
+		redis.call('ZADD', KEYS[1], now, ARGV[4] .. ':' .. i)
+	end
+	redis.call('PEXPIRE', KEYS[1], math.ceil(window / 1000))
+	count = count + n
+	allowed = 1
+elseif n > limit then
+	retry = window
+else
+	-- 需要等待第count+n-limit条最早的请求移出窗口
+	local e = redis.call('ZRANGE', KEYS[1], count + n - limit - 1, count + n - limit - 1, 'WITHSCORES')
+	retry = tonumber(e[2]) + window - now
+end
+
+local reset = 0
+local last = redis.call('ZRANGE', KEYS[1], -1, -1, 'WITHSCORES')
+if last[2] then
+	reset = tonumber(last[2]) + window - now
+end
+return {allowed, limit - count, retry, reset}
+`)
+
+// runLimitScript 执行限流脚本并解析结果
+func runLimitScript(ctx context.Context, rds redis.UniversalClient, script *redis.Script, key string, limit int64, args ...any) (RateLimitResult, error) {
+	vals, err := script.Run(ctx, rds, []string{key}, args...).Int64Slice()
+	if err != nil {
+		return RateLimitResult{}, err
+	}
+	r := RateLimitResult{
+		Allowed:    vals[0] == 1,
+		Limit:      limit,
+		Remaining:  vals[1],
+		RetryAfter: time.Duration(vals[2]) * time.Microsecond,
+		ResetAfter: time.Duration(vals[3]) * time.Microsecond,
+	}
+	if r.Remaining < 0 {
+		r.Remaining = 0
+	}
+	return r, nil
+}
+
+// TokenBucket 令牌桶：以Rate个/秒的速度补充令牌，最多保存Burst个，允许短时间的突发
+type TokenBucket struct {
+	rds    redis.UniversalClient
+	prefix string
+	rate   float64
+	burst  int
+}
+
+// NewTokenBucket 创建令牌桶限流器，prefix为空时使用"ratelimit:"；rate和burst需要大于0
+func NewTokenBucket(rds redis.UniversalClient, prefix string, rate float64, burst int) (*TokenBucket, error) {
+	if !(rate > 0) || math.IsInf(rate, 1) || burst <= 0 {
+		return nil, fmt.Errorf("%w: 令牌桶的速率%v和容量%d需要大于0", ErrInvalidConfig, rate, burst)
+	}
+	if prefix == "" {
+		prefix = defaultRateLimitPrefix
+	}
+	return &TokenBucket{rds: rds, prefix: prefix, rate: rate, burst: burst}, nil
+}
+
+// checkWindow 校验窗口类限流器的限额和窗口长度，窗口以微秒为单位计算
+func checkWindow(limit int, window time.Duration) error {
+	if limit <= 0 || window < time.Microsecond {
+		return fmt.Errorf("%w: 限额%d需要大于0，窗口%v不能小于1微秒", ErrInvalidConfig, limit, window)
+	}
+	return nil
+}
+
+func (b *TokenBucket) Allow(ctx context.Context, key string) (RateLimitResult, error) {
+	return b.AllowN(ctx, key, 1)
+}
+
+func (b *TokenBucket) AllowN(ctx context.Context, key string, n int) (RateLimitResult, error) {
+	return runLimitScript(ctx, b.rds, tokenBucketScript, b.prefix+key, int64(b.burst), b.rate, b.burst, n)
+}
+
+// FixedWindow 固定窗口：每个窗口内最多Limit次，窗口边界附近可能出现两倍的突发
+type FixedWindow struct {
+	rds    redis.UniversalClient
+	prefix string
+	limit  int
+	window time.Duration
+}
+
+// NewFixedWindow 创建固定窗口限流器，prefix为空时使用"ratelimit:"；limit需要大于0，window不能小于1微秒
+func NewFixedWindow(rds redis.UniversalClient, prefix string, limit int, window time.Duration) (*FixedWindow, error) {
+	if err := checkWindow(limit, window); err != nil {
+		return nil, err
+	}
+	if prefix == "" {
+		prefix = defaultRateLimitPrefix
+	}
+	return &FixedWindow{rds: rds, prefix: prefix, limit: limit, window: window}, nil
+}
+
+func (w *FixedWindow) Allow(ctx context.Context, key string) (RateLimitResult, error) {
+	return w.AllowN(ctx, key, 1)
+}
+
+func (w *FixedWindow) AllowN(ctx context.Context, key string, n int) (RateLimitResult, error) {
+	return runLimitScript(ctx, w.rds, fixedWindowScript, w.prefix+key, int64(w.limit), w.limit, w.window.Microseconds(), n)
+}
+
+// SlidingLog 滑动窗口日志：记录每次请求的时间，任意Window长度内最多Limit次，精确但每个请求占用一个zset成员
+type SlidingLog struct {
+	rds    redis.UniversalClient
+	prefix string
+	limit  int
+	window time.Duration
+}
+
+// NewSlidingLog 创建滑动窗口日志限流器，prefix为空时使用"ratelimit:"；limit需要大于0，window不能小于1微秒
+func NewSlidingLog(rds redis.UniversalClient, prefix string, limit int, window time.Duration) (*SlidingLog, error) {
+	if err := checkWindow(limit, window); err != nil {
+		return nil, err
+	}
+	if prefix == "" {
+		prefix = defaultRateLimitPrefix
+	}
+	return &SlidingLog{rds: rds, prefix: prefix, limit: limit, window: window}, nil
+}
+
+func (l *SlidingLog) Allow(ctx context.Context, key string) (RateLimitResult, error) {
+	return l.AllowN(ctx, key, 1)
+}
+
+func (l *SlidingLog) AllowN(ctx context.Context, key string, n int) (RateLimitResult, error) {
+	id, err := newLockToken()
+	if err != nil {
+		return RateLimitResult{}, err
+	}
+	return runLimitScript(ctx, l.rds, slidingLogScript, l.prefix+key, int64(l.limit), l.limit, l.window.Microseconds(), n, id)
+}
+
+// RemoteIP 以客户端IP作为限流key
+func RemoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// RateLimitOptions 限流中间件的配置
+type RateLimitOptions struct {
+	// Key 限流key，返回空字符串时不限流；为nil时使用RemoteIP
+	Key func(*http.Request) string
+	// FailClosed Redis出错时为true返回503，否则放行请求
+	FailClosed bool
+	// OnError Redis出错时调用，用于记录日志或指标；为nil时不记录
+	OnError func(r *http.Request, err error)
+}
+
+// RateLimitMiddleware 对每个请求按opts.Key(r)限流
+//
+// 响应中设置X-RateLimit-Limit、X-RateLimit-Remaining和X-RateLimit-Reset（秒），被拒绝时返回429并设置Retry-After；
+// Redis出错时调用OnError，并按FailClosed拒绝或放行请求
+func RateLimitMiddleware(l RateLimiter, opts RateLimitOptions) func(http.Handler) http.Handler {
+	key := opts.Key
+	if key == nil {
+		key = RemoteIP
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			k := key(r)
+			if k == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			res, err := l.Allow(r.Context(), k)
+			if err != nil {
+				if opts.OnError != nil {
+					opts.OnError(r, err)
+				}
+				if opts.FailClosed {
+					http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+					return
+				}
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			h := w.Header()
+			h.Set("X-RateLimit-Limit", strconv.FormatInt(res.Limit, 10))
+			h.Set("X-RateLimit-Remaining", strconv.FormatInt(res.Remaining, 10))
+			h.Set("X-RateLimit-Reset", strconv.FormatInt(ceilSeconds(res.ResetAfter), 10))
+			if !res.Allowed {
+				h.Set("Retry-After", strconv.FormatInt(ceilSeconds(res.RetryAfter), 10))
+				http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func ceilSeconds(d time.Duration) int64 {
+	return int64(math.Ceil(d.Seconds()))
+}
@@ -2,6 +2,7 @@ package mredis
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"time"
 
@@ -9,163 +10,324 @@ import (
 )
 
 // redis客户端操作out-of-box函数
+//
+// XxxContext使用调用方的ctx并返回(值, error)，key不存在时返回ErrNotFound；不带Context后缀的函数使用context.Background()
+// 并忽略错误，仅为兼容保留
 
+// ErrNotFound key或属性不存在
+var ErrNotFound = errors.New("[mouse] -> redis key不存在")
+
+// notFound 将redis.Nil转换为ErrNotFound
+func notFound(err error) error {
+	if errors.Is(err, redis.Nil) {
+		return ErrNotFound
+	}
+	return err
+}
+
+// NewRedisClient 创建连接单机Redis的客户端，需要认证、TLS、哨兵或集群时使用Options.NewClient
 func NewRedisClient(addr string, db int) *redis.Client {
 	return redis.NewClient(&redis.Options{
 		Addr: addr,
-		DB:   0,
+		DB:   db,
 	})
 }
 
+// StrSetContext 设置单个string值，exp为0时不过期
+func StrSetContext(ctx context.Context, rds redis.UniversalClient, key, val string, exp time.Duration) error {
+	return rds.Set(ctx, key, val, exp).Err()
+}
+
+// StrGetContext 获取单个string值
+func StrGetContext(ctx context.Context, rds redis.UniversalClient, key string) (string, error) {
+	v, err := rds.Get(ctx, key).Result()
+	return v, notFound(err)
+}
+
+// KeysContext 查询匹配pattern的keys
+func KeysContext(ctx context.Context, rds redis.UniversalClient, pattern string) ([]string, error) {
+	return rds.Keys(ctx, pattern).Result()
+}
+
+// DelContext 删除keys，返回删除的数量
+func DelContext(ctx context.Context, rds redis.UniversalClient, keys ...string) (int64, error) {
+	return rds.Del(ctx, keys...).Result()
+}
+
+// ListSetAndLpushContext 在列表尾部追加值，返回追加后的长度
+func ListSetAndLpushContext(ctx context.Context, rds redis.UniversalClient, key string, vals ...string) (int64, error) {
+	return rds.RPush(ctx, key, vals).Result()
+}
+
+// ListRangeContext 查找列表[start, end]范围内的值
+func ListRangeContext(ctx context.Context, rds redis.UniversalClient, key string, start, end int64) ([]string, error) {
+	return rds.LRange(ctx, key, start, end).Result()
+}
+
+// ListLenContext 查找列表的长度
+func ListLenContext(ctx context.Context, rds redis.UniversalClient, key string) (int, error) {
+	n, err := rds.LLen(ctx, key).Result()
+	return int(n), err
+}
+
+// ListModifyContext 根据索引值修改列表的值
+func ListModifyContext(ctx context.Context, rds redis.UniversalClient, key string, idx int64, newVal string) error {
+	return rds.LSet(ctx, key, idx, newVal).Err()
+}
+
+// ListLpopContext 列表头移除值并返回，列表为空时返回ErrNotFound
+func ListLpopContext(ctx context.Context, rds redis.UniversalClient, key string) (string, error) {
+	v, err := rds.LPop(ctx, key).Result()
+	return v, notFound(err)
+}
+
+// ListRpopContext 列表尾移除值并返回，列表为空时返回ErrNotFound
+func ListRpopContext(ctx context.Context, rds redis.UniversalClient, key string) (string, error) {
+	v, err := rds.RPop(ctx, key).Result()
+	return v, notFound(err)
+}
+
+// HashSetContext 插入hash值
+func HashSetContext(ctx context.Context, rds redis.UniversalClient, key string, kv map[string]string) error {
+	params := make([]string, 0, len(kv)*2)
+	for k, v := range kv {
+		params = append(params, k, v)
+	}
+	return rds.HSet(ctx, key, params).Err()
+}
+
+// HashGetContext 获取hash中某个属性的值
+func HashGetContext(ctx context.Context, rds redis.UniversalClient, key string, field string) (string, error) {
+	v, err := rds.HGet(ctx, key, field).Result()
+	return v, notFound(err)
+}
+
+// HashGetAllContext 获取hash的所有值，key不存在时返回空map
+func HashGetAllContext(ctx context.Context, rds redis.UniversalClient, key string) (map[string]string, error) {
+	return rds.HGetAll(ctx, key).Result()
+}
+
+// HashKeysContext 获取hash中所有的key
+func HashKeysContext(ctx context.Context, rds redis.UniversalClient, key string) ([]string, error) {
+	return rds.HKeys(ctx, key).Result()
+}
+
+// HashLenContext 获取hash的长度
+func HashLenContext(ctx context.Context, rds redis.UniversalClient, key string) (int, error) {
+	n, err := rds.HLen(ctx, key).Result()
+	return int(n), err
+}
+
+// HashDelContext 删除hash中某些属性的值，返回删除的数量
+func HashDelContext(ctx context.Context, rds redis.UniversalClient, key string, fields ...string) (int64, error) {
+	return rds.HDel(ctx, key, fields...).Result()
+}
+
+// ZSetAddContext 创建/添加zset值，已存在的值不更新score，返回新增的数量
+func ZSetAddContext(ctx context.Context, rds redis.UniversalClient, key string, members map[float64]string) (int64, error) {
+	zs := make([]redis.Z, 0, len(members))
+	for score, mem := range members {
+		zs = append(zs, redis.Z{
+			Score:  score,
+			Member: mem,
+		})
+	}
+	return rds.ZAddArgs(ctx, key, redis.ZAddArgs{
+		NX:      true,
+		Members: zs,
+	}).Result()
+}
+
+// ZCountContext 根据score获取[min, max]范围内值的个数
+func ZCountContext(ctx context.Context, rds redis.UniversalClient, key string, min, max float64) (int, error) {
+	n, err := rds.ZCount(ctx, key, fmt.Sprint(min), fmt.Sprint(max)).Result()
+	return int(n), err
+}
+
+// ZCardContext 获取zset的值的个数
+func ZCardContext(ctx context.Context, rds redis.UniversalClient, key string) (int, error) {
+	n, err := rds.ZCard(ctx, key).Result()
+	return int(n), err
+}
+
+// ZRangeContext 根据score范围获取所有的值
+func ZRangeContext(ctx context.Context, rds redis.UniversalClient, key string, min, max float64) ([]string, error) {
+	return rds.ZRangeArgs(ctx, redis.ZRangeArgs{
+		Key:     key,
+		ByScore: true,
+		Start:   min,
+		Stop:    max,
+	}).Result()
+}
+
 // StrSet 设置单个string值
-func StrSet(rds *redis.Client, key, val string) error {
-	ctx := context.Background()
-	return rds.Set(ctx, key, val, 0).Err()
+//
+// Deprecated: 使用StrSetContext
+func StrSet(rds redis.UniversalClient, key, val string) error {
+	return StrSetContext(context.Background(), rds, key, val, 0)
 }
 
 // StrGet 获取单个string值
-func StrGet(rds *redis.Client, key string) string {
-	ctx := context.Background()
-	return rds.Get(ctx, key).Val()
+//
+// Deprecated: 使用StrGetContext
+func StrGet(rds redis.UniversalClient, key string) string {
+	v, _ := StrGetContext(context.Background(), rds, key)
+	return v
 }
 
 // Keys 查询keys
-func Keys(rds *redis.Client, query string) []string {
-	ctx := context.Background()
-	return rds.Keys(ctx, query).Val()
+//
+// Deprecated: 使用KeysContext
+func Keys(rds redis.UniversalClient, query string) []string {
+	v, _ := KeysContext(context.Background(), rds, query)
+	return v
 }
 
-// Del 获取单个string值
-func Del(rds *redis.Client, keys ...string) error {
-	ctx := context.Background()
-	return rds.Del(ctx, keys...).Err()
+// Del 删除keys
+//
+// Deprecated: 使用DelContext
+func Del(rds redis.UniversalClient, keys ...string) error {
+	_, err := DelContext(context.Background(), rds, keys...)
+	return err
 }
 
 // Lock 获取锁
-func Lock(rds *redis.Client, key string, exp time.Duration) bool {
+//
+// Deprecated: 任何调用方都可以释放该锁，使用NewMutex
+func Lock(rds redis.UniversalClient, key string, exp time.Duration) bool {
 	ctx := context.Background()
 	return rds.SetNX(ctx, key, "", exp).Val()
 }
 
 // UnLock 释放锁
-func UnLock(rds *redis.Client, key string) error {
+//
+// Deprecated: 使用Mutex.Unlock
+func UnLock(rds redis.UniversalClient, key string) error {
 	return Del(rds, key)
 }
 
-// ListSet 创建一个列表
-func ListSetAndLpush(rds *redis.Client, key string, vals ...string) error {
-	ctx := context.Background()
-	return rds.RPush(ctx, key, vals).Err()
+// ListSetAndLpush 创建一个列表
+//
+// Deprecated: 使用ListSetAndLpushContext
+func ListSetAndLpush(rds redis.UniversalClient, key string, vals ...string) error {
+	_, err := ListSetAndLpushContext(context.Background(), rds, key, vals...)
+	return err
 }
 
 // ListRange 查找列表的值
-func ListRange(rds *redis.Client, key string, start, end int64) []string {
-	ctx := context.Background()
-	return rds.LRange(ctx, key, start, end).Val()
+//
+// Deprecated: 使用ListRangeContext
+func ListRange(rds redis.UniversalClient, key string, start, end int64) []string {
+	v, _ := ListRangeContext(context.Background(), rds, key, start, end)
+	return v
 }
 
-// ListLen 查找列表的长度
-func ListLen(rds *redis.Client, key string, start, end int64) int {
-	ctx := context.Background()
-	return int(rds.LLen(ctx, key).Val())
+// ListLen 查找列表的长度，start和end不使用
+//
+// Deprecated: 使用ListLenContext
+func ListLen(rds redis.UniversalClient, key string, start, end int64) int {
+	v, _ := ListLenContext(context.Background(), rds, key)
+	return v
 }
 
 // ListModify 根据索引值修改列表的值
-func ListModify(rds *redis.Client, key string, idx int64, newVal string) error {
-	ctx := context.Background()
-	return rds.LSet(ctx, key, idx, newVal).Err()
+//
+// Deprecated: 使用ListModifyContext
+func ListModify(rds redis.UniversalClient, key string, idx int64, newVal string) error {
+	return ListModifyContext(context.Background(), rds, key, idx, newVal)
 }
 
 // ListLpop 列表头移除值并返回
-func ListLpop(rds *redis.Client, key string) string {
-	ctx := context.Background()
-	return rds.LPop(ctx, key).String()
+//
+// Deprecated: 使用ListLpopContext
+func ListLpop(rds redis.UniversalClient, key string) string {
+	v, _ := ListLpopContext(context.Background(), rds, key)
+	return v
 }
 
 // ListRpop 列表尾移除值并返回
-func ListRpop(rds *redis.Client, key string) string {
-	ctx := context.Background()
-	return rds.RPop(ctx, key).String()
+//
+// Deprecated: 使用ListRpopContext
+func ListRpop(rds redis.UniversalClient, key string) string {
+	v, _ := ListRpopContext(context.Background(), rds, key)
+	return v
 }
 
 // HashSet 插入hash值
-func HashSet(rds *redis.Client, key string, kv map[string]string) error {
-	params := make([]string, 0, len(kv)*2)
-	for k, v := range kv {
-		params = append(params, k, v)
-	}
-	ctx := context.Background()
-	return rds.HSet(ctx, key, params).Err()
+//
+// Deprecated: 使用HashSetContext
+func HashSet(rds redis.UniversalClient, key string, kv map[string]string) error {
+	return HashSetContext(context.Background(), rds, key, kv)
 }
 
 // HashGet 获取hash中某个属性的值
-func HashGet(rds *redis.Client, key string, field string) string {
-	ctx := context.Background()
-	return rds.HGet(ctx, key, field).String()
+//
+// Deprecated: 使用HashGetContext
+func HashGet(rds redis.UniversalClient, key string, field string) string {
+	v, _ := HashGetContext(context.Background(), rds, key, field)
+	return v
 }
 
 // HashGetAll 获取hash的所有值
-func HashGetAll(rds *redis.Client, key string) map[string]string {
-	ctx := context.Background()
-	return rds.HGetAll(ctx, key).Val()
+//
+// Deprecated: 使用HashGetAllContext
+func HashGetAll(rds redis.UniversalClient, key string) map[string]string {
+	v, _ := HashGetAllContext(context.Background(), rds, key)
+	return v
 }
 
 // HashKeys 获取hash中所有的key
-func HashKeys(rds *redis.Client, key string) []string {
-	ctx := context.Background()
-	return rds.HKeys(ctx, key).Val()
+//
+// Deprecated: 使用HashKeysContext
+func HashKeys(rds redis.UniversalClient, key string) []string {
+	v, _ := HashKeysContext(context.Background(), rds, key)
+	return v
 }
 
 // HashLen 获取hash的长度
-func HashLen(rds *redis.Client, key string) int {
-	ctx := context.Background()
-	return int(rds.HLen(ctx, key).Val())
+//
+// Deprecated: 使用HashLenContext
+func HashLen(rds redis.UniversalClient, key string) int {
+	v, _ := HashLenContext(context.Background(), rds, key)
+	return v
 }
 
 // HashDel 删除hash中某些属性的值
-func HashDel(rds *redis.Client, key string, fields ...string) error {
-	ctx := context.Background()
-	return rds.HDel(ctx, key, fields...).Err()
+//
+// Deprecated: 使用HashDelContext
+func HashDel(rds redis.UniversalClient, key string, fields ...string) error {
+	_, err := HashDelContext(context.Background(), rds, key, fields...)
+	return err
 }
 
 // ZSetAdd 创建/添加zset值
-func ZSetAdd(rds *redis.Client, key string, members map[float64]string) error {
-	ctx := context.Background()
-	return rds.ZAddArgs(ctx, key, redis.ZAddArgs{
-		NX: true,
-		Members: func(m map[float64]string) []redis.Z {
-			r := make([]redis.Z, 0)
-			for score, mem := range m {
-				r = append(r, redis.Z{
-					Score:  score,
-					Member: mem,
-				})
-			}
-			return r
-		}(members),
-	}).Err()
+//
+// Deprecated: 使用ZSetAddContext
+func ZSetAdd(rds redis.UniversalClient, key string, members map[float64]string) error {
+	_, err := ZSetAddContext(context.Background(), rds, key, members)
+	return err
 }
 
 // ZCount 根据score获取范围内值的个数
-func ZCount(rds *redis.Client, key string, min, max float64) int {
-	mi, mx := fmt.Sprint(min), fmt.Sprint(max)
-	ctx := context.Background()
-	return int(rds.ZCount(ctx, key, mi, mx).Val())
+//
+// Deprecated: 使用ZCountContext
+func ZCount(rds redis.UniversalClient, key string, min, max float64) int {
+	v, _ := ZCountContext(context.Background(), rds, key, min, max)
+	return v
 }
 
 // ZCard 获取zset的值的个数
-func ZCard(rds *redis.Client, key string) int {
-	ctx := context.Background()
-	return int(rds.ZCard(ctx, key).Val())
+//
+// Deprecated: 使用ZCardContext
+func ZCard(rds redis.UniversalClient, key string) int {
+	v, _ := ZCardContext(context.Background(), rds, key)
+	return v
 }
 
 // ZRange 根据score范围获取所有的值
-func ZRange(rds *redis.Client, key string, min, max float64) []string {
-	ctx := context.Background()
-	return rds.ZRangeArgs(ctx, redis.ZRangeArgs{
-		Key:     key,
-		ByScore: true,
-		Start:   min,
-		Stop:    max,
-	}).Val()
+//
+// Deprecated: 使用ZRangeContext
+func ZRange(rds redis.UniversalClient, key string, min, max float64) []string {
+	v, _ := ZRangeContext(context.Background(), rds, key, min, max)
+	return v
 }
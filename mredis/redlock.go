@@ -0,0 +1,209 @@
+package mredis
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v9"
+)
+
+// Redlock：在N个相互独立的Redis节点上获取同一把锁，多数节点成功且剩余有效时间大于0时视为获取成功，
+// 少于半数的节点故障时锁仍然可用
+
+const (
+	// redlockDriftFactor 时钟漂移占TTL的比例
+	redlockDriftFactor = 0.01
+	// redlockDriftMin 固定的时钟漂移
+	redlockDriftMin = 2 * time.Millisecond
+)
+
+// Redlock 多节点分布式锁，同一个Redlock不能被并发地多次获取
+type Redlock struct {
+	clients []redis.UniversalClient
+	key     string
+	opts    LockOptions
+	quorum  int
+
+	mu    sync.Mutex
+	token string
+	until time.Time
+	wd    *watchdog
+	lost  chan struct{}
+}
+
+// NewRedlock 创建在clients上以key为锁名的分布式锁，clients应该是相互独立的主节点（不是同一个集群的主从）
+func NewRedlock(clients []redis.UniversalClient, key string, opts LockOptions) *Redlock {
+	return &Redlock{
+		clients: clients,
+		key:     key,
+		opts:    opts.withDefaults(),
+		quorum:  len(clients)/2 + 1,
+	}
+}
+
+// nodeTimeout 单个节点的请求超时，远小于TTL，避免在故障节点上等待过久
+func (l *Redlock) nodeTimeout() time.Duration {
+	return l.opts.TTL / 10
+}
+
+// drift 时钟漂移
+func (l *Redlock) drift(ttl time.Duration) time.Duration {
+	return time.Duration(float64(ttl)*redlockDriftFactor) + redlockDriftMin
+}
+
+// each 并发地在所有节点上执行fn，返回成功的节点数和第一个错误
+func (l *Redlock) each(ctx context.Context, fn func(ctx context.Context, c redis.UniversalClient) (bool, error)) (int, error) {
+	var (
+		mu       sync.Mutex
+		wg       sync.WaitGroup
+		n        int
+		firstErr error
+	)
+	for _, c := range l.clients {
+		wg.Add(1)
+		go func(c redis.UniversalClient) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(ctx, l.nodeTimeout())
+			defer cancel()
+			ok, err := fn(ctx, c)
+			mu.Lock()
+			defer mu.Unlock()
+			if ok {
+				n++
+			}
+			if err != nil && firstErr == nil {
+				firstErr = err
+			}
+		}(c)
+	}
+	wg.Wait()
+	return n, firstErr
+}
+
+// TryLock 尝试在所有节点上获取一次锁，没有获取到多数节点或剩余有效时间不足时释放已获取的节点并返回false；
+// 没有节点成功且有节点出错时返回错误
+func (l *Redlock) TryLock(ctx context.Context) (bool, error) {
+	token, err := newLockToken()
+	if err != nil {
+		return false, err
+	}
+
+	start := time.Now()
+	n, err := l.each(ctx, func(ctx context.Context, c redis.UniversalClient) (bool, error) {
+		return c.SetNX(ctx, l.key, token, l.opts.TTL).Result()
+	})
+	validity := l.opts.TTL - time.Since(start) - l.drift(l.opts.TTL)
+	if n < l.quorum || validity <= 0 {
+		l.release(context.Background(), token)
+		if n == 0 && err != nil {
+			return false, err
+		}
+		return false, nil
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.token = token
+	l.until = start.Add(validity)
+	l.lost = make(chan struct{})
+	if l.opts.Watchdog {
+		l.wd = startWatchdog(l.opts.TTL, l.lost, func(ctx context.Context) error {
+			return l.extend(ctx, token, l.opts.TTL)
+		})
+	}
+	return true, nil
+}
+
+// Lock 阻塞获取锁，获取失败时按退避间隔重试，ctx结束时返回ErrLockNotAcquired
+func (l *Redlock) Lock(ctx context.Context) error {
+	return lockWithBackoff(ctx, l.opts, l.TryLock)
+}
+
+// Unlock 在所有节点上释放锁，释放成功的节点少于多数时返回ErrLockNotHeld
+func (l *Redlock) Unlock(ctx context.Context) error {
+	l.mu.Lock()
+	token := l.token
+	l.token = ""
+	l.until = time.Time{}
+	wd := l.wd
+	l.wd = nil
+	l.mu.Unlock()
+
+	wd.Stop()
+	if token == "" {
+		return ErrLockNotHeld
+	}
+	n, err := l.release(ctx, token)
+	if n >= l.quorum {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return ErrLockNotHeld
+}
+
+func (l *Redlock) release(ctx context.Context, token string) (int, error) {
+	return l.each(ctx, func(ctx context.Context, c redis.UniversalClient) (bool, error) {
+		n, err := lockReleaseScript.Run(ctx, c, []string{l.key}, token).Int()
+		return n == 1, err
+	})
+}
+
+// Extend 在所有节点上将过期时间重新设置为ttl，ttl为0时使用LockOptions.TTL；续期成功的节点少于多数时返回ErrLockNotHeld
+func (l *Redlock) Extend(ctx context.Context, ttl time.Duration) error {
+	l.mu.Lock()
+	token := l.token
+	l.mu.Unlock()
+	if token == "" {
+		return ErrLockNotHeld
+	}
+	if ttl <= 0 {
+		ttl = l.opts.TTL
+	}
+	return l.extend(ctx, token, ttl)
+}
+
+func (l *Redlock) extend(ctx context.Context, token string, ttl time.Duration) error {
+	start := time.Now()
+	n, err := l.each(ctx, func(ctx context.Context, c redis.UniversalClient) (bool, error) {
+		n, err := lockExtendScript.Run(ctx, c, []string{l.key}, token, ttl.Milliseconds()).Int()
+		return n == 1, err
+	})
+	validity := ttl - time.Since(start) - l.drift(ttl)
+	if n < l.quorum || validity <= 0 {
+		if n == 0 && err != nil {
+			return err
+		}
+		return ErrLockNotHeld
+	}
+
+	l.mu.Lock()
+	if l.token == token {
+		l.until = start.Add(validity)
+	}
+	l.mu.Unlock()
+	return nil
+}
+
+// Validity 锁的有效截止时间，扣除了获取耗时和时钟漂移，未持有时为零值
+func (l *Redlock) Validity() time.Time {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.until
+}
+
+// Token 当前持有者的token，未持有时为空
+func (l *Redlock) Token() string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.token
+}
+
+// Lost 自动续期失败时关闭，未持有锁时返回nil
+func (l *Redlock) Lost() <-chan struct{} {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.lost
+}
@@ -0,0 +1,366 @@
+package mredis
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v9"
+)
+
+// 基于Redis Streams的轻量队列：XADD发布，消费者组通过XREADGROUP读取，处理成功后XACK；
+// 消费者崩溃或处理失败后留在待确认列表中的消息，空闲超过MinIdle后由其他消费者通过XAUTOCLAIM接管，
+// 投递次数超过MaxRetries+1的消息不再处理，移入死信stream
+
+const (
+	defaultStreamCount         = 10
+	defaultStreamBlock         = 2 * time.Second
+	defaultStreamMinIdle       = time.Minute
+	defaultStreamClaimInterval = 30 * time.Second
+	defaultStreamMaxRetries    = 3
+	streamRetryMin             = 100 * time.Millisecond
+	streamRetryMax             = 10 * time.Second
+)
+
+const (
+	// StreamFieldDeadID 死信消息中记录原消息ID的字段
+	StreamFieldDeadID = "x-dead-id"
+	// StreamFieldDeadDeliveries 死信消息中记录原消息投递次数的字段
+	StreamFieldDeadDeliveries = "x-dead-deliveries"
+)
+
+// StreamProducer 向stream发布消息
+type StreamProducer struct {
+	rds    redis.UniversalClient
+	stream string
+	maxLen int64
+}
+
+// NewStreamProducer 创建发布者，maxLen大于0时发布时近似地裁剪到maxLen条（MAXLEN ~）
+func NewStreamProducer(rds redis.UniversalClient, stream string, maxLen int64) *StreamProducer {
+	return &StreamProducer{rds: rds, stream: stream, maxLen: maxLen}
+}
+
+// Publish 发布消息，返回消息ID
+func (p *StreamProducer) Publish(ctx context.Context, values map[string]any) (string, error) {
+	return p.rds.XAdd(ctx, &redis.XAddArgs{
+		Stream: p.stream,
+		MaxLen: p.maxLen,
+		Approx: p.maxLen > 0,
+		Values: values,
+	}).Result()
+}
+
+// StreamHandler 处理一条消息，返回nil时消息被确认，否则留在待确认列表中等待重新投递
+type StreamHandler func(ctx context.Context, msg redis.XMessage) error
+
+// StreamConsumerConfig 消费者组配置
+type StreamConsumerConfig struct {
+	Stream string
+	Group  string
+	// Consumer 消费者名，同一个组内唯一，默认为主机名-进程号；重启后使用相同的名字可以先处理自己未确认的消息
+	Consumer string
+	// StartID 创建消费者组时的起始ID，默认为"$"只消费之后发布的消息，"0"从头消费
+	StartID string
+	// Count 每次读取的最大消息数，默认10
+	Count int64
+	// Block 没有消息时的阻塞时间，默认2秒，也是Run响应ctx取消的最长延迟
+	Block time.Duration
+	// MinIdle 其他消费者的消息空闲超过该时间后被接管，默认1分钟
+	MinIdle time.Duration
+	// ClaimInterval 检查需要接管的消息的间隔，默认30秒
+	ClaimInterval time.Duration
+	// MaxRetries 处理失败后最多重新投递的次数，超过后移入DeadStream，默认3，小于0时不重新投递
+	MaxRetries int
+	// DeadStream 死信stream，默认为Stream+":dead"；死信消息保留原消息的字段，
+	// 并在StreamFieldDeadID和StreamFieldDeadDeliveries中记录原消息ID和投递次数
+	DeadStream string
+	// OnError Run中Redis出错时调用，用于记录日志或指标；为nil时不记录
+	OnError func(err error)
+}
+
+// StreamConsumer 消费者组中的一个消费者
+type StreamConsumer struct {
+	rds  redis.UniversalClient
+	conf StreamConsumerConfig
+	h    StreamHandler
+}
+
+// NewStreamConsumer 创建消费者
+func NewStreamConsumer(rds redis.UniversalClient, conf StreamConsumerConfig, h StreamHandler) *StreamConsumer {
+	if conf.Consumer == "" {
+		host, _ := os.Hostname()
+		conf.Consumer = fmt.Sprintf("%s-%d", host, os.Getpid())
+	}
+	if conf.StartID == "" {
+		conf.StartID = "$"
+	}
+	if conf.Count <= 0 {
+		conf.Count = defaultStreamCount
+	}
+	if conf.Block <= 0 {
+		conf.Block = defaultStreamBlock
+	}
+	if conf.MinIdle <= 0 {
+		conf.MinIdle = defaultStreamMinIdle
+	}
+	if conf.ClaimInterval <= 0 {
+		conf.ClaimInterval = defaultStreamClaimInterval
+	}
+	if conf.MaxRetries < 0 {
+		conf.MaxRetries = 0
+	} else if conf.MaxRetries == 0 {
+		conf.MaxRetries = defaultStreamMaxRetries
+	}
+	if conf.DeadStream == "" {
+		conf.DeadStream = conf.Stream + ":dead"
+	}
+	return &StreamConsumer{rds: rds, conf: conf, h: h}
+}
+
+// EnsureGroup 创建消费者组，stream不存在时一并创建，消费者组已存在时不做任何事
+func (c *StreamConsumer) EnsureGroup(ctx context.Context) error {
+	err := c.rds.XGroupCreateMkStream(ctx, c.conf.Stream, c.conf.Group, c.conf.StartID).Err()
+	if err != nil && strings.HasPrefix(err.Error(), "BUSYGROUP") {
+		return nil
+	}
+	return err
+}
+
+// Run 创建消费者组并循环消费，直到ctx结束
+//
+// 启动时先处理自己未确认的消息，之后每ClaimInterval接管一次其他消费者空闲的消息；
+// Redis出错时调用OnError，等待100毫秒到10秒之间翻倍的时间后从出错的步骤重试，不会退出
+func (c *StreamConsumer) Run(ctx context.Context) error {
+	var (
+		st      streamRunState
+		backoff time.Duration
+	)
+	for ctx.Err() == nil {
+		err := c.step(ctx, &st)
+		if err == nil {
+			backoff = 0
+			continue
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		if c.conf.OnError != nil {
+			c.conf.OnError(err)
+		}
+		// 消费者组被删除（如Redis故障切换后数据丢失）时重新创建
+		if strings.HasPrefix(err.Error(), "NOGROUP") {
+			st.ready = false
+		}
+		if backoff *= 2; backoff < streamRetryMin {
+			backoff = streamRetryMin
+		} else if backoff > streamRetryMax {
+			backoff = streamRetryMax
+		}
+		t := time.NewTimer(backoff)
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			t.Stop()
+		}
+	}
+	return nil
+}
+
+// streamRunState Run的进度，出错重试时从未完成的步骤继续
+type streamRunState struct {
+	ready     bool
+	nextClaim time.Time
+}
+
+// step 执行Run的一轮：首次创建消费者组并处理自己未确认的消息，到期时接管空闲的消息，然后读取并处理一批新消息
+func (c *StreamConsumer) step(ctx context.Context, st *streamRunState) error {
+	if !st.ready {
+		if err := c.EnsureGroup(ctx); err != nil {
+			return err
+		}
+		if err := c.drainOwn(ctx); err != nil {
+			return err
+		}
+		st.ready = true
+	}
+	if !time.Now().Before(st.nextClaim) {
+		if _, err := c.Reclaim(ctx); err != nil {
+			return err
+		}
+		st.nextClaim = time.Now().Add(c.conf.ClaimInterval)
+	}
+
+	streams, err := c.rds.XReadGroup(ctx, &redis.XReadGroupArgs{
+		Group:    c.conf.Group,
+		Consumer: c.conf.Consumer,
+		Streams:  []string{c.conf.Stream, ">"},
+		Count:    c.conf.Count,
+		Block:    c.conf.Block,
+	}).Result()
+	if errors.Is(err, redis.Nil) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for _, s := range streams {
+		if err := c.handle(ctx, s.Messages, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// stopErr ctx结束导致的错误视为正常退出
+func stopErr(ctx context.Context, err error) error {
+	if ctx.Err() != nil {
+		return nil
+	}
+	return err
+}
+
+// drainOwn 处理已投递给自己但未确认的消息，处理失败的消息留在待确认列表中
+func (c *StreamConsumer) drainOwn(ctx context.Context) error {
+	start := "0"
+	for {
+		streams, err := c.rds.XReadGroup(ctx, &redis.XReadGroupArgs{
+			Group:    c.conf.Group,
+			Consumer: c.conf.Consumer,
+			Streams:  []string{c.conf.Stream, start},
+			Count:    c.conf.Count,
+		}).Result()
+		if errors.Is(err, redis.Nil) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if len(streams) == 0 || len(streams[0].Messages) == 0 {
+			return nil
+		}
+		msgs := streams[0].Messages
+		deliveries, err := c.deliveries(ctx, msgs)
+		if err != nil {
+			return err
+		}
+		if err := c.handle(ctx, msgs, deliveries); err != nil {
+			return err
+		}
+		start = msgs[len(msgs)-1].ID
+	}
+}
+
+// Reclaim 接管空闲超过MinIdle的消息并处理，投递次数超过MaxRetries+1的消息移入死信stream，返回接管的消息数
+func (c *StreamConsumer) Reclaim(ctx context.Context) (int, error) {
+	start := "0-0"
+	total := 0
+	for {
+		msgs, next, err := c.rds.XAutoClaim(ctx, &redis.XAutoClaimArgs{
+			Stream:   c.conf.Stream,
+			Group:    c.conf.Group,
+			Consumer: c.conf.Consumer,
+			MinIdle:  c.conf.MinIdle,
+			Start:    start,
+			Count:    c.conf.Count,
+		}).Result()
+		if err != nil {
+			return total, err
+		}
+		total += len(msgs)
+		deliveries, err := c.deliveries(ctx, msgs)
+		if err != nil {
+			return total, err
+		}
+		if err := c.handle(ctx, msgs, deliveries); err != nil {
+			return total, err
+		}
+		if next == "0-0" || next == "" {
+			return total, nil
+		}
+		start = next
+	}
+}
+
+// deliveries 通过XPENDING查询自己待确认的消息的投递次数，msgs需按ID排序
+func (c *StreamConsumer) deliveries(ctx context.Context, msgs []redis.XMessage) (map[string]int64, error) {
+	if len(msgs) == 0 {
+		return nil, nil
+	}
+	ps, err := c.rds.XPendingExt(ctx, &redis.XPendingExtArgs{
+		Stream:   c.conf.Stream,
+		Group:    c.conf.Group,
+		Start:    msgs[0].ID,
+		End:      msgs[len(msgs)-1].ID,
+		Count:    int64(len(msgs)),
+		Consumer: c.conf.Consumer,
+	}).Result()
+	if err != nil {
+		return nil, err
+	}
+	r := make(map[string]int64, len(ps))
+	for _, p := range ps {
+		r[p.ID] = p.RetryCount
+	}
+	return r, nil
+}
+
+// handle 依次处理消息并确认成功的消息，消息已被删除时（XAUTOCLAIM返回的空消息）直接确认；
+// deliveries中投递次数超过MaxRetries+1的消息不再处理，移入死信stream后确认
+func (c *StreamConsumer) handle(ctx context.Context, msgs []redis.XMessage, deliveries map[string]int64) error {
+	acks := make([]string, 0, len(msgs))
+	for _, m := range msgs {
+		if ctx.Err() != nil {
+			break
+		}
+		if n := deliveries[m.ID]; m.Values != nil && n > int64(c.conf.MaxRetries)+1 {
+			if err := c.deadLetter(ctx, m, n); err != nil {
+				c.Ack(ctx, acks...)
+				return err
+			}
+			acks = append(acks, m.ID)
+			continue
+		}
+		if m.Values == nil || c.h(ctx, m) == nil {
+			acks = append(acks, m.ID)
+		}
+	}
+	// 退出时仍确认已处理的消息，避免重复投递
+	if ctx.Err() != nil {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+	}
+	return c.Ack(ctx, acks...)
+}
+
+// deadLetter 将消息写入死信stream
+func (c *StreamConsumer) deadLetter(ctx context.Context, m redis.XMessage, deliveries int64) error {
+	values := make(map[string]any, len(m.Values)+2)
+	for k, v := range m.Values {
+		values[k] = v
+	}
+	values[StreamFieldDeadID] = m.ID
+	values[StreamFieldDeadDeliveries] = deliveries
+	return c.rds.XAdd(ctx, &redis.XAddArgs{Stream: c.conf.DeadStream, Values: values}).Err()
+}
+
+// Ack 确认消息
+func (c *StreamConsumer) Ack(ctx context.Context, ids ...string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	return c.rds.XAck(ctx, c.conf.Stream, c.conf.Group, ids...).Err()
+}
+
+// Pending 消费者组中未确认的消息数
+func (c *StreamConsumer) Pending(ctx context.Context) (int64, error) {
+	p, err := c.rds.XPending(ctx, c.conf.Stream, c.conf.Group).Result()
+	if err != nil {
+		return 0, err
+	}
+	return p.Count, nil
+}
@@ -0,0 +1,210 @@
+package mredis_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/mouseleee/mlib/mredis"
+)
+
+type cachedUser struct {
+	ID   int
+	Name string
+	Tags []string
+}
+
+func TestCacheCodecs(t *testing.T) {
+	mr := miniredis.RunT(t)
+	c := mredis.NewRedisClient(mr.Addr(), 0)
+	ctx := context.Background()
+
+	codecs := map[string]mredis.Codec{"json": mredis.JSONCodec, "msgpack": mredis.MsgpackCodec, "gob": mredis.GobCodec}
+	for name, codec := range codecs {
+		cache := mredis.NewCache[cachedUser](c, mredis.CacheOptions{Prefix: name + ":", TTL: time.Minute, Codec: codec})
+		u := cachedUser{ID: 1, Name: "mouse", Tags: []string{"a", "b"}}
+		if err := cache.Set(ctx, "1", u); err != nil {
+			t.Fatal(name, err)
+		}
+		if !mr.Exists(name + ":1") {
+			t.Errorf("%s: key没有前缀", name)
+		}
+		got, err := cache.Get(ctx, "1")
+		if err != nil || got.Name != u.Name || len(got.Tags) != 2 {
+			t.Errorf("%s: got %+v, err = %v", name, got, err)
+		}
+		if _, err := cache.Get(ctx, "2"); !errors.Is(err, mredis.ErrNotFound) {
+			t.Errorf("%s: missing err = %v", name, err)
+		}
+	}
+}
+
+func TestCacheTTLJitter(t *testing.T) {
+	mr := miniredis.RunT(t)
+	c := mredis.NewRedisClient(mr.Addr(), 0)
+	ctx := context.Background()
+
+	cache := mredis.NewCache[int](c, mredis.CacheOptions{TTL: time.Minute, Jitter: 0.5})
+	items := make(map[string]int)
+	for i := 0; i < 20; i++ {
+		items[string(rune('a'+i))] = i
+	}
+	if err := cache.SetMany(ctx, items); err != nil {
+		t.Fatal(err)
+	}
+	distinct := make(map[time.Duration]bool)
+	for k := range items {
+		ttl := mr.TTL(k)
+		if ttl < time.Minute || ttl >= 90*time.Second {
+			t.Errorf("%s ttl = %v", k, ttl)
+		}
+		distinct[ttl] = true
+	}
+	if len(distinct) < 2 {
+		t.Error("过期时间没有抖动")
+	}
+
+	if err := cache.SetTTL(ctx, "x", 1, 0); err != nil {
+		t.Fatal(err)
+	}
+	if ttl := mr.TTL("x"); ttl != 0 {
+		t.Errorf("不过期的ttl = %v", ttl)
+	}
+}
+
+func TestCacheMany(t *testing.T) {
+	mr := miniredis.RunT(t)
+	c := mredis.NewRedisClient(mr.Addr(), 0)
+	ctx := context.Background()
+
+	cache := mredis.NewCache[string](c, mredis.CacheOptions{Prefix: "s:"})
+	if err := cache.SetMany(ctx, map[string]string{"a": "1", "b": "2"}); err != nil {
+		t.Fatal(err)
+	}
+	got, err := cache.GetMany(ctx, []string{"a", "b", "c"})
+	if err != nil || len(got) != 2 || got["a"] != "1" || got["b"] != "2" {
+		t.Errorf("got %v, err = %v", got, err)
+	}
+	if err := cache.Delete(ctx, "a", "c"); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := cache.GetMany(ctx, []string{"a", "b"}); len(got) != 1 {
+		t.Errorf("after delete %v", got)
+	}
+	// 无法解码的值返回错误
+	mr.Set("s:bad", "{")
+	if _, err := cache.Get(ctx, "bad"); err == nil {
+		t.Error("解码错误没有返回")
+	}
+}
+
+func TestCacheGetOrLoad(t *testing.T) {
+	mr := miniredis.RunT(t)
+	c := mredis.NewRedisClient(mr.Addr(), 0)
+	ctx := context.Background()
+
+	cache := mredis.NewCache[cachedUser](c, mredis.CacheOptions{Prefix: "u:", TTL: time.Minute})
+	var calls int32
+	release := make(chan struct{})
+	loader := func(ctx context.Context) (cachedUser, error) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		return cachedUser{ID: 7, Name: "loaded"}, nil
+	}
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			u, err := cache.GetOrLoad(ctx, "7", loader)
+			if err != nil || u.Name != "loaded" {
+				t.Errorf("u = %+v, err = %v", u, err)
+			}
+		}()
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	if calls != 1 {
+		t.Errorf("loader called %d times", calls)
+	}
+	if !mr.Exists("u:7") {
+		t.Error("加载的值没有写入缓存")
+	}
+
+	// 命中时不调用loader
+	if _, err := cache.GetOrLoad(ctx, "7", loader); err != nil || calls != 1 {
+		t.Errorf("calls = %d, err = %v", calls, err)
+	}
+
+	// loader的错误被返回且不写入缓存
+	errLoad := errors.New("load failed")
+	if _, err := cache.GetOrLoad(ctx, "8", func(ctx context.Context) (cachedUser, error) {
+		return cachedUser{}, errLoad
+	}); !errors.Is(err, errLoad) {
+		t.Errorf("err = %v", err)
+	}
+	if mr.Exists("u:8") {
+		t.Error("失败的加载被缓存")
+	}
+
+	// 无法解码的旧值视为未命中并被覆盖
+	mr.Set("u:9", "not json")
+	if u, err := cache.GetOrLoad(ctx, "9", func(ctx context.Context) (cachedUser, error) {
+		return cachedUser{ID: 9}, nil
+	}); err != nil || u.ID != 9 {
+		t.Errorf("u = %+v, err = %v", u, err)
+	}
+	if u, err := cache.Get(ctx, "9"); err != nil || u.ID != 9 {
+		t.Errorf("u = %+v, err = %v", u, err)
+	}
+}
+
+func TestCacheGetOrLoadCancel(t *testing.T) {
+	mr := miniredis.RunT(t)
+	c := mredis.NewRedisClient(mr.Addr(), 0)
+	cache := mredis.NewCache[cachedUser](c, mredis.CacheOptions{Prefix: "u:"})
+
+	started, release := make(chan struct{}), make(chan struct{})
+	loadErr := make(chan error, 1)
+	loader := func(ctx context.Context) (cachedUser, error) {
+		close(started)
+		<-release
+		loadErr <- ctx.Err()
+		return cachedUser{ID: 7}, nil
+	}
+
+	// 发起加载的调用方取消后立即返回，不影响其他调用方
+	ctx, cancel := context.WithCancel(context.Background())
+	first := make(chan error, 1)
+	go func() {
+		_, err := cache.GetOrLoad(ctx, "7", loader)
+		first <- err
+	}()
+	<-started
+	second := make(chan cachedUser, 1)
+	go func() {
+		u, _ := cache.GetOrLoad(context.Background(), "7", loader)
+		second <- u
+	}()
+
+	cancel()
+	if err := <-first; !errors.Is(err, context.Canceled) {
+		t.Errorf("err = %v", err)
+	}
+	close(release)
+	if err := <-loadErr; err != nil {
+		t.Errorf("loader ctx err = %v", err)
+	}
+	if u := <-second; u.ID != 7 {
+		t.Errorf("u = %+v", u)
+	}
+	if u, err := cache.Get(context.Background(), "7"); err != nil || u.ID != 7 {
+		t.Errorf("u = %+v, err = %v", u, err)
+	}
+}
@@ -0,0 +1,59 @@
+package mredis_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/mouseleee/mlib/mredis"
+)
+
+func TestDedupStore(t *testing.T) {
+	mr := miniredis.RunT(t)
+	c := mredis.NewRedisClient(mr.Addr(), 0)
+	s := mredis.NewDedupStore(c, "")
+	ctx := context.Background()
+
+	acquired, done, err := s.Begin(ctx, "k1", time.Minute)
+	if err != nil || !acquired || done {
+		t.Fatalf("acquired = %v, done = %v, err = %v", acquired, done, err)
+	}
+	// 处理中
+	if acquired, done, _ = s.Begin(ctx, "k1", time.Minute); acquired || done {
+		t.Errorf("acquired = %v, done = %v", acquired, done)
+	}
+
+	if err := s.Commit(ctx, "k1", time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if acquired, done, _ = s.Begin(ctx, "k1", time.Minute); acquired || !done {
+		t.Errorf("acquired = %v, done = %v", acquired, done)
+	}
+	if ttl := mr.TTL("dedup:k1"); ttl != time.Hour {
+		t.Errorf("ttl = %v", ttl)
+	}
+	// 已完成的记录不会被Abort删除
+	if err := s.Abort(ctx, "k1"); err != nil {
+		t.Fatal(err)
+	}
+	if !mr.Exists("dedup:k1") {
+		t.Error("已完成的记录被删除")
+	}
+
+	// 释放后可以重新占用
+	s.Begin(ctx, "k2", time.Minute)
+	if err := s.Abort(ctx, "k2"); err != nil {
+		t.Fatal(err)
+	}
+	if acquired, _, _ = s.Begin(ctx, "k2", time.Minute); !acquired {
+		t.Error("释放后无法占用")
+	}
+
+	// 占用过期
+	s.Begin(ctx, "k3", time.Second)
+	mr.FastForward(2 * time.Second)
+	if acquired, _, _ = s.Begin(ctx, "k3", time.Minute); !acquired {
+		t.Error("过期后无法占用")
+	}
+}
@@ -0,0 +1,163 @@
+package mredis_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/mouseleee/mlib/mredis"
+)
+
+func TestDelayQueue(t *testing.T) {
+	mr := miniredis.RunT(t)
+	c := mredis.NewRedisClient(mr.Addr(), 0)
+	ctx := context.Background()
+	d := mredis.NewDelayQueue(c, "reminders", mredis.DelayQueueOptions{})
+
+	now := time.UnixMilli(1700000000000)
+	a, _ := d.Schedule(ctx, []byte("a"), now.Add(2*time.Minute))
+	b, _ := d.Schedule(ctx, []byte("b"), now.Add(time.Minute))
+	if err := d.ScheduleID(ctx, "c", []byte("c"), now.Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	if n, _ := d.Len(ctx); n != 3 {
+		t.Errorf("len = %d", n)
+	}
+	// 与zset辅助函数共用同一个key
+	if n, _ := mredis.ZCountContext(ctx, c, d.Key(), 0, float64(now.Add(2*time.Minute).UnixMilli())); n != 2 {
+		t.Errorf("count = %d", n)
+	}
+	ids, _ := d.Scheduled(ctx, now, now.Add(10*time.Minute))
+	if fmt.Sprint(ids) != fmt.Sprint([]string{b, a}) {
+		t.Errorf("scheduled = %v", ids)
+	}
+
+	// 未到期时取不到
+	jobs, err := d.Claim(ctx, now)
+	if err != nil || len(jobs) != 0 {
+		t.Fatalf("jobs = %v, err = %v", jobs, err)
+	}
+	jobs, _ = d.Claim(ctx, now.Add(5*time.Minute))
+	if len(jobs) != 2 || jobs[0].ID != b || string(jobs[1].Payload) != "a" || !jobs[1].RunAt.Equal(now.Add(2*time.Minute)) {
+		t.Fatalf("jobs = %+v", jobs)
+	}
+	// 取出后不会被再次取出
+	if jobs, _ := d.Claim(ctx, now.Add(5*time.Minute)); len(jobs) != 0 {
+		t.Errorf("jobs = %v", jobs)
+	}
+	if n, _ := c.HLen(ctx, "delay:{reminders}:jobs").Result(); n != 1 {
+		t.Errorf("payloads = %d", n)
+	}
+}
+
+func TestDelayQueueCancelAndReschedule(t *testing.T) {
+	mr := miniredis.RunT(t)
+	c := mredis.NewRedisClient(mr.Addr(), 0)
+	ctx := context.Background()
+	d := mredis.NewDelayQueue(c, "reminders", mredis.DelayQueueOptions{})
+
+	now := time.UnixMilli(1700000000000)
+	id, _ := d.Schedule(ctx, []byte("a"), now.Add(time.Hour))
+
+	if err := d.Reschedule(ctx, id, now.Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	// 执行时间不变时同样成功
+	if err := d.Reschedule(ctx, id, now.Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if at, err := d.RunAt(ctx, id); err != nil || !at.Equal(now.Add(time.Minute)) {
+		t.Errorf("runAt = %v, err = %v", at, err)
+	}
+	if jobs, _ := d.Claim(ctx, now.Add(time.Minute)); len(jobs) != 1 || string(jobs[0].Payload) != "a" {
+		t.Fatalf("jobs = %v", jobs)
+	}
+	// 已取出的任务无法修改
+	if err := d.Reschedule(ctx, id, now); !errors.Is(err, mredis.ErrNotFound) {
+		t.Errorf("err = %v", err)
+	}
+	if _, err := d.RunAt(ctx, id); !errors.Is(err, mredis.ErrNotFound) {
+		t.Errorf("err = %v", err)
+	}
+
+	id, _ = d.Schedule(ctx, []byte("b"), now)
+	if err := d.Cancel(ctx, id); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Cancel(ctx, id); !errors.Is(err, mredis.ErrNotFound) {
+		t.Errorf("err = %v", err)
+	}
+	if jobs, _ := d.Claim(ctx, now.Add(time.Hour)); len(jobs) != 0 {
+		t.Errorf("jobs = %v", jobs)
+	}
+	if n, _ := c.HLen(ctx, "delay:{reminders}:jobs").Result(); n != 0 {
+		t.Errorf("payloads = %d", n)
+	}
+}
+
+func TestDelayQueueRun(t *testing.T) {
+	mr := miniredis.RunT(t)
+	c := mredis.NewRedisClient(mr.Addr(), 0)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	const total = 300
+	opts := mredis.DelayQueueOptions{PollInterval: 20 * time.Millisecond, Batch: 10, RetryDelay: 10 * time.Millisecond}
+	producer := mredis.NewDelayQueue(c, "jobs", opts)
+	for i := 0; i < total; i++ {
+		producer.ScheduleID(ctx, fmt.Sprint(i), []byte(fmt.Sprint(i)), time.Now().Add(-time.Second))
+	}
+	// 稍后到期的任务
+	producer.ScheduleID(ctx, "late", []byte("late"), time.Now().Add(100*time.Millisecond))
+
+	var (
+		mu     sync.Mutex
+		seen   = make(map[string]int)
+		failed bool
+	)
+	rctx, stop := context.WithCancel(ctx)
+	handler := func(ctx context.Context, job *mredis.DelayedJob) error {
+		mu.Lock()
+		defer mu.Unlock()
+		// 失败的任务重新调度
+		if job.ID == "7" && !failed {
+			failed = true
+			return errors.New("retry")
+		}
+		seen[job.ID]++
+		if len(seen) == total+1 {
+			stop()
+		}
+		return nil
+	}
+
+	// 多个实例同时运行，每个任务只被处理一次
+	wg := sync.WaitGroup{}
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := mredis.NewDelayQueue(c, "jobs", opts).Run(rctx, handler); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if len(seen) != total+1 || !failed {
+		t.Fatalf("seen %d, failed = %v", len(seen), failed)
+	}
+	for id, n := range seen {
+		if n != 1 {
+			t.Errorf("%s handled %d times", id, n)
+		}
+	}
+	if n, _ := producer.Len(ctx); n != 0 {
+		t.Errorf("len = %d", n)
+	}
+}
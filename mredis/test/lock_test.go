@@ -0,0 +1,173 @@
+package mredis_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/mouseleee/mlib/mredis"
+)
+
+func TestMutex(t *testing.T) {
+	mr := miniredis.RunT(t)
+	c := mredis.NewRedisClient(mr.Addr(), 0)
+	ctx := context.Background()
+
+	a := mredis.NewMutex(c, "lock:a", mredis.LockOptions{TTL: time.Minute})
+	b := mredis.NewMutex(c, "lock:a", mredis.LockOptions{TTL: time.Minute})
+
+	if ok, err := a.TryLock(ctx); err != nil || !ok {
+		t.Fatalf("ok = %v, err = %v", ok, err)
+	}
+	if v, _ := mr.Get("lock:a"); v != a.Token() || v == "" {
+		t.Errorf("value = %q, token = %q", v, a.Token())
+	}
+	if ok, _ := b.TryLock(ctx); ok {
+		t.Error("锁被重复获取")
+	}
+	// 其他持有者无法释放和续期
+	if err := b.Unlock(ctx); !errors.Is(err, mredis.ErrLockNotHeld) {
+		t.Errorf("b.Unlock = %v", err)
+	}
+	if err := b.Extend(ctx, time.Hour); !errors.Is(err, mredis.ErrLockNotHeld) {
+		t.Errorf("b.Extend = %v", err)
+	}
+
+	if err := a.Extend(ctx, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if ttl := mr.TTL("lock:a"); ttl != time.Hour {
+		t.Errorf("ttl = %v", ttl)
+	}
+	if err := a.Unlock(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if mr.Exists("lock:a") {
+		t.Error("锁没有释放")
+	}
+	if err := a.Unlock(ctx); !errors.Is(err, mredis.ErrLockNotHeld) {
+		t.Errorf("重复释放 = %v", err)
+	}
+
+	// 过期后被其他持有者获取，原持有者无法释放
+	a.TryLock(ctx)
+	mr.FastForward(2 * time.Minute)
+	if ok, _ := b.TryLock(ctx); !ok {
+		t.Fatal("过期后无法获取")
+	}
+	if err := a.Unlock(ctx); !errors.Is(err, mredis.ErrLockNotHeld) {
+		t.Errorf("过期后释放 = %v", err)
+	}
+	if !mr.Exists("lock:a") {
+		t.Error("释放了其他持有者的锁")
+	}
+}
+
+func TestMutexBlockingLock(t *testing.T) {
+	mr := miniredis.RunT(t)
+	c := mredis.NewRedisClient(mr.Addr(), 0)
+	opts := mredis.LockOptions{TTL: time.Minute, MinBackoff: 5 * time.Millisecond, MaxBackoff: 20 * time.Millisecond}
+
+	holder := mredis.NewMutex(c, "lock:b", opts)
+	if err := holder.Lock(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	m := mredis.NewMutex(c, "lock:b", opts)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := m.Lock(ctx); !errors.Is(err, mredis.ErrLockNotAcquired) {
+		t.Fatalf("Lock = %v", err)
+	}
+
+	go func() {
+		time.Sleep(30 * time.Millisecond)
+		holder.Unlock(context.Background())
+	}()
+	ctx, cancel = context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := m.Lock(ctx); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMutexWatchdog(t *testing.T) {
+	mr := miniredis.RunT(t)
+	c := mredis.NewRedisClient(mr.Addr(), 0)
+	ctx := context.Background()
+
+	m := mredis.NewMutex(c, "lock:w", mredis.LockOptions{TTL: 300 * time.Millisecond, Watchdog: true})
+	if err := m.Lock(ctx); err != nil {
+		t.Fatal(err)
+	}
+	// miniredis的过期时间不随真实时间减少，快进后等待续期恢复
+	mr.FastForward(250 * time.Millisecond)
+	deadline := time.Now().Add(2 * time.Second)
+	for mr.TTL("lock:w") < 100*time.Millisecond {
+		if time.Now().After(deadline) {
+			t.Fatal("没有自动续期")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// 锁被其他持有者占用后通知丢失
+	mr.Set("lock:w", "other")
+	select {
+	case <-m.Lost():
+	case <-time.After(2 * time.Second):
+		t.Fatal("没有通知锁丢失")
+	}
+	if err := m.Unlock(ctx); !errors.Is(err, mredis.ErrLockNotHeld) {
+		t.Errorf("Unlock = %v", err)
+	}
+}
+
+func TestMutexExclusive(t *testing.T) {
+	mr := miniredis.RunT(t)
+	c := mredis.NewRedisClient(mr.Addr(), 0)
+
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		holders int
+		maxSeen int
+		total   int
+	)
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			m := mredis.NewMutex(c, "lock:x", mredis.LockOptions{TTL: time.Minute, MinBackoff: time.Millisecond, MaxBackoff: 5 * time.Millisecond})
+			for j := 0; j < 5; j++ {
+				ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+				err := m.Lock(ctx)
+				cancel()
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				mu.Lock()
+				holders++
+				if holders > maxSeen {
+					maxSeen = holders
+				}
+				total++
+				mu.Unlock()
+				time.Sleep(time.Millisecond)
+				mu.Lock()
+				holders--
+				mu.Unlock()
+				if err := m.Unlock(context.Background()); err != nil {
+					t.Error(err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if maxSeen != 1 || total != 40 {
+		t.Errorf("max holders = %d, total = %d", maxSeen, total)
+	}
+}
@@ -0,0 +1,217 @@
+package mredis_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/mouseleee/mlib/mredis"
+)
+
+func waitInvalidations(t *testing.T, n *mredis.NearCache[string], want int64) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for n.Stats().Invalidations < want {
+		if time.Now().After(deadline) {
+			t.Fatalf("没有收到失效通知，stats = %+v", n.Stats())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestNearCacheInvalidation(t *testing.T) {
+	mr := miniredis.RunT(t)
+	ctx := context.Background()
+	opts := mredis.CacheOptions{Prefix: "n:", TTL: time.Minute}
+
+	a, err := mredis.NewNearCache[string](ctx, mredis.NewRedisClient(mr.Addr(), 0), opts, mredis.NearCacheOptions{TTL: time.Minute})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Close()
+	b, err := mredis.NewNearCache[string](ctx, mredis.NewRedisClient(mr.Addr(), 0), opts, mredis.NearCacheOptions{TTL: time.Minute})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	if err := a.Set(ctx, "k", "v1"); err != nil {
+		t.Fatal(err)
+	}
+	// 等待b处理完这次写入的通知，避免通知晚于下面的读取到达
+	waitInvalidations(t, b, 1)
+	if v, err := b.Get(ctx, "k"); err != nil || v != "v1" {
+		t.Fatalf("v = %q, err = %v", v, err)
+	}
+	// 第二次从本地读取，Redis中的值被直接修改也读不到
+	mr.Set("n:k", `"direct"`)
+	if v, _ := b.Get(ctx, "k"); v != "v1" {
+		t.Errorf("本地副本 = %q", v)
+	}
+	if s := b.Stats(); s.Hits != 1 || s.Misses != 1 || s.Size != 1 || s.Invalidations != 1 {
+		t.Errorf("stats = %+v", s)
+	}
+
+	// a写入后b的本地副本失效
+	if err := a.Set(ctx, "k", "v2"); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		v, _ := b.Get(ctx, "k")
+		if v == "v2" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("没有收到失效通知，v = %q", v)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	// 删除后两边都不存在
+	if err := a.Delete(ctx, "k"); err != nil {
+		t.Fatal(err)
+	}
+	deadline = time.Now().Add(2 * time.Second)
+	for {
+		_, err := b.Get(ctx, "k")
+		if errors.Is(err, mredis.ErrNotFound) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("删除没有通知，err = %v", err)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if _, err := a.Get(ctx, "k"); !errors.Is(err, mredis.ErrNotFound) {
+		t.Errorf("a err = %v", err)
+	}
+}
+
+func TestNearCacheLRU(t *testing.T) {
+	mr := miniredis.RunT(t)
+	ctx := context.Background()
+	c := mredis.NewRedisClient(mr.Addr(), 0)
+
+	n, err := mredis.NewNearCache[int](ctx, c, mredis.CacheOptions{}, mredis.NearCacheOptions{Size: 2, TTL: 50 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer n.Close()
+
+	if err := n.SetMany(ctx, map[string]int{"a": 1, "b": 2}); err != nil {
+		t.Fatal(err)
+	}
+	n.Get(ctx, "a")
+	n.Set(ctx, "c", 3)
+	// b最久没有使用，被淘汰
+	if s := n.Stats(); s.Evictions != 1 || s.Size != 2 {
+		t.Errorf("stats = %+v", s)
+	}
+	got, err := n.GetMany(ctx, []string{"a", "b", "c", "d"})
+	if err != nil || len(got) != 3 {
+		t.Errorf("got %v, err = %v", got, err)
+	}
+
+	// 本地副本过期后从Redis读取
+	mr.Set("a", "10")
+	time.Sleep(60 * time.Millisecond)
+	if v, _ := n.Get(ctx, "a"); v != 10 {
+		t.Errorf("过期后 = %d", v)
+	}
+
+	calls := 0
+	loader := func(ctx context.Context) (int, error) {
+		calls++
+		return 42, nil
+	}
+	n.GetOrLoad(ctx, "x", loader)
+	if v, err := n.GetOrLoad(ctx, "x", loader); err != nil || v != 42 || calls != 1 {
+		t.Errorf("v = %d, calls = %d, err = %v", v, calls, err)
+	}
+
+	n.Purge()
+	if s := n.Stats(); s.Size != 0 {
+		t.Errorf("purge stats = %+v", s)
+	}
+}
+
+func TestNearCacheInFlightRead(t *testing.T) {
+	mr := miniredis.RunT(t)
+	ctx := context.Background()
+	opts := mredis.CacheOptions{Prefix: "n:", TTL: time.Minute}
+	a, err := mredis.NewNearCache[string](ctx, mredis.NewRedisClient(mr.Addr(), 0), opts, mredis.NearCacheOptions{TTL: time.Minute})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Close()
+	b, err := mredis.NewNearCache[string](ctx, mredis.NewRedisClient(mr.Addr(), 0), opts, mredis.NearCacheOptions{TTL: time.Minute})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	// 通过a读取key，load期间由b写入other
+	loadDuring := func(key, other string, want int64) {
+		t.Helper()
+		loader := func(ctx context.Context) (string, error) {
+			if err := b.Set(ctx, other, "x"); err != nil {
+				return "", err
+			}
+			waitInvalidations(t, a, want)
+			return "loaded", nil
+		}
+		if _, err := a.GetOrLoad(ctx, key, loader); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// 其他key的失效不影响读取结果保存到本地
+	loadDuring("k1", "other", 1)
+	a.Get(ctx, "k1")
+	if s := a.Stats(); s.Hits != 1 {
+		t.Errorf("其他key失效后没有保存本地副本，stats = %+v", s)
+	}
+
+	// 同一个key在读取期间失效时不保存
+	loadDuring("k2", "k2", 2)
+	a.Get(ctx, "k2")
+	if s := a.Stats(); s.Hits != 1 {
+		t.Errorf("同一个key失效后保存了本地副本，stats = %+v", s)
+	}
+}
+
+func TestNearCacheConcurrentSet(t *testing.T) {
+	mr := miniredis.RunT(t)
+	ctx := context.Background()
+	n, err := mredis.NewNearCache[int](ctx, mredis.NewRedisClient(mr.Addr(), 0), mredis.CacheOptions{Prefix: "n:"}, mredis.NearCacheOptions{TTL: time.Minute})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer n.Close()
+
+	// 并发写入同一个key后本地副本与Redis一致
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if err := n.Set(ctx, "k", i); err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	remote, err := mr.Get("n:k")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := n.Get(ctx, "k"); fmt.Sprint(v) != remote {
+		t.Errorf("local = %d, redis = %s", v, remote)
+	}
+}
@@ -0,0 +1,436 @@
+package mredis_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/mouseleee/mlib/mredis"
+)
+
+func TestQueue(t *testing.T) {
+	mr := miniredis.RunT(t)
+	c := mredis.NewRedisClient(mr.Addr(), 0)
+	ctx := context.Background()
+	q := mredis.NewQueue(c, "jobs", mredis.QueueOptions{Worker: "w1"})
+
+	for i := 0; i < 3; i++ {
+		if _, err := q.Enqueue(ctx, []byte(fmt.Sprint(i)), 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n, _ := q.Len(ctx); n != 3 {
+		t.Errorf("len = %d", n)
+	}
+
+	// 先进先出
+	job, err := q.Dequeue(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(job.Payload) != "0" || job.Retries != 0 {
+		t.Errorf("job = %+v", job)
+	}
+	// 处理中的任务在工作者自己的列表中
+	if l, _ := mr.List("queue:{jobs}:processing:w1"); len(l) != 1 || l[0] != job.ID {
+		t.Errorf("processing = %v", l)
+	}
+	if err := q.Ack(ctx, job); err != nil {
+		t.Fatal(err)
+	}
+	if l, _ := mr.List("queue:{jobs}:processing:w1"); len(l) != 0 {
+		t.Errorf("processing = %v", l)
+	}
+	if mr.HGet("queue:{jobs}:jobs", job.ID) != "" {
+		t.Error("确认后任务未删除")
+	}
+	if n, _ := q.Len(ctx); n != 2 {
+		t.Errorf("len = %d", n)
+	}
+
+	if _, err := q.Enqueue(ctx, nil, 1); err == nil {
+		t.Error("优先级超出范围")
+	}
+}
+
+func TestQueuePriority(t *testing.T) {
+	mr := miniredis.RunT(t)
+	c := mredis.NewRedisClient(mr.Addr(), 0)
+	ctx := context.Background()
+	q := mredis.NewQueue(c, "jobs", mredis.QueueOptions{Worker: "w1", Priorities: 3})
+
+	q.Enqueue(ctx, []byte("low"), 2)
+	q.Enqueue(ctx, []byte("mid"), 1)
+	q.Enqueue(ctx, []byte("high"), 0)
+	q.Enqueue(ctx, []byte("mid2"), 1)
+
+	var got []string
+	for i := 0; i < 4; i++ {
+		job, err := q.Dequeue(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, string(job.Payload))
+		q.Ack(ctx, job)
+	}
+	if fmt.Sprint(got) != "[high mid mid2 low]" {
+		t.Errorf("got %v", got)
+	}
+}
+
+func TestQueueRetryAndDeadLetter(t *testing.T) {
+	mr := miniredis.RunT(t)
+	c := mredis.NewRedisClient(mr.Addr(), 0)
+	ctx := context.Background()
+	q := mredis.NewQueue(c, "jobs", mredis.QueueOptions{Worker: "w1", MaxRetries: 2, Priorities: 2})
+
+	id, _ := q.Enqueue(ctx, []byte("bad"), 1)
+	for i := 0; i < 3; i++ {
+		job, err := q.Dequeue(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if job.ID != id || job.Retries != i || job.Priority != 1 {
+			t.Fatalf("job = %+v", job)
+		}
+		if err := q.Nack(ctx, job); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// 第3次失败后移入死信列表
+	if n, _ := q.Len(ctx); n != 0 {
+		t.Errorf("len = %d", n)
+	}
+	dead, err := q.Dead(ctx, 0, -1)
+	if err != nil || len(dead) != 1 {
+		t.Fatalf("dead = %v, err = %v", dead, err)
+	}
+	if dead[0].ID != id || string(dead[0].Payload) != "bad" || dead[0].Retries != 3 || dead[0].Priority != 1 {
+		t.Errorf("dead = %+v", dead[0])
+	}
+
+	if err := q.RetryDead(ctx, id); err != nil {
+		t.Fatal(err)
+	}
+	if err := q.RetryDead(ctx, id); !errors.Is(err, mredis.ErrNotFound) {
+		t.Errorf("err = %v", err)
+	}
+	if n, _ := q.DeadLen(ctx); n != 0 {
+		t.Errorf("dead len = %d", n)
+	}
+	job, err := q.Dequeue(ctx)
+	if err != nil || job.ID != id || job.Retries != 0 {
+		t.Fatalf("job = %+v, err = %v", job, err)
+	}
+
+	// Release不计重试，放回队列头部
+	q.Enqueue(ctx, []byte("other"), 1)
+	if err := q.Release(ctx, job); err != nil {
+		t.Fatal(err)
+	}
+	job, _ = q.Dequeue(ctx)
+	if job.ID != id || job.Retries != 0 {
+		t.Errorf("job = %+v", job)
+	}
+}
+
+func TestQueueVisibilityTimeout(t *testing.T) {
+	mr := miniredis.RunT(t)
+	c := mredis.NewRedisClient(mr.Addr(), 0)
+	ctx := context.Background()
+	now := time.Unix(1700000000, 0)
+	mr.SetTime(now)
+
+	opts := mredis.QueueOptions{Worker: "w1", VisibilityTimeout: time.Minute}
+	w1 := mredis.NewQueue(c, "jobs", opts)
+	opts.Worker = "w2"
+	w2 := mredis.NewQueue(c, "jobs", opts)
+
+	w1.Enqueue(ctx, []byte("a"), 0)
+	job, err := w1.Dequeue(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 未超时时不放回
+	if n, err := w2.Reap(ctx); err != nil || n != 0 {
+		t.Fatalf("reaped %d, err = %v", n, err)
+	}
+
+	// Touch延长期限
+	mr.SetTime(now.Add(50 * time.Second))
+	if err := w1.Touch(ctx, job); err != nil {
+		t.Fatal(err)
+	}
+	mr.SetTime(now.Add(100 * time.Second))
+	if n, _ := w2.Reap(ctx); n != 0 {
+		t.Fatalf("reaped %d", n)
+	}
+
+	mr.SetTime(now.Add(111 * time.Second))
+	if n, err := w2.Reap(ctx); err != nil || n != 1 {
+		t.Fatalf("reaped %d, err = %v", n, err)
+	}
+	if l, _ := mr.List("queue:{jobs}:processing:w1"); len(l) != 0 {
+		t.Errorf("processing = %v", l)
+	}
+
+	// 超时的任务计一次重试后被其他工作者取出，原工作者无法再确认
+	job2, err := w2.Dequeue(ctx)
+	if err != nil || job2.ID != job.ID || job2.Retries != 1 {
+		t.Fatalf("job = %+v, err = %v", job2, err)
+	}
+	if err := w1.Ack(ctx, job); !errors.Is(err, mredis.ErrJobLost) {
+		t.Errorf("w1.Ack = %v", err)
+	}
+	if err := w1.Touch(ctx, job); !errors.Is(err, mredis.ErrJobLost) {
+		t.Errorf("w1.Touch = %v", err)
+	}
+	if err := w2.Ack(ctx, job2); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestQueueRedeliveredToSameWorker(t *testing.T) {
+	mr := miniredis.RunT(t)
+	c := mredis.NewRedisClient(mr.Addr(), 0)
+	ctx := context.Background()
+	now := time.Unix(1700000000, 0)
+	mr.SetTime(now)
+
+	// 同一个工作者的两个goroutine先后领取同一个超时的任务，旧的领取不能确认新的领取
+	q := mredis.NewQueue(c, "jobs", mredis.QueueOptions{Worker: "w1", VisibilityTimeout: time.Minute})
+	q.Enqueue(ctx, []byte("a"), 0)
+	old, err := q.Dequeue(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mr.SetTime(now.Add(2 * time.Minute))
+	if n, _ := q.Reap(ctx); n != 1 {
+		t.Fatalf("reaped %d", n)
+	}
+	job, err := q.Dequeue(ctx)
+	if err != nil || job.ID != old.ID {
+		t.Fatalf("job = %+v, err = %v", job, err)
+	}
+
+	if err := q.Touch(ctx, old); !errors.Is(err, mredis.ErrJobLost) {
+		t.Errorf("Touch = %v", err)
+	}
+	if err := q.Nack(ctx, old); !errors.Is(err, mredis.ErrJobLost) {
+		t.Errorf("Nack = %v", err)
+	}
+	if err := q.Ack(ctx, old); !errors.Is(err, mredis.ErrJobLost) {
+		t.Errorf("Ack = %v", err)
+	}
+	// 新的领取仍在处理中列表中
+	if l, _ := mr.List("queue:{jobs}:processing:w1"); len(l) != 1 || l[0] != job.ID {
+		t.Errorf("processing = %v", l)
+	}
+	if err := q.Touch(ctx, job); err != nil {
+		t.Fatal(err)
+	}
+	if err := q.Ack(ctx, job); err != nil {
+		t.Fatal(err)
+	}
+	if l, _ := mr.List("queue:{jobs}:processing:w1"); len(l) != 0 {
+		t.Errorf("processing = %v", l)
+	}
+}
+
+func TestQueueReapOrphan(t *testing.T) {
+	mr := miniredis.RunT(t)
+	c := mredis.NewRedisClient(mr.Addr(), 0)
+	ctx := context.Background()
+	now := time.Unix(1700000000, 0)
+	mr.SetTime(now)
+
+	q := mredis.NewQueue(c, "jobs", mredis.QueueOptions{Worker: "w1", VisibilityTimeout: time.Minute})
+	crashed := mredis.NewQueue(c, "jobs", mredis.QueueOptions{Worker: "crashed", Block: time.Second})
+	// 工作者在空队列上等待时，处理中列表为空也不能被Reap遗忘
+	if _, err := crashed.Dequeue(ctx); !errors.Is(err, mredis.ErrQueueEmpty) {
+		t.Fatal(err)
+	}
+	if n, _ := q.Reap(ctx); n != 0 {
+		t.Fatalf("reaped %d", n)
+	}
+	id, _ := q.Enqueue(ctx, []byte("a"), 0)
+	// 模拟阻塞取出后、记录期限前崩溃
+	c.RPopLPush(ctx, "queue:{jobs}:pending:0", "queue:{jobs}:processing:crashed")
+
+	if n, _ := q.Reap(ctx); n != 0 {
+		t.Fatalf("reaped %d", n)
+	}
+	mr.SetTime(now.Add(2 * time.Minute))
+	if n, _ := q.Reap(ctx); n != 1 {
+		t.Fatalf("reaped %d", n)
+	}
+	if l, _ := mr.List("queue:{jobs}:processing:crashed"); len(l) != 0 {
+		t.Errorf("processing = %v", l)
+	}
+	job, err := q.Dequeue(ctx)
+	if err != nil || job.ID != id || string(job.Payload) != "a" {
+		t.Fatalf("job = %+v, err = %v", job, err)
+	}
+}
+
+func TestQueueReapStaleWorkers(t *testing.T) {
+	mr := miniredis.RunT(t)
+	c := mredis.NewRedisClient(mr.Addr(), 0)
+	ctx := context.Background()
+	now := time.Unix(1700000000, 0)
+	mr.SetTime(now)
+
+	opts := mredis.QueueOptions{VisibilityTimeout: time.Minute, Block: time.Second}
+	opts.Worker = "reaper"
+	reaper := mredis.NewQueue(c, "jobs", opts)
+	opts.Worker = "idle"
+	idle := mredis.NewQueue(c, "jobs", opts)
+	opts.Worker = "crashed"
+	crashed := mredis.NewQueue(c, "jobs", opts)
+	// 旧版本记录在workers集合中的工作者
+	c.SAdd(ctx, "queue:{jobs}:workers", "legacy")
+
+	idle.Dequeue(ctx)
+	reaper.Enqueue(ctx, []byte("a"), 0)
+	if _, err := crashed.Dequeue(ctx); err != nil {
+		t.Fatal(err)
+	}
+	heartbeats := func() []string {
+		ws, _ := c.ZRange(ctx, "queue:{jobs}:heartbeats", 0, -1).Result()
+		return ws
+	}
+	if n, err := reaper.Reap(ctx); err != nil || n != 0 {
+		t.Fatalf("reaped %d, err = %v", n, err)
+	}
+	// 旧版本的工作者列表为空，直接移除
+	if ws := heartbeats(); len(ws) != 2 || ws[0] != "crashed" || ws[1] != "idle" {
+		t.Errorf("heartbeats = %v", ws)
+	}
+
+	// 心跳过期后，空闲的工作者被移除，崩溃的工作者等任务超时放回后移除
+	mr.SetTime(now.Add(2 * time.Minute))
+	reaper.Dequeue(ctx)
+	if n, _ := reaper.Reap(ctx); n != 1 {
+		t.Fatalf("reaped %d", n)
+	}
+	if ws := heartbeats(); len(ws) != 2 || ws[0] != "crashed" || ws[1] != "reaper" {
+		t.Errorf("heartbeats = %v", ws)
+	}
+	reaper.Reap(ctx)
+	if ws := heartbeats(); len(ws) != 1 || ws[0] != "reaper" {
+		t.Errorf("heartbeats = %v", ws)
+	}
+
+	// 再次取任务时重新加入
+	idle.Dequeue(ctx)
+	if ws := heartbeats(); len(ws) != 2 {
+		t.Errorf("heartbeats = %v", ws)
+	}
+}
+
+func TestQueueBlockingDequeue(t *testing.T) {
+	mr := miniredis.RunT(t)
+	c := mredis.NewRedisClient(mr.Addr(), 0)
+	ctx := context.Background()
+	q := mredis.NewQueue(c, "jobs", mredis.QueueOptions{Worker: "w1", Block: time.Second})
+
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		q.Enqueue(ctx, []byte("late"), 0)
+	}()
+	job, err := q.Dequeue(ctx)
+	if err != nil || string(job.Payload) != "late" {
+		t.Fatalf("job = %+v, err = %v", job, err)
+	}
+	// 阻塞取出的任务同样记录了期限
+	if err := q.Touch(ctx, job); err != nil {
+		t.Error(err)
+	}
+	if err := q.Ack(ctx, job); err != nil {
+		t.Error(err)
+	}
+
+	if _, err := q.Dequeue(ctx); !errors.Is(err, mredis.ErrQueueEmpty) {
+		t.Errorf("err = %v", err)
+	}
+}
+
+func TestQueueConcurrentWorkers(t *testing.T) {
+	mr := miniredis.RunT(t)
+	c := mredis.NewRedisClient(mr.Addr(), 0)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	const total = 200
+	producer := mredis.NewQueue(c, "jobs", mredis.QueueOptions{Priorities: 2})
+	for i := 0; i < total; i++ {
+		if _, err := producer.Enqueue(ctx, []byte(fmt.Sprint(i)), i%2); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var (
+		mu     sync.Mutex
+		done   = make(map[string]int)
+		failed = make(map[string]bool)
+	)
+	rctx, stop := context.WithCancel(ctx)
+	handler := func(ctx context.Context, job *mredis.Job) error {
+		mu.Lock()
+		defer mu.Unlock()
+		p := string(job.Payload)
+		// 每个任务第一次处理失败
+		if !failed[p] {
+			failed[p] = true
+			return errors.New("retry")
+		}
+		done[p]++
+		if len(done) == total {
+			stop()
+		}
+		return nil
+	}
+
+	wg := sync.WaitGroup{}
+	errs := make(chan error, 8)
+	for w := 0; w < 4; w++ {
+		q := mredis.NewQueue(c, "jobs", mredis.QueueOptions{Worker: fmt.Sprint("w", w), Priorities: 2, Block: time.Second})
+		// 每个工作者两个goroutine共用一个处理中列表
+		for g := 0; g < 2; g++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				errs <- q.Run(rctx, handler)
+			}()
+		}
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Error(err)
+		}
+	}
+
+	if len(done) != total {
+		t.Fatalf("done %d", len(done))
+	}
+	for p, n := range done {
+		if n != 1 {
+			t.Errorf("%s done %d times", p, n)
+		}
+	}
+	if n, _ := producer.Len(ctx); n != 0 {
+		t.Errorf("len = %d", n)
+	}
+	if n, _ := producer.DeadLen(ctx); n != 0 {
+		t.Errorf("dead len = %d", n)
+	}
+	if n, _ := c.HLen(ctx, "queue:{jobs}:jobs").Result(); n != 0 {
+		t.Errorf("jobs = %d", n)
+	}
+}
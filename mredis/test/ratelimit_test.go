@@ -0,0 +1,213 @@
+package mredis_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/mouseleee/mlib/mredis"
+)
+
+// allowSeq 依次请求n次，返回每次是否允许
+func allowSeq(t *testing.T, l mredis.RateLimiter, key string, n int) []bool {
+	t.Helper()
+	r := make([]bool, n)
+	for i := range r {
+		res, err := l.Allow(context.Background(), key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r[i] = res.Allowed
+	}
+	return r
+}
+
+func countAllowed(bs []bool) int {
+	n := 0
+	for _, b := range bs {
+		if b {
+			n++
+		}
+	}
+	return n
+}
+
+func TestTokenBucket(t *testing.T) {
+	mr := miniredis.RunT(t)
+	c := mredis.NewRedisClient(mr.Addr(), 0)
+	ctx := context.Background()
+	now := time.Unix(1700000000, 0)
+	mr.SetTime(now)
+
+	l, err := mredis.NewTokenBucket(c, "", 10, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := countAllowed(allowSeq(t, l, "a", 8)); n != 5 {
+		t.Errorf("突发允许了%d次", n)
+	}
+	res, err := l.Allow(ctx, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Allowed || res.Limit != 5 || res.Remaining != 0 || res.RetryAfter != 100*time.Millisecond || res.ResetAfter != 500*time.Millisecond {
+		t.Errorf("res = %+v", res)
+	}
+	// 其他key不受影响
+	if n := countAllowed(allowSeq(t, l, "b", 1)); n != 1 {
+		t.Error("其他key被限流")
+	}
+
+	// 300毫秒补充3个令牌
+	mr.SetTime(now.Add(300 * time.Millisecond))
+	if n := countAllowed(allowSeq(t, l, "a", 5)); n != 3 {
+		t.Errorf("补充后允许了%d次", n)
+	}
+	// 被拒绝的请求不扣减
+	mr.SetTime(now.Add(time.Second))
+	res, _ = l.AllowN(ctx, "a", 6)
+	if res.Allowed || res.Remaining != 5 {
+		t.Errorf("res = %+v", res)
+	}
+	if res, _ = l.AllowN(ctx, "a", 5); !res.Allowed || res.Remaining != 0 {
+		t.Errorf("res = %+v", res)
+	}
+	if !mr.Exists("ratelimit:a") || mr.TTL("ratelimit:a") <= 0 {
+		t.Error("没有设置过期时间")
+	}
+}
+
+func TestFixedWindow(t *testing.T) {
+	mr := miniredis.RunT(t)
+	c := mredis.NewRedisClient(mr.Addr(), 0)
+	ctx := context.Background()
+	start := time.Unix(1700000000, 0)
+	mr.SetTime(start.Add(400 * time.Millisecond))
+
+	l, err := mredis.NewFixedWindow(c, "fw:", 3, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := countAllowed(allowSeq(t, l, "a", 5)); n != 3 {
+		t.Errorf("允许了%d次", n)
+	}
+	res, _ := l.Allow(ctx, "a")
+	if res.Allowed || res.Remaining != 0 || res.RetryAfter != 600*time.Millisecond || res.ResetAfter != 600*time.Millisecond {
+		t.Errorf("res = %+v", res)
+	}
+	// 下一个窗口恢复
+	mr.SetTime(start.Add(time.Second))
+	res, _ = l.Allow(ctx, "a")
+	if !res.Allowed || res.Remaining != 2 {
+		t.Errorf("res = %+v", res)
+	}
+}
+
+func TestSlidingLog(t *testing.T) {
+	mr := miniredis.RunT(t)
+	c := mredis.NewRedisClient(mr.Addr(), 0)
+	ctx := context.Background()
+	now := time.Unix(1700000000, 0)
+
+	l, err := mredis.NewSlidingLog(c, "", 3, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		mr.SetTime(now.Add(time.Duration(i) * 300 * time.Millisecond))
+		if res, _ := l.Allow(ctx, "a"); !res.Allowed {
+			t.Fatalf("第%d次被拒绝", i)
+		}
+	}
+	// 600毫秒时窗口内已有3次，需要等最早的一次在1000毫秒时移出窗口
+	mr.SetTime(now.Add(700 * time.Millisecond))
+	res, _ := l.Allow(ctx, "a")
+	if res.Allowed || res.RetryAfter != 300*time.Millisecond || res.ResetAfter != 900*time.Millisecond {
+		t.Errorf("res = %+v", res)
+	}
+	if n, _ := mredis.ZCardContext(ctx, c, "ratelimit:a"); n != 3 {
+		t.Errorf("日志中有%d条", n)
+	}
+
+	// 固定窗口在这里会放行，滑动窗口只放行移出的那一次
+	mr.SetTime(now.Add(1100 * time.Millisecond))
+	if n := countAllowed(allowSeq(t, l, "a", 3)); n != 1 {
+		t.Errorf("允许了%d次", n)
+	}
+	if res, _ := l.AllowN(ctx, "a", 4); res.Allowed || res.RetryAfter != time.Second {
+		t.Errorf("res = %+v", res)
+	}
+}
+
+func TestRateLimitMiddleware(t *testing.T) {
+	mr := miniredis.RunT(t)
+	c := mredis.NewRedisClient(mr.Addr(), 0)
+	mr.SetTime(time.Unix(1700000000, 0))
+
+	l, err := mredis.NewFixedWindow(c, "", 2, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var errs int
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+	h := mredis.RateLimitMiddleware(l, mredis.RateLimitOptions{
+		OnError: func(*http.Request, error) { errs++ },
+	})(next)
+
+	do := func(addr string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = addr
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	for i := 0; i < 2; i++ {
+		if rec := do("10.0.0.1:1234"); rec.Code != http.StatusNoContent {
+			t.Fatalf("第%d次 %d", i, rec.Code)
+		}
+	}
+	rec := do("10.0.0.1:5678")
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("code = %d", rec.Code)
+	}
+	if rec.Header().Get("Retry-After") != "40" || rec.Header().Get("X-RateLimit-Limit") != "2" || rec.Header().Get("X-RateLimit-Remaining") != "0" {
+		t.Errorf("headers = %v", rec.Header())
+	}
+	if rec := do("10.0.0.2:1234"); rec.Code != http.StatusNoContent {
+		t.Errorf("其他IP被限流 %d", rec.Code)
+	}
+
+	// Redis不可用时放行并通知OnError
+	mr.Close()
+	if rec := do("10.0.0.1:1234"); rec.Code != http.StatusNoContent || errs != 1 {
+		t.Errorf("Redis不可用时 %d, errs = %d", rec.Code, errs)
+	}
+	// FailClosed时拒绝
+	h = mredis.RateLimitMiddleware(l, mredis.RateLimitOptions{FailClosed: true})(next)
+	if rec := do("10.0.0.1:1234"); rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("FailClosed时 %d", rec.Code)
+	}
+}
+
+func TestRateLimitInvalidConfig(t *testing.T) {
+	c := mredis.NewRedisClient("localhost:0", 0)
+	if _, err := mredis.NewTokenBucket(c, "", 0, 5); !errors.Is(err, mredis.ErrInvalidConfig) {
+		t.Errorf("rate = 0: %v", err)
+	}
+	if _, err := mredis.NewTokenBucket(c, "", 10, 0); !errors.Is(err, mredis.ErrInvalidConfig) {
+		t.Errorf("burst = 0: %v", err)
+	}
+	if _, err := mredis.NewFixedWindow(c, "", 0, time.Second); !errors.Is(err, mredis.ErrInvalidConfig) {
+		t.Errorf("limit = 0: %v", err)
+	}
+	if _, err := mredis.NewSlidingLog(c, "", 3, 0); !errors.Is(err, mredis.ErrInvalidConfig) {
+		t.Errorf("window = 0: %v", err)
+	}
+}
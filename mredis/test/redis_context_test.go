@@ -0,0 +1,133 @@
+package mredis_test
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/mouseleee/mlib/mredis"
+)
+
+func TestStrContext(t *testing.T) {
+	mr := miniredis.RunT(t)
+	c := mredis.NewRedisClient(mr.Addr(), 0)
+	ctx := context.Background()
+
+	if _, err := mredis.StrGetContext(ctx, c, "missing"); !errors.Is(err, mredis.ErrNotFound) {
+		t.Errorf("missing key err = %v", err)
+	}
+	if err := mredis.StrSetContext(ctx, c, "k", "", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	// 空字符串与不存在可以区分
+	if v, err := mredis.StrGetContext(ctx, c, "k"); err != nil || v != "" {
+		t.Errorf("v = %q, err = %v", v, err)
+	}
+	if ttl := mr.TTL("k"); ttl != time.Minute {
+		t.Errorf("ttl = %v", ttl)
+	}
+	if ks, err := mredis.KeysContext(ctx, c, "k*"); err != nil || !reflect.DeepEqual(ks, []string{"k"}) {
+		t.Errorf("keys = %v, err = %v", ks, err)
+	}
+	if n, err := mredis.DelContext(ctx, c, "k", "missing"); err != nil || n != 1 {
+		t.Errorf("n = %d, err = %v", n, err)
+	}
+
+	// ctx取消时返回错误
+	cctx, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := mredis.StrGetContext(cctx, c, "k"); !errors.Is(err, context.Canceled) {
+		t.Errorf("cancelled err = %v", err)
+	}
+}
+
+func TestListContext(t *testing.T) {
+	mr := miniredis.RunT(t)
+	c := mredis.NewRedisClient(mr.Addr(), 0)
+	ctx := context.Background()
+
+	if n, err := mredis.ListSetAndLpushContext(ctx, c, "l", "1", "2", "3"); err != nil || n != 3 {
+		t.Fatalf("n = %d, err = %v", n, err)
+	}
+	if err := mredis.ListModifyContext(ctx, c, "l", 1, "b"); err != nil {
+		t.Fatal(err)
+	}
+	if vs, err := mredis.ListRangeContext(ctx, c, "l", 0, -1); err != nil || !reflect.DeepEqual(vs, []string{"1", "b", "3"}) {
+		t.Errorf("range = %v, err = %v", vs, err)
+	}
+	if n, err := mredis.ListLenContext(ctx, c, "l"); err != nil || n != 3 {
+		t.Errorf("len = %d, err = %v", n, err)
+	}
+	if v, err := mredis.ListLpopContext(ctx, c, "l"); err != nil || v != "1" {
+		t.Errorf("lpop = %q, err = %v", v, err)
+	}
+	if v, err := mredis.ListRpopContext(ctx, c, "l"); err != nil || v != "3" {
+		t.Errorf("rpop = %q, err = %v", v, err)
+	}
+	mredis.ListLpopContext(ctx, c, "l")
+	if _, err := mredis.ListLpopContext(ctx, c, "l"); !errors.Is(err, mredis.ErrNotFound) {
+		t.Errorf("empty lpop err = %v", err)
+	}
+	// 兼容函数返回值而不是命令的调试字符串
+	mredis.ListSetAndLpush(c, "l", "x")
+	if v := mredis.ListLpop(c, "l"); v != "x" {
+		t.Errorf("ListLpop = %q", v)
+	}
+}
+
+func TestHashContext(t *testing.T) {
+	mr := miniredis.RunT(t)
+	c := mredis.NewRedisClient(mr.Addr(), 0)
+	ctx := context.Background()
+
+	if err := mredis.HashSetContext(ctx, c, "h", map[string]string{"a": "1", "b": "2"}); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := mredis.HashGetContext(ctx, c, "h", "a"); err != nil || v != "1" {
+		t.Errorf("a = %q, err = %v", v, err)
+	}
+	if _, err := mredis.HashGetContext(ctx, c, "h", "c"); !errors.Is(err, mredis.ErrNotFound) {
+		t.Errorf("missing field err = %v", err)
+	}
+	if m, err := mredis.HashGetAllContext(ctx, c, "h"); err != nil || len(m) != 2 {
+		t.Errorf("all = %v, err = %v", m, err)
+	}
+	if ks, err := mredis.HashKeysContext(ctx, c, "h"); err != nil || len(ks) != 2 {
+		t.Errorf("keys = %v, err = %v", ks, err)
+	}
+	if n, err := mredis.HashDelContext(ctx, c, "h", "a", "c"); err != nil || n != 1 {
+		t.Errorf("del = %d, err = %v", n, err)
+	}
+	if n, err := mredis.HashLenContext(ctx, c, "h"); err != nil || n != 1 {
+		t.Errorf("len = %d, err = %v", n, err)
+	}
+	if v := mredis.HashGet(c, "h", "b"); v != "2" {
+		t.Errorf("HashGet = %q", v)
+	}
+}
+
+func TestZSetContext(t *testing.T) {
+	mr := miniredis.RunT(t)
+	c := mredis.NewRedisClient(mr.Addr(), 0)
+	ctx := context.Background()
+
+	if n, err := mredis.ZSetAddContext(ctx, c, "z", map[float64]string{1: "a", 2: "b", 3: "c"}); err != nil || n != 3 {
+		t.Fatalf("n = %d, err = %v", n, err)
+	}
+	// 已存在的值不更新
+	if n, _ := mredis.ZSetAddContext(ctx, c, "z", map[float64]string{10: "a"}); n != 0 {
+		t.Errorf("n = %d", n)
+	}
+	if n, err := mredis.ZCountContext(ctx, c, "z", 2, 3); err != nil || n != 2 {
+		t.Errorf("count = %d, err = %v", n, err)
+	}
+	if n, err := mredis.ZCardContext(ctx, c, "z"); err != nil || n != 3 {
+		t.Errorf("card = %d, err = %v", n, err)
+	}
+	if vs, err := mredis.ZRangeContext(ctx, c, "z", 1, 2); err != nil || !reflect.DeepEqual(vs, []string{"a", "b"}) {
+		t.Errorf("range = %v, err = %v", vs, err)
+	}
+}
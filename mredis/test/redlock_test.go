@@ -0,0 +1,134 @@
+package mredis_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v9"
+	"github.com/mouseleee/mlib/mredis"
+)
+
+func redlockNodes(t *testing.T, n int) ([]*miniredis.Miniredis, []redis.UniversalClient) {
+	t.Helper()
+	mrs := make([]*miniredis.Miniredis, n)
+	cs := make([]redis.UniversalClient, n)
+	for i := range mrs {
+		mrs[i] = miniredis.RunT(t)
+		cs[i] = mredis.NewRedisClient(mrs[i].Addr(), 0)
+	}
+	return mrs, cs
+}
+
+func TestRedlock(t *testing.T) {
+	mrs, cs := redlockNodes(t, 3)
+	ctx := context.Background()
+
+	var a, b mredis.Locker
+	a = mredis.NewRedlock(cs, "lock:r", mredis.LockOptions{TTL: time.Second})
+	b = mredis.NewRedlock(cs, "lock:r", mredis.LockOptions{TTL: time.Second})
+
+	if ok, err := a.TryLock(ctx); err != nil || !ok {
+		t.Fatalf("ok = %v, err = %v", ok, err)
+	}
+	rl := a.(*mredis.Redlock)
+	if v := rl.Validity(); !v.After(time.Now()) || v.After(time.Now().Add(time.Second)) {
+		t.Errorf("validity = %v", v)
+	}
+	for i, mr := range mrs {
+		if v, _ := mr.Get("lock:r"); v != rl.Token() {
+			t.Errorf("node %d value = %q", i, v)
+		}
+	}
+	if ok, _ := b.TryLock(ctx); ok {
+		t.Error("锁被重复获取")
+	}
+
+	if err := a.Extend(ctx, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	for i, mr := range mrs {
+		if ttl := mr.TTL("lock:r"); ttl != time.Hour {
+			t.Errorf("node %d ttl = %v", i, ttl)
+		}
+	}
+	if err := b.Unlock(ctx); !errors.Is(err, mredis.ErrLockNotHeld) {
+		t.Errorf("b.Unlock = %v", err)
+	}
+	if err := a.Unlock(ctx); err != nil {
+		t.Fatal(err)
+	}
+	for i, mr := range mrs {
+		if mr.Exists("lock:r") {
+			t.Errorf("node %d 没有释放", i)
+		}
+	}
+}
+
+func TestRedlockQuorum(t *testing.T) {
+	mrs, cs := redlockNodes(t, 3)
+	ctx := context.Background()
+
+	// 一个节点故障时仍然可以获取
+	mrs[2].Close()
+	l := mredis.NewRedlock(cs, "lock:q", mredis.LockOptions{TTL: time.Second})
+	if ok, err := l.TryLock(ctx); err != nil || !ok {
+		t.Fatalf("ok = %v, err = %v", ok, err)
+	}
+	if err := l.Unlock(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// 另一个节点被其他持有者占用时没有多数，已获取的节点被释放
+	mrs[1].Set("lock:q", "other")
+	if ok, err := l.TryLock(ctx); err != nil || ok {
+		t.Fatalf("ok = %v, err = %v", ok, err)
+	}
+	if mrs[0].Exists("lock:q") {
+		t.Error("失败后没有释放已获取的节点")
+	}
+
+	// 所有节点故障时返回错误
+	mrs[0].Close()
+	mrs[1].Close()
+	if ok, err := l.TryLock(ctx); err == nil || ok {
+		t.Fatalf("ok = %v, err = %v", ok, err)
+	}
+	ctx2, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	if err := l.Lock(ctx2); err == nil {
+		t.Error("所有节点故障时获取成功")
+	}
+}
+
+func TestRedlockWatchdog(t *testing.T) {
+	mrs, cs := redlockNodes(t, 3)
+	ctx := context.Background()
+
+	l := mredis.NewRedlock(cs, "lock:w", mredis.LockOptions{TTL: 300 * time.Millisecond, Watchdog: true})
+	if err := l.Lock(ctx); err != nil {
+		t.Fatal(err)
+	}
+	for _, mr := range mrs {
+		mr.FastForward(250 * time.Millisecond)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for mrs[0].TTL("lock:w") < 100*time.Millisecond {
+		if time.Now().After(deadline) {
+			t.Fatal("没有自动续期")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// 多数节点被其他持有者占用后通知丢失
+	mrs[0].Set("lock:w", "other")
+	mrs[1].Set("lock:w", "other")
+	select {
+	case <-l.Lost():
+	case <-time.After(2 * time.Second):
+		t.Fatal("没有通知锁丢失")
+	}
+	l.Unlock(ctx)
+}
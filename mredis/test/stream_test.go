@@ -0,0 +1,270 @@
+package mredis_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v9"
+	"github.com/mouseleee/mlib/mredis"
+)
+
+func TestStreamProducerTrim(t *testing.T) {
+	mr := miniredis.RunT(t)
+	c := mredis.NewRedisClient(mr.Addr(), 0)
+	ctx := context.Background()
+
+	p := mredis.NewStreamProducer(c, "s", 0)
+	id, err := p.Publish(ctx, map[string]any{"n": 1})
+	if err != nil || id == "" {
+		t.Fatalf("id = %q, err = %v", id, err)
+	}
+
+	p = mredis.NewStreamProducer(c, "trim", 5)
+	for i := 0; i < 20; i++ {
+		if _, err := p.Publish(ctx, map[string]any{"n": i}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// 近似裁剪只保证不超过太多
+	if n, _ := c.XLen(ctx, "trim").Result(); n < 5 || n > 20 {
+		t.Errorf("len = %d", n)
+	}
+}
+
+func TestStreamConsumer(t *testing.T) {
+	mr := miniredis.RunT(t)
+	c := mredis.NewRedisClient(mr.Addr(), 0)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	conf := mredis.StreamConsumerConfig{Stream: "jobs", Group: "g", Consumer: "c1", StartID: "0", Block: 20 * time.Millisecond}
+	p := mredis.NewStreamProducer(c, "jobs", 0)
+	for i := 0; i < 5; i++ {
+		p.Publish(ctx, map[string]any{"n": fmt.Sprint(i)})
+	}
+
+	var (
+		mu  sync.Mutex
+		got []string
+	)
+	rctx, stop := context.WithCancel(ctx)
+	con := mredis.NewStreamConsumer(c, conf, func(ctx context.Context, msg redis.XMessage) error {
+		mu.Lock()
+		defer mu.Unlock()
+		got = append(got, msg.Values["n"].(string))
+		if len(got) == 7 {
+			stop()
+		}
+		return nil
+	})
+	done := make(chan error, 1)
+	go func() {
+		done <- con.Run(rctx)
+	}()
+	// 运行中发布的消息也被消费
+	time.Sleep(50 * time.Millisecond)
+	p.Publish(ctx, map[string]any{"n": "5"})
+	p.Publish(ctx, map[string]any{"n": "6"})
+
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 7 || got[0] != "0" || got[6] != "6" {
+		t.Errorf("got %v", got)
+	}
+	if n, err := con.Pending(ctx); err != nil || n != 0 {
+		t.Errorf("pending = %d, err = %v", n, err)
+	}
+	// 消费者组已存在时EnsureGroup不报错
+	if err := con.EnsureGroup(ctx); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestStreamConsumerReclaim(t *testing.T) {
+	mr := miniredis.RunT(t)
+	c := mredis.NewRedisClient(mr.Addr(), 0)
+	ctx := context.Background()
+
+	conf := mredis.StreamConsumerConfig{Stream: "jobs", Group: "g", StartID: "0", Block: 20 * time.Millisecond, MinIdle: 10 * time.Millisecond}
+	p := mredis.NewStreamProducer(c, "jobs", 0)
+	for i := 0; i < 3; i++ {
+		p.Publish(ctx, map[string]any{"n": fmt.Sprint(i)})
+	}
+
+	// c1处理失败后崩溃，消息留在c1的待确认列表中
+	conf.Consumer = "c1"
+	errFail := errors.New("crash")
+	c1 := mredis.NewStreamConsumer(c, conf, func(ctx context.Context, msg redis.XMessage) error {
+		return errFail
+	})
+	rctx, stop := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer stop()
+	if err := c1.Run(rctx); err != nil {
+		t.Fatal(err)
+	}
+	if n, _ := c1.Pending(ctx); n != 3 {
+		t.Fatalf("pending = %d", n)
+	}
+
+	// c2接管空闲的消息
+	conf.Consumer = "c2"
+	var got []string
+	c2 := mredis.NewStreamConsumer(c, conf, func(ctx context.Context, msg redis.XMessage) error {
+		got = append(got, msg.Values["n"].(string))
+		return nil
+	})
+	time.Sleep(20 * time.Millisecond)
+	n, err := c2.Reclaim(ctx)
+	if err != nil || n != 3 {
+		t.Fatalf("reclaimed %d, err = %v", n, err)
+	}
+	if len(got) != 3 {
+		t.Errorf("got %v", got)
+	}
+	if n, _ := c2.Pending(ctx); n != 0 {
+		t.Errorf("pending = %d", n)
+	}
+}
+
+func TestStreamConsumerOwnPending(t *testing.T) {
+	mr := miniredis.RunT(t)
+	c := mredis.NewRedisClient(mr.Addr(), 0)
+	ctx := context.Background()
+
+	conf := mredis.StreamConsumerConfig{Stream: "jobs", Group: "g", Consumer: "c1", StartID: "0", Block: 20 * time.Millisecond}
+	mredis.NewStreamProducer(c, "jobs", 0).Publish(ctx, map[string]any{"n": "0"})
+
+	// 读取后没有确认就退出
+	fail := mredis.NewStreamConsumer(c, conf, func(ctx context.Context, msg redis.XMessage) error {
+		return errors.New("crash")
+	})
+	rctx, stop := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer stop()
+	fail.Run(rctx)
+
+	// 以相同的名字重启后先处理自己未确认的消息
+	handled := 0
+	rctx2, stop2 := context.WithTimeout(ctx, 2*time.Second)
+	defer stop2()
+	ok := mredis.NewStreamConsumer(c, conf, func(ctx context.Context, msg redis.XMessage) error {
+		handled++
+		stop2()
+		return nil
+	})
+	if err := ok.Run(rctx2); err != nil {
+		t.Fatal(err)
+	}
+	if handled != 1 {
+		t.Errorf("handled = %d", handled)
+	}
+	if n, _ := ok.Pending(ctx); n != 0 {
+		t.Errorf("pending = %d", n)
+	}
+}
+
+func TestStreamConsumerDeadLetter(t *testing.T) {
+	mr := miniredis.RunT(t)
+	c := mredis.NewRedisClient(mr.Addr(), 0)
+	ctx := context.Background()
+
+	conf := mredis.StreamConsumerConfig{Stream: "jobs", Group: "g", Consumer: "c1", StartID: "0", Block: 20 * time.Millisecond,
+		MinIdle: 10 * time.Millisecond, MaxRetries: 1}
+	id, _ := mredis.NewStreamProducer(c, "jobs", 0).Publish(ctx, map[string]any{"n": "0"})
+
+	// 一直处理失败的消息，第一次投递和1次重试之后移入死信stream
+	attempts := 0
+	con := mredis.NewStreamConsumer(c, conf, func(ctx context.Context, msg redis.XMessage) error {
+		attempts++
+		return errors.New("boom")
+	})
+	rctx, stop := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer stop()
+	con.Run(rctx)
+	for i := 0; i < 2; i++ {
+		time.Sleep(20 * time.Millisecond)
+		if n, err := con.Reclaim(ctx); err != nil || n != 1 {
+			t.Fatalf("reclaimed %d, err = %v", n, err)
+		}
+	}
+
+	if attempts != 2 {
+		t.Errorf("attempts = %d", attempts)
+	}
+	if n, _ := con.Pending(ctx); n != 0 {
+		t.Errorf("pending = %d", n)
+	}
+	dead, err := c.XRange(ctx, "jobs:dead", "-", "+").Result()
+	if err != nil || len(dead) != 1 {
+		t.Fatalf("dead = %v, err = %v", dead, err)
+	}
+	v := dead[0].Values
+	if v["n"] != "0" || v[mredis.StreamFieldDeadID] != id || v[mredis.StreamFieldDeadDeliveries] != "3" {
+		t.Errorf("dead = %v", v)
+	}
+}
+
+func TestStreamConsumerRetriesRedisErrors(t *testing.T) {
+	mr := miniredis.RunT(t)
+	c := mredis.NewRedisClient(mr.Addr(), 0)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var (
+		mu      sync.Mutex
+		errs    int
+		handled = make(chan string, 1)
+	)
+	conf := mredis.StreamConsumerConfig{Stream: "jobs", Group: "g", Consumer: "c1", StartID: "0", Block: 20 * time.Millisecond,
+		OnError: func(err error) {
+			mu.Lock()
+			defer mu.Unlock()
+			errs++
+		}}
+	con := mredis.NewStreamConsumer(c, conf, func(ctx context.Context, msg redis.XMessage) error {
+		handled <- msg.Values["n"].(string)
+		return nil
+	})
+	done := make(chan error, 1)
+	go func() { done <- con.Run(ctx) }()
+
+	// Redis不可用期间Run不退出，恢复后继续消费
+	mr.SetError("LOADING Redis is loading the dataset in memory")
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		mu.Lock()
+		n := errs
+		mu.Unlock()
+		if n > 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("OnError not called")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	mr.SetError("")
+	if _, err := mredis.NewStreamProducer(c, "jobs", 0).Publish(ctx, map[string]any{"n": "0"}); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case v := <-handled:
+		if v != "0" {
+			t.Errorf("handled %q", v)
+		}
+	case err := <-done:
+		t.Fatalf("Run returned %v", err)
+	case <-ctx.Done():
+		t.Fatal("message not handled after Redis recovered")
+	}
+	cancel()
+	if err := <-done; err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,106 @@
+package msql
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// 基于MySQL的消息去重记录，满足mkafka.DedupStore
+
+const (
+	dedupProcessing = 0
+	dedupDone       = 1
+)
+
+// DedupRecord 去重记录表结构
+type DedupRecord struct {
+	DedupKey   string    `col:"dedup_key" primary:"true" strlen:"191" comment:"去重键"`
+	Status     int       `col:"status" default:"0" comment:"0处理中 1已完成"`
+	ExpireTime time.Time `col:"expire_time" comment:"过期时间"`
+}
+
+// DedupStore 将消息的处理状态记录在dedup_record表中；
+// 过期时间在SQL中比较，不扫描DATETIME列，DSN不需要parseTime=true
+type DedupStore struct {
+	db *sql.DB
+}
+
+// NewDedupStore 创建去重记录，表需要预先通过DedupTableSQL创建
+func NewDedupStore(db *sql.DB) *DedupStore {
+	return &DedupStore{db: db}
+}
+
+// DedupTableSQL 返回去重记录表的建表语句
+func DedupTableSQL() (string, error) {
+	return CreateTableSQL(DedupRecord{}, "消息去重记录")
+}
+
+// Begin 原子地占用key，见mkafka.DedupStore
+func (s *DedupStore) Begin(ctx context.Context, key string, lease time.Duration) (bool, bool, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, false, NewMysqlErr("开启事务失败", err)
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	var (
+		status int
+		alive  bool
+	)
+	err = tx.QueryRowContext(ctx, "SELECT status, expire_time > ? FROM dedup_record WHERE dedup_key = ? FOR UPDATE", now, key).Scan(&status, &alive)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		// 并发插入同一个key时被忽略，视为正在处理中
+		r, err := tx.ExecContext(ctx, "INSERT IGNORE INTO dedup_record (dedup_key, status, expire_time) VALUES (?, ?, ?)", key, dedupProcessing, now.Add(lease))
+		if err != nil {
+			return false, false, NewMysqlErr("写入去重记录失败", err)
+		}
+		if n, err := r.RowsAffected(); err != nil || n == 0 {
+			return false, false, err
+		}
+	case err != nil:
+		return false, false, NewMysqlErr("查询去重记录失败", err)
+	case alive:
+		return false, status == dedupDone, nil
+	default:
+		if _, err := tx.ExecContext(ctx, "UPDATE dedup_record SET status = ?, expire_time = ? WHERE dedup_key = ?", dedupProcessing, now.Add(lease), key); err != nil {
+			return false, false, NewMysqlErr("更新去重记录失败", err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return false, false, NewMysqlErr("提交事务失败", err)
+	}
+	return true, false, nil
+}
+
+// Commit 标记key已处理完成，保留ttl
+func (s *DedupStore) Commit(ctx context.Context, key string, ttl time.Duration) error {
+	_, err := s.db.ExecContext(ctx,
+		"INSERT INTO dedup_record (dedup_key, status, expire_time) VALUES (?, ?, ?) ON DUPLICATE KEY UPDATE status = VALUES(status), expire_time = VALUES(expire_time)",
+		key, dedupDone, time.Now().Add(ttl))
+	if err != nil {
+		return NewMysqlErr("更新去重记录失败", err)
+	}
+	return nil
+}
+
+// Abort 释放处理中的key
+func (s *DedupStore) Abort(ctx context.Context, key string) error {
+	_, err := s.db.ExecContext(ctx, "DELETE FROM dedup_record WHERE dedup_key = ? AND status = ?", key, dedupProcessing)
+	if err != nil {
+		return NewMysqlErr("删除去重记录失败", err)
+	}
+	return nil
+}
+
+// Purge 删除已过期的去重记录，返回删除的行数
+func (s *DedupStore) Purge(ctx context.Context) (int64, error) {
+	r, err := s.db.ExecContext(ctx, "DELETE FROM dedup_record WHERE expire_time < ?", time.Now())
+	if err != nil {
+		return 0, NewMysqlErr("删除去重记录失败", err)
+	}
+	return r.RowsAffected()
+}
@@ -1,3 +1,5 @@
 module github.com/mouseleee/mlib/msql
 
 go 1.19
+
+require github.com/DATA-DOG/go-sqlmock v1.5.0
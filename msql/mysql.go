@@ -1,6 +1,7 @@
 package msql
 
 import (
+	_ "embed"
 	"fmt"
 	"os"
 	"reflect"
@@ -31,6 +32,38 @@ type ColumnInfo struct {
 	Comment    string
 }
 
+// QuoteString 转义并加上单引号，作为SQL字符串字面量
+func QuoteString(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+var (
+	defaultFuncReg = regexp.MustCompile(`(?i)^(CURRENT_TIMESTAMP|LOCALTIMESTAMP|NOW)(\(\d*\))?$`)
+	numericColReg  = regexp.MustCompile(`^(INT|BIGINT|FLOAT|BOOLEAN)`)
+)
+
+// DefaultSQL DEFAULT子句的值：NULL、CURRENT_TIMESTAMP等时间函数、括号中的表达式以及数值列的数值原样输出，其余作为字符串转义；
+// 数值列的默认值不是数值或true/false时返回错误
+func (c ColumnInfo) DefaultSQL() (string, error) {
+	v := c.DefaultVal
+	switch {
+	case strings.EqualFold(v, "NULL"), defaultFuncReg.MatchString(v):
+		return v, nil
+	case strings.HasPrefix(v, "(") && strings.HasSuffix(v, ")"):
+		return v, nil
+	case numericColReg.MatchString(c.ColType):
+		if _, err := strconv.ParseFloat(v, 64); err == nil {
+			return v, nil
+		}
+		if strings.EqualFold(v, "TRUE") || strings.EqualFold(v, "FALSE") {
+			return v, nil
+		}
+		return "", NewMysqlErr(fmt.Sprintf("%s列%s的默认值%q不是数值", c.ColType, c.ColName, v), nil)
+	}
+	return QuoteString(v), nil
+}
+
 type Create struct {
 	TableMeta   TableMetaData
 	TableCols   []ColumnInfo
@@ -70,6 +103,9 @@ const (
 
 const defaultLogLevel = "debug"
 
+//go:embed templates/create_table.template
+var createTableTemplate string
+
 var logger zerolog.Logger
 
 func init() {
@@ -94,19 +130,49 @@ func NewMysqlErr(msg string, e error) MouseMysqlErr {
 
 // RegisterType 创建并执行建表语句，表不存在时创建，存在则更新
 func RegisterTable(tb any, comment string) {
-	tmpl, err := template.ParseFiles("./templates/create_table.template")
+	ddl, err := CreateTableSQL(tb, comment)
+	if err != nil {
+		logger.Error().AnErr("create sql err", err).Msg("create sql failed")
+		return
+	}
+
+	outLoc := "./templates/dup"
+	f, err := os.Create(outLoc)
+	if err != nil {
+		logger.Error().AnErr("new file err", err).Msg("create file failed")
+		return
+	}
+	defer f.Close()
+
+	_, err = f.WriteString(ddl)
+	if err != nil {
+		logger.Error().AnErr("exec err", err).Msg("exec failed")
+	}
+}
+
+// CreateTableSQL 根据表结构体生成建表语句
+func CreateTableSQL(tb any, comment string) (string, error) {
+	tmpl, err := template.New("create_table").Funcs(template.FuncMap{"quote": QuoteString}).Parse(createTableTemplate)
 	if err != nil {
-		logger.Error().AnErr("parse err", err).Msg("parse failed")
+		return "", NewMysqlErr("解析建表模板失败", err)
 	}
 
 	meta, err := ExtractTableInfo(tb, comment)
 	if err != nil {
-		logger.Error().AnErr("table extract err", err).Msg("table extract failed")
+		return "", err
 	}
 
 	colsInfo, err := ExtractColFromTableType(tb)
 	if err != nil {
-		logger.Error().AnErr("col extract err", err).Msg("col extract failed")
+		return "", err
+	}
+	for _, col := range colsInfo {
+		if col.DefaultVal == "" {
+			continue
+		}
+		if _, err := col.DefaultSQL(); err != nil {
+			return "", err
+		}
 	}
 
 	c := Create{
@@ -116,17 +182,11 @@ func RegisterTable(tb any, comment string) {
 		MaxIndexIdx: 0,
 	}
 
-	outLoc := "./templates/dup"
-	f, err := os.Create(outLoc)
-	if err != nil {
-		logger.Error().AnErr("new file err", err).Msg("create file failed")
-	}
-
-	err = tmpl.Execute(f, c)
-	if err != nil {
-		logger.Error().AnErr("exec err", err).Msg("exec failed")
+	b := strings.Builder{}
+	if err := tmpl.Execute(&b, c); err != nil {
+		return "", NewMysqlErr("生成建表语句失败", err)
 	}
-
+	return b.String(), nil
 }
 
 func ExtractTableInfo(tb any, comment string) (*TableMetaData, error) {
@@ -159,11 +219,10 @@ func GroupColInfo(t reflect.Type) ([]ColumnInfo, error) {
 			longStr bool
 			strLen  int
 		)
+		if _, ok := tag.Lookup(LongStr); ok {
+			longStr = true
+		}
 		if f.Type.Kind() == reflect.String {
-			if _, ok := tag.Lookup(LongStr); ok {
-				longStr = true
-			}
-
 			if v, ok := tag.Lookup(StrLen); ok {
 				l, _ := strconv.ParseInt(v, 10, 32)
 				strLen = int(l)
@@ -222,12 +281,14 @@ func GroupColInfo(t reflect.Type) ([]ColumnInfo, error) {
 		}
 
 		r = append(r, ColumnInfo{
-			ColName:   colName,
-			ColType:   colType,
-			IsPrimary: primary,
-			IsUnique:  unique,
-			IsAuto:    auto,
-			IsNull:    null,
+			ColName:    colName,
+			ColType:    colType,
+			IsPrimary:  primary,
+			IsUnique:   unique,
+			IsAuto:     auto,
+			IsNull:     null,
+			DefaultVal: tag.Get(Default),
+			Comment:    tag.Get(Comment),
 		})
 	}
 
@@ -254,6 +315,14 @@ func GoTypeToMysqlColType(t reflect.Type, longText bool, strLen int) string {
 		} else {
 			return "VARCHAR(" + strconv.FormatInt(int64(strLen), 10) + ")"
 		}
+	case reflect.Slice:
+		if t.Elem().Kind() != reflect.Uint8 {
+			return ""
+		}
+		if longText {
+			return "LONGBLOB"
+		}
+		return "BLOB"
 	case reflect.Struct:
 		if t.AssignableTo(reflect.TypeOf(time.Time{})) {
 			return "DATETIME"
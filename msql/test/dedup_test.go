@@ -0,0 +1,106 @@
+package msql_test
+
+import (
+	"context"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/mouseleee/mlib/msql"
+)
+
+func TestDedupTableSQL(t *testing.T) {
+	ddl, err := msql.DedupTableSQL()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(ddl, "`dedup_key` VARCHAR(191) NOT NULL PRIMARY KEY") {
+		t.Errorf("ddl = %s", ddl)
+	}
+}
+
+var selectDedup = regexp.QuoteMeta("SELECT status, expire_time > ? FROM dedup_record WHERE dedup_key = ? FOR UPDATE")
+
+func TestDedupStoreBegin(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	s := msql.NewDedupStore(db)
+	ctx := context.Background()
+	cols := []string{"status", "alive"}
+
+	// 新key
+	mock.ExpectBegin()
+	mock.ExpectQuery(selectDedup).WithArgs(sqlmock.AnyArg(), "k1").WillReturnRows(sqlmock.NewRows(cols))
+	mock.ExpectExec(regexp.QuoteMeta("INSERT IGNORE INTO dedup_record")).WithArgs("k1", 0, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	if acquired, done, err := s.Begin(ctx, "k1", time.Minute); err != nil || !acquired || done {
+		t.Errorf("acquired = %v, done = %v, err = %v", acquired, done, err)
+	}
+
+	// 并发插入
+	mock.ExpectBegin()
+	mock.ExpectQuery(selectDedup).WithArgs(sqlmock.AnyArg(), "k1").WillReturnRows(sqlmock.NewRows(cols))
+	mock.ExpectExec(regexp.QuoteMeta("INSERT IGNORE INTO dedup_record")).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectRollback()
+	if acquired, done, err := s.Begin(ctx, "k1", time.Minute); err != nil || acquired || done {
+		t.Errorf("acquired = %v, done = %v, err = %v", acquired, done, err)
+	}
+
+	// 已完成
+	mock.ExpectBegin()
+	mock.ExpectQuery(selectDedup).WithArgs(sqlmock.AnyArg(), "k1").WillReturnRows(sqlmock.NewRows(cols).AddRow(1, 1))
+	mock.ExpectRollback()
+	if acquired, done, err := s.Begin(ctx, "k1", time.Minute); err != nil || acquired || !done {
+		t.Errorf("acquired = %v, done = %v, err = %v", acquired, done, err)
+	}
+
+	// 已过期
+	mock.ExpectBegin()
+	mock.ExpectQuery(selectDedup).WithArgs(sqlmock.AnyArg(), "k1").WillReturnRows(sqlmock.NewRows(cols).AddRow(0, 0))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE dedup_record SET status = ?, expire_time = ? WHERE dedup_key = ?")).
+		WithArgs(0, sqlmock.AnyArg(), "k1").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	if acquired, done, err := s.Begin(ctx, "k1", time.Minute); err != nil || !acquired || done {
+		t.Errorf("acquired = %v, done = %v, err = %v", acquired, done, err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestDedupStoreCommitAbort(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	s := msql.NewDedupStore(db)
+	ctx := context.Background()
+
+	mock.ExpectExec(regexp.QuoteMeta("ON DUPLICATE KEY UPDATE")).WithArgs("k1", 1, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta("DELETE FROM dedup_record WHERE dedup_key = ? AND status = ?")).WithArgs("k2", 0).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta("DELETE FROM dedup_record WHERE expire_time < ?")).
+		WillReturnResult(sqlmock.NewResult(0, 3))
+
+	if err := s.Commit(ctx, "k1", time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Abort(ctx, "k2"); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := s.Purge(ctx); err != nil || n != 3 {
+		t.Errorf("purged = %d, err = %v", n, err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
@@ -1,6 +1,7 @@
 package msql_test
 
 import (
+	"strings"
 	"testing"
 	"time"
 
@@ -41,3 +42,80 @@ func TestExtractTableTags(t *testing.T) {
 		t.Error(err)
 	}
 }
+
+func TestCreateTableSQL(t *testing.T) {
+	ddl, err := msql.CreateTableSQL(msql.Student{}, "学生")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, s := range []string{
+		"CREATE TABLE IF NOT EXISTS student",
+		"`id` BIGINT NOT NULL AUTO_INCREMENT PRIMARY KEY",
+		"`name` VARCHAR(200) NOT NULL UNIQUE",
+		"`update_time` DATETIME NOT NULL\n",
+		"COMMENT '学生'",
+	} {
+		if !strings.Contains(ddl, s) {
+			t.Errorf("建表语句缺少 %q:\n%s", s, ddl)
+		}
+	}
+}
+
+type ddlColumns struct {
+	Id      int64     `col:"id" primary:"true" auto:"true"`
+	Code    string    `col:"code" unique:"true" strlen:"32"`
+	Note    string    `col:"note" default:"it's" comment:"备注'引号"`
+	Path    string    `col:"path" default:"C:\\tmp"`
+	Count   int       `col:"count" default:"0"`
+	Ratio   float64   `col:"ratio" default:"-1.5"`
+	Enabled bool      `col:"enabled" default:"true"`
+	Created time.Time `col:"created" default:"CURRENT_TIMESTAMP"`
+	Updated time.Time `col:"updated" null:"true" default:"NULL"`
+	Expr    string    `col:"expr" default:"(uuid())"`
+	Blob    []byte    `col:"blob" null:"true"`
+	Long    []byte    `col:"long" longstr:"true"`
+}
+
+func TestCreateTableSQLColumns(t *testing.T) {
+	ddl, err := msql.CreateTableSQL(ddlColumns{}, "表'注释")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, s := range []string{
+		"`code` VARCHAR(32) NOT NULL UNIQUE",
+		// 字符串默认值和注释转义
+		"`note` VARCHAR(200) NOT NULL DEFAULT 'it''s' COMMENT '备注''引号'",
+		"`path` VARCHAR(200) NOT NULL DEFAULT 'C:\\\\tmp'",
+		// 数值、布尔、函数和表达式不加引号
+		"`count` INT NOT NULL DEFAULT 0",
+		"`ratio` FLOAT NOT NULL DEFAULT -1.5",
+		"`enabled` BOOLEAN NOT NULL DEFAULT true",
+		"`created` DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP",
+		"`updated` DATETIME NULL DEFAULT NULL",
+		"`expr` VARCHAR(200) NOT NULL DEFAULT (uuid())",
+		"`blob` BLOB NULL",
+		"`long` LONGBLOB NOT NULL",
+		"COMMENT '表''注释'",
+	} {
+		if !strings.Contains(ddl, s) {
+			t.Errorf("建表语句缺少 %q:\n%s", s, ddl)
+		}
+	}
+}
+
+type badDefault struct {
+	Level int `col:"level" default:"high"`
+}
+
+func TestCreateTableSQLInvalidDefault(t *testing.T) {
+	// 数值列的默认值不是数值时返回错误，而不是生成会被MySQL拒绝的建表语句
+	_, err := msql.CreateTableSQL(badDefault{}, "")
+	if err == nil || !strings.Contains(err.Error(), "level") {
+		t.Errorf("err = %v", err)
+	}
+	if _, err := (msql.ColumnInfo{ColName: "level", ColType: "INT", DefaultVal: "high"}).DefaultSQL(); err == nil {
+		t.Error("DefaultSQL accepted a non-numeric default")
+	}
+}